@@ -0,0 +1,75 @@
+package qf
+
+import "container/list"
+
+// DefaultAdaptiveEntries is the number of false positives ReportFalsePositive remembers
+// unless changed with WithAdaptiveEntries.
+const DefaultAdaptiveEntries = 1024
+
+// ReportFalsePositive tells the filter that Contains returned true for a key that was never
+// added, so that it returns false for the key from now on. The filter remembers the full
+// 64 bit hash of the key, which extends its fingerprint with the hash bits above q + r, and
+// lookups matching a reported hash are misses. Adding the key later makes it present again.
+// Only keys with exactly the same hash as an added key can't be told apart from it.
+// It does nothing on a sealed filter.
+func (qf *QuotientFilter) ReportFalsePositive(key string) {
+	if qf.sealed {
+		return
+	}
+	h, q, r := qf.fingerprintString(key)
+	if !qf.contains(q, r) {
+		return
+	}
+	qf.mu.Lock()
+	defer qf.mu.Unlock()
+	if qf.reported == nil {
+		qf.reported = newReportedSet(qf.adaptiveEntries)
+	}
+	qf.reported.add(h)
+}
+
+// reportedSet is a bounded set of reported false positive hashes, evicting the least
+// recently reported.
+type reportedSet struct {
+	size  int
+	order *list.List
+	index map[uint64]*list.Element
+}
+
+func newReportedSet(size int) *reportedSet {
+	return &reportedSet{size: size, order: list.New(), index: make(map[uint64]*list.Element)}
+}
+
+func (s *reportedSet) add(h uint64) {
+	if e, ok := s.index[h]; ok {
+		s.order.MoveToFront(e)
+		return
+	}
+	s.index[h] = s.order.PushFront(h)
+	if s.order.Len() > s.size {
+		delete(s.index, s.order.Remove(s.order.Back()).(uint64))
+	}
+}
+
+func (s *reportedSet) contains(h uint64) bool {
+	_, ok := s.index[h]
+	return ok
+}
+
+// remove forgets h and reports whether it was present.
+func (s *reportedSet) remove(h uint64) bool {
+	e, ok := s.index[h]
+	if ok {
+		s.order.Remove(e)
+		delete(s.index, h)
+	}
+	return ok
+}
+
+func (s *reportedSet) clone() *reportedSet {
+	c := newReportedSet(s.size)
+	for e := s.order.Back(); e != nil; e = e.Prev() {
+		c.add(e.Value.(uint64))
+	}
+	return c
+}
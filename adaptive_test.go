@@ -0,0 +1,75 @@
+package qf
+
+import (
+	"fmt"
+	"testing"
+)
+
+// falsePositives returns n keys that were not added but qf contains.
+func falsePositives(qf *QuotientFilter, n int) []string {
+	var out []string
+	for i := 0; len(out) < n; i++ {
+		if key := fmt.Sprintf("probe:%d", i); qf.Contains(key) {
+			out = append(out, key)
+		}
+	}
+	return out
+}
+
+func TestReportFalsePositive(t *testing.T) {
+	qf, _ := NewWithOptions(0, WithQR(10, 4))
+	items := generateItems(500)
+	qf.AddAll(items)
+	fps := falsePositives(qf, 20)
+	for _, s := range fps {
+		qf.ReportFalsePositive(s)
+	}
+	for _, s := range fps {
+		if qf.Contains(s) || qf.ContainsAny([]string{s}) || qf.ContainsEach([]string{s}, nil)[0] {
+			t.Fatal("Reported false positive still present", s)
+		}
+	}
+	for _, s := range items {
+		if !qf.Contains(s) {
+			t.Fatal("False negative after reporting false positives", s)
+		}
+	}
+	clone := qf.Clone()
+	// adding a reported key makes it present.
+	if err := qf.Add(fps[0]); err != nil || !qf.Contains(fps[0]) {
+		t.Fatal("Reported key not present after adding it", err)
+	}
+	if existed, err := qf.ContainsOrAdd(fps[1]); existed || err != nil || !qf.Contains(fps[1]) {
+		t.Fatal("Unexpected ContainsOrAdd result for a reported key", existed, err)
+	}
+	if clone.Contains(fps[0]) || clone.Contains(fps[1]) {
+		t.Fatal("Clone shares the reported false positives")
+	}
+	qf.Reset()
+	qf.AddAll(items)
+	if !qf.Contains(fps[2]) {
+		t.Fatal("Reset should forget reported false positives")
+	}
+}
+
+func TestReportFalsePositiveEviction(t *testing.T) {
+	qf, _ := NewWithOptions(0, WithQR(10, 4), WithAdaptiveEntries(2))
+	qf.AddAll(generateItems(500))
+	fps := falsePositives(qf, 3)
+	qf.ReportFalsePositive(fps[0])
+	qf.ReportFalsePositive(fps[1])
+	// reporting again makes fps[0] the most recent, so fps[1] is evicted.
+	qf.ReportFalsePositive(fps[0])
+	qf.ReportFalsePositive(fps[2])
+	if qf.Contains(fps[0]) || !qf.Contains(fps[1]) || qf.Contains(fps[2]) {
+		t.Fatal("Unexpected eviction", qf.Contains(fps[0]), qf.Contains(fps[1]), qf.Contains(fps[2]))
+	}
+	// keys that are not present are not recorded.
+	qf.ReportFalsePositive("turbo:not:present")
+	if qf.reported.order.Len() != 2 {
+		t.Fatal("Unexpected reported set size", qf.reported.order.Len())
+	}
+	if _, err := NewWithOptions(100, WithAdaptiveEntries(0)); err == nil {
+		t.Fatal("Expected an error for zero adaptive entries")
+	}
+}
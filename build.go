@@ -0,0 +1,146 @@
+package qf
+
+import (
+	"fmt"
+	"slices"
+)
+
+// BuildFromSorted returns a filter like New(q, r) holding the fingerprints fps, which have to
+// be in ascending order, as returned by Fingerprints. Duplicates are skipped. The table is laid
+// out run by run in sequential passes without shifting any slots, which is much faster than
+// adding the fingerprints one by one and gives exactly the same table. It returns ErrFull if
+// there are more distinct fingerprints than the filter takes.
+func BuildFromSorted(q, r uint8, fps []uint64) (*QuotientFilter, error) {
+	qf, err := New(q, r)
+	if err != nil {
+		return nil, err
+	}
+	if err := qf.layoutSorted(fps); err != nil {
+		return nil, err
+	}
+	return qf, nil
+}
+
+// layoutSorted fills the empty table of the filter with the ascending fingerprints fps.
+func (qf *QuotientFilter) layoutSorted(fps []uint64) error {
+	bits := qf.qbits + qf.rbits
+	n := uint64(0)
+	for i, fp := range fps {
+		switch {
+		case bits < 64 && fp>>bits != 0:
+			return fmt.Errorf("fingerprint %d, %#x, has more than q + r %d bits", i, fp, bits)
+		case i > 0 && fp < fps[i-1]:
+			return fmt.Errorf("fingerprint %d, %#x, is smaller than the one before it", i, fp)
+		case i == 0 || fp != fps[i-1]:
+			n++
+		}
+	}
+	if n > qf.maxLen {
+		return ErrFull
+	}
+	if n == 0 {
+		return nil
+	}
+	// the clusters at the end of the table can wrap around to its start and push the first
+	// clusters right, which can push the last ones further. The number of slots wrapping
+	// around only grows, so the layout is repeated until it no longer changes, which takes
+	// two rounds unless the table is close to full.
+	wrap := uint64(0)
+	for {
+		l := layout{qf: qf, pos: wrap}
+		for _, fp := range fps {
+			l.add(fp)
+		}
+		if l.pos <= qf.cap+wrap {
+			break
+		}
+		wrap = l.pos - qf.cap
+	}
+	l := layout{qf: qf, t: qf, pos: wrap}
+	for _, fp := range fps {
+		l.add(fp)
+	}
+	qf.buildOffsets()
+	qf.len = n
+	qf.publishLen()
+	return nil
+}
+
+// slotTable is the table a layout writes the slots to, the filter itself or a part of it.
+type slotTable interface {
+	getSlot(index uint64) slot
+	setSlot(index uint64, s slot)
+}
+
+// layout lays out ascending fingerprints one by one starting at slot pos, skipping duplicates.
+// The slots are written to t unless it is nil, slots past the end of the table wrapped
+// around. pos is the slot after the last one used.
+type layout struct {
+	qf   *QuotientFilter
+	t    slotTable
+	pos  uint64
+	prev uint64
+	n    uint64
+}
+
+func (l *layout) add(fp uint64) {
+	if l.n > 0 && fp == l.prev {
+		return
+	}
+	qf := l.qf
+	q, r := fp>>qf.rbits&qf.qMask, fp&qf.rMask
+	s := newSlot(r)
+	if l.n > 0 && l.prev>>qf.rbits&qf.qMask == q {
+		s = s.setContinuation()
+	} else {
+		l.pos = max(l.pos, q)
+		if l.t != nil {
+			l.t.setSlot(q, l.t.getSlot(q).setOccupied())
+		}
+	}
+	if l.pos != q {
+		s = s.setShifted()
+	}
+	if l.t != nil {
+		// is_occupied belongs to the quotient and not to the fingerprint in the slot.
+		index := l.pos & qf.qMask
+		if l.t.getSlot(index).isOccupied() {
+			s = s.setOccupied()
+		}
+		l.t.setSlot(index, s)
+	}
+	l.pos++
+	l.prev = fp
+	l.n++
+}
+
+// radixSortMin is the number of fingerprints from which sortFingerprints uses a radix sort.
+const radixSortMin = 1 << 12
+
+// sortFingerprints sorts fingerprints of bits bits in ascending order. Large slices are sorted
+// a byte at a time with a radix sort, which takes a pass over them per byte of the
+// fingerprints and is several times faster than slices.Sort, at the cost of a copy of fps.
+func sortFingerprints(fps []uint64, bits uint8) {
+	if len(fps) < radixSortMin {
+		slices.Sort(fps)
+		return
+	}
+	src, dst := fps, make([]uint64, len(fps))
+	for shift := uint8(0); shift < bits; shift += 8 {
+		var offsets [256]int
+		for _, fp := range src {
+			offsets[fp>>shift&0xff]++
+		}
+		pos := 0
+		for d, n := range offsets {
+			offsets[d], pos = pos, pos+n
+		}
+		for _, fp := range src {
+			d := fp >> shift & 0xff
+			dst[offsets[d]] = fp
+			offsets[d]++
+		}
+		src, dst = dst, src
+	}
+	copy(fps, src)
+}
@@ -0,0 +1,140 @@
+package qf
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"slices"
+	"testing"
+)
+
+func TestBuildFromSorted(t *testing.T) {
+	tests := []struct {
+		Name string
+		Q, R uint8
+		N    int
+	}{
+		{"empty", 8, 8, 0},
+		{"sparse", 12, 9, 500},
+		{"default load", 12, 9, 3686},
+		{"wide remainders", 6, 58, 50},
+	}
+	for _, test := range tests {
+		added := MustNew(test.Q, test.R)
+		added.AddAll(randomItems(test.N))
+		fps := added.Fingerprints()
+		// duplicates are skipped.
+		withDuplicates := slices.Clone(fps)
+		if len(fps) > 10 {
+			withDuplicates = slices.Insert(withDuplicates, 10, fps[9], fps[10])
+		}
+		built, err := BuildFromSorted(test.Q, test.R, withDuplicates)
+		if err != nil {
+			t.Fatal(test.Name, "unexpected error", err)
+		}
+		if !bytes.Equal(built.data, added.data) || built.Len() != added.Len() {
+			t.Fatal(test.Name, "table differs from the one built with Add")
+		}
+		if err := built.checkTable(); err != nil {
+			t.Fatal(test.Name, err)
+		}
+	}
+}
+
+func TestBuildFromSortedWrap(t *testing.T) {
+	// clusters wrapping around the end of the table push the first clusters right.
+	for _, n := range []int{10, 40, 63, 64} {
+		rnd := rand.New(rand.NewSource(int64(n)))
+		var fps []uint64
+		for i := 0; i < n; i++ {
+			q := uint64(rnd.Intn(8))
+			if i%2 == 0 {
+				q = 63 - q
+			}
+			fps = append(fps, q<<5|uint64(rnd.Intn(32)))
+		}
+		slices.Sort(fps)
+		fps = slices.Compact(fps)
+		added, built := newFull(6, 5), newFull(6, 5)
+		if err := added.AddFingerprints(fps); err != nil {
+			t.Fatal("Unexpected error", err)
+		}
+		if err := built.layoutSorted(fps); err != nil {
+			t.Fatal("Unexpected error", err)
+		}
+		if !bytes.Equal(built.data, added.data) || built.Len() != added.Len() {
+			t.Fatal(n, "table differs from the one built with Add")
+		}
+	}
+}
+
+func TestBuildFromSortedErrors(t *testing.T) {
+	if _, err := BuildFromSorted(8, 8, []uint64{1, 3, 2}); err == nil {
+		t.Fatal("Expected an error for unsorted fingerprints")
+	}
+	if _, err := BuildFromSorted(8, 8, []uint64{1, 1 << 16}); err == nil {
+		t.Fatal("Expected an error for a fingerprint of more than q + r bits")
+	}
+	fps := make([]uint64, 250)
+	for i := range fps {
+		fps[i] = uint64(i) << 8
+	}
+	if _, err := BuildFromSorted(8, 8, fps); !errors.Is(err, ErrFull) {
+		t.Fatal("Expected ErrFull, got", err)
+	}
+	if _, err := BuildFromSorted(1, 8, nil); err == nil {
+		t.Fatal("Expected an error for invalid q")
+	}
+}
+
+// buildFingerprints returns n sorted random fingerprints of q + r bits.
+func buildFingerprints(n int, q, r uint8) []uint64 {
+	fps := make([]uint64, n)
+	for i := range fps {
+		fps[i] = rand.Uint64() & maskLower(uint64(q+r))
+	}
+	slices.Sort(fps)
+	return fps
+}
+
+func BenchmarkBuildFromSorted(b *testing.B) {
+	fps := buildFingerprints(10_000_000, 24, 8)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := BuildFromSorted(24, 8, fps); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkBuildWithAdd(b *testing.B) {
+	// keys arrive in no particular order.
+	fps := buildFingerprints(10_000_000, 24, 8)
+	rand.Shuffle(len(fps), func(i, j int) { fps[i], fps[j] = fps[j], fps[i] })
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		qf := MustNew(24, 8)
+		if err := qf.AddFingerprints(fps); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestSortFingerprints(t *testing.T) {
+	for _, bits := range []uint8{9, 16, 33, 64} {
+		for _, n := range []int{0, 10, radixSortMin, 3*radixSortMin + 7} {
+			fps := make([]uint64, n)
+			for i := range fps {
+				fps[i] = rand.Uint64() & maskLower(uint64(bits))
+				if i%5 == 0 && i > 0 {
+					fps[i] = fps[i-1]
+				}
+			}
+			want := slices.Clone(fps)
+			slices.Sort(want)
+			if sortFingerprints(fps, bits); !slices.Equal(fps, want) {
+				t.Fatal("fingerprints of", bits, "bits not sorted, n", n)
+			}
+		}
+	}
+}
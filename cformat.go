@@ -0,0 +1,116 @@
+package qf
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+// The C layout is the memory image of struct quotient_filter of vedantk/quotient-filter, the
+// C library this package is a port of, on a little-endian 64 bit system, followed by its table:
+//
+//	qf_qbits, qf_rbits  1 byte each
+//	qf_elem_bits        1 byte, r + 3
+//	padding             1 byte
+//	qf_entries          4 bytes, fingerprints in the table
+//	qf_index_mask       8 bytes, the lower q bits set
+//	qf_rmask            8 bytes, the lower r bits set
+//	qf_elem_mask        8 bytes, the lower r + 3 bits set
+//	qf_max_size         8 bytes, 1 << q
+//	qf_table            8 bytes, the table pointer, ignored
+//	table               qf_table_size(q, r) bytes, (1 << q) * (r + 3) bits rounded up to bytes
+//
+// The table packs the slots one after the other, the metadata bits first and least significant
+// bit first in little-endian words, like the data words of encodings before version 4. The
+// slots are rearranged into the blocks of this package, see blockSlots, on import and back on
+// export.
+// The library has no serialization of its own, this is what writing the struct and the table
+// with fwrite produces. Layouts of big-endian or 32 bit systems are not supported.
+const cHeaderLen = 48
+
+// ImportC returns a filter read from r in the C layout, see ExportC. The filter has the
+// default hash function and a max load factor of 1, the C library fills every slot. The C
+// library inserts hashes computed by its caller, keys are only found with Contains if they
+// were hashed with 64 bit FNV-1a, ContainsHash looks up the hashes themselves. The header
+// and the table are validated like UnmarshalBinary does.
+func ImportC(r io.Reader) (*QuotientFilter, error) {
+	var h [cHeaderLen]byte
+	if _, err := io.ReadFull(r, h[:]); err == io.EOF || err == io.ErrUnexpectedEOF {
+		return nil, fmt.Errorf("%w: unexpected end of data", ErrInvalidEncoding)
+	} else if err != nil {
+		return nil, err
+	}
+	q, rbits := h[0], h[1]
+	if err := validateQR(q, rbits); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidEncoding, err)
+	}
+	entries := uint64(binary.LittleEndian.Uint32(h[4:]))
+	masks := [4]uint64{maskLower(uint64(q)), maskLower(uint64(rbits)), maskLower(uint64(rbits) + 3), 1 << q}
+	for i, want := range masks {
+		if got := binary.LittleEndian.Uint64(h[8+8*i:]); got != want {
+			return nil, fmt.Errorf("%w: field %d of the C header is %#x, q %d and r %d need %#x, only little-endian 64 bit layouts are supported", ErrInvalidEncoding, i+4, got, q, rbits, want)
+		}
+	}
+	if h[2] != rbits+3 || entries > 1<<q {
+		return nil, fmt.Errorf("%w: element bits %d and %d entries in the C header of q %d and r %d", ErrInvalidEncoding, h[2], entries, q, rbits)
+	}
+	if size, ok := dataBytes(q, rbits, false); !ok || size > DefaultMaxMemory {
+		return nil, fmt.Errorf("%w: q %d and r %d need more than the limit of %d bytes", ErrInvalidEncoding, q, rbits, uint64(DefaultMaxMemory))
+	}
+	// the table is read before the filter is allocated, so that a short stream can't force
+	// a large allocation.
+	size := cTableSize(q, rbits)
+	table, err := io.ReadAll(io.LimitReader(r, int64(size)))
+	if err != nil {
+		return nil, err
+	}
+	if len(table) != size {
+		return nil, fmt.Errorf("%w: %d bytes of table, q %d and r %d need %d", ErrInvalidEncoding, len(table), q, rbits, size)
+	}
+	qf, err := NewWithOptions(0, WithQR(q, rbits), WithMaxLoadFactor(1))
+	if err != nil {
+		return nil, err
+	}
+	copy(qf.data, table)
+	qf.unpack()
+	qf.len = entries
+	qf.publishLen()
+	if err := qf.checkTable(); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidEncoding, err)
+	}
+	qf.buildOffsets()
+	return qf, nil
+}
+
+// ExportC writes the filter to w in the C layout, see ImportC, and returns the number of
+// bytes written. The pointer in the header is written as zero. The layout has no room for
+// the rest of the filter, so filters with stashed fingerprints or more than math.MaxUint32
+// fingerprints can't be exported, and the hash function, key transformer and namespaces
+// are left out.
+func (qf *QuotientFilter) ExportC(w io.Writer) (int64, error) {
+	if len(qf.stash) > 0 {
+		return 0, fmt.Errorf("%d stashed fingerprints can't be exported in the C layout", len(qf.stash))
+	}
+	if qf.len > math.MaxUint32 {
+		return 0, fmt.Errorf("%d fingerprints don't fit in the C layout", qf.len)
+	}
+	buf := make([]byte, cHeaderLen)
+	buf[0], buf[1], buf[2] = qf.qbits, qf.rbits, qf.ssize
+	binary.LittleEndian.PutUint32(buf[4:], uint32(qf.len))
+	binary.LittleEndian.PutUint64(buf[8:], qf.qMask)
+	binary.LittleEndian.PutUint64(buf[16:], qf.rMask)
+	binary.LittleEndian.PutUint64(buf[24:], qf.sMask)
+	binary.LittleEndian.PutUint64(buf[32:], qf.cap)
+	n, err := writeFull(w, buf)
+	if err != nil {
+		return n, err
+	}
+	m, err := writeFull(w, qf.packed()[:cTableSize(qf.qbits, qf.rbits)])
+	return n + m, err
+}
+
+// cTableSize returns qf_table_size(q, r) of the C library.
+func cTableSize(q, r uint8) int {
+	return int((uint64(1)<<q*(uint64(r)+3) + 7) / 8)
+}
@@ -0,0 +1,96 @@
+package qf
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// cGoldenVectors are written by testdata/c/qfdump.c from the C library's insertion code.
+var cGoldenVectors = []struct {
+	File string
+	Q, R uint8
+	Keys int
+}{
+	{"c-q8-r8.golden", 8, 8, 200},
+	{"c-q5-r10.golden", 5, 10, 28},
+	{"c-q10-r7.golden", 10, 7, 900},
+}
+
+func cGoldenKeys(n int) []string {
+	keys := make([]string, n)
+	for i := range keys {
+		keys[i] = fmt.Sprint("key", i)
+	}
+	return keys
+}
+
+func TestImportCGolden(t *testing.T) {
+	for _, v := range cGoldenVectors {
+		golden, err := os.ReadFile(filepath.Join("testdata", v.File))
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys := cGoldenKeys(v.Keys)
+		qf, err := ImportC(bytes.NewReader(golden))
+		if err != nil {
+			t.Fatal(v.File, "unexpected error", err)
+		}
+		if qf.qbits != v.Q || qf.rbits != v.R || !qf.ContainsAll(keys) {
+			t.Fatal(v.File, "imported filter is missing keys")
+		}
+		// the same keys added here give the table of the C library.
+		added := newFull(v.Q, v.R)
+		added.AddAll(keys)
+		if added.Len() != qf.Len() || !bytes.Equal(added.data, qf.data) {
+			t.Fatal(v.File, "table differs from the one built with Add")
+		}
+		var buf bytes.Buffer
+		if n, err := added.ExportC(&buf); err != nil || n != int64(len(golden)) || !bytes.Equal(buf.Bytes(), golden) {
+			t.Fatal(v.File, "ExportC differs from the C layout", n, err)
+		}
+	}
+}
+
+func TestImportCInvalid(t *testing.T) {
+	golden, _ := os.ReadFile(filepath.Join("testdata", "c-q8-r8.golden"))
+	corrupt := func(f func(b []byte)) []byte {
+		c := bytes.Clone(golden)
+		f(c)
+		return c
+	}
+	tests := []struct {
+		Name string
+		Data []byte
+	}{
+		{"empty", nil},
+		{"short header", golden[:40]},
+		{"short table", golden[:len(golden)-1]},
+		{"q", corrupt(func(b []byte) { b[0] = 1 })},
+		{"element bits", corrupt(func(b []byte) { b[2] = 12 })},
+		{"big-endian", corrupt(func(b []byte) { b[8], b[15] = 0, 0xff })},
+		{"max size", corrupt(func(b []byte) { b[33] = 2 })},
+		{"entries", corrupt(func(b []byte) { b[4]++ })},
+		{"table", corrupt(func(b []byte) { b[cHeaderLen] |= 2 })},
+	}
+	for _, test := range tests {
+		if _, err := ImportC(bytes.NewReader(test.Data)); !errors.Is(err, ErrInvalidEncoding) {
+			t.Fatal(test.Name, "expected ErrInvalidEncoding, got", err)
+		}
+	}
+	// the pointer and whatever follows the table are ignored.
+	if _, err := ImportC(bytes.NewReader(append(corrupt(func(b []byte) { b[40] = 1 }), 1, 2, 3))); err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+}
+
+func TestExportCErrors(t *testing.T) {
+	qf, _ := NewWithOptions(0, WithQR(6, 6), WithStash(4, 0))
+	qf.AddAll(randomItems(60))
+	if _, err := qf.ExportC(new(bytes.Buffer)); err == nil {
+		t.Fatal("Expected an error exporting stashed fingerprints")
+	}
+}
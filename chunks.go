@@ -0,0 +1,207 @@
+package qf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+)
+
+// ExportChunks frames the binary encoding into chunks that are checksummed one by one, so
+// that a transfer cut short resumes after the last chunk received. Every chunk carries its
+// sequence number and the checksum of the encoding, which tells exports apart, all fields
+// are little-endian:
+//
+//	magic     "QFCK"
+//	export    4 bytes, the checksum of the binary encoding
+//	sequence  4 bytes, from 0
+//	count     4 bytes, chunks of the export
+//	length    4 bytes, length of the payload, the chunk size except in the last chunk
+//	checksum  4 bytes, CRC-32C of the fields before it and the payload
+//	payload   length bytes of the binary encoding
+const (
+	chunkMagic = "QFCK"
+	// ChunkOverhead is the size of the header of every chunk, chunk i of an export with
+	// chunk size n starts at byte i * (n + ChunkOverhead) of the stream.
+	ChunkOverhead = 24
+	// MaxChunkSize is the largest chunk size of ExportChunks.
+	MaxChunkSize = 1 << 30
+)
+
+// ChunkError is returned by ImportChunks when the chunks stop before the last one.
+type ChunkError struct {
+	// Resume is the sequence number of the first chunk missing, the chunks before it have
+	// been received.
+	Resume int
+	Err    error
+}
+
+func (e *ChunkError) Error() string {
+	return fmt.Sprintf("resume from chunk %d: %v", e.Resume, e.Err)
+}
+
+// Unwrap returns the underlying error, such as io.ErrUnexpectedEOF or ErrChecksum.
+func (e *ChunkError) Unwrap() error {
+	return e.Err
+}
+
+// ExportChunks writes the filter to w in the binary format of MarshalBinary, framed into
+// chunks of chunkSize bytes, see ChunkImporter. It returns the number of bytes written.
+func (qf *QuotientFilter) ExportChunks(w io.Writer, chunkSize int) (int64, error) {
+	if chunkSize <= 0 || chunkSize > MaxChunkSize {
+		return 0, fmt.Errorf("chunk size %d is not between 1 and %d", chunkSize, MaxChunkSize)
+	}
+	head := qf.encodingHead(make([]byte, 0, chunkWords*8))
+	size := uint64(len(head)) + uint64(qf.words())*8
+	count := (size + uint64(chunkSize) - 1) / uint64(chunkSize)
+	if count > math.MaxUint32 {
+		return 0, fmt.Errorf("%d chunks of %d bytes are too many, use larger chunks", count, chunkSize)
+	}
+	src := io.MultiReader(bytes.NewReader(head), qf.dataReader())
+	buf := make([]byte, ChunkOverhead+min(uint64(chunkSize), size))
+	copy(buf, chunkMagic)
+	binary.LittleEndian.PutUint32(buf[4:], binary.LittleEndian.Uint32(head[6:]))
+	binary.LittleEndian.PutUint32(buf[12:], uint32(count))
+	var written int64
+	for seq := uint64(0); seq < count; seq++ {
+		chunk := buf[:ChunkOverhead+min(uint64(chunkSize), size-seq*uint64(chunkSize))]
+		if _, err := io.ReadFull(src, chunk[ChunkOverhead:]); err != nil {
+			return written, err
+		}
+		binary.LittleEndian.PutUint32(chunk[8:], uint32(seq))
+		binary.LittleEndian.PutUint32(chunk[16:], uint32(len(chunk)-ChunkOverhead))
+		binary.LittleEndian.PutUint32(chunk[20:], chunkChecksum(chunk))
+		n, err := writeFull(w, chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// chunkChecksum returns the checksum of a chunk, which skips the checksum field.
+func chunkChecksum(chunk []byte) uint32 {
+	return crc32.Update(crc32.Checksum(chunk[:20], castagnoli), castagnoli, chunk[ChunkOverhead:])
+}
+
+// ChunkImporter decodes a filter from the chunks of ExportChunks, received over one or more
+// streams. It keeps the payload of the chunks received in memory until the last one arrives.
+type ChunkImporter struct {
+	target *QuotientFilter
+	qf     *QuotientFilter
+	// export, count and size of the chunks, known from the first one.
+	export, count uint32
+	size          int
+	next          int
+	data          []byte
+}
+
+// NewChunkImporter returns an importer decoding into a filter created with opts, needed for
+// filters with a custom hash function or a key transformer like with LoadFromFile.
+func NewChunkImporter(opts ...Option) (*ChunkImporter, error) {
+	target, err := decodeTarget(opts)
+	if err != nil {
+		return nil, err
+	}
+	return &ChunkImporter{target: target}, nil
+}
+
+// Resume returns the sequence number of the first chunk not received yet.
+func (imp *ChunkImporter) Resume() int {
+	return imp.next
+}
+
+// ImportChunks reads consecutive chunks from r and returns the filter once the last chunk
+// has been received. Only the first resumeFrom chunks received by earlier calls are kept,
+// resumeFrom can be at most Resume(), and 0 starts over with any export. r can start with
+// any chunk up to resumeFrom, so a transfer resumes either by reading the stream again from
+// the start or from the chunk to resume from, chunks already received are skipped.
+//
+// If r ends, or a chunk is corrupt, missing or out of order, the chunks before it are kept
+// and a *ChunkError tells which chunk to resume from. Chunks of another export are
+// rejected. Once the filter has been decoded, later calls return it without reading r.
+func (imp *ChunkImporter) ImportChunks(r io.Reader, resumeFrom int) (*QuotientFilter, error) {
+	if imp.qf != nil {
+		return imp.qf, nil
+	}
+	if resumeFrom < 0 || resumeFrom > imp.next {
+		return nil, &ChunkError{imp.next, fmt.Errorf("can't resume from chunk %d", resumeFrom)}
+	}
+	imp.next, imp.data = resumeFrom, imp.data[:resumeFrom*imp.size]
+	if resumeFrom == 0 {
+		imp.count = 0
+	}
+	var h [ChunkOverhead]byte
+	first, prev := true, 0
+	for {
+		if _, err := io.ReadFull(r, h[:]); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, &ChunkError{imp.next, err}
+		}
+		seq, length := int(binary.LittleEndian.Uint32(h[8:])), binary.LittleEndian.Uint32(h[16:])
+		if string(h[:4]) != chunkMagic || length == 0 || length > MaxChunkSize {
+			return nil, &ChunkError{imp.next, fmt.Errorf("%w: not a chunk of a filter", ErrInvalidEncoding)}
+		}
+		chunk := make([]byte, ChunkOverhead+int(length))
+		copy(chunk, h[:])
+		if _, err := io.ReadFull(r, chunk[ChunkOverhead:]); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, &ChunkError{imp.next, err}
+		}
+		if chunkChecksum(chunk) != binary.LittleEndian.Uint32(h[20:]) {
+			return nil, &ChunkError{imp.next, fmt.Errorf("%w: chunk %d: %w", ErrInvalidEncoding, seq, ErrChecksum)}
+		}
+		export, count := binary.LittleEndian.Uint32(h[4:]), binary.LittleEndian.Uint32(h[12:])
+		if err := imp.check(seq, first, prev, export, count, int(length)); err != nil {
+			return nil, &ChunkError{imp.next, err}
+		}
+		first, prev = false, seq
+		if seq < imp.next {
+			continue
+		}
+		imp.data = append(imp.data, chunk[ChunkOverhead:]...)
+		if imp.next++; imp.next < int(imp.count) {
+			continue
+		}
+		if err := imp.target.UnmarshalBinary(imp.data); err != nil {
+			return nil, err
+		}
+		imp.qf, imp.data = imp.target, nil
+		return imp.qf, nil
+	}
+}
+
+// check returns an error if chunk seq with the fields given does not follow the chunk prev
+// of the stream, or the first chunk of the stream if first is set, or does not belong to the
+// export of the chunks received before.
+func (imp *ChunkImporter) check(seq int, first bool, prev int, export, count uint32, length int) error {
+	switch {
+	case first && seq > imp.next:
+		return fmt.Errorf("chunks %d to %d are missing", imp.next, seq-1)
+	case !first && seq > prev+1:
+		return fmt.Errorf("chunks %d to %d are missing", prev+1, seq-1)
+	case !first && seq <= prev:
+		return fmt.Errorf("chunk %d is out of order after chunk %d", seq, prev)
+	case seq >= int(count):
+		return fmt.Errorf("%w: chunk %d of %d chunks", ErrInvalidEncoding, seq, count)
+	}
+	if imp.count == 0 {
+		// chunk 0 of the first export seen, its length is the chunk size.
+		imp.export, imp.count, imp.size = export, count, length
+	}
+	switch {
+	case export != imp.export || count != imp.count:
+		return errors.New("chunk of another export")
+	case seq < int(count)-1 && length != imp.size || length > imp.size:
+		return fmt.Errorf("%w: chunk %d of %d bytes, the chunks have %d", ErrInvalidEncoding, seq, length, imp.size)
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package qf
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestChunks(t *testing.T) {
+	qf, _ := NewWithOptions(0, WithQR(12, 9), WithStash(4, 0))
+	qf.AddAll(randomItems(3000))
+	qf.AddNS("ns", "key")
+	want, _ := qf.MarshalBinary()
+	const size = 1000
+	var stream bytes.Buffer
+	n, err := qf.ExportChunks(&stream, size)
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	count := (len(want) + size - 1) / size
+	if n != int64(stream.Len()) || n != int64(len(want)+count*ChunkOverhead) {
+		t.Fatal("Wrote", n, "bytes in", stream.Len(), "for", len(want), "bytes in", count, "chunks")
+	}
+	full := stream.Bytes()
+	imp, _ := NewChunkImporter()
+	got, err := imp.ImportChunks(bytes.NewReader(full), 0)
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	if enc, _ := got.MarshalBinary(); !bytes.Equal(enc, want) {
+		t.Fatal("Imported filter differs from the exported one")
+	}
+	if again, err := imp.ImportChunks(bytes.NewReader(nil), 0); again != got || err != nil {
+		t.Fatal("Done importer returned", again, err)
+	}
+
+	offset := func(chunk int) int { return chunk * (size + ChunkOverhead) }
+	for _, cut := range []int{0, 1, ChunkOverhead, offset(1), offset(1) + 1, offset(3) - 1, offset(count-1) + ChunkOverhead, len(full) - 1} {
+		resume := cut / (size + ChunkOverhead)
+		// resuming reads either the rest of the stream or the whole stream again.
+		for _, restart := range []bool{false, true} {
+			imp, _ := NewChunkImporter()
+			_, err := imp.ImportChunks(bytes.NewReader(full[:cut]), 0)
+			var ce *ChunkError
+			if !errors.As(err, &ce) || ce.Resume != resume || !errors.Is(err, io.ErrUnexpectedEOF) {
+				t.Fatal("Cut at", cut, "returned", err, "want resume from", resume)
+			}
+			if imp.Resume() != resume {
+				t.Fatal("Cut at", cut, "resumes from", imp.Resume(), "want", resume)
+			}
+			rest := full[offset(resume):]
+			if restart {
+				rest = full
+			}
+			got, err := imp.ImportChunks(bytes.NewReader(rest), ce.Resume)
+			if err != nil {
+				t.Fatal("Cut at", cut, "unexpected error", err)
+			}
+			if enc, _ := got.MarshalBinary(); !bytes.Equal(enc, want) {
+				t.Fatal("Cut at", cut, "resumed filter differs from a clean transfer")
+			}
+		}
+	}
+}
+
+func TestChunksErrors(t *testing.T) {
+	qf, _ := NewWithOptions(0, WithQR(10, 7))
+	qf.AddAll(randomItems(500))
+	const size = 256
+	var stream bytes.Buffer
+	qf.ExportChunks(&stream, size)
+	full := stream.Bytes()
+	chunk := func(i int) []byte {
+		start := i * (size + ChunkOverhead)
+		return full[start:min(start+size+ChunkOverhead, len(full))]
+	}
+	join := func(parts ...[]byte) []byte { return bytes.Join(parts, nil) }
+	qf.Add("another")
+	var other bytes.Buffer
+	qf.ExportChunks(&other, size)
+	corrupt := join(chunk(0), chunk(1))
+	corrupt[len(corrupt)-1] ^= 1
+
+	for _, c := range []struct {
+		name   string
+		stream []byte
+		resume int
+	}{
+		{"missing", join(chunk(0), chunk(2)), 1},
+		{"out of order", join(chunk(0), chunk(2), chunk(1)), 1},
+		{"repeated", join(chunk(0), chunk(1), chunk(1)), 2},
+		{"corrupt", corrupt, 1},
+		{"another export", join(chunk(0), other.Bytes()[size+ChunkOverhead:]), 1},
+		{"not chunks", []byte("QFGO and then some bytes that are not a chunk"), 0},
+	} {
+		imp, _ := NewChunkImporter()
+		_, err := imp.ImportChunks(bytes.NewReader(c.stream), 0)
+		var ce *ChunkError
+		if !errors.As(err, &ce) || ce.Resume != c.resume {
+			t.Fatal(c.name, "returned", err, "want resume from", c.resume)
+		}
+	}
+
+	imp, _ := NewChunkImporter()
+	if _, err := imp.ImportChunks(bytes.NewReader(chunk(2)), 0); err == nil {
+		t.Fatal("Stream starting after the resume point was accepted")
+	}
+	if _, err := imp.ImportChunks(bytes.NewReader(full), 1); err == nil {
+		t.Fatal("Resume past the chunks received was accepted")
+	}
+	imp.ImportChunks(bytes.NewReader(chunk(0)), 0)
+	if _, err := imp.ImportChunks(bytes.NewReader(other.Bytes()), 0); err != nil {
+		t.Fatal("Starting over with another export returned", err)
+	}
+	for _, size := range []int{0, -1, MaxChunkSize + 1} {
+		if _, err := qf.ExportChunks(io.Discard, size); err == nil {
+			t.Fatal("Chunk size", size, "was accepted")
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package qf
+
+import "hash/maphash"
+
+// AddComparable adds the value v of any comparable type to the filter, hashed with
+// maphash.Comparable, so that struct and integer keys don't need an encoding of their own.
+//
+// The values are hashed with a maphash seed of the filter, the seed of WithMaphash for
+// maphash filters and a random one otherwise, which keeps the same value the same key for the
+// lifetime of the filter and its clones. They are a keyspace of their own: Add(s) and
+// AddComparable(qf, s) add different keys, and neither the key transformer nor the hash
+// function of the filter apply. Encodings don't record the seed, so that comparable keys are
+// only found in decoded and merged filters of the same maphash seed.
+func AddComparable[T comparable](qf *QuotientFilter, v T) error {
+	return qf.AddHash(maphash.Comparable(qf.comparableSeed, v))
+}
+
+// ContainsComparable checks if the value v is present in the filter, see AddComparable.
+func ContainsComparable[T comparable](qf *QuotientFilter, v T) bool {
+	return qf.ContainsHash(maphash.Comparable(qf.comparableSeed, v))
+}
+
+// comparableSeed returns the seed AddComparable hashes with in filters of the hash id and
+// seed.
+func comparableSeed(hashID, hashSeed string) maphash.Seed {
+	if hashID == HashMaphash {
+		if s := lookupMaphashSeed(hashSeed); s != nil {
+			return s.seed
+		}
+	}
+	return maphash.MakeSeed()
+}
@@ -0,0 +1,60 @@
+package qf
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestComparable(t *testing.T) {
+	type point struct {
+		X, Y int32
+		Tag  string
+	}
+	qf, _ := NewWithOptions(0, WithQR(16, 20))
+	for i := range 1000 {
+		if err := AddComparable(qf, i); err != nil {
+			t.Fatal("Unexpected error", err)
+		}
+		AddComparable(qf, fmt.Sprint("key", i))
+		AddComparable(qf, point{int32(i), int32(-i), "p"})
+	}
+	clone := qf.Clone()
+	for i := range 1000 {
+		for _, f := range []*QuotientFilter{qf, clone} {
+			if !ContainsComparable(f, i) || !ContainsComparable(f, fmt.Sprint("key", i)) || !ContainsComparable(f, point{int32(i), int32(-i), "p"}) {
+				t.Fatal("Missing", i)
+			}
+		}
+	}
+	// comparable keys are a keyspace of their own.
+	if qf.Contains("key1") || qf.ContainsUint64(1) {
+		t.Fatal("Comparable keys are found by the string and integer lookups")
+	}
+	if n := testing.AllocsPerRun(100, func() {
+		AddComparable(qf, 12345)
+		ContainsComparable(qf, uint64(12345))
+	}); n != 0 {
+		t.Fatal("Integer keys allocate", n)
+	}
+
+	// maphash filters hash comparable keys with their seed, which decoded filters share.
+	m, _ := NewWithOptions(0, WithQR(16, 20), WithMaphash())
+	AddComparable(m, point{1, 2, "p"})
+	b, _ := m.MarshalBinary()
+	decoded := MustNew(4, 4)
+	if err := decoded.UnmarshalBinary(b); err != nil || !ContainsComparable(decoded, point{1, 2, "p"}) {
+		t.Fatal("Decoded maphash filter lost a comparable key", err)
+	}
+}
+
+func BenchmarkContainsComparable(b *testing.B) {
+	qf, _ := NewWithOptions(0, WithQR(20, 16))
+	for i := range 1 << 15 {
+		AddComparable(qf, i)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ContainsComparable(qf, i&(1<<16-1))
+	}
+}
@@ -0,0 +1,285 @@
+package qf
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math/bits"
+	"slices"
+)
+
+// Flags of the version 2 encoding.
+const (
+	// flagCompressed marks data compressed by WriteToCompressed.
+	flagCompressed = 1 << 0
+	// flagCacheLineBlocks marks data with the blocks padded to cache lines, see
+	// WithCacheLineBlocks, only in version 4 and later.
+	flagCacheLineBlocks = 1 << 1
+	// flagAlignedSlots marks data with the remainders stored in 8, 16, 32 or 64 bits, see
+	// WithAlignedSlots, only in version 4 and later.
+	flagAlignedSlots = 1 << 2
+	knownFlags       = flagCompressed | flagCacheLineBlocks | flagAlignedSlots
+)
+
+// The compressed data replaces the data words with:
+//
+//	for every chunk of compressChunkSlots slots:
+//	  used   a bitmap with a bit set for every slot that is not zero, as run-length words
+//	  slots  the slots that are not zero, ssize bits each, least significant bit first,
+//	         padded to a whole byte
+//	tail     the data words from the one holding the last bit of the last slot, with the
+//	         bits of the slots cleared, as run-length words
+//
+// Run-length words are uvarint pairs of a count of zero words and a count of literal words,
+// followed by the literal words, until all the words are covered. Every pair covers at least
+// one word.
+const compressChunkSlots = 1 << 18
+
+// WriteToCompressed is like WriteTo but leaves out the empty slots, which makes the encoding
+// much smaller for filters that are not close to full. ReadFrom, UnmarshalBinary and
+// LoadFromFile detect compressed encodings and decode them to the same data words.
+// OpenMmap can't map the encoding.
+func (qf *QuotientFilter) WriteToCompressed(w io.Writer) (int64, error) {
+	head := qf.appendEncodingHeader(make([]byte, prefixLen, 128), flagCompressed)
+	// the checksum comes first, so the data is compressed twice.
+	crc := crc32.Update(0, castagnoli, head[prefixLen:])
+	qf.compress(func(b []byte) error {
+		crc = crc32.Update(crc, castagnoli, b)
+		return nil
+	})
+	putPrefix(head, encodingVersion, crc)
+	written, err := writeFull(w, head)
+	if err != nil {
+		return written, err
+	}
+	err = qf.compress(func(b []byte) error {
+		n, err := writeFull(w, b)
+		written += n
+		return err
+	})
+	return written, err
+}
+
+// compress passes the compressed data to emit in chunks.
+func (qf *QuotientFilter) compress(emit func(b []byte) error) error {
+	c := compressor{buf: make([]byte, 0, chunkWords*8+64), emit: emit}
+	used := make([]uint64, compressChunkSlots/64)
+	for start := uint64(0); start < qf.cap && c.err == nil; start += compressChunkSlots {
+		n := min(compressChunkSlots, qf.cap-start)
+		clear(used)
+		for i := uint64(0); i < n; i++ {
+			if qf.getSlot(start+i) != 0 {
+				used[i/64] |= 1 << (i % 64)
+			}
+		}
+		words := used[:(n+63)/64]
+		c.words(len(words), func(i int) uint64 { return words[i] })
+		for i, w := range words {
+			for ; w != 0; w &= w - 1 {
+				c.bits(uint64(qf.getSlot(start+uint64(i*64+bits.TrailingZeros64(w)))), qf.ssize)
+			}
+		}
+		c.pad()
+	}
+	first, slotBits := qf.tail()
+	c.words(qf.words()-int(first), func(i int) uint64 {
+		if i == 0 {
+			return qf.word(first) &^ slotBits
+		}
+		return qf.word(first + uint64(i))
+	})
+	c.flush()
+	return c.err
+}
+
+// tail returns the data word holding the last bit of the last slot and the mask of the slot
+// bits in it. The mask is zero if the slots end at a word boundary. The padding of the last
+// block to a cache line is in the tail.
+func (qf *QuotientFilter) tail() (first, slotBits uint64) {
+	n := qf.blockWords(qf.cap-qf.blockLen)*64 + qf.blockLen*(uint64(qf.rwidth)+3)
+	return n / 64, maskLower(n % 64)
+}
+
+// compressor buffers the compressed data, after the first error from emit it does nothing.
+type compressor struct {
+	buf  []byte
+	emit func(b []byte) error
+	err  error
+	// bits not yet written to buf.
+	acc  uint64
+	nacc uint8
+}
+
+func (c *compressor) flush() {
+	if c.err == nil && len(c.buf) > 0 {
+		c.err = c.emit(c.buf)
+	}
+	c.buf = c.buf[:0]
+}
+
+func (c *compressor) maybeFlush() {
+	if len(c.buf) >= chunkWords*8 {
+		c.flush()
+	}
+}
+
+func (c *compressor) uint64(w uint64) {
+	c.buf = binary.LittleEndian.AppendUint64(c.buf, w)
+	c.maybeFlush()
+}
+
+// words writes n words returned by word as run-length words.
+func (c *compressor) words(n int, word func(i int) uint64) {
+	for i := 0; i < n && c.err == nil; {
+		zeros := 0
+		for i+zeros < n && word(i+zeros) == 0 {
+			zeros++
+		}
+		literals := 0
+		for i+zeros+literals < n && word(i+zeros+literals) != 0 {
+			literals++
+		}
+		c.buf = binary.AppendUvarint(c.buf, uint64(zeros))
+		c.buf = binary.AppendUvarint(c.buf, uint64(literals))
+		for j := 0; j < literals; j++ {
+			c.uint64(word(i + zeros + j))
+		}
+		c.maybeFlush()
+		i += zeros + literals
+	}
+}
+
+// bits writes the n lowest bits of v, n is at most 64.
+func (c *compressor) bits(v uint64, n uint8) {
+	v &= maskLower(uint64(n))
+	c.acc |= v << c.nacc
+	if c.nacc+n < 64 {
+		c.nacc += n
+		return
+	}
+	c.uint64(c.acc)
+	// shifts by 64 give 0 in Go, so nothing is carried when acc was empty.
+	c.acc = v >> (64 - c.nacc)
+	c.nacc = c.nacc + n - 64
+}
+
+// pad writes the pending bits padded to a whole byte.
+func (c *compressor) pad() {
+	for ; c.nacc > 0; c.nacc -= min(c.nacc, 8) {
+		c.buf = append(c.buf, byte(c.acc))
+		c.acc >>= 8
+	}
+	c.acc = 0
+	c.maybeFlush()
+}
+
+// decompress decodes the compressed data of the filter, which has nil data, from d. The
+// tail covers the encoded data words, past the data of the filter for older versions.
+// The data is allocated chunk by chunk as it is decoded, or page by page as the slots of
+// sparse filters are written.
+func (qf *QuotientFilter) decompress(d *decoder, encoded uint64) {
+	words, _ := uint64Size(qf.qbits, qf.rwidth, qf.cacheLineBlocks)
+	grow := func(n uint64) {
+		if qf.sparse != nil {
+			// the pages the chunks so far allocated have to fit in the limit.
+			d.err = qf.sparse.checkLimit()
+		} else if old := uint64(len(qf.data)); n > old {
+			qf.data = slices.Grow(qf.data, int(n-old))[:n]
+			clear(qf.data[old:])
+		}
+	}
+	used := make([]uint64, compressChunkSlots/64)
+	var packed []byte
+	for start := uint64(0); start < qf.cap && d.err == nil; start += compressChunkSlots {
+		n := min(compressChunkSlots, qf.cap-start)
+		clear(used)
+		nw := int((n + 63) / 64)
+		d.words(nw, func(i int, w uint64) { used[i] = w })
+		if d.err != nil {
+			return
+		}
+		if n%64 != 0 && used[nw-1]>>(n%64) != 0 {
+			d.err = fmt.Errorf("%w: used bits past the last slot", ErrInvalidEncoding)
+			return
+		}
+		count := uint64(0)
+		for _, w := range used[:nw] {
+			count += uint64(bits.OnesCount64(w))
+		}
+		size := (count*uint64(qf.ssize) + 7) / 8
+		// room for reading whole words past the end.
+		packed = slices.Grow(packed[:0], int(size)+8)[:size+8]
+		clear(packed[size:])
+		d.read(packed[:size])
+		if d.err != nil {
+			return
+		}
+		grow(qf.blockWords(start+n) * 8)
+		if d.err != nil {
+			return
+		}
+		br := bitReader{b: packed}
+		for i, w := range used[:nw] {
+			for ; w != 0; w &= w - 1 {
+				s := slot(br.read(qf.ssize))
+				if s == 0 {
+					d.err = fmt.Errorf("%w: empty slot marked as used", ErrInvalidEncoding)
+					return
+				}
+				qf.setSlot(start+uint64(i*64+bits.TrailingZeros64(w)), s)
+			}
+		}
+	}
+	grow(words * 8)
+	if d.err != nil {
+		return
+	}
+	first, slotBits := qf.tail()
+	d.words(int(encoded-first), func(i int, w uint64) {
+		switch j := first + uint64(i); {
+		case i == 0 && w&slotBits != 0:
+			d.err = fmt.Errorf("%w: slot bits in the tail", ErrInvalidEncoding)
+		case j < words:
+			qf.setWord(j, qf.word(j)|w)
+		case w != 0:
+			d.err = fmt.Errorf("%w: data past the table is not zero", ErrInvalidEncoding)
+		}
+	})
+}
+
+// words decodes n run-length words, calling fn with the index of every literal word.
+func (d *decoder) words(n int, fn func(i int, w uint64)) {
+	for i := 0; i < n && d.err == nil; {
+		zeros, literals := d.uvarint(), d.uvarint()
+		if d.err != nil {
+			return
+		}
+		left := uint64(n - i)
+		if zeros+literals == 0 || zeros > left || literals > left-zeros {
+			d.err = fmt.Errorf("%w: invalid run of %d zero and %d literal words", ErrInvalidEncoding, zeros, literals)
+			return
+		}
+		i += int(zeros)
+		for ; literals > 0 && d.err == nil; literals-- {
+			fn(i, d.uint64())
+			i++
+		}
+	}
+}
+
+// bitReader reads the packed slots, b has to have 8 bytes after the last bit.
+type bitReader struct {
+	b   []byte
+	pos uint64
+}
+
+func (br *bitReader) read(n uint8) uint64 {
+	i, off := br.pos/8, br.pos%8
+	v := binary.LittleEndian.Uint64(br.b[i:]) >> off
+	if off+uint64(n) > 64 {
+		v |= uint64(br.b[i+8]) << (64 - off)
+	}
+	br.pos += uint64(n)
+	return v & maskLower(uint64(n))
+}
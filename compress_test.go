@@ -0,0 +1,151 @@
+package qf
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestWriteToCompressed(t *testing.T) {
+	tests := []struct {
+		Name string
+		Opts []Option
+		Fill float64
+	}{
+		{"empty", []Option{WithQR(10, 9)}, 0},
+		{"sparse", []Option{WithQR(16, 9)}, 0.3},
+		{"full", []Option{WithQR(12, 5), WithMaxLoadFactor(1)}, 1},
+		{"word sized slots", []Option{WithQR(3, 61), WithMaxLoadFactor(1)}, 1},
+		{"several chunks", []Option{WithQR(19, 7)}, 0.1},
+		{"everything", []Option{WithQR(8, 20), WithStash(4, 0), WithKeyTransformer("lower", strings.ToLower)}, 0.9},
+	}
+	for _, test := range tests {
+		qf, _ := NewWithOptions(0, test.Opts...)
+		qf.AddNS("users", "42")
+		qf.AddAll(randomItems(int(test.Fill * float64(qf.cap))))
+		var buf bytes.Buffer
+		n, err := qf.WriteToCompressed(&buf)
+		if err != nil || n != int64(buf.Len()) {
+			t.Fatal(test.Name, "unexpected WriteToCompressed result", n, err)
+		}
+		compressed := buf.Bytes()
+		plain, _ := qf.MarshalBinary()
+		// at low fill most slots are empty, the compressed size is compared with the slots alone.
+		if slotBytes := int(qf.cap * uint64(qf.ssize) / 8); test.Fill < 0.5 && len(compressed) > slotBytes/2 {
+			t.Fatal(test.Name, "compressed to", len(compressed), "bytes from", slotBytes)
+		}
+
+		decoded, _ := NewWithOptions(0, WithQR(2, 2), WithKeyTransformer("lower", strings.ToLower))
+		if test.Name != "everything" {
+			decoded = new(QuotientFilter)
+		}
+		if err := decoded.UnmarshalBinary(compressed); err != nil {
+			t.Fatal(test.Name, "unexpected error", err)
+		}
+		// the decoded filter encodes to exactly the same bytes.
+		again, _ := decoded.MarshalBinary()
+		if sha256.Sum256(again) != sha256.Sum256(plain) || !bytes.Equal(decoded.data, qf.data) {
+			t.Fatal(test.Name, "decompressed data differs")
+		}
+		if decoded.Stats() != qf.Stats() || !decoded.ContainsNS("users", "42") {
+			t.Fatal(test.Name, "decoded filter differs", decoded.Stats(), qf.Stats())
+		}
+		streamed := decoded.Clone()
+		if n, err := streamed.ReadFrom(iotest.OneByteReader(bytes.NewReader(compressed))); err != nil || n != int64(len(compressed)) || !bytes.Equal(streamed.data, qf.data) {
+			t.Fatal(test.Name, "unexpected ReadFrom result", n, err)
+		}
+	}
+}
+
+func TestWriteToCompressedBits(t *testing.T) {
+	// empty slots with remainder bits and set bits after the last slot survive compression.
+	qf := MustNew(6, 7)
+	qf.Add("fox")
+	qf.setSlot(10, newSlot(5))
+	qf.data[len(qf.data)-1] = 0x80
+	first, slotBits := qf.tail()
+	qf.setWord(first, qf.word(first)|^slotBits)
+	var buf bytes.Buffer
+	qf.WriteToCompressed(&buf)
+	var decoded QuotientFilter
+	if err := decoded.UnmarshalBinary(buf.Bytes()); err != nil || !bytes.Equal(decoded.data, qf.data) {
+		t.Fatal("Decompressed data differs", err)
+	}
+}
+
+func TestCompressedInvalid(t *testing.T) {
+	qf := MustNew(10, 8)
+	qf.AddAll(randomItems(300))
+	var buf bytes.Buffer
+	qf.WriteToCompressed(&buf)
+	b := buf.Bytes()
+	for _, size := range []int{20, 60, len(b) / 2, len(b) - 1} {
+		if err := new(QuotientFilter).UnmarshalBinary(b[:size]); !errors.Is(err, ErrInvalidEncoding) {
+			t.Fatal("Expected ErrInvalidEncoding for", size, "bytes, got", err)
+		}
+	}
+	flags := len(qf.appendHeader(make([]byte, prefixLen)))
+	corrupt := func(f func(b []byte) []byte) []byte {
+		c := f(append([]byte(nil), b...))
+		reseal(c)
+		return c
+	}
+	tests := []struct {
+		Name string
+		Data []byte
+		Err  error
+	}{
+		{"flags", corrupt(func(b []byte) []byte { b[flags] |= 8; return b }), ErrUnsupportedVersion},
+		{"empty run", corrupt(func(b []byte) []byte {
+			return append(append(b[:flags+1:flags+1], 0, 0), b[flags+1:]...)
+		}), ErrInvalidEncoding},
+		{"long run", corrupt(func(b []byte) []byte {
+			return append(append(b[:flags+1:flags+1], 100, 0), b[flags+1:]...)
+		}), ErrInvalidEncoding},
+	}
+	for _, test := range tests {
+		if err := new(QuotientFilter).UnmarshalBinary(test.Data); !errors.Is(err, test.Err) || !errors.Is(err, ErrInvalidEncoding) {
+			t.Fatal(test.Name, "expected", test.Err, "got", err)
+		}
+	}
+}
+
+func TestGoldenCompressed(t *testing.T) {
+	v := goldenVectors[0]
+	qf := MustNew(v.Q, v.R)
+	qf.AddAll(v.Present)
+	var buf bytes.Buffer
+	qf.WriteToCompressed(&buf)
+	path := filepath.Join("testdata", "v4-q6-r10-compressed.golden")
+	if *update {
+		if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	golden, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), golden) {
+		t.Fatalf("Encoding differs from %s, run go test -update if the format changed on purpose", path)
+	}
+	uncompressed, _ := os.ReadFile(filepath.Join("testdata", v.File))
+	// the compressed slots are the same in version 3, version 2 compresses 8 times the data
+	// words.
+	v3, _ := os.ReadFile(filepath.Join("testdata", "v3-q6-r10-compressed.golden"))
+	v2, _ := os.ReadFile(filepath.Join("testdata", "v2-q6-r10-compressed.golden"))
+	for _, golden := range [][]byte{golden, v3, v2} {
+		var decoded QuotientFilter
+		if err := decoded.UnmarshalBinary(golden); err != nil {
+			t.Fatal("Unexpected error decoding", path, err)
+		}
+		if b, _ := decoded.MarshalBinary(); !bytes.Equal(b, uncompressed) {
+			t.Fatal("Compressed golden file does not decode to", v.File)
+		}
+	}
+}
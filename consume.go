@@ -0,0 +1,71 @@
+package qf
+
+import (
+	"context"
+	"errors"
+)
+
+// consumeBatch is the largest number of keys ConsumeFrom adds at a time.
+const consumeBatch = 512
+
+// ConsumeFrom adds the keys received from the channel until it is closed or ctx is cancelled,
+// and returns the number of keys inserted that were not already present. Keys are added in
+// batches of the keys already waiting in the channel, so senders are only blocked while
+// ConsumeFrom adds the keys received before theirs. It stops with ctx.Err() when ctx is
+// cancelled, all keys received until then have been added, and with the error of the first
+// key that could not be added, such as ErrFull, leaving the rest of the keys in the channel.
+func (qf *QuotientFilter) ConsumeFrom(ctx context.Context, keys <-chan string) (int, error) {
+	return consume(ctx, keys, qf.AddAll)
+}
+
+// ConsumeFrom adds the keys received from the channel, see QuotientFilter.ConsumeFrom. Each
+// batch of keys is added under one write lock.
+func (s *Safe) ConsumeFrom(ctx context.Context, keys <-chan string) (int, error) {
+	return consume(ctx, keys, s.AddAll)
+}
+
+// ConsumeFrom adds the keys received from the channel, see QuotientFilter.ConsumeFrom. Each
+// batch of keys is hashed before any stripe is locked.
+func (s *Striped) ConsumeFrom(ctx context.Context, keys <-chan string) (int, error) {
+	return consume(ctx, keys, s.AddAll)
+}
+
+// consume passes the keys received from the channel to addAll in batches.
+func consume(ctx context.Context, keys <-chan string, addAll func(keys []string) (int, error)) (inserted int, err error) {
+	batch := make([]string, 0, consumeBatch)
+	for {
+		select {
+		case <-ctx.Done():
+			return inserted, ctx.Err()
+		case k, ok := <-keys:
+			if !ok {
+				return inserted, nil
+			}
+			batch = append(batch[:0], k)
+		}
+		open := true
+	receive:
+		for open && len(batch) < cap(batch) {
+			select {
+			case k, ok := <-keys:
+				if open = ok; ok {
+					batch = append(batch, k)
+				}
+			default:
+				break receive
+			}
+		}
+		n, err := addAll(batch)
+		inserted += n
+		if err != nil {
+			var be *BatchError
+			if errors.As(err, &be) {
+				err = be.Err
+			}
+			return inserted, err
+		}
+		if !open {
+			return inserted, nil
+		}
+	}
+}
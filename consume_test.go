@@ -0,0 +1,92 @@
+package qf
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+// produce sends the keys of producers goroutines to the returned channel, which is closed
+// once all are sent or ctx is done.
+func produce(ctx context.Context, producers, perProducer int) (<-chan string, []string) {
+	var keys []string
+	for p := 0; p < producers; p++ {
+		for i := 0; i < perProducer; i++ {
+			keys = append(keys, fmt.Sprint("producer", p, "key", i))
+		}
+	}
+	ch := make(chan string)
+	var wg sync.WaitGroup
+	for p := 0; p < producers; p++ {
+		wg.Add(1)
+		go func(keys []string) {
+			defer wg.Done()
+			for _, k := range keys {
+				select {
+				case ch <- k:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}(keys[p*perProducer : (p+1)*perProducer])
+	}
+	go func() {
+		wg.Wait()
+		close(ch)
+	}()
+	return ch, keys
+}
+
+func TestConsumeFrom(t *testing.T) {
+	plain, _ := NewWithOptions(0, WithQR(16, 16))
+	safe, _ := NewWithOptions(0, WithQR(16, 16))
+	inner, _ := NewWithOptions(0, WithQR(16, 16))
+	striped, _ := NewStriped(inner, 64)
+	for name, c := range map[string]struct {
+		consume  func(ctx context.Context, keys <-chan string) (int, error)
+		contains func(key string) bool
+		len      func() uint64
+	}{
+		"QuotientFilter": {plain.ConsumeFrom, plain.Contains, plain.Len},
+		"Safe":           {NewSafe(safe).ConsumeFrom, safe.Contains, safe.Len},
+		"Striped":        {striped.ConsumeFrom, striped.Contains, striped.Len},
+	} {
+		ch, keys := produce(context.Background(), 24, 500)
+		n, err := c.consume(context.Background(), ch)
+		if err != nil {
+			t.Fatal(name, "unexpected error", err)
+		}
+		if uint64(n) != c.len() || n < len(keys)-10 {
+			t.Fatal(name, "inserted", n, "of", len(keys), "keys, len", c.len())
+		}
+		for _, k := range keys {
+			if !c.contains(k) {
+				t.Fatal(name, "missing", k)
+			}
+		}
+	}
+
+	// ErrFull stops consuming, the keys consumed until then are in the filter.
+	full, _ := New(6, 8)
+	ctx, cancel := context.WithCancel(context.Background())
+	ch, _ := produce(ctx, 4, 100)
+	n, err := full.ConsumeFrom(ctx, ch)
+	cancel()
+	if !errors.Is(err, ErrFull) || uint64(n) != full.Len() || n == 0 {
+		t.Fatal("Consuming into a full filter returned", n, err)
+	}
+
+	// cancelling the context stops consuming.
+	ctx, cancel = context.WithCancel(context.Background())
+	open := make(chan string)
+	go func() {
+		open <- "key"
+		cancel()
+	}()
+	f := MustNew(10, 8)
+	if n, err := f.ConsumeFrom(ctx, open); !errors.Is(err, context.Canceled) || n != 1 || !f.Contains("key") {
+		t.Fatal("Consuming until the context is cancelled returned", n, err)
+	}
+}
@@ -0,0 +1,62 @@
+package qf
+
+import (
+	"errors"
+	"sync/atomic"
+)
+
+// Counters are counts of a filter that can be read while keys are added, see
+// QuotientFilter.Counters.
+type Counters struct {
+	// Len is the number of fingerprints, as returned by Len.
+	Len uint64
+	// Adds is the number of keys added that were not present yet.
+	Adds uint64
+	// Duplicates is the number of keys added that were already present.
+	Duplicates uint64
+	// Full is the number of keys that didn't fit, for which adding returned ErrFull.
+	Full uint64
+}
+
+// counters of a filter, read and written atomically. They are written by the goroutine
+// holding the lock of the filter.
+type counters struct {
+	len, adds, duplicates, full uint64
+}
+
+// add counts the result of adding a key.
+func (c *counters) add(existed bool, err error) {
+	switch {
+	case errors.Is(err, ErrFull):
+		atomic.AddUint64(&c.full, 1)
+	case err != nil:
+	case existed:
+		atomic.AddUint64(&c.duplicates, 1)
+	default:
+		atomic.AddUint64(&c.adds, 1)
+	}
+}
+
+// publishLen makes the number of fingerprints visible to Len and Counters.
+func (qf *QuotientFilter) publishLen() {
+	n := qf.len + uint64(len(qf.stash))
+	atomic.StoreUint64(&qf.counts.len, n)
+	if qf.seq != nil {
+		atomic.StoreUint64(qf.seq.len, n)
+	}
+}
+
+// Counters returns the number of fingerprints and counts of the keys added since the filter
+// was created or decoded. Like Len it takes no lock, so it can be called from any goroutine
+// while another holds the lock of a Safe filter for a long AddAll. Each count is updated at
+// the end of an operation and read on its own: the counts never go back, other than Len for
+// deleted keys, but they may lag the operations in progress by one and can be from slightly
+// different points in time.
+func (qf *QuotientFilter) Counters() Counters {
+	return Counters{
+		Len:        qf.Len(),
+		Adds:       atomic.LoadUint64(&qf.counts.adds),
+		Duplicates: atomic.LoadUint64(&qf.counts.duplicates),
+		Full:       atomic.LoadUint64(&qf.counts.full),
+	}
+}
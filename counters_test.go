@@ -0,0 +1,75 @@
+package qf
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestCounters(t *testing.T) {
+	qf, _ := NewWithOptions(0, WithQR(12, 16))
+	s := NewSafe(qf)
+	const batches, batchSize = 10, 500
+	var done atomic.Bool
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer done.Store(true)
+		for b := 0; b < batches; b++ {
+			keys := make([]string, batchSize)
+			for i := range keys {
+				// every other batch repeats the keys of the one before.
+				keys[i] = fmt.Sprint("batch", b/2*2, "key", i)
+			}
+			if _, err := s.AddAll(keys); err != nil && !errors.Is(err, ErrFull) {
+				t.Error("Unexpected error", err)
+				return
+			}
+		}
+	}()
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var last Counters
+			for !done.Load() {
+				c := s.Counters()
+				if c.Adds < last.Adds || c.Duplicates < last.Duplicates || c.Full < last.Full || c.Len < last.Len {
+					t.Error("Counters went back from", last, "to", c)
+					return
+				}
+				if n := s.Len(); n < c.Len || n > qf.Cap() {
+					t.Error("Len", n, "after Counters.Len", c.Len)
+					return
+				}
+				last = c
+			}
+		}()
+	}
+	wg.Wait()
+
+	c := s.Counters()
+	if c.Len != c.Adds || c.Adds+c.Duplicates+c.Full == 0 {
+		t.Fatal("Counters after adding are", c)
+	}
+	if c.Full == 0 && c.Adds+c.Duplicates != batches*batchSize {
+		t.Fatal(c.Adds, "adds and", c.Duplicates, "duplicates of", batches*batchSize, "keys")
+	}
+
+	full, _ := New(4, 8)
+	for i := 0; i < 32; i++ {
+		full.Add(fmt.Sprint(i))
+	}
+	full.Add("0")
+	if c := full.Counters(); c.Full == 0 || c.Len != full.Len() || c.Len != c.Adds {
+		t.Fatal("Counters of a full filter are", c)
+	}
+	full.Delete("0")
+	full.Reset()
+	if c := full.Counters(); c.Len != 0 || c.Adds == 0 {
+		t.Fatal("Counters after Reset are", c)
+	}
+}
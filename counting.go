@@ -0,0 +1,344 @@
+package qf
+
+import (
+	"container/heap"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// CountingFilter is a quotient filter that counts how many times each key has been added.
+//
+// Counts are stored in the run of the key the same way as in the counting quotient filter,
+// using slots that would otherwise hold remainders, so the memory used is proportional
+// to the number of distinct keys plus the size of their counts. For a remainder x:
+//
+//	count 1:   x
+//	count 2:   x x
+//	count > 2: x c... x       for x > 0
+//	count > 2: 0 0 0 c... 0   for x = 0
+//
+// where c... are the digits of count - 3 in base 2^r - 1, most significant first.
+// Digits skip the value x so the closing x is unambiguous, and the first digit after x
+// is always less than x, which can't happen for the next remainder of a sorted run.
+// Counts saturate at math.MaxUint64, a saturated count is never decremented.
+type CountingFilter struct {
+	qf *QuotientFilter
+	// number of distinct fingerprints.
+	distinct uint64
+	// scratch space for encoding counters.
+	digits []uint64
+	enc    []uint64
+}
+
+// NewCounting returns a CountingFilter with q quotient and r remainder bits.
+// r has to be at least 2 for the counter digits.
+func NewCounting(q, r uint8) (*CountingFilter, error) {
+	if r < 2 {
+		return nil, fmt.Errorf("r %d has to be at least 2 for counting", r)
+	}
+	qf, err := New(q, r)
+	if err != nil {
+		return nil, err
+	}
+	return &CountingFilter{qf: qf}, nil
+}
+
+// Len returns the number of distinct fingerprints in the filter.
+func (c *CountingFilter) Len() uint64 {
+	return c.distinct
+}
+
+// Add increments the count of the key. It returns ErrFull if the count needs more
+// slots than the max load factor allows.
+func (c *CountingFilter) Add(key string) error {
+	return c.add(c.qf.quotientAndRemainder(c.qf.hashString(key)))
+}
+
+// Count returns the number of times the key has been added, counts of keys sharing a
+// fingerprint are combined so the count can be higher than the real one but never lower.
+func (c *CountingFilter) Count(key string) uint64 {
+	return c.count(c.qf.quotientAndRemainder(c.qf.hashString(key)))
+}
+
+// Contains checks if the key has a count above zero.
+func (c *CountingFilter) Contains(key string) bool {
+	return c.Count(key) > 0
+}
+
+// Delete decrements the count of the key and reports whether the key was present.
+// Deleting a key with a zero count does nothing.
+func (c *CountingFilter) Delete(key string) bool {
+	return c.remove(c.qf.quotientAndRemainder(c.qf.hashString(key)))
+}
+
+// Validate checks the table of the filter like QuotientFilter.Validate, except for the order
+// of the slots in a run, which hold the digits of the counters.
+func (c *CountingFilter) Validate() error {
+	return c.qf.validate(false)
+}
+
+// FingerprintCount is a fingerprint, quotient << r | remainder, and its count.
+type FingerprintCount struct {
+	Fingerprint uint64
+	Count       uint64
+}
+
+// TopK returns the k fingerprints with the highest counts, highest first.
+// Fingerprints with equal counts are ordered by fingerprint. If the filter holds
+// fewer than k fingerprints all of them are returned.
+func (c *CountingFilter) TopK(k int) []FingerprintCount {
+	if k <= 0 {
+		return nil
+	}
+	top := make(topK, 0, k)
+	c.forEach(func(q, r, count uint64) {
+		fc := FingerprintCount{Fingerprint: q<<c.qf.rbits | r, Count: count}
+		if len(top) < k {
+			heap.Push(&top, fc)
+		} else if top.less(top[0], fc) {
+			top[0] = fc
+			heap.Fix(&top, 0)
+		}
+	})
+	sort.Slice(top, func(i, j int) bool { return top.less(top[j], top[i]) })
+	return top
+}
+
+// topK is a min heap of the highest counts seen.
+type topK []FingerprintCount
+
+func (t topK) less(a, b FingerprintCount) bool {
+	if a.Count != b.Count {
+		return a.Count < b.Count
+	}
+	return a.Fingerprint > b.Fingerprint
+}
+func (t topK) Len() int            { return len(t) }
+func (t topK) Less(i, j int) bool  { return t.less(t[i], t[j]) }
+func (t topK) Swap(i, j int)       { t[i], t[j] = t[j], t[i] }
+func (t *topK) Push(x interface{}) { *t = append(*t, x.(FingerprintCount)) }
+func (t *topK) Pop() interface{} {
+	old := *t
+	x := old[len(old)-1]
+	*t = old[:len(old)-1]
+	return x
+}
+
+// forEach calls fn with the quotient, remainder and count of every fingerprint in the filter.
+func (c *CountingFilter) forEach(fn func(q, r, count uint64)) {
+	qf := c.qf
+	if qf.len == 0 {
+		return
+	}
+	// start from a cluster start, so that runs can be matched with their quotients.
+	start := qf.nextClusterStart(0)
+	quotient := start
+	index := start
+	for visited := uint64(0); visited < qf.cap; {
+		s := qf.getSlot(index)
+		if s.isEmpty() {
+			used := qf.nextUsed(index)
+			visited += (used - index) & qf.qMask
+			index = used
+			continue
+		}
+		if s.isClusterStart() {
+			quotient = index
+		} else if !s.isContinuation() {
+			quotient = qf.nextOccupied(qf.next(quotient))
+		}
+		count, end := c.decode(index, s.remainder())
+		fn(quotient, s.remainder(), count)
+		visited += (end-index)&qf.qMask + 1
+		index = qf.next(end)
+	}
+}
+
+func (c *CountingFilter) add(q, r uint64) error {
+	qf := c.qf
+	slot := qf.getSlot(q)
+	if !slot.isOccupied() {
+		if qf.len >= qf.maxLen {
+			return ErrFull
+		}
+		if slot.isEmpty() {
+			qf.setSlot(q, newSlot(r).setOccupied())
+			qf.len++
+		} else {
+			qf.setSlot(q, slot.setOccupied())
+			start := qf.findRun(q)
+			qf.insertAt(q, start, start, r, true)
+		}
+		c.distinct++
+		return nil
+	}
+	start, index, end, count, found := c.find(q, r)
+	if !found {
+		if qf.len >= qf.maxLen {
+			return ErrFull
+		}
+		qf.insertAt(q, start, index, r, false)
+		c.distinct++
+		return nil
+	}
+	if count == math.MaxUint64 {
+		return nil
+	}
+	return c.setCount(q, start, index, end, r, count+1)
+}
+
+func (c *CountingFilter) count(q, r uint64) uint64 {
+	if !c.qf.getSlot(q).isOccupied() {
+		return 0
+	}
+	_, _, _, count, found := c.find(q, r)
+	if !found {
+		return 0
+	}
+	return count
+}
+
+func (c *CountingFilter) remove(q, r uint64) bool {
+	if !c.qf.getSlot(q).isOccupied() {
+		return false
+	}
+	start, index, end, count, found := c.find(q, r)
+	if !found {
+		return false
+	}
+	if count == math.MaxUint64 {
+		return true
+	}
+	if count == 1 {
+		c.distinct--
+	}
+	// shrinking a counter never needs more slots.
+	c.setCount(q, start, index, end, r, count-1)
+	return true
+}
+
+// find locates the counter of remainder r in the run of quotient q, which has to be occupied.
+// If found, the counter takes the slots from index to end. Otherwise index is where
+// the counter for r would be inserted.
+func (c *CountingFilter) find(q, r uint64) (start, index, end, count uint64, found bool) {
+	qf := c.qf
+	start = qf.findRun(q)
+	index = start
+	for {
+		x := qf.getSlot(index).remainder()
+		if x > r {
+			return start, index, 0, 0, false
+		}
+		count, end = c.decode(index, x)
+		if x == r {
+			return start, index, end, count, true
+		}
+		next := qf.next(end)
+		if !qf.getSlot(next).isContinuation() {
+			return start, next, 0, 0, false
+		}
+		index = next
+	}
+}
+
+// decode decodes the counter for remainder x starting at index, end is its last slot.
+func (c *CountingFilter) decode(index, x uint64) (count, end uint64) {
+	qf := c.qf
+	// next returns the remainder in the slot after i, ok is false at the end of the run.
+	next := func(i uint64) (uint64, bool) {
+		s := qf.getSlot(qf.next(i))
+		return s.remainder(), s.isContinuation()
+	}
+	v, ok := next(index)
+	switch {
+	case !ok || v > x:
+		return 1, index
+	case x > 0 && v == x:
+		return 2, qf.next(index)
+	case x == 0:
+		// v == 0, a third zero starts the digits.
+		second := qf.next(index)
+		if w, ok := next(second); !ok || w != 0 {
+			return 2, second
+		}
+		index = qf.next(second)
+	}
+	// digits until the closing x.
+	base := qf.rMask
+	var value uint64
+	for {
+		index = qf.next(index)
+		d := qf.getSlot(index).remainder()
+		if d == x {
+			if value > math.MaxUint64-3 {
+				return math.MaxUint64, index
+			}
+			return value + 3, index
+		}
+		if x == 0 || d > x {
+			d--
+		}
+		value = value*base + d
+	}
+}
+
+// encode returns the slots for remainder x with count.
+func (c *CountingFilter) encode(x, count uint64) []uint64 {
+	enc := c.enc[:0]
+	switch count {
+	case 0:
+		return enc
+	case 1:
+		return append(enc, x)
+	case 2:
+		return append(enc, x, x)
+	}
+	base := c.qf.rMask
+	digits := c.digits[:0]
+	for v := count - 3; ; v /= base {
+		d := v % base
+		if x == 0 || d >= x {
+			d++
+		}
+		digits = append(digits, d)
+		if v < base {
+			break
+		}
+	}
+	enc = append(enc, x)
+	if x == 0 {
+		enc = append(enc, 0, 0)
+	} else if digits[len(digits)-1] > x {
+		// the first digit has to be less than x, a leading zero does not change the value.
+		enc = append(enc, 0)
+	}
+	for i := len(digits) - 1; i >= 0; i-- {
+		enc = append(enc, digits[i])
+	}
+	enc = append(enc, x)
+	c.digits, c.enc = digits, enc
+	return enc
+}
+
+// setCount replaces the counter of remainder x taking the slots from index to end with count.
+func (c *CountingFilter) setCount(q, start, index, end, x, count uint64) error {
+	qf := c.qf
+	enc := c.encode(x, count)
+	n := int((end-index)&qf.qMask) + 1
+	if len(enc) > n && qf.len+uint64(len(enc)-n) > qf.maxLen {
+		return ErrFull
+	}
+	i := 0
+	for ; i < n && i < len(enc); i++ {
+		pos := (index + uint64(i)) & qf.qMask
+		qf.setSlot(pos, qf.getSlot(pos)&7|newSlot(enc[i]))
+	}
+	for ; i < len(enc); i++ {
+		qf.insertAt(q, start, (index+uint64(i))&qf.qMask, enc[i], false)
+	}
+	for ; i < n; i++ {
+		qf.removeAt(q, start, (index+uint64(len(enc)))&qf.qMask)
+	}
+	return nil
+}
@@ -0,0 +1,172 @@
+package qf
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestCounting(t *testing.T) {
+	c, err := NewCounting(12, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	items := generateItems(300)
+	want := map[string]uint64{}
+	for i, s := range items {
+		n := uint64(1 + i%40)
+		if i%50 == 0 {
+			n = 3000
+		}
+		for j := uint64(0); j < n; j++ {
+			if err := c.Add(s); err != nil {
+				t.Fatal("Unexpected error", err)
+			}
+		}
+		want[s] = n
+	}
+	if c.Len() > 300 {
+		t.Fatal("Unexpected len", c.Len())
+	}
+	for s, n := range want {
+		// colliding fingerprints only ever increase the count.
+		if got := c.Count(s); got < n || got > n+3000 {
+			t.Fatal("Count for", s, "is", got, "expected", n)
+		}
+	}
+	for _, s := range items {
+		for c.Delete(s) {
+		}
+		if c.Contains(s) {
+			t.Fatal("Key still present after deleting every count", s)
+		}
+	}
+	if c.Len() != 0 || c.qf.Len() != 0 {
+		t.Fatal("Filter not empty after deleting every count", c.Len(), c.qf.Len())
+	}
+	if c.Delete(items[0]) {
+		t.Fatal("Delete on a zero count should do nothing")
+	}
+}
+
+func TestCountingRandomized(t *testing.T) {
+	for round := 0; round < 100; round++ {
+		r := uint8(2 + rand.Intn(3))
+		c, _ := NewCounting(4, r)
+		c.qf.maxLoad, c.qf.maxLen = 1, c.qf.cap
+		want := map[[2]uint64]uint64{}
+		for ops := 0; ops < 400; ops++ {
+			fp := [2]uint64{uint64(rand.Intn(16)), uint64(rand.Intn(1 << r))}
+			if rand.Intn(5) < 3 {
+				// jump the count sometimes to get multi digit counters.
+				n := uint64(1)
+				if rand.Intn(10) == 0 {
+					n = uint64(rand.Intn(200))
+				}
+				for i := uint64(0); i < n; i++ {
+					if c.add(fp[0], fp[1]) != nil {
+						break
+					}
+					want[fp]++
+				}
+			} else if c.remove(fp[0], fp[1]) != (want[fp] > 0) {
+				t.Fatal("remove returned", want[fp] == 0, "for", fp)
+			} else if want[fp] > 0 {
+				want[fp]--
+			}
+			checkCounts(t, c, want)
+		}
+	}
+}
+
+func TestCountingSaturates(t *testing.T) {
+	c, _ := NewCounting(6, 4)
+	c.Add("fox")
+	q, r := c.qf.Fingerprint("fox")
+	start, index, end, _, _ := c.find(q, r)
+	c.setCount(q, start, index, end, r, math.MaxUint64-1)
+	for i := 0; i < 3; i++ {
+		c.Add("fox")
+	}
+	if c.Count("fox") != math.MaxUint64 {
+		t.Fatal("Count did not saturate", c.Count("fox"))
+	}
+	if !c.Delete("fox") || c.Count("fox") != math.MaxUint64 {
+		t.Fatal("Saturated count should not be decremented", c.Count("fox"))
+	}
+}
+
+func TestTopK(t *testing.T) {
+	c, _ := NewCounting(14, 12)
+	zipf := rand.NewZipf(rand.New(rand.NewSource(1)), 1.3, 1, 10000)
+	for i := 0; i < 200000; i++ {
+		c.Add(fmt.Sprintf("key:%d", zipf.Uint64()))
+	}
+	top := c.TopK(10)
+	if len(top) != 10 {
+		t.Fatal("Expected 10 results, got", len(top))
+	}
+	for i := 1; i < len(top); i++ {
+		if top[i].Count > top[i-1].Count {
+			t.Fatal("TopK not sorted by count", top)
+		}
+	}
+	// the head of the distribution is far ahead of the rest.
+	for i := 0; i < 5; i++ {
+		key := fmt.Sprintf("key:%d", i)
+		if top[i].Fingerprint != c.qf.Fingerprint64(key) || top[i].Count != c.Count(key) {
+			t.Fatal("Expected", key, "at position", i, "got", top[i])
+		}
+	}
+
+	small, _ := NewCounting(6, 8)
+	for _, s := range []string{"a", "b", "c"} {
+		small.Add(s)
+		small.Add(s)
+	}
+	small.Add("d")
+	all := small.TopK(10)
+	if len(all) != 4 || all[3].Fingerprint != small.qf.Fingerprint64("d") || all[3].Count != 1 {
+		t.Fatal("Unexpected TopK for k larger than the filter", all)
+	}
+	for i := 0; i < 2; i++ {
+		// ties are broken by fingerprint.
+		if all[i].Count != 2 || all[i].Fingerprint > all[i+1].Fingerprint {
+			t.Fatal("Unexpected tie order", all)
+		}
+	}
+	if small.TopK(0) != nil {
+		t.Fatal("Expected no results for k 0")
+	}
+}
+
+func TestCountingInvalid(t *testing.T) {
+	if _, err := NewCounting(8, 1); err == nil {
+		t.Fatal("Expected an error for r 1")
+	}
+	if _, err := NewCounting(1, 8); err == nil {
+		t.Fatal("Expected an error for q 1")
+	}
+}
+
+// checkCounts verifies the count of every fingerprint of a small filter.
+func checkCounts(t *testing.T, c *CountingFilter, want map[[2]uint64]uint64) {
+	t.Helper()
+	var distinct uint64
+	for q := uint64(0); q < c.qf.cap; q++ {
+		for r := uint64(0); r <= c.qf.rMask; r++ {
+			fp := [2]uint64{q, r}
+			if got := c.count(q, r); got != want[fp] {
+				c.qf.info()
+				t.Fatal("Count for", fp, "is", got, "expected", want[fp])
+			}
+			if want[fp] > 0 {
+				distinct++
+			}
+		}
+	}
+	if c.Len() != distinct {
+		t.Fatal("Len is", c.Len(), "expected", distinct)
+	}
+}
@@ -0,0 +1,133 @@
+package qf
+
+import (
+	"fmt"
+	"math"
+	"math/bits"
+	"slices"
+)
+
+// Diagnostics is a report of how evenly the fingerprints of a filter spread over its
+// quotients and remainder bits, to tell a hash function that doesn't suit the keys from a
+// false positive rate that is just bad luck. See QuotientFilter.Diagnostics.
+type Diagnostics struct {
+	// Fingerprints is the number of fingerprints, in the table and the stash.
+	Fingerprints uint64
+	// QuotientChiSquared is the chi-squared statistic of the number of fingerprints in
+	// Buckets buckets of consecutive quotients against a uniform distribution, which has
+	// Buckets - 1 degrees of freedom. The buckets hold at least 16 fingerprints on average.
+	QuotientChiSquared float64
+	Buckets            uint64
+	// RemainderEntropy is the sum of the entropies of the r remainder bits, r for bits that
+	// are each set in half the remainders. MinBitEntropy is the lowest entropy of a bit.
+	RemainderEntropy float64
+	MinBitEntropy    float64
+	// LongestCluster is the number of slots of the longest cluster.
+	LongestCluster uint64
+}
+
+// Err returns an error wrapping ErrWeakHash if the report is unlikely for a hash function
+// spreading the keys uniformly: the chi-squared statistic or the number of remainders
+// setting a bit are more than 6 standard deviations off. Small filters pass either way.
+func (d Diagnostics) Err() error {
+	if df := float64(d.Buckets - 1); df > 0 && d.QuotientChiSquared > df+6*math.Sqrt(2*df) {
+		return fmt.Errorf("%w: chi-squared %.1f of the quotients in %d buckets is more than %.1f", ErrWeakHash, d.QuotientChiSquared, d.Buckets, df+6*math.Sqrt(2*df))
+	}
+	// a bit set in 1/2 + 3/sqrt(n) of the remainders has an entropy of 1 - 18/(n ln 2).
+	if limit := 1 - 18/(float64(d.Fingerprints)*math.Ln2); d.Fingerprints > 0 && d.MinBitEntropy < limit {
+		return fmt.Errorf("%w: a remainder bit has an entropy of %.4f, less than %.4f", ErrWeakHash, d.MinBitEntropy, limit)
+	}
+	return nil
+}
+
+// Diagnostics scans the table and returns the report of how evenly its fingerprints
+// spread, see Diagnostics.Err for the thresholds of a hash function that suits the keys.
+func (qf *QuotientFilter) Diagnostics() Diagnostics {
+	qf.mu.Lock()
+	defer qf.mu.Unlock()
+	n := qf.len + uint64(len(qf.stash))
+	d := Diagnostics{Fingerprints: n, Buckets: 1, LongestCluster: qf.longestCluster()}
+	if n == 0 {
+		return d
+	}
+	for d.Buckets < qf.cap && d.Buckets*32 <= n {
+		d.Buckets *= 2
+	}
+	shift := qf.qbits - uint8(bits.TrailingZeros64(d.Buckets))
+	counts, ones := make([]uint64, d.Buckets), make([]uint64, qf.rbits)
+	add := func(q, r uint64) {
+		counts[q>>shift]++
+		for b := range ones {
+			ones[b] += r >> b & 1
+		}
+	}
+	qf.forEach(add)
+	for _, fp := range qf.stash {
+		add(fp>>qf.rbits&qf.qMask, fp&qf.rMask)
+	}
+	expected := float64(n) / float64(d.Buckets)
+	for _, c := range counts {
+		d.QuotientChiSquared += (float64(c) - expected) * (float64(c) - expected) / expected
+	}
+	d.MinBitEntropy = 1
+	for _, c := range ones {
+		e := bitEntropy(float64(c) / float64(n))
+		d.RemainderEntropy += e
+		d.MinBitEntropy = min(d.MinBitEntropy, e)
+	}
+	return d
+}
+
+// longestCluster returns the number of slots of the longest cluster, counting the cluster
+// wrapping around the end of the table as one.
+func (qf *QuotientFilter) longestCluster() uint64 {
+	start := uint64(0)
+	for start < qf.cap && !qf.getSlot(start).isEmpty() {
+		start++
+	}
+	if start == qf.cap {
+		return qf.cap
+	}
+	var longest, n uint64
+	for i := range qf.cap {
+		if qf.getSlot((start + i) & qf.qMask).isEmpty() {
+			n = 0
+		} else {
+			n++
+			longest = max(longest, n)
+		}
+	}
+	return longest
+}
+
+// bitEntropy returns the entropy in bits of a bit that is set with probability p.
+func bitEntropy(p float64) float64 {
+	if p <= 0 || p >= 1 {
+		return 0
+	}
+	return -p*math.Log2(p) - (1-p)*math.Log2(1-p)
+}
+
+// CheckHashQuality hashes the sample keys with h into the fingerprints of a filter sized
+// for them with the default false positive rate, and returns their Diagnostics and its Err,
+// to check a hash function before committing to it. The sample should hold a few thousand
+// keys like the ones the filter will hold.
+func CheckHashQuality(h func([]byte) uint64, sample []string) (Diagnostics, error) {
+	c := defaultConfig()
+	c.capacity = len(sample)
+	if err := c.sizeQR(); err != nil {
+		return Diagnostics{}, err
+	}
+	mask := maskLower(uint64(c.q + c.r))
+	fps := make([]uint64, len(sample))
+	for i, k := range sample {
+		fps[i] = h([]byte(k)) & mask
+	}
+	slices.Sort(fps)
+	qf, err := BuildFromSorted(c.q, c.r, fps)
+	if err != nil {
+		return Diagnostics{}, err
+	}
+	d := qf.Diagnostics()
+	return d, d.Err()
+}
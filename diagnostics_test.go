@@ -0,0 +1,47 @@
+package qf
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestDiagnostics(t *testing.T) {
+	qf, _ := NewWithOptions(20000)
+	if d := qf.Diagnostics(); d.Fingerprints != 0 || d.LongestCluster != 0 || d.Err() != nil {
+		t.Fatal("Unexpected diagnostics of an empty filter", d)
+	}
+	qf.AddAll(randomItems(20000))
+	d := qf.Diagnostics()
+	if err := d.Err(); err != nil || d.Fingerprints != qf.Len() || d.Buckets < 512 || d.LongestCluster < 2 {
+		t.Fatal("Unexpected diagnostics of FNV-64a", d, err)
+	}
+	if df := float64(d.Buckets - 1); d.QuotientChiSquared > 1.5*df || d.RemainderEntropy < float64(qf.rbits)-0.05 {
+		t.Fatal("FNV-64a does not pass comfortably", d)
+	}
+	if s := NewSafe(qf).Diagnostics(); s != d {
+		t.Fatal("Diagnostics of Safe differ", s, d)
+	}
+
+	sample := randomItems(5000)
+	fnv := func(b []byte) uint64 { return fnv64a(fnvOffset64, b) }
+	if d, err := CheckHashQuality(fnv, sample); err != nil || d.Fingerprints < 4900 {
+		t.Fatal("FNV-64a failed the check", d, err)
+	}
+	for name, h := range map[string]func([]byte) uint64{
+		// the top quotient bits are always zero.
+		"constant top bits":   func(b []byte) uint64 { return fnv(b) & (1<<12 - 1) },
+		"stuck remainder bit": func(b []byte) uint64 { return fnv(b) | 1<<3 },
+		// the lowest bit is set in 7 of 8 remainders.
+		"biased remainder bit": func(b []byte) uint64 {
+			h := fnv(b)
+			if h>>40&3 != 0 {
+				h |= 1
+			}
+			return h
+		},
+	} {
+		if d, err := CheckHashQuality(h, sample); !errors.Is(err, ErrWeakHash) {
+			t.Fatal(name, "passed the check", d)
+		}
+	}
+}
@@ -0,0 +1,270 @@
+package qf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+// DefaultDiffBlockSize is the block size of Checkpoint for filters without dirty tracking.
+const DefaultDiffBlockSize = 4096
+
+// ErrDiffBase is returned by ApplyDiff when the filter is not the one the diff was made
+// against, and by Diff when the digest is not of a filter with the same parameters.
+var ErrDiffBase = errors.New("diff does not apply to the filter")
+
+// Digest holds the checksums of the blocks of the data of a filter at one point in time.
+// A receiver sends the digest of its copy of a filter to the sender, see Diff.
+type Digest struct {
+	q, r      uint8
+	blockSize int
+	crcs      []uint32
+}
+
+// Checkpoint returns the digest of the data of the filter. The blocks are the ones of dirty
+// tracking, see WithDirtyTracking, and the checksums of the blocks not modified since the
+// last Sync or Checkpoint are not computed again. Without dirty tracking the blocks are
+// DefaultDiffBlockSize bytes.
+func (qf *QuotientFilter) Checkpoint() Digest {
+	size := DefaultDiffBlockSize
+	if qf.dirty != nil {
+		size = qf.dirty.size
+	}
+	return Digest{q: qf.qbits, r: qf.rbits, blockSize: size, crcs: qf.blockCRCs(size)}
+}
+
+// blockCRCs returns the checksums of the blocks of size bytes of the data.
+func (qf *QuotientFilter) blockCRCs(size int) []uint32 {
+	d := qf.dirty
+	cached := d != nil && d.size == size
+	crcs := make([]uint32, (qf.words()*8+size-1)/size)
+	for b := range crcs {
+		if cached && d.valid && !d.isDirty(b) {
+			crcs[b] = d.crcs[b]
+			continue
+		}
+		crcs[b] = crc32.Checksum(qf.block(b, size), castagnoli)
+	}
+	if cached {
+		copy(d.crcs, crcs)
+		d.valid = true
+	}
+	return crcs
+}
+
+// sum returns the checksum of the block checksums, which identifies the data of the digest.
+func (d Digest) sum() uint32 {
+	crc := uint32(0)
+	var buf [4]byte
+	for _, c := range d.crcs {
+		binary.LittleEndian.PutUint32(buf[:], c)
+		crc = crc32.Update(crc, castagnoli, buf[:])
+	}
+	return crc
+}
+
+// The binary encoding of a digest:
+//
+//	magic      "QFGD"
+//	q, r       1 byte each
+//	block size uvarint
+//	checksums  uvarint count and count 4 byte block checksums
+const digestMagic = "QFGD"
+
+// MarshalBinary encodes the digest so that it can be sent to the filter's sender.
+func (d Digest) MarshalBinary() ([]byte, error) {
+	buf := append(make([]byte, 0, 16+len(d.crcs)*4), digestMagic...)
+	buf = append(buf, d.q, d.r)
+	buf = binary.AppendUvarint(buf, uint64(d.blockSize))
+	buf = binary.AppendUvarint(buf, uint64(len(d.crcs)))
+	for _, c := range d.crcs {
+		buf = binary.LittleEndian.AppendUint32(buf, c)
+	}
+	return buf, nil
+}
+
+// UnmarshalBinary decodes a digest encoded with MarshalBinary.
+func (d *Digest) UnmarshalBinary(data []byte) error {
+	if !bytes.HasPrefix(data, []byte(digestMagic)) {
+		return fmt.Errorf("%w: %w", ErrInvalidEncoding, ErrBadMagic)
+	}
+	dec := decoder{r: bytes.NewReader(data[len(digestMagic):])}
+	q, r := dec.byte(), dec.byte()
+	size, count := dec.uvarint(), dec.uvarint()
+	if dec.err != nil {
+		return dec.err
+	}
+	if err := validateQRBits(q, r, 128); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidEncoding, err)
+	}
+	if size == 0 || size%8 != 0 || size > 1<<40 {
+		return fmt.Errorf("%w: block size %d", ErrInvalidEncoding, size)
+	}
+	if left := uint64(len(data)) - uint64(len(digestMagic)) - uint64(dec.n); count > left/4 || left != count*4 {
+		return fmt.Errorf("%w: %d bytes for %d checksums", ErrInvalidEncoding, left, count)
+	}
+	crcs := make([]uint32, count)
+	for i := range crcs {
+		dec.read(dec.buf[:4])
+		crcs[i] = binary.LittleEndian.Uint32(dec.buf[:4])
+	}
+	*d = Digest{q: q, r: r, blockSize: int(size), crcs: crcs}
+	return nil
+}
+
+// The binary encoding of a diff, all integers are little-endian:
+//
+//	magic      "QFDF"
+//	checksum   4 bytes, CRC-32C of everything after it
+//	base       4 bytes, checksum of the block checksums of the digest it was made against
+//	block size uvarint
+//	header     the header of the binary encoding of the filter, version 1
+//	blocks     uvarint count, each as uvarint block index and the data bytes of the block
+const (
+	diffMagic     = "QFDF"
+	diffPrefixLen = 8
+)
+
+// Diff returns the blocks of the data that changed since the filter was at the state of
+// since, together with the rest of the filter, len, the stash and namespaces, so that
+// ApplyDiff turns a filter at that state into a copy of this one. The diff is a fraction
+// of the binary encoding when only a few blocks changed. since has to be the digest of a
+// filter with the same q and r.
+func (qf *QuotientFilter) Diff(since Digest) ([]byte, error) {
+	if since.q != qf.qbits || since.r != qf.rbits || since.blockSize <= 0 {
+		return nil, fmt.Errorf("%w: digest of q %d and r %d, the filter has q %d and r %d", ErrDiffBase, since.q, since.r, qf.qbits, qf.rbits)
+	}
+	crcs := qf.blockCRCs(since.blockSize)
+	if len(crcs) != len(since.crcs) {
+		return nil, fmt.Errorf("%w: digest of %d blocks, the filter has %d", ErrDiffBase, len(since.crcs), len(crcs))
+	}
+	var changed []int
+	for b, crc := range crcs {
+		if crc != since.crcs[b] {
+			changed = append(changed, b)
+		}
+	}
+	buf := make([]byte, diffPrefixLen, 128+len(changed)*(since.blockSize+4))
+	buf = binary.LittleEndian.AppendUint32(buf, since.sum())
+	buf = binary.AppendUvarint(buf, uint64(since.blockSize))
+	buf = qf.appendHeader(buf)
+	buf = binary.AppendUvarint(buf, uint64(len(changed)))
+	for _, b := range changed {
+		buf = binary.AppendUvarint(buf, uint64(b))
+		buf = append(buf, qf.block(b, since.blockSize)...)
+	}
+	copy(buf, diffMagic)
+	binary.LittleEndian.PutUint32(buf[4:], crc32.Checksum(buf[diffPrefixLen:], castagnoli))
+	return buf, nil
+}
+
+// ApplyDiff applies a diff made by Diff against the digest of the filter. It returns an error
+// wrapping ErrDiffBase if the data of the filter is not what it was when its digest was made,
+// the checksums of the blocks are compared rather than trusting the diff. Like UnmarshalBinary
+// the diff is validated, including the table it produces, and the filter is left unchanged
+// on errors. The modified blocks are marked for Sync with dirty tracking.
+func (qf *QuotientFilter) ApplyDiff(diff []byte) error {
+	if qf.readOnly {
+		return qf.errReadOnly()
+	}
+	if len(diff) < diffPrefixLen+4 || string(diff[:4]) != diffMagic {
+		return fmt.Errorf("%w: not a filter diff", ErrInvalidEncoding)
+	}
+	if crc32.Checksum(diff[diffPrefixLen:], castagnoli) != binary.LittleEndian.Uint32(diff[4:]) {
+		return fmt.Errorf("%w: %w", ErrInvalidEncoding, ErrChecksum)
+	}
+	base := binary.LittleEndian.Uint32(diff[diffPrefixLen:])
+	r := bytes.NewReader(diff[diffPrefixLen+4:])
+	d := decoder{r: r}
+	size := d.uvarint()
+	if d.err != nil {
+		return d.err
+	}
+	h, _, err := decodeHeader(r, encodingVersionLegacy)
+	if err != nil {
+		return err
+	}
+	if h.q != qf.qbits || h.r != qf.rbits {
+		return fmt.Errorf("%w: diff of q %d and r %d, the filter has q %d and r %d", ErrDiffBase, h.q, h.r, qf.qbits, qf.rbits)
+	}
+	if size == 0 || size%8 != 0 || size > 1<<40 {
+		return fmt.Errorf("%w: block size %d", ErrInvalidEncoding, size)
+	}
+	// the header of a diff has no flags, the data keeps the layout of the filter.
+	h.flags = qf.layoutFlags()
+	decoded, err := qf.fromHeader(h)
+	if err != nil {
+		return err
+	}
+	digest := Digest{q: qf.qbits, r: qf.rbits, blockSize: int(size), crcs: qf.blockCRCs(int(size))}
+	if digest.sum() != base {
+		return fmt.Errorf("%w: the data changed since the digest of the diff", ErrDiffBase)
+	}
+
+	// the blocks are checked before any is applied.
+	type change struct {
+		offset   int
+		dst, src []byte
+	}
+	var changes []change
+	next := uint64(0)
+	for count := d.uvarint(); uint64(len(changes)) < count && d.err == nil; {
+		b := d.uvarint()
+		if d.err != nil {
+			break
+		}
+		if b < next || b >= uint64(len(digest.crcs)) {
+			return fmt.Errorf("%w: block %d out of order or past the %d blocks", ErrInvalidEncoding, b, len(digest.crcs))
+		}
+		block := qf.block(int(b), int(size))
+		if r.Len() < len(block) {
+			return fmt.Errorf("%w: unexpected end of data", ErrInvalidEncoding)
+		}
+		pos := len(diff) - r.Len()
+		r.Seek(int64(len(block)), io.SeekCurrent)
+		changes = append(changes, change{int(b) * int(size), block, diff[pos : pos+len(block)]})
+		next = b + 1
+	}
+	if d.err != nil {
+		return d.err
+	}
+	if r.Len() > 0 {
+		return fmt.Errorf("%w: %d bytes after the diff", ErrInvalidEncoding, r.Len())
+	}
+
+	// the blocks are applied in place, the old ones are kept to undo them if the table
+	// turns out to be invalid.
+	qf.beginUpdate()
+	defer qf.endUpdate()
+	old := make([]byte, 0, len(changes)*int(size))
+	for _, c := range changes {
+		old = append(old, c.dst...)
+		qf.writeData(c.offset, c.src)
+	}
+	decoded.data, decoded.sparse = qf.data, qf.sparse
+	err = decoded.checkTable()
+	if err != nil {
+		err = fmt.Errorf("%w: %v", ErrInvalidEncoding, err)
+	} else if qf.sparse != nil {
+		err = qf.sparse.checkLimit()
+	}
+	if err != nil {
+		for _, c := range changes {
+			qf.writeData(c.offset, old[:len(c.dst)])
+			old = old[len(c.dst):]
+		}
+		return err
+	}
+	decoded.dirty, decoded.seq = qf.dirty, qf.seq
+	decoded.buildOffsets()
+	if qf.dirty != nil {
+		for _, c := range changes {
+			qf.dirty.markRange(c.offset, len(c.dst))
+		}
+	}
+	*qf = *decoded
+	return nil
+}
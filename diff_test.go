@@ -0,0 +1,167 @@
+package qf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	sender, _ := NewWithOptions(0, WithQR(19, 9), WithStash(8, 0))
+	sender.AddAll(randomItems(20000))
+	receiver := sender.Clone()
+	full, _ := sender.MarshalBinary()
+	for round := 0; round < 5; round++ {
+		for i := 0; i < 10; i++ {
+			sender.Add(fmt.Sprint("round", round, "key", i))
+		}
+		sender.Delete(fmt.Sprint("round", round-1, "key", 3))
+		sender.AddNS("round", fmt.Sprint(round))
+		diff, err := sender.Diff(receiver.Checkpoint())
+		if err != nil {
+			t.Fatal("Unexpected error", err)
+		}
+		if len(diff) > len(full)/10 {
+			t.Fatal("Diff of", len(diff), "bytes, the filter has", len(full))
+		}
+		if err := receiver.ApplyDiff(diff); err != nil {
+			t.Fatal("Unexpected error", err)
+		}
+		want, _ := sender.MarshalBinary()
+		if got, _ := receiver.MarshalBinary(); !bytes.Equal(got, want) {
+			t.Fatal("Round", round, "receiver differs from the sender")
+		}
+		if !receiver.ContainsNS("round", fmt.Sprint(round)) {
+			t.Fatal("Round", round, "namespace missing")
+		}
+	}
+	// an unchanged filter has an empty diff.
+	diff, _ := sender.Diff(receiver.Checkpoint())
+	if err := receiver.ApplyDiff(diff); err != nil || len(diff) > 200 {
+		t.Fatal("Unexpected diff of", len(diff), "bytes", err)
+	}
+}
+
+func TestApplyDiffBase(t *testing.T) {
+	sender := MustNew(12, 8)
+	sender.AddAll(randomItems(1000))
+	receiver := sender.Clone()
+	digest := receiver.Checkpoint()
+	sender.Add("fox")
+	diff, _ := sender.Diff(digest)
+
+	// the receiver changed after its digest was made.
+	absent := "dog"
+	for receiver.Contains(absent) {
+		absent += "!"
+	}
+	receiver.Add(absent)
+	before, _ := receiver.MarshalBinary()
+	if err := receiver.ApplyDiff(diff); !errors.Is(err, ErrDiffBase) {
+		t.Fatal("Expected ErrDiffBase, got", err)
+	}
+	if after, _ := receiver.MarshalBinary(); !bytes.Equal(after, before) {
+		t.Fatal("Receiver changed by a failed diff")
+	}
+	if _, err := MustNew(12, 9).Diff(digest); !errors.Is(err, ErrDiffBase) {
+		t.Fatal("Expected ErrDiffBase for a digest of other parameters, got", err)
+	}
+	if err := MustNew(12, 9).ApplyDiff(diff); !errors.Is(err, ErrDiffBase) {
+		t.Fatal("Expected ErrDiffBase for a filter of other parameters, got", err)
+	}
+}
+
+func TestApplyDiffInvalid(t *testing.T) {
+	sender := MustNew(10, 8)
+	sender.AddAll(randomItems(200))
+	receiver := sender.Clone()
+	digest := receiver.Checkpoint()
+	// a block the sender can't have produced, a continuation of a run that is not shifted.
+	sender.setSlot(700, newSlot(3).setContinuation())
+	sender.len++
+	diff, _ := sender.Diff(digest)
+	before, _ := receiver.MarshalBinary()
+	reseal := func(b []byte) []byte {
+		binary.LittleEndian.PutUint32(b[4:], crc32.Checksum(b[diffPrefixLen:], castagnoli))
+		return b
+	}
+	tests := []struct {
+		Name string
+		Diff []byte
+		Err  error
+	}{
+		{"empty", nil, ErrInvalidEncoding},
+		{"checksum", append(diff[:len(diff)-1:len(diff)-1], diff[len(diff)-1]^1), ErrChecksum},
+		{"truncated", reseal(append([]byte(nil), diff[:len(diff)-100]...)), ErrInvalidEncoding},
+		{"trailing bytes", reseal(append(append([]byte(nil), diff...), 0)), ErrInvalidEncoding},
+		{"table", diff, ErrInvalidEncoding},
+	}
+	for _, test := range tests {
+		if err := receiver.ApplyDiff(test.Diff); !errors.Is(err, test.Err) {
+			t.Fatal(test.Name, "expected", test.Err, "got", err)
+		}
+		if after, _ := receiver.MarshalBinary(); !bytes.Equal(after, before) {
+			t.Fatal(test.Name, "receiver changed by a failed diff")
+		}
+	}
+}
+
+func TestDiffDirtyTracking(t *testing.T) {
+	// with dirty tracking the digest reuses the block checksums and applied blocks are synced.
+	sender, _ := NewWithOptions(0, WithQR(14, 9), WithDirtyTracking(256))
+	sender.AddAll(randomItems(5000))
+	receiver := sender.Clone()
+	path := filepath.Join(t.TempDir(), "filter.qf")
+	f, _ := os.Create(path)
+	defer f.Close()
+	receiver.Sync(f)
+
+	before := receiver.Checkpoint()
+	sender.AddAll([]string{"fox", "dog", "cat"})
+	digest := receiver.Checkpoint()
+	if !slices.Equal(digest.crcs, before.crcs) || digest.blockSize != 256 {
+		t.Fatal("Unexpected digest of an unchanged filter")
+	}
+	diff, _ := sender.Diff(digest)
+	if err := receiver.ApplyDiff(diff); err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	if n, _ := receiver.DirtyBlocks(); n == 0 || n > 3 {
+		t.Fatal("Unexpected number of dirty blocks", n)
+	}
+	if err := receiver.Sync(f); err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	loaded, err := LoadFromFile(path)
+	if err != nil || !bytes.Equal(loaded.data, sender.data) || !loaded.ContainsAll([]string{"fox", "dog", "cat"}) {
+		t.Fatal("Synced file differs from the sender", err)
+	}
+}
+
+func TestDigestMarshalBinary(t *testing.T) {
+	qf := MustNew(12, 8)
+	qf.AddAll(randomItems(1000))
+	digest := qf.Checkpoint()
+	b, _ := digest.MarshalBinary()
+	var decoded Digest
+	if err := decoded.UnmarshalBinary(b); err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	if decoded.q != 12 || decoded.r != 8 || decoded.blockSize != DefaultDiffBlockSize || !slices.Equal(decoded.crcs, digest.crcs) {
+		t.Fatal("Decoded digest differs")
+	}
+	for _, size := range []int{0, 3, len(b) - 1} {
+		if err := decoded.UnmarshalBinary(b[:size]); !errors.Is(err, ErrInvalidEncoding) {
+			t.Fatal("Expected ErrInvalidEncoding for", size, "bytes, got", err)
+		}
+	}
+	if err := decoded.UnmarshalBinary(append(b, 0)); !errors.Is(err, ErrInvalidEncoding) {
+		t.Fatal("Expected ErrInvalidEncoding for a trailing byte, got", err)
+	}
+}
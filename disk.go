@@ -0,0 +1,457 @@
+package qf
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math/bits"
+	"os"
+	"slices"
+	"unsafe"
+)
+
+// DefaultDiskBufferSize is the buffer size of NewDiskBuilder when it is passed zero.
+const DefaultDiskBufferSize = 64 << 20
+
+// minDiskBufferSize is the smallest buffer NewDiskBuilder accepts.
+const minDiskBufferSize = 1024
+
+// maxSpillPartitions bounds the spill files a DiskBuilder keeps open while keys are added.
+const maxSpillPartitions = 256
+
+// DiskBuilder builds a filter that is too large to build in memory straight into a file. The
+// fingerprints of the keys are written to spill files partitioned by quotient in a temporary
+// directory, Finish sorts each partition and lays the table out with the sequential passes of
+// BuildFromSorted, writing it out region by region. A DiskBuilder is not thread safe.
+type DiskBuilder struct {
+	// parameters and hash function of the filter, it holds no data.
+	qf  *QuotientFilter
+	dir string
+	// fingerprints not spilled yet, then the buffer the partitions are sorted in.
+	buf     []uint64
+	outSize int
+	// spill files of the partitions of step quotients each, created on first use.
+	parts []*os.File
+	step  uint64
+	// sorted files of distinct fingerprints in ascending order, and their number.
+	sorted []string
+	n      uint64
+}
+
+// NewDiskBuilder returns a builder of a filter like New(q, r) keeping its temporary files in a
+// new directory in dir, the system's temporary directory if dir is empty. The builder holds
+// at most bufferSize bytes of fingerprints and output in memory, apart from the slots of the
+// longest cluster of the table, DefaultDiskBufferSize if bufferSize is zero. The temporary
+// files take 8 bytes per key added.
+func NewDiskBuilder(dir string, q, r uint8, bufferSize int) (*DiskBuilder, error) {
+	if bufferSize == 0 {
+		bufferSize = DefaultDiskBufferSize
+	}
+	if bufferSize < minDiskBufferSize {
+		return nil, fmt.Errorf("buffer of %d bytes, the builder needs at least %d", bufferSize, minDiskBufferSize)
+	}
+	if _, err := BufferSize(q, r); err != nil {
+		return nil, err
+	}
+	c := defaultConfig()
+	c.q, c.r, c.noData = q, r, true
+	qf := newFilter(c)
+	tmp, err := os.MkdirTemp(dir, "qf-build-*")
+	if err != nil {
+		return nil, err
+	}
+	// a quarter of the buffer buffers the output file, the rest holds fingerprints.
+	b := &DiskBuilder{qf: qf, dir: tmp, outSize: bufferSize / 4}
+	b.buf = make([]uint64, 0, (bufferSize-b.outSize)/8)
+	// partitions are expected to fit in half the buffer at the max load factor.
+	n := min((qf.maxLen*8+uint64(cap(b.buf))*4-1)/(uint64(cap(b.buf))*4), maxSpillPartitions, qf.cap)
+	b.parts = make([]*os.File, 1<<bits.Len64(max(n, 1)-1))
+	b.step = qf.cap / uint64(len(b.parts))
+	return b, nil
+}
+
+// Add adds the key to the filter being built.
+func (b *DiskBuilder) Add(key string) error {
+	return b.AddHash(b.qf.hashString(key))
+}
+
+// AddHash adds a key that has already been hashed to h, see QuotientFilter.AddHash.
+func (b *DiskBuilder) AddHash(h uint64) error {
+	if b.parts == nil {
+		return errBuilderDone
+	}
+	b.buf = append(b.buf, h&maskLower(uint64(b.qf.qbits)+uint64(b.qf.rbits)))
+	if len(b.buf) == cap(b.buf) {
+		return b.flush()
+	}
+	return nil
+}
+
+var errBuilderDone = errors.New("builder is finished or closed")
+
+// flush spills the buffered fingerprints to the partitions.
+func (b *DiskBuilder) flush() error {
+	slices.Sort(b.buf)
+	err := b.spill(slices.Compact(b.buf), b.parts, 0, b.step)
+	b.buf = b.buf[:0]
+	return err
+}
+
+// spill appends the ascending fingerprints fps to files, the partitions of step quotients
+// from lo. Spill files hold fingerprints in the byte order of the host.
+func (b *DiskBuilder) spill(fps []uint64, files []*os.File, lo, step uint64) error {
+	for len(fps) > 0 {
+		i := (b.quotient(fps[0]) - lo) / step
+		n := len(fps)
+		if end := lo + (i+1)*step; end < b.qf.cap {
+			n, _ = slices.BinarySearch(fps, end<<b.qf.rbits)
+		}
+		if files[i] == nil {
+			f, err := os.CreateTemp(b.dir, "spill-*")
+			if err != nil {
+				return err
+			}
+			files[i] = f
+		}
+		if _, err := files[i].Write(fingerprintBytes(fps[:n])); err != nil {
+			return err
+		}
+		fps = fps[n:]
+	}
+	return nil
+}
+
+func (b *DiskBuilder) quotient(fp uint64) uint64 {
+	return fp >> b.qf.rbits & b.qf.qMask
+}
+
+// fingerprintBytes returns the memory of fps.
+func fingerprintBytes(fps []uint64) []byte {
+	return unsafe.Slice((*byte)(unsafe.Pointer(unsafe.SliceData(fps))), len(fps)*8)
+}
+
+// Finish writes the filter to path in the binary format of MarshalBinary, through a synced
+// temporary file like SaveToFile, and removes the temporary files. It returns ErrFull if
+// there are more distinct fingerprints than the filter takes. The builder can't be used
+// afterwards. The file loads with LoadFromFile or OpenMmap, filters larger than
+// DefaultMaxMemory load into a filter created WithMaxMemory.
+func (b *DiskBuilder) Finish(path string) error {
+	if b.parts == nil {
+		return errBuilderDone
+	}
+	defer b.Close()
+	if err := b.flush(); err != nil {
+		return err
+	}
+	for i, f := range b.parts {
+		if f == nil {
+			continue
+		}
+		lo := uint64(i) * b.step
+		if err := b.sortPartition(f, lo, lo+b.step); err != nil {
+			return err
+		}
+		b.parts[i] = nil
+	}
+	if b.n > b.qf.maxLen {
+		return ErrFull
+	}
+	// the slots wrapping around the end, see layoutSorted. The layouts from different slots
+	// are the same once they reach the same slot, so the later rounds stop there.
+	wrap := uint64(0)
+	end, err := b.end(0, 0, 0)
+	if err != nil {
+		return err
+	}
+	for end > b.qf.cap+wrap {
+		next := end - b.qf.cap
+		if end, err = b.end(next, wrap, end); err != nil {
+			return err
+		}
+		wrap = next
+	}
+	return replaceFile(path, func(f *os.File) error {
+		return b.write(f, wrap)
+	})
+}
+
+// Close removes the temporary files, it is called by Finish.
+func (b *DiskBuilder) Close() error {
+	for _, f := range b.parts {
+		if f != nil {
+			f.Close()
+		}
+	}
+	b.parts = nil
+	return os.RemoveAll(b.dir)
+}
+
+// sortPartition sorts the fingerprints of f, which have quotients from lo to hi, and appends the
+// sorted files to b.sorted. Partitions that don't fit in the buffer are split by quotient.
+func (b *DiskBuilder) sortPartition(f *os.File, lo, hi uint64) error {
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	count := uint64(fi.Size()) / 8
+	if count <= uint64(cap(b.buf)) {
+		fps := b.buf[:count]
+		if _, err := f.ReadAt(fingerprintBytes(fps), 0); err != nil {
+			return err
+		}
+		slices.Sort(fps)
+		fps = slices.Compact(fps)
+		if _, err := f.WriteAt(fingerprintBytes(fps), 0); err != nil {
+			return err
+		}
+		if err := f.Truncate(int64(len(fps)) * 8); err != nil {
+			return err
+		}
+		b.sorted = append(b.sorted, f.Name())
+		b.n += uint64(len(fps))
+		return nil
+	}
+	if hi-lo == 1 {
+		return fmt.Errorf("%d fingerprints of quotient %d don't fit in the buffer of the builder", count, lo)
+	}
+	parts := make([]*os.File, min(16, hi-lo))
+	step := (hi - lo + uint64(len(parts)) - 1) / uint64(len(parts))
+	err = b.each(f, func(fps []uint64) error {
+		slices.Sort(fps)
+		return b.spill(slices.Compact(fps), parts, lo, step)
+	})
+	os.Remove(f.Name())
+	if err != nil {
+		for _, p := range parts {
+			if p != nil {
+				p.Close()
+			}
+		}
+		return err
+	}
+	for i, p := range parts {
+		if p == nil {
+			continue
+		}
+		sub := lo + uint64(i)*step
+		if err := b.sortPartition(p, sub, min(sub+step, hi)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// each calls fn with the fingerprints of f read into the buffer, a buffer full at a time.
+func (b *DiskBuilder) each(f *os.File, fn func(fps []uint64) error) error {
+	buf := b.buf[:cap(b.buf)]
+	for off := int64(0); ; {
+		n, err := f.ReadAt(fingerprintBytes(buf), off)
+		if n%8 != 0 {
+			return fmt.Errorf("spill file %s of %d bytes", f.Name(), off+int64(n))
+		}
+		if n > 0 {
+			if err := fn(buf[:n/8]); err != nil {
+				return err
+			}
+		}
+		off += int64(n)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// eachSorted calls fn with the sorted fingerprints, a buffer full at a time, until fn
+// returns false.
+func (b *DiskBuilder) eachSorted(fn func(fps []uint64) bool) error {
+	errStop := errors.New("stop")
+	for _, name := range b.sorted {
+		f, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		err = b.each(f, func(fps []uint64) error {
+			if !fn(fps) {
+				return errStop
+			}
+			return nil
+		})
+		f.Close()
+		if err == errStop {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// end returns the slot after the last one of the layout starting at slot wrap. The layout
+// from slot known, if it is not wrap, ends at knownEnd.
+func (b *DiskBuilder) end(wrap, known, knownEnd uint64) (uint64, error) {
+	l, k := layout{qf: b.qf, pos: wrap}, layout{qf: b.qf, pos: known}
+	converged := false
+	err := b.eachSorted(func(fps []uint64) bool {
+		for _, fp := range fps {
+			l.add(fp)
+			if wrap != known {
+				k.add(fp)
+				if converged = l.pos == k.pos; converged {
+					return false
+				}
+			}
+		}
+		return true
+	})
+	if converged {
+		return knownEnd, err
+	}
+	return l.pos, err
+}
+
+// write writes the filter with the sorted fingerprints laid out from slot wrap to f.
+func (b *DiskBuilder) write(f *os.File, wrap uint64) error {
+	qf := b.qf
+	qf.len = b.n
+	qf.publishLen()
+	head := qf.appendEncodingHeader(make([]byte, prefixLen, 128), 0)
+	size, _ := dataBytes(qf.qbits, qf.rwidth, false)
+	// the slots below the wrapped ones are written last, the window starts at the start of
+	// a block.
+	period := qf.blockLen
+	t := &diskTable{period: period, w: bufio.NewWriterSize(f, b.outSize)}
+	for _, part := range []*QuotientFilter{&t.head, &t.window} {
+		part.rbits, part.rwidth, part.ssize, part.rMask, part.blockLen, part.stride = qf.rbits, qf.rwidth, qf.ssize, qf.rMask, qf.blockLen, qf.stride
+	}
+	t.headSlots = min((wrap+period-1)/period*period, qf.cap)
+	t.base = t.headSlots
+	t.head.data = make([]byte, qf.blockWords(t.headSlots)*8)
+	// the head is still empty, it is written again at the end.
+	if _, err := t.w.Write(head); err != nil {
+		return err
+	}
+	if _, err := t.w.Write(t.head.data); err != nil {
+		return err
+	}
+
+	l := layout{qf: qf, t: t, pos: wrap}
+	err := b.eachSorted(func(fps []uint64) bool {
+		for _, fp := range fps {
+			if t.err = t.advance(b.quotient(fp)); t.err != nil {
+				return false
+			}
+			l.add(fp)
+		}
+		return true
+	})
+	if err == nil {
+		err = t.err
+	}
+	if err == nil {
+		err = t.emit(t.window.data)
+	}
+	if err == nil {
+		err = t.zeros(size - uint64(len(t.head.data)) - t.written)
+	}
+	if err == nil {
+		err = t.w.Flush()
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteAt(t.head.data, int64(len(head))); err != nil {
+		return err
+	}
+	data := crc32Combine(crc32.Checksum(t.head.data, castagnoli), t.crc, int64(t.written))
+	putPrefix(head, encodingVersion, crc32Combine(crc32.Checksum(head[prefixLen:], castagnoli), data, int64(size)))
+	_, err = f.WriteAt(head[:prefixLen], 0)
+	return err
+}
+
+// diskTable is the table of a DiskBuilder. The slots below headSlots stay in memory until the
+// end, the rest are in a window of the slots from base, which is written out and moved on as
+// the layout moves on. head and window are filters holding nothing but data.
+type diskTable struct {
+	head, window    QuotientFilter
+	headSlots, base uint64
+	period          uint64
+	// writer of the data after the head, the checksum and number of the bytes written.
+	w       *bufio.Writer
+	crc     uint32
+	written uint64
+	err     error
+}
+
+func (t *diskTable) getSlot(index uint64) slot {
+	if index < t.headSlots {
+		return t.head.getSlot(index)
+	}
+	t.grow(index)
+	return t.window.getSlot(index - t.base)
+}
+
+func (t *diskTable) setSlot(index uint64, s slot) {
+	if index < t.headSlots {
+		t.head.setSlot(index, s)
+		return
+	}
+	t.grow(index)
+	t.window.setSlot(index-t.base, s)
+}
+
+// grow extends the window to the block of the slot at index.
+func (t *diskTable) grow(index uint64) {
+	blocks := (index-t.base)/t.period + 1
+	need := int(t.window.blockWords(blocks*t.period) * 8)
+	if n := len(t.window.data); n < need {
+		t.window.data = slices.Grow(t.window.data, need-n)[:need]
+		clear(t.window.data[n:])
+	}
+}
+
+// advance writes out the words of the window before the slot at index, nothing before it is
+// modified any more.
+func (t *diskTable) advance(index uint64) error {
+	base := index / t.period * t.period
+	if base <= t.base {
+		return nil
+	}
+	n := t.window.blockWords(base-t.base) * 8
+	done := min(n, uint64(len(t.window.data)))
+	if err := t.emit(t.window.data[:done]); err != nil {
+		return err
+	}
+	if err := t.zeros(n - done); err != nil {
+		return err
+	}
+	t.window.data = t.window.data[:copy(t.window.data, t.window.data[done:])]
+	t.base = base
+	return nil
+}
+
+func (t *diskTable) emit(b []byte) error {
+	t.crc = crc32.Update(t.crc, castagnoli, b)
+	t.written += uint64(len(b))
+	_, err := t.w.Write(b)
+	return err
+}
+
+// zeroBlock is written for the empty parts of the table.
+var zeroBlock [4096]byte
+
+// zeros writes n zero bytes.
+func (t *diskTable) zeros(n uint64) error {
+	for ; n > 0; n -= min(n, uint64(len(zeroBlock))) {
+		if err := t.emit(zeroBlock[:min(n, uint64(len(zeroBlock)))]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,141 @@
+package qf
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestDiskBuilder(t *testing.T) {
+	tests := []struct {
+		Name string
+		Q, R uint8
+		N    int
+		// fraction of the hashes with the top quotients, which overflows partitions and
+		// makes the last cluster wrap around.
+		Skew float64
+	}{
+		{"empty", 8, 8, 0, 0},
+		{"sparse", 12, 9, 500, 0},
+		{"default load", 12, 9, 3686, 0},
+		{"skewed", 12, 9, 3000, 0.05},
+		{"wrapping", 10, 20, 900, 0.1},
+		{"wide remainders", 6, 58, 50, 0},
+		{"small table", 3, 7, 7, 0.5},
+	}
+	for _, test := range tests {
+		rnd := rand.New(rand.NewSource(int64(test.N)))
+		dir := t.TempDir()
+		b, err := NewDiskBuilder(dir, test.Q, test.R, minDiskBufferSize)
+		if err != nil {
+			t.Fatal(test.Name, "unexpected error", err)
+		}
+		added := MustNew(test.Q, test.R)
+		for i := 0; i < test.N; i++ {
+			h := rnd.Uint64()
+			if rnd.Float64() < test.Skew {
+				h |= maskLower(uint64(test.Q)-1) << test.R << 1
+			}
+			if added.AddHash(h) != nil {
+				continue
+			}
+			// duplicates are skipped.
+			for j := 0; j < 1+i%2; j++ {
+				if err := b.AddHash(h); err != nil {
+					t.Fatal(test.Name, "unexpected error", err)
+				}
+			}
+		}
+		path := filepath.Join(dir, "filter.qf")
+		if err := b.Finish(path); err != nil {
+			t.Fatal(test.Name, "unexpected error", err)
+		}
+		built, err := LoadFromFile(path)
+		if err != nil {
+			t.Fatal(test.Name, "unexpected error", err)
+		}
+		if !bytes.Equal(built.data, added.data) || built.Len() != added.Len() {
+			t.Fatal(test.Name, "table differs from the one built with Add")
+		}
+		if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+			t.Fatal(test.Name, "temporary files left", len(entries))
+		}
+	}
+}
+
+func TestDiskBuilderKeys(t *testing.T) {
+	b, _ := NewDiskBuilder(t.TempDir(), 14, 9, 0)
+	items := randomItems(10000)
+	for _, s := range items {
+		b.Add(s)
+	}
+	path := filepath.Join(t.TempDir(), "filter.qf")
+	if err := b.Finish(path); err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	mapped, err := OpenMmap(path)
+	if err != nil {
+		t.Skip("memory mapping not supported", err)
+	}
+	defer mapped.Close()
+	if !mapped.ContainsAll(items) {
+		t.Fatal("Key missing from the built filter")
+	}
+}
+
+func TestDiskBuilderErrors(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := NewDiskBuilder(dir, 8, 8, minDiskBufferSize-1); err == nil {
+		t.Fatal("Expected an error for a small buffer")
+	}
+	if _, err := NewDiskBuilder(dir, 1, 8, 0); err == nil {
+		t.Fatal("Expected an error for invalid q")
+	}
+	b, _ := NewDiskBuilder(dir, 8, 8, 0)
+	for i := 0; i < 250; i++ {
+		b.AddHash(uint64(i) << 8)
+	}
+	if err := b.Finish(filepath.Join(dir, "full.qf")); !errors.Is(err, ErrFull) {
+		t.Fatal("Expected ErrFull, got", err)
+	}
+	if err := b.AddHash(1); err == nil {
+		t.Fatal("Expected an error adding to a finished builder")
+	}
+	if err := b.Finish(filepath.Join(dir, "again.qf")); err == nil {
+		t.Fatal("Expected an error finishing twice")
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Fatal("Files left after errors", len(entries))
+	}
+}
+
+func TestDiskBuilderMemory(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds a large filter")
+	}
+	// the table takes megabytes, the builder allocates a fraction of that.
+	const q, r, size = 20, 8, 64 << 10
+	dir := t.TempDir()
+	rnd := rand.New(rand.NewSource(1))
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	b, err := NewDiskBuilder(dir, q, r, size)
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	for i := 0; i < 900_000; i++ {
+		b.AddHash(rnd.Uint64())
+	}
+	if err := b.Finish(filepath.Join(dir, "filter.qf")); err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	runtime.ReadMemStats(&after)
+	table, _ := BufferSize(q, r)
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 16*size {
+		t.Fatal("Builder allocated", allocated, "bytes for a table of", table)
+	}
+}
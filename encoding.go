@@ -0,0 +1,715 @@
+package qf
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+)
+
+var (
+	_ encoding.BinaryMarshaler   = (*QuotientFilter)(nil)
+	_ encoding.BinaryUnmarshaler = (*QuotientFilter)(nil)
+	_ io.WriterTo                = (*QuotientFilter)(nil)
+	_ io.ReaderFrom              = (*QuotientFilter)(nil)
+	_ gob.GobEncoder             = (*QuotientFilter)(nil)
+	_ gob.GobDecoder             = (*QuotientFilter)(nil)
+)
+
+// Errors returned when decoding the binary encoding. Every decoding error caused by the
+// data itself wraps ErrInvalidEncoding, the more specific errors are wrapped as well.
+var (
+	// ErrInvalidEncoding is returned when decoding data that is not a valid filter encoding.
+	ErrInvalidEncoding = errors.New("invalid filter encoding")
+	// ErrBadMagic is returned when the data does not start with the magic bytes.
+	ErrBadMagic = errors.New("not a quotient filter encoding")
+	// ErrUnsupportedVersion is returned for encodings of an unknown format version.
+	ErrUnsupportedVersion = errors.New("unsupported filter encoding version")
+	// ErrChecksum is returned when the checksum of the data does not match.
+	ErrChecksum = errors.New("filter encoding checksum mismatch")
+)
+
+// The binary encoding of a filter, all integers are little-endian:
+//
+//	magic           "QFGO"
+//	version         2 bytes, encodingVersion
+//	checksum        4 bytes, CRC-32C of everything after it
+//	q, r            1 byte each
+//	max load factor float64 bits, 8 bytes
+//	len             8 bytes, fingerprints in the table
+//	hash id         uvarint length and bytes, the name of the hash, then a zero byte and the
+//	                seed for hashes with one
+//	key transformer uvarint length and bytes, empty without one
+//	namespaces      uvarint count, each as uvarint length and bytes
+//	stash           uvarint size, uvarint probe limit, uvarint count and count 8 byte fingerprints
+//	flags           uvarint, since version 2, flagCompressed, flagCacheLineBlocks and
+//	                flagAlignedSlots
+//	data            the data words, 8 bytes each, or compressed with flagCompressed
+//
+// Version 4 is the one written, its data words hold the slots in blocks, see blockSlots. The
+// data words of earlier versions hold the slots packed one after the other, r + 3 bits each,
+// which decoding rearranges into blocks. The data of versions 1 and 2 has the number of bytes
+// the slots need as its number of words, 8 times the words of version 3, the words past the
+// table are zero and decoding drops them. Version 1 has no flags, which version 2 adds.
+// Compressed data holds the slots one by one and is the same in every version. The data
+// words of filters created with WithCacheLineBlocks hold the zero words padding the blocks,
+// and those of filters created with WithAlignedSlots the remainders in 8, 16, 32 or 64 bits.
+// Built-in hash functions are created again from the hash id. A filter using a custom hash
+// can only be decoded into a filter configured with the same one or a resolver for it, see
+// WithHashResolver, and one with a key transformer into a filter with the same transformer.
+// Reported false positives and the verifier are not encoded.
+const (
+	encodingMagic   = "QFGO"
+	encodingVersion = 4
+	// versions of encodings with 8 times the data words, without and with flags. The
+	// header of version 1 is the one of diffs.
+	encodingVersionLegacy = 1
+	encodingVersionFlags  = 2
+	// the last version with the slots packed one after the other.
+	encodingVersionPacked = 3
+	// length of the magic, version and checksum.
+	prefixLen = 10
+)
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// header is the decoded part of the encoding before the data words.
+type header struct {
+	q, r        uint8
+	maxLoad     float64
+	len         uint64
+	hashID      string
+	hashSeed    string
+	transformID string
+	namespaces  []string
+	stashSize   uint64
+	probeLimit  uint64
+	stash       []uint64
+	flags       uint64
+}
+
+// width returns the number of bits the data words store a remainder in, see remainderWidth.
+func (h header) width() uint8 {
+	return remainderWidth(h.r, h.flags&flagAlignedSlots != 0)
+}
+
+// MarshalBinary encodes the filter in the binary format, see UnmarshalBinary.
+func (qf *QuotientFilter) MarshalBinary() ([]byte, error) {
+	buf := qf.appendEncodingHeader(make([]byte, prefixLen, 128+qf.words()*8), 0)
+	buf = qf.appendData(buf, 0, qf.words())
+	putPrefix(buf, encodingVersion, crc32.Checksum(buf[prefixLen:], castagnoli))
+	return buf, nil
+}
+
+// UnmarshalBinary replaces the filter with one encoded by MarshalBinary. Filters using the
+// default hash function can be decoded into a zero QuotientFilter, filters with a custom
+// hash or a key transformer have to be decoded into a filter with the same ones.
+// The filter is left unchanged if data is invalid.
+//
+// Invalid data returns an error rather than panicking, and the memory allocated is bounded
+// by the limit of the filter, see WithMaxMemory. Data that is not backed by enough bytes
+// fails before the table is allocated. The metadata bits of the decoded table are checked
+// as well, so that a filter decoded from corrupt data with a matching checksum can't hang
+// lookups.
+func (qf *QuotientFilter) UnmarshalBinary(data []byte) error {
+	decoded := *qf
+	r := bytes.NewReader(data)
+	if _, err := decoded.ReadFrom(r); err != nil {
+		return err
+	}
+	if r.Len() > 0 {
+		return fmt.Errorf("%w: %d bytes after the filter", ErrInvalidEncoding, r.Len())
+	}
+	*qf = decoded
+	return nil
+}
+
+// chunkWords is the number of data words WriteTo and ReadFrom buffer at a time.
+const chunkWords = 4096
+
+// WriteTo writes the filter to w in the binary format of MarshalBinary, streaming the data
+// in fixed size chunks. It returns the number of bytes written.
+func (qf *QuotientFilter) WriteTo(w io.Writer) (int64, error) {
+	buf := make([]byte, 0, chunkWords*8)
+	written, err := writeFull(w, qf.encodingHead(buf))
+	if err != nil {
+		return written, err
+	}
+	for i := 0; i < qf.words(); i += chunkWords {
+		n, err := writeFull(w, qf.appendChunk(buf, i))
+		written += n
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// encodingHead returns the prefix and the header of the binary encoding, buf is scratch
+// space of chunkWords words. The checksum comes first, so the data is encoded twice.
+func (qf *QuotientFilter) encodingHead(buf []byte) []byte {
+	head := qf.appendEncodingHeader(make([]byte, prefixLen, 128), 0)
+	crc := crc32.Update(0, castagnoli, head[prefixLen:])
+	for i := 0; i < qf.words(); i += chunkWords {
+		crc = crc32.Update(crc, castagnoli, qf.appendChunk(buf, i))
+	}
+	putPrefix(head, encodingVersion, crc)
+	return head
+}
+
+// appendChunk appends the encoding of the data words from i to the next chunk to buf[:0].
+func (qf *QuotientFilter) appendChunk(buf []byte, i int) []byte {
+	return qf.appendData(buf[:0], i, min(i+chunkWords, qf.words()))
+}
+
+// appendData appends the data words from i to j to buf.
+func (qf *QuotientFilter) appendData(buf []byte, i, j int) []byte {
+	if qf.sparse != nil {
+		return qf.sparse.appendBytes(buf, uint64(i), uint64(j))
+	}
+	return append(buf, qf.data[i*8:j*8]...)
+}
+
+func putPrefix(buf []byte, version uint16, crc uint32) {
+	copy(buf, encodingMagic)
+	binary.LittleEndian.PutUint16(buf[4:], version)
+	binary.LittleEndian.PutUint32(buf[6:], crc)
+}
+
+func writeFull(w io.Writer, b []byte) (int64, error) {
+	n, err := w.Write(b)
+	if err == nil && n < len(b) {
+		err = io.ErrShortWrite
+	}
+	return int64(n), err
+}
+
+// ReadFrom replaces the filter with one read from r in the binary format of MarshalBinary,
+// see UnmarshalBinary. Nothing after the filter is read from r. The filter is only replaced
+// once it has been read completely and its checksum verified, on errors it is left unchanged.
+// It returns the number of bytes read.
+func (qf *QuotientFilter) ReadFrom(r io.Reader) (int64, error) {
+	version, want, read, err := readPrefix(r)
+	if err != nil {
+		return read, err
+	}
+	crc := crc32.New(castagnoli)
+	src := r
+	r = io.TeeReader(r, crc)
+
+	h, n64, err := decodeHeader(r, version)
+	read += n64
+	if err != nil {
+		return read, err
+	}
+	decoded, err := qf.fromHeader(h)
+	if err != nil {
+		return read, err
+	}
+	cacheLine := h.flags&flagCacheLineBlocks != 0
+	words, _ := uint64Size(h.q, h.width(), cacheLine)
+	encoded := encodedWords(version, h.q, h.width(), cacheLine)
+	if h.flags&flagCompressed != 0 {
+		d := decoder{r: r}
+		decoded.decompress(&d, encoded)
+		read += d.n
+		if d.err != nil {
+			return read, d.err
+		}
+		return read, qf.replace(decoded, crc.Sum32(), want)
+	}
+	// the table is allocated up front only when r is known to hold all of it, otherwise
+	// it grows as the data arrives so that a short stream can't force a large allocation.
+	prealloc := min(words, chunkWords)
+	if sized, ok := src.(interface{ Len() int }); ok {
+		if uint64(sized.Len()) < encoded*8 {
+			return read, fmt.Errorf("%w: %d bytes left, q %d and r %d need %d", ErrInvalidEncoding, sized.Len(), h.q, h.r, encoded*8)
+		}
+		prealloc = words
+	}
+	if decoded.sparse == nil {
+		decoded.data = make([]byte, 0, prealloc*8)
+	}
+	buf := make([]byte, min(encoded, chunkWords)*8)
+	for done := uint64(0); done < encoded*8; {
+		chunk := buf[:min(chunkWords*8, encoded*8-done)]
+		n, err := io.ReadFull(r, chunk)
+		read += int64(n)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return read, fmt.Errorf("%w: data ends after %d bytes", ErrInvalidEncoding, read)
+		} else if err != nil {
+			return read, err
+		}
+		// the words of older versions past the table are dropped.
+		keep := chunk[:min(uint64(len(chunk)), words*8-min(words*8, done))]
+		if !isZero(chunk[len(keep):]) {
+			return read, fmt.Errorf("%w: data past the table is not zero", ErrInvalidEncoding)
+		}
+		if decoded.sparse != nil {
+			decoded.sparse.write(int(done), keep)
+			if err := decoded.sparse.checkLimit(); err != nil {
+				return read, err
+			}
+		} else {
+			decoded.data = append(decoded.data, keep...)
+		}
+		done += uint64(len(chunk))
+	}
+	if version <= encodingVersionPacked {
+		decoded.unpack()
+	}
+	return read, qf.replace(decoded, crc.Sum32(), want)
+}
+
+// unpack rearranges data words holding the slots packed one after the other, as encodings up
+// to version 3 and the C layout do, into blocks. A block takes the same words either way, so
+// the words are rearranged block by block in place.
+func (qf *QuotientFilter) unpack() {
+	n := (qf.blockLen*uint64(qf.ssize) + 63) / 64
+	// room for reading the word after the one the last slot starts in.
+	packed := make([]byte, n*8+8)
+	for first := uint64(0); first < qf.cap; first += qf.blockLen {
+		w := first / blockSlots * qf.stride
+		for k := range n {
+			binary.LittleEndian.PutUint64(packed[k*8:], qf.word(w+k))
+			qf.setWord(w+k, 0)
+		}
+		for i := range qf.blockLen {
+			qf.setSlot(first+i, packedSlot(packed, i, qf.ssize))
+		}
+	}
+}
+
+// packed returns the data words of the filter with the slots packed one after the other, see
+// unpack.
+func (qf *QuotientFilter) packed() []byte {
+	data := make([]byte, qf.words()*8)
+	for i := range qf.cap {
+		putPackedSlot(data, i, qf.ssize, qf.getSlot(i))
+	}
+	return data
+}
+
+// packedSlot returns the slot i of data holding slots of ssize bits packed one after the other,
+// data has to hold the word after the one the slot starts in.
+func packedSlot(data []byte, i uint64, ssize uint8) slot {
+	pos := i * uint64(ssize)
+	w, b := pos/64*8, pos%64
+	s := binary.LittleEndian.Uint64(data[w:]) >> b
+	if b+uint64(ssize) > 64 {
+		s |= binary.LittleEndian.Uint64(data[w+8:]) << (64 - b)
+	}
+	return slot(s & maskLower(uint64(ssize)))
+}
+
+func putPackedSlot(data []byte, i uint64, ssize uint8, s slot) {
+	pos := i * uint64(ssize)
+	w, b := pos/64*8, pos%64
+	mask, v := maskLower(uint64(ssize)), uint64(s)
+	binary.LittleEndian.PutUint64(data[w:], binary.LittleEndian.Uint64(data[w:])&^(mask<<b)|v<<b)
+	if b+uint64(ssize) > 64 {
+		binary.LittleEndian.PutUint64(data[w+8:], binary.LittleEndian.Uint64(data[w+8:])&^(mask>>(64-b))|v>>(64-b))
+	}
+}
+
+// replace replaces the filter with the decoded one after checking the checksum and table.
+func (qf *QuotientFilter) replace(decoded *QuotientFilter, crc, want uint32) error {
+	if crc != want {
+		return fmt.Errorf("%w: %w", ErrInvalidEncoding, ErrChecksum)
+	}
+	if err := decoded.checkTable(); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidEncoding, err)
+	}
+	decoded.alignData()
+	decoded.buildOffsets()
+	*qf = *decoded
+	return nil
+}
+
+// readPrefix reads the magic, version and the checksum that follows them.
+func readPrefix(r io.Reader) (version uint16, crc uint32, read int64, err error) {
+	var prefix [prefixLen]byte
+	n, err := io.ReadFull(r, prefix[:])
+	read = int64(n)
+	switch {
+	case err == io.EOF || err == io.ErrUnexpectedEOF:
+		return 0, 0, read, fmt.Errorf("%w: unexpected end of data", ErrInvalidEncoding)
+	case err != nil:
+		return 0, 0, read, err
+	case string(prefix[:4]) != encodingMagic:
+		return 0, 0, read, fmt.Errorf("%w: %w", ErrInvalidEncoding, ErrBadMagic)
+	}
+	version = binary.LittleEndian.Uint16(prefix[4:])
+	if version < encodingVersionLegacy || version > encodingVersion {
+		return 0, 0, read, fmt.Errorf("%w: %w %d", ErrInvalidEncoding, ErrUnsupportedVersion, version)
+	}
+	return version, binary.LittleEndian.Uint32(prefix[6:]), read, nil
+}
+
+// GobEncode encodes the filter for encoding/gob in the binary format of MarshalBinary.
+func (qf *QuotientFilter) GobEncode() ([]byte, error) {
+	return qf.MarshalBinary()
+}
+
+// GobDecode decodes a filter encoded with GobEncode. Filters using the default hash function
+// are restored with it, decoding a filter with a custom hash function fails unless it is
+// decoded into an existing filter created with the same hash, see UnmarshalBinary.
+func (qf *QuotientFilter) GobDecode(data []byte) error {
+	return qf.UnmarshalBinary(data)
+}
+
+func (qf *QuotientFilter) appendHeader(buf []byte) []byte {
+	buf = append(buf, qf.qbits, qf.rbits)
+	buf = binary.LittleEndian.AppendUint64(buf, math.Float64bits(qf.maxLoad))
+	buf = binary.LittleEndian.AppendUint64(buf, qf.len)
+	buf = appendString(buf, qf.hashIdentity())
+	buf = appendString(buf, qf.transformID)
+	namespaces := qf.Namespaces()
+	buf = binary.AppendUvarint(buf, uint64(len(namespaces)))
+	for _, ns := range namespaces {
+		buf = appendString(buf, ns)
+	}
+	buf = binary.AppendUvarint(buf, uint64(cap(qf.stash)))
+	buf = binary.AppendUvarint(buf, qf.probeLimit)
+	buf = binary.AppendUvarint(buf, uint64(len(qf.stash)))
+	for _, fp := range qf.stash {
+		buf = binary.LittleEndian.AppendUint64(buf, fp)
+	}
+	return buf
+}
+
+// appendEncodingHeader appends the header of the binary encoding with flags, which is the
+// header of version 1 followed by the flags.
+func (qf *QuotientFilter) appendEncodingHeader(buf []byte, flags uint64) []byte {
+	return binary.AppendUvarint(qf.appendHeader(buf), flags|qf.layoutFlags())
+}
+
+// layoutFlags returns the flags of the layout of the data words.
+func (qf *QuotientFilter) layoutFlags() uint64 {
+	var flags uint64
+	if qf.cacheLineBlocks {
+		flags |= flagCacheLineBlocks
+	}
+	if qf.alignedSlots {
+		flags |= flagAlignedSlots
+	}
+	return flags
+}
+
+// isZero reports whether all bytes of b are zero.
+func isZero(b []byte) bool {
+	for _, c := range b {
+		if c != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func appendString(buf []byte, s string) []byte {
+	return append(binary.AppendUvarint(buf, uint64(len(s))), s...)
+}
+
+// decodeHeader decodes the header of an encoding of version read from r and returns the
+// number of bytes it took.
+func decodeHeader(r io.Reader, version uint16) (h header, n int64, err error) {
+	d := decoder{r: r}
+	h.q, h.r = d.byte(), d.byte()
+	h.maxLoad = math.Float64frombits(d.uint64())
+	h.len = d.uint64()
+	h.hashID, h.hashSeed = splitHashIdentity(d.string())
+	h.transformID = d.string()
+	for i, count := uint64(0), d.uvarint(); i < count && d.err == nil; i++ {
+		h.namespaces = append(h.namespaces, d.string())
+	}
+	h.stashSize = d.uvarint()
+	h.probeLimit = d.uvarint()
+	count := d.uvarint()
+	if d.err == nil && count > h.stashSize {
+		return h, d.n, stashError(count, h.stashSize)
+	}
+	for i := uint64(0); i < count && d.err == nil; i++ {
+		h.stash = append(h.stash, d.uint64())
+	}
+	if version >= encodingVersionFlags {
+		h.flags = d.uvarint()
+		if d.err == nil && h.flags&^knownFlags != 0 {
+			return h, d.n, fmt.Errorf("%w: %w, unknown flags %#x", ErrInvalidEncoding, ErrUnsupportedVersion, h.flags&^knownFlags)
+		}
+		if d.err == nil && version <= encodingVersionPacked && h.flags&flagCacheLineBlocks != 0 {
+			return h, d.n, fmt.Errorf("%w: version %d data can't have padded blocks", ErrInvalidEncoding, version)
+		}
+		if d.err == nil && version <= encodingVersionPacked && h.flags&flagAlignedSlots != 0 {
+			return h, d.n, fmt.Errorf("%w: version %d data can't have aligned slots", ErrInvalidEncoding, version)
+		}
+	}
+	return h, d.n, d.err
+}
+
+// fromHeader returns a filter with the parameters of h and nil data, which the caller
+// allocates once it knows the data is there. The hash function is resolved with qf, see
+// resolveHash, the key transformer is taken from qf when h needs it, the memory limit, hash
+// resolver and dirty tracking always are.
+func (qf *QuotientFilter) fromHeader(h header) (*QuotientFilter, error) {
+	if qf.sealed {
+		return nil, ErrSealed
+	}
+	// the 128-bit hash function of the filter decodes filters of q + r above 64 bits.
+	same128 := qf.hash128 != nil && h.hashID == qf.hashID && h.hashSeed == qf.hashSeed
+	bits := 64
+	if same128 {
+		bits = 128
+	}
+	if err := validateQRBits(h.q, h.r, bits); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidEncoding, err)
+	}
+	if !(h.maxLoad > 0 && h.maxLoad <= 1) {
+		return nil, fmt.Errorf("%w: max load factor %v", ErrInvalidEncoding, h.maxLoad)
+	}
+	if h.len > uint64(h.maxLoad*float64(uint64(1)<<h.q)) {
+		return nil, fmt.Errorf("%w: len %d is more than q %d allows", ErrInvalidEncoding, h.len, h.q)
+	}
+	if uint64(len(h.stash)) > h.stashSize {
+		return nil, stashError(uint64(len(h.stash)), h.stashSize)
+	}
+	if h.stashSize > 1<<h.q {
+		return nil, fmt.Errorf("%w: stash of %d is larger than the table", ErrInvalidEncoding, h.stashSize)
+	}
+	if h.q+h.r > 64 && h.stashSize > 0 {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidEncoding, errWideStash)
+	}
+	for _, fp := range h.stash {
+		if h.q+h.r < 64 && fp>>(h.q+h.r) != 0 {
+			return nil, fmt.Errorf("%w: stashed fingerprint %#x has more than q + r bits", ErrInvalidEncoding, fp)
+		}
+	}
+	limit := qf.maxMemory
+	if limit == 0 {
+		limit = DefaultMaxMemory
+	}
+	cacheLine, aligned := h.flags&flagCacheLineBlocks != 0, h.flags&flagAlignedSlots != 0
+	size, ok := dataBytes(h.q, h.width(), cacheLine)
+	if ok && qf.sparse != nil {
+		// the pages are checked against the limit as they are allocated.
+		size = sparseIndexBytes(h.q, h.width(), cacheLine)
+	}
+	if !ok || size > limit || h.stashSize*8 > limit-size {
+		return nil, fmt.Errorf("%w: q %d, r %d and a stash of %d need more than the limit of %d bytes", ErrInvalidEncoding, h.q, h.r, h.stashSize, limit)
+	}
+	c := &config{q: h.q, r: h.r, maxLoad: h.maxLoad, maxMemory: limit, adaptiveEntries: DefaultAdaptiveEntries,
+		stashSize: int(h.stashSize), probeLimit: h.probeLimit, noData: true, cacheLineBlocks: cacheLine, alignedSlots: aligned,
+		sparse: qf.sparse != nil}
+	newHash, err := qf.resolveHash(h.hashID, h.hashSeed)
+	if err != nil {
+		return nil, err
+	}
+	c.newHash, c.hashID, c.hashSeed, c.resolver = newHash, h.hashID, h.hashSeed, qf.resolver
+	c.displacementLimit, c.rehashKeys = qf.displacementLimit, qf.rehashKeys
+	if same128 {
+		// the hash function of the filter is folded again for the r of the encoding, or split
+		// for q + r above 64 bits.
+		c.hash128 = qf.hash128
+	}
+	if h.transformID != qf.transformID {
+		return nil, fmt.Errorf("filter uses key transformer %q, decode it into a filter created with the same transformer", h.transformID)
+	}
+	c.transform, c.transformID = qf.transform, qf.transformID
+	if qf.dirty != nil {
+		c.dirtyBlockSize = qf.dirty.size
+	}
+	if qf.atomicWords {
+		if h.stashSize > 0 {
+			return nil, errors.New("filters with optimistic reads can't have a stash, decode it into an ordinary filter")
+		}
+		c.optimistic = true
+	}
+	if qf.adaptiveEntries > 0 {
+		c.adaptiveEntries = qf.adaptiveEntries
+	}
+	decoded := newFilter(c)
+	decoded.len = h.len
+	decoded.stash = append(decoded.stash, h.stash...)
+	decoded.publishLen()
+	for _, ns := range h.namespaces {
+		if decoded.namespaces == nil {
+			decoded.namespaces = make(map[string]struct{}, len(h.namespaces))
+		}
+		decoded.namespaces[ns] = struct{}{}
+	}
+	return decoded, nil
+}
+
+// checkTable returns an error if the metadata bits of the table contradict each other or
+// len. The scans through clusters rely on them, on inconsistent bits they can loop forever.
+func (qf *QuotientFilter) checkTable() error {
+	if err := qf.checkPadding(); err != nil {
+		return err
+	}
+	return qf.checkSlots(true)
+}
+
+// checkPadding returns an error if the words padding the blocks to cache lines or the bits
+// padding aligned remainders are not zero, so that every table has one encoding.
+func (qf *QuotientFilter) checkPadding() error {
+	if qf.rwidth > qf.rbits {
+		for i := range qf.cap {
+			w, b := qf.remainderBit(i)
+			field := qf.word(w) >> b
+			if b+uint64(qf.rwidth) > 64 {
+				field |= qf.word(w+1) << (64 - b)
+			}
+			if field&maskLower(uint64(qf.rwidth))>>qf.rbits != 0 {
+				return fmt.Errorf("padding of the remainder of slot %d is not zero", i)
+			}
+		}
+	}
+	if qf.blockLen < blockSlots {
+		return nil
+	}
+	for b := range qf.cap / blockSlots {
+		for w := b*qf.stride + uint64(qf.rwidth) + 3; w < (b+1)*qf.stride; w++ {
+			if qf.word(w) != 0 {
+				return fmt.Errorf("padding of block %d is not zero", b)
+			}
+		}
+	}
+	return nil
+}
+
+// checkSlots is checkTable, which also checks that the runs are sorted if sorted is true.
+func (qf *QuotientFilter) checkSlots(sorted bool) error {
+	var used, occupied uint64
+	start := qf.cap
+	for i := uint64(0); i < qf.cap; i++ {
+		s, prev := qf.getSlot(i), qf.getSlot(qf.previous(i))
+		switch {
+		case s.isEmpty():
+		case s.isContinuation() && !s.isShifted():
+			return fmt.Errorf("slot %d continues a run but is not shifted", i)
+		case s.isShifted() && prev.isEmpty():
+			return fmt.Errorf("slot %d is shifted but follows an empty slot", i)
+		case sorted && s.isContinuation() && s.remainder() < prev.remainder():
+			return fmt.Errorf("run is not sorted at slot %d", i)
+		}
+		if s.isOccupied() {
+			occupied++
+		}
+		if !s.isEmpty() {
+			used++
+			if !s.isShifted() && start == qf.cap {
+				start = i
+			}
+		}
+	}
+	if used != qf.len {
+		return fmt.Errorf("%d slots are used but len is %d", used, qf.len)
+	}
+	if used == 0 {
+		return nil
+	}
+	if start == qf.cap {
+		return errors.New("no cluster starts in the table")
+	}
+	// match the runs with their quotients the way findRun does, the runs of a cluster
+	// belong to its occupied quotients in order and come after them.
+	var runs uint64
+	quotient := start
+	for n := uint64(0); n < qf.cap; n++ {
+		i := (start + n) & qf.qMask
+		s := qf.getSlot(i)
+		if s.isEmpty() || s.isContinuation() {
+			continue
+		}
+		runs++
+		if !s.isShifted() {
+			quotient = i
+			continue
+		}
+		for quotient = qf.next(quotient); quotient != i && !qf.getSlot(quotient).isOccupied(); quotient = qf.next(quotient) {
+		}
+		if quotient == i {
+			return fmt.Errorf("run at slot %d has no occupied quotient before it", i)
+		}
+	}
+	if runs != occupied {
+		return fmt.Errorf("%d quotients are occupied but there are %d runs", occupied, runs)
+	}
+	return nil
+}
+
+// maxStringLen limits the strings of the header, names and namespaces are short.
+const maxStringLen = 1 << 16
+
+func stashError(count, size uint64) error {
+	return fmt.Errorf("%w: %d fingerprints in a stash of %d", ErrInvalidEncoding, count, size)
+}
+
+// decoder reads the header of the binary encoding byte by byte so that nothing after it
+// is consumed from r. After the first error every read returns zero values.
+type decoder struct {
+	r   io.Reader
+	n   int64
+	buf [8]byte
+	err error
+}
+
+func (d *decoder) read(b []byte) {
+	if d.err != nil {
+		for i := range b {
+			b[i] = 0
+		}
+		return
+	}
+	n, err := io.ReadFull(d.r, b)
+	d.n += int64(n)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		d.err = fmt.Errorf("%w: unexpected end of data", ErrInvalidEncoding)
+	} else if err != nil {
+		d.err = err
+	}
+}
+
+func (d *decoder) ReadByte() (byte, error) {
+	d.read(d.buf[:1])
+	return d.buf[0], d.err
+}
+
+func (d *decoder) byte() byte {
+	b, _ := d.ReadByte()
+	return b
+}
+
+func (d *decoder) uint64() uint64 {
+	d.read(d.buf[:8])
+	return binary.LittleEndian.Uint64(d.buf[:8])
+}
+
+func (d *decoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	v, err := binary.ReadUvarint(d)
+	if err != nil && d.err == nil {
+		d.err = fmt.Errorf("%w: invalid varint", ErrInvalidEncoding)
+	}
+	return v
+}
+
+func (d *decoder) string() string {
+	n := d.uvarint()
+	if d.err != nil {
+		return ""
+	}
+	if n > maxStringLen {
+		d.err = fmt.Errorf("%w: string of %d bytes", ErrInvalidEncoding, n)
+		return ""
+	}
+	b := make([]byte, n)
+	d.read(b)
+	return string(b)
+}
@@ -0,0 +1,561 @@
+package qf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"flag"
+	"hash"
+	"hash/crc32"
+	"hash/fnv"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestMarshalBinary(t *testing.T) {
+	tests := []struct {
+		Name string
+		Opts []Option
+	}{
+		{"default", []Option{WithQR(12, 9)}},
+		{"word sized slots", []Option{WithQR(3, 61), WithMaxLoadFactor(1)}},
+		{"stash", []Option{WithQR(8, 20), WithStash(4, 0)}},
+		{"load factor", []Option{WithQR(10, 5), WithMaxLoadFactor(0.5)}},
+		{"cache line blocks", []Option{WithQR(12, 9), WithCacheLineBlocks()}},
+		{"aligned slots", []Option{WithQR(12, 9), WithAlignedSlots()}},
+	}
+	for _, test := range tests {
+		qf, _ := NewWithOptions(0, test.Opts...)
+		qf.AddNS("users", "42")
+		items := randomItems(int(qf.maxLen))
+		qf.AddAll(items)
+		b, err := qf.MarshalBinary()
+		if err != nil {
+			t.Fatal(test.Name, "unexpected error", err)
+		}
+		var decoded QuotientFilter
+		if err := decoded.UnmarshalBinary(b); err != nil {
+			t.Fatal(test.Name, "unexpected error", err)
+		}
+		if decoded.Params() != qf.Params() || decoded.Len() != qf.Len() || decoded.FPProbability() != qf.FPProbability() ||
+			decoded.MaxLoadFactor() != qf.MaxLoadFactor() || decoded.Stats() != qf.Stats() {
+			t.Fatal(test.Name, "decoded filter differs", decoded.Stats(), qf.Stats())
+		}
+		if decoded.sMask != qf.sMask || decoded.qMask != qf.qMask || decoded.rMask != qf.rMask || decoded.maxLen != qf.maxLen {
+			t.Fatal(test.Name, "masks differ after decoding")
+		}
+		if !reflect.DeepEqual(decoded.data, qf.data) || !reflect.DeepEqual(decoded.Namespaces(), qf.Namespaces()) {
+			t.Fatal(test.Name, "data differs after decoding")
+		}
+		for _, s := range items {
+			if qf.Contains(s) != decoded.Contains(s) {
+				t.Fatal(test.Name, "membership differs for", s)
+			}
+		}
+		if !decoded.ContainsNS("users", "42") {
+			t.Fatal(test.Name, "namespaced key missing after decoding")
+		}
+		// the decoded filter is fully usable.
+		decoded.Delete(items[0])
+		decoded.Add("fox")
+		if !decoded.Contains("fox") {
+			t.Fatal(test.Name, "decoded filter does not work")
+		}
+	}
+}
+
+func TestUnmarshalBinaryInvalid(t *testing.T) {
+	qf := MustNew(8, 8)
+	qf.AddAll(generateItems(100))
+	b, _ := qf.MarshalBinary()
+	for _, data := range [][]byte{nil, b[:1], b[:20], b[:len(b)-1], append(b, 0)} {
+		var decoded QuotientFilter
+		if err := decoded.UnmarshalBinary(data); !errors.Is(err, ErrInvalidEncoding) {
+			t.Fatal("Expected ErrInvalidEncoding for", len(data), "bytes, got", err)
+		}
+	}
+	// q, r and len are checked against each other.
+	for _, c := range []struct {
+		Off int
+		V   byte
+	}{{10, 1}, {10, 9}, {11, 0}, {11, 62}, {27, 1}} {
+		data := append([]byte(nil), b...)
+		data[c.Off] = c.V
+		reseal(data)
+		if err := new(QuotientFilter).UnmarshalBinary(data); !errors.Is(err, ErrInvalidEncoding) || errors.Is(err, ErrChecksum) {
+			t.Fatal("Expected ErrInvalidEncoding for corrupt header, got", err)
+		}
+	}
+	// a failed decode leaves the filter unchanged.
+	before := qf.Clone()
+	if err := qf.UnmarshalBinary(b[:len(b)-8]); err == nil || !reflect.DeepEqual(qf.data, before.data) || qf.Len() != before.Len() {
+		t.Fatal("Failed decode changed the filter", err)
+	}
+}
+
+// reseal recomputes the checksum of an encoding.
+func reseal(b []byte) {
+	binary.LittleEndian.PutUint32(b[6:], crc32.Checksum(b[prefixLen:], castagnoli))
+}
+
+func TestUnmarshalBinaryErrors(t *testing.T) {
+	qf := MustNew(8, 8)
+	qf.AddAll(generateItems(100))
+	b, _ := qf.MarshalBinary()
+	corrupt := func(f func(b []byte)) []byte {
+		c := append([]byte(nil), b...)
+		f(c)
+		return c
+	}
+	tests := []struct {
+		Name string
+		Data []byte
+		Err  error
+	}{
+		{"magic", corrupt(func(b []byte) { b[0] = 'X' }), ErrBadMagic},
+		{"gob", []byte("\x0e\xff\x81\x03\x01\x02\xff\x82\x00\x01\x10\x01\x10\x00"), ErrBadMagic},
+		{"version", corrupt(func(b []byte) { b[4] = 5 }), ErrUnsupportedVersion},
+		{"version 0", corrupt(func(b []byte) { b[4] = 0 }), ErrUnsupportedVersion},
+		{"checksum", corrupt(func(b []byte) { b[7]++ }), ErrChecksum},
+		{"data", corrupt(func(b []byte) { b[len(b)-100] ^= 1 }), ErrChecksum},
+		{"header", corrupt(func(b []byte) { b[20] ^= 1 }), ErrChecksum},
+	}
+	for _, test := range tests {
+		err := new(QuotientFilter).UnmarshalBinary(test.Data)
+		if !errors.Is(err, test.Err) || !errors.Is(err, ErrInvalidEncoding) {
+			t.Fatal(test.Name, "expected", test.Err, "got", err)
+		}
+		for _, other := range []error{ErrBadMagic, ErrUnsupportedVersion, ErrChecksum} {
+			if other != test.Err && errors.Is(err, other) {
+				t.Fatal(test.Name, "error also matches", other)
+			}
+		}
+	}
+}
+
+func TestUnmarshalBinaryHostile(t *testing.T) {
+	qf := MustNew(8, 8)
+	qf.AddAll(generateItems(100))
+	b, _ := qf.MarshalBinary()
+	// the limit of the receiver bounds what decoding allocates.
+	small, _ := NewWithOptions(0, WithQR(2, 2), WithMaxMemory(300))
+	if err := small.UnmarshalBinary(b); !errors.Is(err, ErrInvalidEncoding) {
+		t.Fatal("Expected an error decoding past the memory limit, got", err)
+	}
+	large, _ := NewWithOptions(0, WithQR(2, 2), WithMaxMemory(1<<20))
+	if err := large.UnmarshalBinary(b); err != nil || large.maxMemory != 1<<20 {
+		t.Fatal("Unexpected error decoding under the memory limit", err)
+	}
+
+	// a header declaring a huge table with no data behind it fails without allocating it.
+	huge := append([]byte(nil), b[:29]...)
+	huge[10], huge[11] = 36, 8
+	huge = append(appendString(huge, HashFNV64a), 0, 0, 0, 0, 0)
+	reseal(huge)
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	for _, r := range []io.Reader{bytes.NewReader(huge), iotest.OneByteReader(bytes.NewReader(huge))} {
+		if _, err := new(QuotientFilter).ReadFrom(r); !errors.Is(err, ErrInvalidEncoding) {
+			t.Fatal("Expected ErrInvalidEncoding for a header without data, got", err)
+		}
+	}
+	runtime.ReadMemStats(&after)
+	if after.TotalAlloc-before.TotalAlloc > 1<<20 {
+		t.Fatal("Decoding a header without data allocated", after.TotalAlloc-before.TotalAlloc, "bytes")
+	}
+
+	// tables with inconsistent metadata bits are rejected even with a valid checksum.
+	tests := []struct {
+		Name    string
+		Corrupt func(qf *QuotientFilter)
+	}{
+		{"len", func(qf *QuotientFilter) { qf.len-- }},
+		{"continuation", func(qf *QuotientFilter) {
+			i := findSlot(qf, func(s slot) bool { return s.isContinuation() })
+			qf.setSlot(i, qf.getSlot(i).clearShifted())
+		}},
+		{"shifted after empty", func(qf *QuotientFilter) {
+			i := findSlot(qf, func(s slot) bool { return s.isEmpty() })
+			qf.setSlot(qf.next(i), qf.getSlot(qf.next(i)).setShifted())
+		}},
+		{"cleared occupied", func(qf *QuotientFilter) {
+			i := findSlot(qf, func(s slot) bool { return s.isClusterStart() })
+			qf.setSlot(i, qf.getSlot(i).clearOccupied())
+		}},
+		{"extra occupied", func(qf *QuotientFilter) {
+			i := findSlot(qf, func(s slot) bool { return s.isShifted() && !s.isOccupied() })
+			qf.setSlot(i, qf.getSlot(i).setOccupied())
+		}},
+		{"everything shifted", func(qf *QuotientFilter) {
+			for i := uint64(0); i < qf.cap; i++ {
+				qf.setSlot(i, newSlot(0).setShifted().setContinuation())
+			}
+			qf.len = qf.cap
+		}},
+	}
+	for _, test := range tests {
+		corrupt, _ := NewWithOptions(0, WithQR(8, 8), WithMaxLoadFactor(1))
+		corrupt.AddAll(generateItems(150))
+		test.Corrupt(corrupt)
+		data, _ := corrupt.MarshalBinary()
+		if err := new(QuotientFilter).UnmarshalBinary(data); !errors.Is(err, ErrInvalidEncoding) || errors.Is(err, ErrChecksum) {
+			t.Fatal(test.Name, "expected ErrInvalidEncoding for an inconsistent table, got", err)
+		}
+		j, _ := corrupt.MarshalJSON()
+		if err := new(QuotientFilter).UnmarshalJSON(j); !errors.Is(err, ErrInvalidEncoding) {
+			t.Fatal(test.Name, "expected ErrInvalidEncoding decoding JSON, got", err)
+		}
+	}
+}
+
+// findSlot returns the first slot of the table that matches f.
+func findSlot(qf *QuotientFilter, f func(s slot) bool) uint64 {
+	for i := uint64(0); i < qf.cap; i++ {
+		if f(qf.getSlot(i)) {
+			return i
+		}
+	}
+	panic("no matching slot")
+}
+
+func FuzzUnmarshalBinary(f *testing.F) {
+	for _, file := range []string{"v4.golden", "v3.golden", "v1.golden", goldenVectors[0].File, goldenVectors[1].File, goldenVectors[0].V3, goldenVectors[0].V1} {
+		b, err := os.ReadFile(filepath.Join("testdata", file))
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(b)
+		f.Add(b[:len(b)/2])
+	}
+	qf, _ := NewWithOptions(0, WithQR(5, 3), WithMaxLoadFactor(1), WithStash(3, 1))
+	qf.AddAll(randomItems(40))
+	b, _ := qf.MarshalBinary()
+	f.Add(b)
+	var compressed bytes.Buffer
+	qf.WriteToCompressed(&compressed)
+	f.Add(compressed.Bytes())
+	keys := append(randomItems(20), "fox", "dog", "alpha", "bravo")
+	f.Fuzz(func(t *testing.T, data []byte) {
+		// most mutations break the checksum, decode them with a valid one as well.
+		resealed := append([]byte(nil), data...)
+		if len(resealed) >= prefixLen {
+			reseal(resealed)
+		}
+		for _, data := range [][]byte{data, resealed} {
+			for _, opt := range []Option{WithQR(2, 2), WithKeyTransformer("lower", strings.ToLower)} {
+				qf, _ := NewWithOptions(0, WithQR(2, 2), WithMaxMemory(1<<20), opt)
+				if err := qf.UnmarshalBinary(data); err != nil {
+					continue
+				}
+				// whatever decodes is a usable filter.
+				if err := qf.checkTable(); err != nil {
+					t.Fatal("Decoded an inconsistent table", err)
+				}
+				for _, k := range keys {
+					qf.Contains(k)
+					if err := qf.Add(k); err == nil && !qf.Contains(k) {
+						t.Fatal("Added key missing", k)
+					}
+				}
+				for _, k := range keys {
+					qf.Delete(k)
+				}
+				if _, err := qf.MarshalBinary(); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+	})
+}
+
+var update = flag.Bool("update", false, "update the golden files in testdata")
+
+// goldenFilter returns a filter using every part of the encoding.
+func goldenFilter() *QuotientFilter {
+	qf, _ := NewWithOptions(0, WithQR(4, 6), WithStash(2, 0), WithKeyTransformer("lower", strings.ToLower))
+	for _, s := range []string{"fox", "dog", "cat", "owl", "elk", "yak", "emu", "ant"} {
+		qf.Add(s)
+	}
+	qf.AddNS("users", "42")
+	return qf
+}
+
+func TestGolden(t *testing.T) {
+	qf := goldenFilter()
+	b, _ := qf.MarshalBinary()
+	path := filepath.Join("testdata", "v4.golden")
+	if *update {
+		if err := os.WriteFile(path, b, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	golden, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, golden) {
+		t.Fatalf("Encoding differs from %s, run go test -update if the format changed on purpose\n got %x\nwant %x", path, b, golden)
+	}
+	// the fixed part of the header, and the flags and data words at the end.
+	if string(golden[:4]) != "QFGO" || binary.LittleEndian.Uint16(golden[4:]) != 4 || golden[10] != 4 || golden[11] != 6 ||
+		binary.LittleEndian.Uint64(golden[20:]) != qf.len || string(golden[29:35]) != "fnv64a" || string(golden[36:41]) != "lower" ||
+		len(qf.data) != 4*8 || golden[len(golden)-len(qf.data)-1] != 0 {
+		t.Fatalf("Unexpected header layout %x", golden[:48])
+	}
+	// version 3 packs the slots one after the other, version 1 also has no flags and 8 times
+	// the data words.
+	v3, err := os.ReadFile(filepath.Join("testdata", "v3.golden"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	v1, err := os.ReadFile(filepath.Join("testdata", "v1.golden"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, golden := range [][]byte{golden, v3, v1} {
+		decoded, _ := NewWithOptions(0, WithQR(2, 2), WithKeyTransformer("lower", strings.ToLower))
+		if err := decoded.UnmarshalBinary(golden); err != nil {
+			t.Fatal("Unexpected error decoding the golden file", err)
+		}
+		if decoded.Stats() != qf.Stats() || !decoded.ContainsAll([]string{"FOX", "dog", "ant"}) || !decoded.ContainsNS("users", "42") {
+			t.Fatal("Decoded golden filter differs", decoded.Stats(), qf.Stats())
+		}
+		if encoded, _ := decoded.MarshalBinary(); !bytes.Equal(encoded, b) {
+			t.Fatal("Decoded golden filter encodes differently")
+		}
+	}
+}
+
+// goldenVectors are small filters with known keys and their exact encodings in testdata,
+// and their version 3 and 1 encodings, which decode to the same filters. Each lists keys
+// that the decoded filter must contain and keys it must not.
+var goldenVectors = []struct {
+	File, V3, V1 string
+	Q, R         uint8
+	Present      []string
+	Absent       []string
+}{
+	{"v4-q6-r10.golden", "v3-q6-r10.golden", "v1-q6-r10.golden", 6, 10, []string{"alpha", "bravo", "charlie", "delta", "echo", "foxtrot", "golf", "hotel"},
+		[]string{"india", "juliett", "kilo", "lima", "mike"}},
+	{"v4-q3-r61.golden", "v3-q3-r61.golden", "v1-q3-r61.golden", 3, 61, []string{"alpha", "bravo", "charlie", "delta", "echo"},
+		[]string{"foxtrot", "golf", "hotel", "india", "juliett"}},
+}
+
+func TestGoldenVectors(t *testing.T) {
+	for _, v := range goldenVectors {
+		qf := MustNew(v.Q, v.R)
+		qf.AddAll(v.Present)
+		b, _ := qf.MarshalBinary()
+		path := filepath.Join("testdata", v.File)
+		if *update {
+			if err := os.WriteFile(path, b, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		golden, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b, golden) {
+			t.Fatalf("Encoding differs from %s, run go test -update if the format changed on purpose", path)
+		}
+		// the data words are the last bytes, least significant byte first.
+		words := golden[len(golden)-len(qf.data):]
+		for i := 0; i < qf.words(); i++ {
+			var decoded uint64
+			for j := 7; j >= 0; j-- {
+				decoded = decoded<<8 | uint64(words[i*8+j])
+			}
+			if w := qf.word(uint64(i)); decoded != w {
+				t.Fatalf("Data word %d is %x, expected %x in little-endian", i, decoded, w)
+			}
+		}
+		v3, err := os.ReadFile(filepath.Join("testdata", v.V3))
+		if err != nil {
+			t.Fatal(err)
+		}
+		v1, err := os.ReadFile(filepath.Join("testdata", v.V1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for path, golden := range map[string][]byte{path: golden, v.V3: v3, v.V1: v1} {
+			var decoded QuotientFilter
+			if err := decoded.UnmarshalBinary(golden); err != nil {
+				t.Fatal("Unexpected error decoding", path, err)
+			}
+			for _, s := range v.Present {
+				if !decoded.Contains(s) {
+					t.Fatal("Golden filter", path, "does not contain", s)
+				}
+			}
+			for _, s := range v.Absent {
+				if decoded.Contains(s) {
+					t.Fatal("Golden filter", path, "contains", s)
+				}
+			}
+			if encoded, _ := decoded.MarshalBinary(); !bytes.Equal(encoded, b) {
+				t.Fatal("Golden filter", path, "encodes differently after decoding")
+			}
+		}
+		// the words of version 1 past the table have to be zero.
+		v1[len(v1)-1] = 1
+		reseal(v1)
+		if err := new(QuotientFilter).UnmarshalBinary(v1); !errors.Is(err, ErrInvalidEncoding) {
+			t.Fatal("Decoding", v.V1, "with data past the table returned", err)
+		}
+	}
+}
+
+func TestUnmarshalBinaryHash(t *testing.T) {
+	newHash := func() hash.Hash64 { return fnv.New64() }
+	custom, _ := NewWithOptions(0, WithQR(10, 10), WithHash(newHash))
+	custom.Add("fox")
+	b, _ := custom.MarshalBinary()
+	if err := new(QuotientFilter).UnmarshalBinary(b); !errors.Is(err, ErrHashMismatch) || !strings.Contains(err.Error(), HashCustom) {
+		t.Fatal("Expected ErrHashMismatch decoding a custom hash filter into a zero filter, got", err)
+	}
+	decoded, _ := NewWithOptions(0, WithQR(4, 4), WithHash(newHash))
+	if err := decoded.UnmarshalBinary(b); err != nil || !decoded.Contains("fox") || decoded.Params() != custom.Params() {
+		t.Fatal("Unexpected result decoding into a filter with the same hash", err)
+	}
+
+	lower, _ := NewWithOptions(0, WithQR(10, 10), WithKeyTransformer("lower", strings.ToLower))
+	lower.Add("FOX")
+	b, _ = lower.MarshalBinary()
+	if err := new(QuotientFilter).UnmarshalBinary(b); err == nil {
+		t.Fatal("Expected an error decoding a filter with a key transformer without it")
+	}
+	decoded, _ = NewWithOptions(0, WithQR(4, 4), WithKeyTransformer("lower", strings.ToLower))
+	if err := decoded.UnmarshalBinary(b); err != nil || !decoded.Contains("Fox") {
+		t.Fatal("Unexpected result decoding into a filter with the same transformer", err)
+	}
+}
+
+type limitedWriter struct {
+	w     io.Writer
+	limit int
+}
+
+func (l *limitedWriter) Write(b []byte) (int, error) {
+	if len(b) > l.limit {
+		n, _ := l.w.Write(b[:l.limit])
+		l.limit = 0
+		return n, errors.New("disk full")
+	}
+	l.limit -= len(b)
+	return l.w.Write(b)
+}
+
+func TestWriteToReadFrom(t *testing.T) {
+	qf := MustNew(16, 8)
+	items := randomItems(20000)
+	qf.AddAll(items)
+	small := MustNew(6, 4)
+	small.Add("fox")
+	b, _ := qf.MarshalBinary()
+
+	var buf bytes.Buffer
+	n, err := qf.WriteTo(&buf)
+	if err != nil || n != int64(len(b)) || !bytes.Equal(buf.Bytes(), b) {
+		t.Fatal("WriteTo differs from MarshalBinary", n, len(b), err)
+	}
+	// filters can follow each other in a stream.
+	small.WriteTo(&buf)
+	var decoded, decodedSmall QuotientFilter
+	if n, err := decoded.ReadFrom(&buf); err != nil || n != int64(len(b)) {
+		t.Fatal("Unexpected ReadFrom result", n, err)
+	}
+	if _, err := decodedSmall.ReadFrom(&buf); err != nil || buf.Len() != 0 || !decodedSmall.Contains("fox") {
+		t.Fatal("Unexpected ReadFrom result for the second filter", err, buf.Len())
+	}
+	if !decoded.ContainsAll(items) || decoded.Params() != qf.Params() || decoded.Len() != qf.Len() {
+		t.Fatal("Decoded filter differs")
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		_, err := qf.WriteTo(pw)
+		pw.CloseWithError(err)
+	}()
+	var piped QuotientFilter
+	if n, err := piped.ReadFrom(pr); err != nil || n != int64(len(b)) || !reflect.DeepEqual(piped.data, qf.data) {
+		t.Fatal("Unexpected ReadFrom result through a pipe", n, err)
+	}
+}
+
+func TestWriteToReadFromErrors(t *testing.T) {
+	qf := MustNew(16, 8)
+	qf.AddAll(randomItems(20000))
+	b, _ := qf.MarshalBinary()
+	for _, limit := range []int{0, 10, 40000, len(b) - 1} {
+		n, err := qf.WriteTo(&limitedWriter{w: io.Discard, limit: limit})
+		if err == nil || n != int64(limit) {
+			t.Fatal("Expected an error after", limit, "bytes, got", n, err)
+		}
+	}
+	target := MustNew(6, 4)
+	target.Add("fox")
+	for _, size := range []int{0, 10, 40000, len(b) - 1} {
+		// a reader that knows its length fails before the data is read.
+		n, err := target.ReadFrom(bytes.NewReader(b[:size]))
+		if !errors.Is(err, ErrInvalidEncoding) || n > int64(size) || (size > 100 && n > 100) {
+			t.Fatal("Expected ErrInvalidEncoding after the header of", size, "bytes, got", n, err)
+		}
+		n, err = target.ReadFrom(iotest.OneByteReader(bytes.NewReader(b[:size])))
+		if !errors.Is(err, ErrInvalidEncoding) || n != int64(size) {
+			t.Fatal("Expected ErrInvalidEncoding after", size, "bytes, got", n, err)
+		}
+		if target.Params() != MustNew(6, 4).Params() || !target.Contains("fox") {
+			t.Fatal("Failed ReadFrom changed the filter")
+		}
+	}
+	readErr := errors.New("connection reset")
+	if _, err := target.ReadFrom(io.MultiReader(bytes.NewReader(b[:40000]), iotest.ErrReader(readErr))); !errors.Is(err, readErr) {
+		t.Fatal("Expected the read error, got", err)
+	}
+}
+
+func TestGob(t *testing.T) {
+	type checkpoint struct {
+		Name    string
+		Seen    *QuotientFilter
+		Blocked *QuotientFilter
+	}
+	in := checkpoint{Name: "shard-1", Seen: MustNew(12, 10), Blocked: MustNew(8, 16)}
+	seen, blocked := randomItems(2000), randomItems(100)
+	in.Seen.AddAll(seen)
+	in.Blocked.AddAll(blocked)
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(in); err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	var out checkpoint
+	if err := gob.NewDecoder(&buf).Decode(&out); err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	if out.Name != in.Name || out.Seen.Params() != in.Seen.Params() || out.Blocked.Params() != in.Blocked.Params() {
+		t.Fatal("Decoded checkpoint differs", out)
+	}
+	if !out.Seen.ContainsAll(seen) || !out.Blocked.ContainsAll(blocked) || out.Seen.Len() != in.Seen.Len() {
+		t.Fatal("Keys missing after gob round trip")
+	}
+	// the default hash function is restored.
+	out.Seen.Add("fox")
+	if !out.Seen.Contains("fox") || out.Seen.Params().Hash != HashFNV64a {
+		t.Fatal("Decoded filter does not hash like the original")
+	}
+
+	custom, _ := NewHash(fnv.New64(), 8, 8)
+	buf.Reset()
+	gob.NewEncoder(&buf).Encode(checkpoint{Seen: custom, Blocked: custom})
+	if err := gob.NewDecoder(&buf).Decode(&out); err == nil || !strings.Contains(err.Error(), HashCustom) {
+		t.Fatal("Expected an error naming the custom hash, got", err)
+	}
+}
@@ -0,0 +1,100 @@
+package qf
+
+import (
+	"fmt"
+	"math/bits"
+	"time"
+)
+
+// Expiring is a quotient filter whose keys expire a fixed time after they were last added.
+// Every slot stores a coarse timestamp next to the remainder, counting time in units of the
+// resolution. Timestamps wrap around after 2^t units, where t is chosen so that the wrap
+// period is at least twice the TTL, so Vacuum has to be called at least once per TTL or an
+// expired key can look fresh again.
+type Expiring struct {
+	m *Map
+	// ttl in units of the resolution.
+	ttl        uint64
+	resolution time.Duration
+	now        func() time.Time
+}
+
+// NewExpiring returns an Expiring filter with q quotient and r remainder bits whose keys
+// expire ttl after they were added, with timestamps rounded to resolution.
+func NewExpiring(q, r uint8, ttl, resolution time.Duration) (*Expiring, error) {
+	if resolution <= 0 || ttl < resolution {
+		return nil, fmt.Errorf("resolution %v has to be positive and at most ttl %v", resolution, ttl)
+	}
+	ticks := uint64((ttl + resolution - 1) / resolution)
+	m, err := NewMap(q, r, uint8(bits.Len64(ticks))+1)
+	if err != nil {
+		return nil, err
+	}
+	return &Expiring{m: m, ttl: ticks, resolution: resolution, now: time.Now}, nil
+}
+
+// SetClock replaces time.Now as the source of the current time, mainly for tests.
+func (e *Expiring) SetClock(now func() time.Time) {
+	e.now = now
+}
+
+// Len returns the number of fingerprints in the filter, including expired ones that
+// have not been removed by Vacuum.
+func (e *Expiring) Len() uint64 {
+	return e.m.Len()
+}
+
+// Add adds the key to the filter, or refreshes its timestamp if it is already present.
+func (e *Expiring) Add(key string) error {
+	return e.m.Put(key, e.tick())
+}
+
+// Contains checks if the key was added less than the TTL ago.
+func (e *Expiring) Contains(key string) bool {
+	stamp, ok := e.m.Get(key)
+	return ok && !e.expired(stamp, e.tick())
+}
+
+// Delete removes the key from the filter and reports whether it was present, expired or not.
+func (e *Expiring) Delete(key string) bool {
+	return e.m.Delete(key)
+}
+
+// Vacuum removes the expired keys from the filter to free their slots and returns
+// the number of keys removed.
+func (e *Expiring) Vacuum() int {
+	qf := e.m.qf
+	now := e.tick()
+	removed := 0
+	for q := uint64(0); q < qf.cap; q++ {
+		if !qf.getSlot(q).isOccupied() {
+			continue
+		}
+		start := qf.findRun(q)
+		index := start
+		for {
+			if !e.expired(qf.getSlot(index).remainder()&e.m.vMask, now) {
+				index = qf.next(index)
+				if !qf.getSlot(index).isContinuation() {
+					break
+				}
+				continue
+			}
+			// the rest of the run shifts left into index.
+			qf.removeAt(q, start, index)
+			removed++
+			if !qf.getSlot(q).isOccupied() || index != start && !qf.getSlot(index).isContinuation() {
+				break
+			}
+		}
+	}
+	return removed
+}
+
+func (e *Expiring) tick() uint64 {
+	return uint64(e.now().UnixNano()/int64(e.resolution)) & e.m.vMask
+}
+
+func (e *Expiring) expired(stamp, now uint64) bool {
+	return (now-stamp)&e.m.vMask >= e.ttl
+}
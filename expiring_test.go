@@ -0,0 +1,112 @@
+package qf
+
+import (
+	"testing"
+	"time"
+)
+
+type fakeClock struct{ t time.Time }
+
+func (c *fakeClock) now() time.Time { return c.t }
+
+func TestExpiring(t *testing.T) {
+	clock := &fakeClock{time.Unix(1000, 0)}
+	e, err := NewExpiring(10, 16, 10*time.Minute, time.Minute)
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	e.SetClock(clock.now)
+	e.Add("fox")
+	e.Add("dog")
+	clock.t = clock.t.Add(9 * time.Minute)
+	if !e.Contains("fox") || !e.Contains("dog") {
+		t.Fatal("Key expired before its TTL")
+	}
+	// re-adding refreshes the timestamp.
+	e.Add("dog")
+	clock.t = clock.t.Add(time.Minute)
+	if e.Contains("fox") || !e.Contains("dog") {
+		t.Fatal("Expected fox to expire and dog to be refreshed")
+	}
+	if e.Len() != 2 || e.Vacuum() != 1 || e.Len() != 1 {
+		t.Fatal("Vacuum did not remove exactly the expired key", e.Len())
+	}
+	clock.t = clock.t.Add(10 * time.Minute)
+	if e.Contains("dog") || e.Vacuum() != 1 || e.Len() != 0 {
+		t.Fatal("Expected dog to expire", e.Len())
+	}
+	if e.Contains("turbo") {
+		t.Fatal("Contains returned true for a key never added")
+	}
+}
+
+func TestExpiringWrapAround(t *testing.T) {
+	clock := &fakeClock{time.Unix(0, 0)}
+	e, _ := NewExpiring(8, 16, 3*time.Second, time.Second)
+	e.SetClock(clock.now)
+	period := int(e.m.vMask) + 1
+	for i := 0; i < 3*period; i++ {
+		e.Add("fox")
+		clock.t = clock.t.Add(time.Second)
+		// the timestamp of fox wraps around with the clock.
+		if i%period == period-1 && !e.Contains("fox") {
+			t.Fatal("Key expired at the wrap around, tick", i)
+		}
+	}
+	e.Add("dog")
+	// vacuuming once per TTL keeps expired keys from looking fresh after a wrap around.
+	for i := 0; i < 3*period; i++ {
+		clock.t = clock.t.Add(time.Second)
+		if i%3 == 0 {
+			e.Vacuum()
+		}
+		if i >= 2 && e.Contains("dog") {
+			t.Fatal("Expired key present at tick", i)
+		}
+	}
+}
+
+func TestVacuum(t *testing.T) {
+	clock := &fakeClock{time.Unix(0, 0)}
+	e, _ := NewExpiring(8, 12, 4*time.Second, time.Second)
+	e.SetClock(clock.now)
+	items := generateItems(200)
+	for i, s := range items {
+		if i%40 == 0 {
+			clock.t = clock.t.Add(time.Second)
+		}
+		if err := e.Add(s); err != nil {
+			t.Fatal("Unexpected error", err)
+		}
+	}
+	before := e.Len()
+	clock.t = clock.t.Add(2 * time.Second)
+	// keys added in the last 4 seconds survive, 40 keys per second.
+	removed := e.Vacuum()
+	if e.Len() != before-uint64(removed) || e.Len() != 80 {
+		t.Fatal("Unexpected len after vacuum", e.Len(), removed)
+	}
+	for i, s := range items {
+		if i >= 120 != e.Contains(s) {
+			t.Fatal("Unexpected Contains for", s)
+		}
+	}
+	// the remaining runs are intact.
+	for _, s := range items {
+		e.Add(s)
+	}
+	for _, s := range items {
+		if !e.Contains(s) {
+			t.Fatal("False negative after vacuum for", s)
+		}
+	}
+}
+
+func TestExpiringInvalid(t *testing.T) {
+	if _, err := NewExpiring(8, 8, time.Second, time.Minute); err == nil {
+		t.Fatal("Expected an error for a resolution longer than the TTL")
+	}
+	if _, err := NewExpiring(8, 8, time.Second, 0); err == nil {
+		t.Fatal("Expected an error for a zero resolution")
+	}
+}
@@ -0,0 +1,100 @@
+package qf
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// SaveToFile writes the filter to path in the binary format of MarshalBinary. The filter is
+// written to a temporary file in the same directory, synced and renamed over path, so path
+// holds either the previous file or the complete new one, even after a crash. The temporary
+// file is removed on errors. A new file gets mode 0644, an existing one keeps its mode.
+func (qf *QuotientFilter) SaveToFile(path string) error {
+	return writeFileAtomic(path, func(w io.Writer) error {
+		_, err := qf.WriteTo(w)
+		return err
+	})
+}
+
+// LoadFromFile reads a filter saved with SaveToFile. Invalid files return the decoding
+// errors of UnmarshalBinary, which wrap ErrInvalidEncoding. The file is decoded into a filter
+// configured with opts, without them it is like decoding into a zero QuotientFilter and fails
+// for filters with a custom hash or a key transformer. WithNamedHash, WithHashResolver,
+// WithKeyTransformer, WithMaxMemory and WithDirtyTracking apply to decoding, the size and
+// the other options are ignored.
+func LoadFromFile(path string, opts ...Option) (*QuotientFilter, error) {
+	qf, err := decodeTarget(opts)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	if _, err := qf.ReadFrom(r); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if _, err := r.ReadByte(); err != io.EOF {
+		return nil, fmt.Errorf("%s: %w: data after the filter", path, ErrInvalidEncoding)
+	}
+	return qf, nil
+}
+
+// writeFileAtomic replaces path with what write writes, through a synced temporary file.
+func writeFileAtomic(path string, write func(w io.Writer) error) error {
+	return replaceFile(path, func(f *os.File) error {
+		w := bufio.NewWriterSize(f, 1<<16)
+		if err := write(w); err != nil {
+			return err
+		}
+		return w.Flush()
+	})
+}
+
+// replaceFile replaces path with the temporary file write writes, once it is synced.
+func replaceFile(path string, write func(f *os.File) error) (err error) {
+	mode := os.FileMode(0644)
+	if fi, err := os.Stat(path); err == nil {
+		mode = fi.Mode().Perm()
+	}
+	dir, name := filepath.Split(path)
+	if dir == "" {
+		dir = "."
+	}
+	tmp, err := os.CreateTemp(dir, "."+name+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+	if err := write(tmp); err != nil {
+		return err
+	}
+	if err := tmp.Chmod(mode); err != nil {
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	// sync the directory so the rename itself is durable, not every platform supports it.
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
+}
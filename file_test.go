@@ -0,0 +1,107 @@
+package qf
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSaveToFile(t *testing.T) {
+	qf := MustNew(12, 9)
+	items := randomItems(3000)
+	qf.AddAll(items)
+	path := filepath.Join(t.TempDir(), "filter.qf")
+	if err := qf.SaveToFile(path); err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	loaded, err := LoadFromFile(path)
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	if loaded.Stats() != qf.Stats() || !reflect.DeepEqual(loaded.data, qf.data) || !loaded.ContainsAll(items) {
+		t.Fatal("Loaded filter differs", loaded.Stats(), qf.Stats())
+	}
+	if fi, _ := os.Stat(path); fi.Mode().Perm() != 0644 {
+		t.Fatal("Unexpected mode", fi.Mode())
+	}
+
+	// saving again replaces the file and keeps its mode.
+	os.Chmod(path, 0600)
+	qf.Add("fox")
+	if err := qf.SaveToFile(path); err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	if loaded, _ = LoadFromFile(path); !loaded.Contains("fox") {
+		t.Fatal("File was not replaced")
+	}
+	if fi, _ := os.Stat(path); fi.Mode().Perm() != 0600 {
+		t.Fatal("Mode was not kept", fi.Mode())
+	}
+	if entries, _ := os.ReadDir(filepath.Dir(path)); len(entries) != 1 {
+		t.Fatal("Unexpected files left behind", entries)
+	}
+}
+
+func TestSaveToFileFailure(t *testing.T) {
+	qf := MustNew(16, 8)
+	qf.AddAll(randomItems(20000))
+	dir := t.TempDir()
+	path := filepath.Join(dir, "filter.qf")
+	if err := MustNew(8, 8).SaveToFile(path); err != nil {
+		t.Fatal(err)
+	}
+	original, _ := os.ReadFile(path)
+
+	// the write fails midway, after part of the filter reached the temporary file.
+	failure := errors.New("disk full")
+	err := writeFileAtomic(path, func(w io.Writer) error {
+		if _, err := qf.WriteTo(&limitedWriter{w: w, limit: 50000}); err == nil {
+			t.Fatal("Expected the write to fail")
+		}
+		return failure
+	})
+	if !errors.Is(err, failure) {
+		t.Fatal("Expected the write error, got", err)
+	}
+	if b, _ := os.ReadFile(path); !bytes.Equal(b, original) {
+		t.Fatal("Failed save changed the original file")
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Fatal("Failed save left files behind", entries)
+	}
+	if err := qf.SaveToFile(filepath.Join(dir, "missing", "filter.qf")); err == nil {
+		t.Fatal("Expected an error saving to a missing directory")
+	}
+}
+
+func TestLoadFromFileInvalid(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := LoadFromFile(filepath.Join(dir, "missing.qf")); !errors.Is(err, os.ErrNotExist) {
+		t.Fatal("Expected ErrNotExist, got", err)
+	}
+	qf := MustNew(8, 8)
+	qf.AddAll(generateItems(100))
+	b, _ := qf.MarshalBinary()
+	corrupt := append([]byte(nil), b...)
+	corrupt[len(corrupt)-1] ^= 1
+	for _, test := range []struct {
+		Name string
+		Data []byte
+		Err  error
+	}{
+		{"checksum", corrupt, ErrChecksum},
+		{"magic", []byte("not a filter"), ErrBadMagic},
+		{"truncated", b[:len(b)-8], ErrInvalidEncoding},
+		{"trailing", append(b, 0), ErrInvalidEncoding},
+	} {
+		path := filepath.Join(dir, test.Name)
+		os.WriteFile(path, test.Data, 0644)
+		if _, err := LoadFromFile(path); !errors.Is(err, test.Err) || !errors.Is(err, ErrInvalidEncoding) {
+			t.Fatal(test.Name, "expected", test.Err, "got", err)
+		}
+	}
+}
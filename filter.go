@@ -0,0 +1,63 @@
+package qf
+
+// Filter is an approximate set membership structure, *QuotientFilter implements it.
+type Filter interface {
+	// Add adds the key to the filter.
+	Add(key string) error
+	// Contains reports whether the key may have been added.
+	Contains(key string) bool
+	// Len returns the number of elements in the filter.
+	Len() uint64
+}
+
+var (
+	_ Filter = (*QuotientFilter)(nil)
+	_ Filter = (*Exact)(nil)
+	_ Filter = (*Rotating)(nil)
+)
+
+// AddAll adds multiple keys to f and returns the number of keys that were not
+// already present. It stops at the first error and returns a *BatchError.
+func AddAll(f Filter, keys []string) (inserted int, err error) {
+	if qf, ok := f.(*QuotientFilter); ok {
+		return qf.AddAll(keys)
+	}
+	for i, k := range keys {
+		if f.Contains(k) {
+			continue
+		}
+		if err := f.Add(k); err != nil {
+			return inserted, &BatchError{Index: i, Err: err}
+		}
+		inserted++
+	}
+	return inserted, nil
+}
+
+// Exact is a Filter backed by a map, it never returns false positives.
+// It is useful for small sets and as a reference in tests.
+type Exact struct {
+	keys map[string]struct{}
+}
+
+// NewExact returns an empty Exact filter.
+func NewExact() *Exact {
+	return &Exact{keys: make(map[string]struct{})}
+}
+
+// Add adds the key to the set, it never fails.
+func (e *Exact) Add(key string) error {
+	e.keys[key] = struct{}{}
+	return nil
+}
+
+// Contains reports whether the key has been added.
+func (e *Exact) Contains(key string) bool {
+	_, ok := e.keys[key]
+	return ok
+}
+
+// Len returns the number of distinct keys added.
+func (e *Exact) Len() uint64 {
+	return uint64(len(e.keys))
+}
@@ -0,0 +1,51 @@
+package qf
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestFilter(t *testing.T) {
+	filters := map[string]Filter{
+		"quotient": MustNew(10, 16),
+		"exact":    NewExact(),
+	}
+	for name, f := range filters {
+		items := generateItems(200)
+		if n, err := AddAll(f, items); n != 200 || err != nil {
+			t.Fatal(name, "unexpected AddAll result", n, err)
+		}
+		if n, err := AddAll(f, items[:100]); n != 0 || err != nil {
+			t.Fatal(name, "expected no insertions for duplicates, got", n, err)
+		}
+		if f.Len() != 200 {
+			t.Fatal(name, "unexpected len", f.Len())
+		}
+		for _, s := range items {
+			if !f.Contains(s) {
+				t.Fatal(name, "returned false for an added item", s)
+			}
+		}
+		if f.Contains("turbo") {
+			t.Fatal(name, "returned true for not added item")
+		}
+	}
+}
+
+type failing struct{ Exact }
+
+func (f *failing) Add(key string) error {
+	if f.Len() >= 3 {
+		return ErrFull
+	}
+	return f.Exact.Add(key)
+}
+
+func TestAddAllFilterError(t *testing.T) {
+	f := &failing{*NewExact()}
+	n, err := AddAll(f, generateItems(5))
+	var berr *BatchError
+	if n != 3 || !errors.As(err, &berr) || berr.Index != 3 || !errors.Is(err, ErrFull) {
+		t.Fatal("Unexpected AddAll result", n, err)
+	}
+}
@@ -0,0 +1,152 @@
+package qf
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"slices"
+)
+
+// Fingerprints returns the fingerprints in the filter, quotient << r | remainder as returned
+// by Fingerprint64, in ascending order, including the stashed ones. AddFingerprints adds them
+// to a filter with the same q and r, which gives a copy of the filter independent of the
+// binary encoding. Like Snapshot it can be called while keys are added and deleted, which wait
+// until the fingerprints are collected. Filters of q + r above 64 bits return nil, their
+// fingerprints don't fit in 64 bits.
+func (qf *QuotientFilter) Fingerprints() []uint64 {
+	if qf.wide {
+		return nil
+	}
+	qf.mu.Lock()
+	defer qf.mu.Unlock()
+	out := make([]uint64, 0, qf.len+uint64(len(qf.stash)))
+	qf.forEach(func(q, r uint64) {
+		out = append(out, q<<qf.rbits|r)
+	})
+	out = append(out, qf.stash...)
+	// the runs are in order from the first cluster start, only clusters wrapping around the
+	// end of the table and the stash are out of order.
+	slices.Sort(out)
+	return out
+}
+
+// AddFingerprints adds fingerprints as returned by Fingerprints, fingerprints already in the
+// filter are skipped. It returns an error without adding anything if a fingerprint has more
+// than q + r bits. If a fingerprint does not fit it stops and returns a *BatchError wrapping
+// ErrFull like AddAll. Filters of q + r above 64 bits return an error.
+func (qf *QuotientFilter) AddFingerprints(fps []uint64) error {
+	if qf.wide {
+		return errWideHash
+	}
+	if bits := qf.qbits + qf.rbits; bits < 64 {
+		for i, fp := range fps {
+			if fp>>bits != 0 {
+				return fmt.Errorf("fingerprint %d, %#x, has more than q + r %d bits", i, fp, bits)
+			}
+		}
+	}
+	for i, fp := range fps {
+		if _, err := qf.insert(fp>>qf.rbits&qf.qMask, fp&qf.rMask); err != nil {
+			return &BatchError{Index: i, Err: err}
+		}
+	}
+	return nil
+}
+
+// forEach calls fn with the quotient and remainder of every fingerprint in the table, in the
+// order of the slots from the first cluster start.
+func (qf *QuotientFilter) forEach(fn func(q, r uint64)) {
+	qf.forEachSlot(func(_, q, r uint64) { fn(q, r) })
+}
+
+// forEachSlot is forEach passing the slot of the fingerprint as well.
+func (qf *QuotientFilter) forEachSlot(fn func(i, q, r uint64)) {
+	if qf.len == 0 {
+		return
+	}
+	// start from a cluster start, so that runs can be matched with their quotients.
+	start := qf.nextClusterStart(0)
+	quotient := start
+	for n := uint64(0); n < qf.cap; n++ {
+		// skip the empty slots, the next used slot wraps around to start after the last one.
+		index := qf.nextUsed((start + n) & qf.qMask)
+		if n += (index - start - n) & qf.qMask; n >= qf.cap {
+			break
+		}
+		s := qf.getSlot(index)
+		switch {
+		case s.isClusterStart():
+			quotient = index
+		case !s.isContinuation():
+			quotient = qf.nextOccupied(qf.next(quotient))
+		}
+		fn(index, quotient, s.remainder())
+	}
+}
+
+// Digest returns a SHA-256 digest of the content of the filter, its q and r, hash function,
+// key transformer and the set of its fingerprints, as returned by Fingerprints. Filters with
+// the same fingerprints have the same digest on every platform, whatever order the keys were
+// added and deleted in, even when that put different fingerprints in the stash. The max load
+// factor, namespaces and reported false positives are not part of it. The digest of the
+// data blocks used by Diff is Checkpoint. Like Fingerprints it can be called while keys are
+// added and deleted.
+func (qf *QuotientFilter) Digest() [32]byte {
+	qf.mu.Lock()
+	defer qf.mu.Unlock()
+	h := sha256.New()
+	buf := append(make([]byte, 0, 4096+64), "QFGO digest"...)
+	buf = append(buf, qf.qbits, qf.rbits)
+	buf = appendString(buf, qf.hashIdentity())
+	buf = appendString(buf, qf.transformID)
+	buf = binary.LittleEndian.AppendUint64(buf, qf.len+uint64(len(qf.stash)))
+	stash := slices.Sorted(slices.Values(qf.stash))
+	emit := func(fp uint64) {
+		for len(stash) > 0 && stash[0] < fp {
+			buf = binary.LittleEndian.AppendUint64(buf, stash[0])
+			stash = stash[1:]
+		}
+		buf = binary.LittleEndian.AppendUint64(buf, fp)
+		if len(buf) >= 4096 {
+			h.Write(buf)
+			buf = buf[:0]
+		}
+	}
+	// fingerprints of more than 64 bits are hashed as their quotient and remainder, those
+	// filters have no stash.
+	emitFingerprint := func(q, r uint64) {
+		if qf.wide {
+			emit(q)
+			emit(r)
+			return
+		}
+		emit(q<<qf.rbits | r)
+	}
+	// the fingerprints are visited from the first cluster start, the ones of the cluster
+	// wrapping around the end of the table with smaller quotients come last. They are
+	// hashed first, the rest in a second pass.
+	start, wrapped := qf.cap, []uint64(nil)
+	qf.forEach(func(q, r uint64) {
+		if start == qf.cap {
+			start = q
+		}
+		if q < start {
+			wrapped = append(wrapped, q, r)
+		}
+	})
+	for i := 0; i < len(wrapped); i += 2 {
+		emitFingerprint(wrapped[i], wrapped[i+1])
+	}
+	qf.forEach(func(q, r uint64) {
+		if q >= start {
+			emitFingerprint(q, r)
+		}
+	})
+	for _, fp := range stash {
+		buf = binary.LittleEndian.AppendUint64(buf, fp)
+	}
+	h.Write(buf)
+	var sum [32]byte
+	h.Sum(sum[:0])
+	return sum
+}
@@ -0,0 +1,140 @@
+package qf
+
+import (
+	"bytes"
+	"errors"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestFingerprints(t *testing.T) {
+	qf, _ := NewWithOptions(0, WithQR(10, 6), WithStash(8, 2), WithMaxLoadFactor(0.95))
+	keys := randomItems(900)
+	qf.AddAll(keys)
+	var want []uint64
+	for _, key := range keys {
+		if fp := qf.Fingerprint64(key); qf.Contains(key) && !slices.Contains(want, fp) {
+			want = append(want, fp)
+		}
+	}
+	slices.Sort(want)
+	fps := qf.Fingerprints()
+	if len(qf.stash) == 0 || !slices.Equal(fps, want) || uint64(len(fps)) != qf.len+uint64(len(qf.stash)) {
+		t.Fatal("Unexpected fingerprints", len(fps), len(want), len(qf.stash))
+	}
+
+	// a fresh filter with the fingerprints in any order has the same fingerprints.
+	fresh, _ := NewWithOptions(0, WithQR(10, 6), WithStash(8, 2), WithMaxLoadFactor(0.95))
+	shuffled := slices.Clone(fps)
+	slices.Reverse(shuffled)
+	if err := fresh.AddFingerprints(append(shuffled, shuffled[:10]...)); err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	if again := fresh.Fingerprints(); !slices.Equal(again, fps) || !fresh.ContainsAll(keys[:700]) {
+		t.Fatal("Fingerprints of the fresh filter differ")
+	}
+	if !slices.Equal(fresh.Fingerprints(), fresh.Fingerprints()) {
+		t.Fatal("Fingerprints are not stable")
+	}
+	if fps := MustNew(8, 8).Fingerprints(); len(fps) != 0 {
+		t.Fatal("Unexpected fingerprints of an empty filter", fps)
+	}
+}
+
+func TestAddFingerprintsErrors(t *testing.T) {
+	qf := MustNew(6, 4)
+	if err := qf.AddFingerprints([]uint64{1, 2, 1 << 10}); err == nil || qf.Len() != 0 {
+		t.Fatal("Expected an error for a fingerprint of more than q + r bits", err)
+	}
+	fps := make([]uint64, 64)
+	for i := range fps {
+		fps[i] = uint64(i) << 4
+	}
+	var batchErr *BatchError
+	if err := qf.AddFingerprints(fps); !errors.As(err, &batchErr) || !errors.Is(err, ErrFull) || batchErr.Index != int(qf.maxLen) {
+		t.Fatal("Expected ErrFull at", qf.maxLen, "got", err)
+	}
+}
+
+func TestDigest(t *testing.T) {
+	keys := randomItems(3000)
+	newFilter := func() *QuotientFilter {
+		qf, _ := NewWithOptions(0, WithQR(12, 9), WithStash(32, 3))
+		return qf
+	}
+	forward, backward := newFilter(), newFilter()
+	forward.AddAll(keys)
+	for i := len(keys) - 1; i >= 0; i-- {
+		backward.Add(keys[i])
+	}
+	// deleting and adding again moves fingerprints in and out of the stash.
+	churned := newFilter()
+	churned.AddAll(append(randomItems(100), keys...))
+	churned.Reset()
+	churned.AddAll(keys[1500:])
+	churned.AddAll(keys[:1500])
+	extra := randomItems(50)
+	churned.AddAll(extra)
+	churned.DeleteAll(extra)
+	if !slices.Equal(forward.Fingerprints(), churned.Fingerprints()) {
+		t.Skip("a deleted key shared a fingerprint with a key of the set")
+	}
+	if slices.Equal(forward.stash, backward.stash) && bytes.Equal(forward.data, backward.data) {
+		t.Fatal("Insertion order did not change the layout, the test needs other parameters")
+	}
+	want := forward.Digest()
+	for _, qf := range []*QuotientFilter{backward, churned, forward.Clone()} {
+		if qf.Digest() != want {
+			t.Fatal("Digests of the same fingerprints differ")
+		}
+	}
+	forward.Add("fox")
+	if forward.Digest() == want {
+		t.Fatal("Digest did not change with an extra key")
+	}
+	other, _ := NewWithOptions(0, WithQR(13, 8), WithStash(32, 3))
+	if MustNew(12, 9).Digest() == other.Digest() {
+		t.Fatal("Digests of empty filters of other parameters are equal")
+	}
+}
+
+func TestFingerprintsConcurrent(t *testing.T) {
+	qf, _ := NewWithOptions(0, WithQR(14, 20))
+	keys := randomItems(3000)
+	churn := randomItems(500)
+	var added atomic.Int64
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i, key := range keys {
+			if err := qf.Add(key); err != nil {
+				t.Error("Unexpected error", err)
+				return
+			}
+			if i%6 == 0 {
+				qf.Add(churn[i/6])
+				qf.Delete(churn[i/6])
+			}
+			added.Store(int64(i + 1))
+		}
+	}()
+	for done := false; !done; {
+		before := added.Load()
+		fps := qf.Fingerprints()
+		// Digest reads the table under the lock too, which the race detector checks.
+		qf.Digest()
+		done = before == int64(len(keys))
+		if !slices.IsSorted(fps) {
+			t.Fatal("Fingerprints are not sorted")
+		}
+		for _, key := range keys[:before] {
+			if _, found := slices.BinarySearch(fps, qf.Fingerprint64(key)); !found {
+				t.Fatal("Fingerprints are missing", key, "added before them")
+			}
+		}
+	}
+	wg.Wait()
+}
@@ -0,0 +1,136 @@
+package qf
+
+import (
+	"errors"
+	"fmt"
+	"hash"
+	"hash/fnv"
+	"strings"
+)
+
+// ErrHashMismatch is returned when decoding a filter whose hash function the decoder can't
+// supply, a custom hash without a filter or resolver providing it, see WithHashResolver.
+// Looking keys up with another hash function than the one they were added with returns
+// wrong results.
+var ErrHashMismatch = errors.New("filter hash function not available")
+
+// HashResolver returns the constructor of the hash function named name with the seed it
+// was created with, see WithNamedHash. It returns nil if it does not know the hash.
+type HashResolver func(name string, seed []byte) (func() hash.Hash64, error)
+
+// builtinHashes are the hash functions decoding creates by name.
+var builtinHashes = map[string]HashResolver{
+	HashFNV64a:   resolveSeeded,
+	HashMaphash:  resolveMaphash,
+	HashXXHash64: resolveSeeded,
+	HashIdentity: func(name string, seed []byte) (func() hash.Hash64, error) {
+		if len(seed) > 0 {
+			return nil, fmt.Errorf("%s has no seed", name)
+		}
+		return newIdentityHash, nil
+	},
+}
+
+func newFNV64a() hash.Hash64 {
+	return fnv.New64a()
+}
+
+// FNV-64a offset basis and prime, the hash of hash/fnv.
+const (
+	fnvOffset64 = 14695981039346656037
+	fnvPrime64  = 1099511628211
+)
+
+// fnv64a continues the FNV-64a hash h, starting at fnvOffset64, over b. Unlike hash/fnv it
+// keeps no state, filters with the built-in hash hash keys with it.
+func fnv64a[T string | []byte](h uint64, b T) uint64 {
+	for i := 0; i < len(b); i++ {
+		h ^= uint64(b[i])
+		h *= fnvPrime64
+	}
+	return h
+}
+
+// WithNamedHash replaces the default hash function like WithHash, naming it so that encoded
+// filters record which hash function they use. seed is recorded with the name, it can be nil
+// for hash functions without one. Decoding such a filter needs a filter created with the same
+// name and seed, or a resolver that knows it, see WithHashResolver. The name can't be one of
+// the built-in ones or HashCustom.
+func WithNamedHash(name string, seed []byte, h func() hash.Hash64) Option {
+	return func(c *config) error {
+		if h == nil {
+			return errors.New("hash function constructor is nil")
+		}
+		if err := validHashName(name); err != nil {
+			return err
+		}
+		c.newHash, c.hashID, c.hashSeed, c.hash128 = h, name, string(seed), nil
+		return nil
+	}
+}
+
+// validHashName returns an error if name can't name a custom hash function.
+func validHashName(name string) error {
+	switch {
+	case name == "" || name == HashCustom || builtinHashes[name] != nil:
+		return fmt.Errorf("hash function name %q is empty or reserved", name)
+	case strings.IndexByte(name, 0) >= 0:
+		return fmt.Errorf("hash function name %q contains a zero byte", name)
+	}
+	return nil
+}
+
+// WithHashResolver makes decoding into the filter create the hash functions of encoded
+// filters with resolve when they are neither built in nor the filter's own. Filters decoded
+// into the filter keep the resolver.
+func WithHashResolver(resolve HashResolver) Option {
+	return func(c *config) error {
+		if resolve == nil {
+			return errors.New("hash resolver is nil")
+		}
+		c.resolver = resolve
+		return nil
+	}
+}
+
+// hashIdentity returns the hash id of the encodings, the name of the hash function followed
+// by a zero byte and the seed for seeded hash functions.
+func (qf *QuotientFilter) hashIdentity() string {
+	if qf.hashSeed == "" {
+		return qf.hashID
+	}
+	return qf.hashID + "\x00" + qf.hashSeed
+}
+
+// splitHashIdentity splits a hash id of an encoding into the name and the seed.
+func splitHashIdentity(id string) (name, seed string) {
+	name, seed, _ = strings.Cut(id, "\x00")
+	return name, seed
+}
+
+// resolveHash returns the constructor of the hash function name with seed, a built-in one,
+// the one of the filter or one of its resolver.
+func (qf *QuotientFilter) resolveHash(name, seed string) (func() hash.Hash64, error) {
+	if resolve := builtinHashes[name]; resolve != nil {
+		newHash, err := resolve(name, []byte(seed))
+		if errors.Is(err, ErrHashMismatch) {
+			return nil, err
+		} else if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidEncoding, err)
+		}
+		return newHash, nil
+	}
+	if name == qf.hashID && seed == qf.hashSeed && qf.newHash != nil {
+		return qf.newHash, nil
+	}
+	if qf.resolver != nil {
+		newHash, err := qf.resolver(name, []byte(seed))
+		if err != nil {
+			return nil, fmt.Errorf("%w: hash function %q: %w", ErrHashMismatch, name, err)
+		}
+		if newHash != nil {
+			return newHash, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: filter uses hash function %q, decode it into a filter created with the same hash or a resolver for it", ErrHashMismatch, name)
+}
@@ -0,0 +1,72 @@
+package qf
+
+import "errors"
+
+// Hash128 is a 128-bit hash function, returning the high and low halves of the hash of key.
+type Hash128 func(key []byte) (hi, lo uint64)
+
+// WithHash128 hashes keys with the 128-bit hash function h. The quotient is drawn from the
+// high half of the hash and the remainder from the low half, so that they are independent
+// even when q + r is close to 64, and q + r can be above 64, up to 63 quotient and 61
+// remainder bits, such as q 30 and r 40.
+//
+// Fingerprints of more than 64 bits don't fit in the 64 bit hashes and fingerprints of the
+// other hash functions. Such filters add and look up keys of every type, and encode and
+// decode like other filters, but AddHash and AddComparable return an error, ContainsHash and
+// ContainsComparable return false, Fingerprints returns nil, AddFingerprints returns an
+// error and Fingerprint64 returns the low 64 bits of the fingerprint. They can't have a
+// stash, and can't be striped or sharded.
+func WithHash128(h Hash128) Option {
+	return func(c *config) error {
+		if h == nil {
+			return errors.New("hash function is nil")
+		}
+		c.hash128, c.newHash = h, nil
+		c.hashID, c.hashSeed = HashCustom, ""
+		return nil
+	}
+}
+
+// WithNamedHash128 names the 128-bit hash function h like WithNamedHash, so that encoded
+// filters record which hash function they use. Decoding such a filter needs a filter
+// created with the same name and seed, resolvers only supply 64-bit hash functions.
+func WithNamedHash128(name string, seed []byte, h Hash128) Option {
+	return func(c *config) error {
+		if h == nil {
+			return errors.New("hash function is nil")
+		}
+		if err := validHashName(name); err != nil {
+			return err
+		}
+		c.hash128, c.newHash = h, nil
+		c.hashID, c.hashSeed = name, string(seed)
+		return nil
+	}
+}
+
+// errWideHash is the error of adding a 64 bit hash to a filter of q + r above 64 bits.
+var errWideHash = errors.New("filters of q + r above 64 bits have no 64 bit hashes, add the keys")
+
+// errWideStash is the error of creating a filter of q + r above 64 bits with a stash, which
+// holds 64 bit fingerprints.
+var errWideStash = errors.New("filters of q + r above 64 bits can't have a stash")
+
+// fingerprint128 returns the hash of key and the quotient and remainder it is stored as for
+// filters of q + r above 64 bits: the low q bits of the high half and the low r bits of the
+// low half of its 128-bit hash. The hash, which identifies reported false positives, folds
+// them like fold128, into the low 64 bits of the fingerprint.
+func (qf *QuotientFilter) fingerprint128(key []byte) (h, q, r uint64) {
+	hi, lo := qf.hash128(key)
+	q, r = hi&qf.qMask, lo&qf.rMask
+	return q<<qf.rbits | r, q, r
+}
+
+// fold128 returns the 64-bit hash function of filters with r remainder bits using h: the
+// low q bits of the high half above the low r bits of the low half.
+func fold128(h Hash128, r uint8) func([]byte) uint64 {
+	mask := maskLower(uint64(r))
+	return func(key []byte) uint64 {
+		hi, lo := h(key)
+		return hi<<r | lo&mask
+	}
+}
@@ -0,0 +1,197 @@
+package qf
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+)
+
+// sha128 is the reference 128-bit hash of the tests, the first 16 bytes of SHA-256.
+func sha128(key []byte) (hi, lo uint64) {
+	sum := sha256.Sum256(key)
+	return binary.BigEndian.Uint64(sum[:8]), binary.BigEndian.Uint64(sum[8:16])
+}
+
+func TestHash128(t *testing.T) {
+	qf, err := NewWithOptions(0, WithQR(16, 10), WithHash128(sha128))
+	if err != nil || qf.Params().Hash != HashCustom {
+		t.Fatal("Unexpected result creating a filter with a 128-bit hash", err)
+	}
+	// the quotient comes from the high half and the remainder from the low half.
+	hi, lo := sha128([]byte("fox"))
+	if q, r := qf.Fingerprint("fox"); q != hi&(1<<16-1) || r != lo&(1<<10-1) {
+		t.Fatal("Fingerprint", q, r, "is not drawn from the halves", hi, lo)
+	}
+
+	const n = 30000
+	for i := 0; i < n; i++ {
+		qf.Add(fmt.Sprint("key", i))
+	}
+	for i := 0; i < n; i++ {
+		if !qf.Contains(fmt.Sprint("key", i)) || !qf.ContainsBytes([]byte(fmt.Sprint("key", i))) {
+			t.Fatal("False negative", i)
+		}
+	}
+	const absent = 200000
+	fps := 0
+	for i := 0; i < absent; i++ {
+		if qf.Contains(fmt.Sprint("absent", i)) {
+			fps++
+		}
+	}
+	rate, want := float64(fps)/absent, qf.FPProbability()
+	if math.Abs(rate-want) > want/2 {
+		t.Fatal("False positive rate", rate, "want about", want)
+	}
+
+	// decoding into a filter with the same named hash folds it for the encoded r.
+	named, _ := NewWithOptions(0, WithQR(12, 20), WithNamedHash128("sha128", nil, sha128))
+	named.Add("fox")
+	b, _ := named.MarshalBinary()
+	target, _ := NewWithOptions(0, WithQR(4, 4), WithNamedHash128("sha128", nil, sha128))
+	if err := target.UnmarshalBinary(b); err != nil || !target.Contains("fox") || target.Params() != named.Params() {
+		t.Fatal("Unexpected result decoding a filter with a named 128-bit hash", err)
+	}
+	if !named.ContainsKey(target.NewKey("fox")) || !named.Clone().Contains("fox") {
+		t.Fatal("Missing key hashed by another filter or in a clone")
+	}
+
+	if _, err := NewWithOptions(0, WithHash128(nil)); err == nil {
+		t.Fatal("A nil 128-bit hash function was accepted")
+	}
+	if _, err := NewWithOptions(0, WithQR(30, 40)); err == nil {
+		t.Fatal("Fingerprints of more than 64 bits were accepted without a 128-bit hash")
+	}
+}
+
+func TestHash128Wide(t *testing.T) {
+	qf, err := NewWithOptions(0, WithQR(16, 56), WithNamedHash128("sha128", nil, sha128))
+	if err != nil {
+		t.Fatal("Unexpected error creating a filter of q + r above 64 bits", err)
+	}
+	// neither half is folded into the other.
+	hi, lo := sha128([]byte("fox"))
+	if q, r := qf.Fingerprint("fox"); q != hi&(1<<16-1) || r != lo&(1<<56-1) {
+		t.Fatal("Fingerprint", q, r, "is not drawn from the halves", hi, lo)
+	}
+
+	const n = 30000
+	for i := 0; i < n; i++ {
+		if err := qf.Add(fmt.Sprint("key", i)); err != nil {
+			t.Fatal("Unexpected error adding", i, err)
+		}
+	}
+	for i := 0; i < n; i++ {
+		k := fmt.Sprint("key", i)
+		if !qf.Contains(k) || !qf.ContainsBytes([]byte(k)) || !qf.ContainsKey(qf.NewKey(k)) {
+			t.Fatal("False negative", i)
+		}
+	}
+	for i := 0; i < 200000; i++ {
+		if qf.Contains(fmt.Sprint("absent", i)) {
+			t.Fatal("False positive with 56 bits of remainder", i)
+		}
+	}
+
+	qf.AddUint64(7)
+	qf.AddNS("ns", "fox")
+	qf.AddReader(strings.NewReader("reader"))
+	if _, err := qf.AddFromReader(strings.NewReader("line1\nline2\n")); err != nil {
+		t.Fatal("Unexpected error adding lines", err)
+	}
+	if !qf.ContainsUint64(7) || !qf.ContainsNS("ns", "fox") || qf.ContainsNS("other", "fox") || !qf.Contains("reader") || !qf.Contains("line2") {
+		t.Fatal("Missing key added with another method")
+	}
+	sealed := qf.Clone().Seal()
+	if !sealed.Contains("key1") || !sealed.ContainsUint64(7) || !sealed.ContainsBatchParallel([]string{"key2"}, 2)[0] {
+		t.Fatal("Missing key in a sealed clone")
+	}
+	safe := NewSafe(qf.Clone())
+	if err := safe.Add("safe"); err != nil || !safe.Contains("safe") || !safe.Contains("key1") || !safe.Delete("safe") || safe.Contains("safe") {
+		t.Fatal("Unexpected result adding and deleting through Safe", err)
+	}
+	if !qf.Delete("key1") || qf.Contains("key1") {
+		t.Fatal("Unexpected result deleting a key")
+	}
+
+	// the 64-bit forms of the fingerprints don't exist for these filters.
+	if err := qf.AddHash(1); err == nil || qf.ContainsHash(qf.Fingerprint64("key2")) || qf.Fingerprints() != nil || qf.AddFingerprints([]uint64{1}) == nil {
+		t.Fatal("Unexpected result of an operation on 64-bit fingerprints")
+	}
+	if _, err := NewWithOptions(0, WithQR(16, 56), WithHash128(sha128), WithStash(8, 0)); err == nil {
+		t.Fatal("A stash was accepted")
+	}
+	if _, err := NewStriped(qf.Clone(), 4); err == nil {
+		t.Fatal("Striping was accepted")
+	}
+
+	// decoding needs the same 128-bit hash function, which splits it for the encoded q and r.
+	b, err := qf.MarshalBinary()
+	if err != nil {
+		t.Fatal("Unexpected error encoding", err)
+	}
+	target, _ := NewWithOptions(0, WithQR(4, 4), WithNamedHash128("sha128", nil, sha128))
+	if err := target.UnmarshalBinary(b); err != nil || !target.Contains("key2") || target.Contains("key1") || target.Params() != qf.Params() || target.Digest() != qf.Digest() {
+		t.Fatal("Unexpected result decoding", err)
+	}
+	if plain, _ := New(4, 4); plain.UnmarshalBinary(b) == nil {
+		t.Fatal("Decoding without the 128-bit hash function was accepted")
+	}
+}
+
+func TestHash128WideFPRate(t *testing.T) {
+	// a hash function with 10 bits in its low half, the remainder can't distinguish more keys
+	// than that when it is not folded with the quotient bits of the high half.
+	narrow := func(key []byte) (hi, lo uint64) {
+		hi, lo = sha128(key)
+		return hi, lo & (1<<10 - 1)
+	}
+	qf, err := NewWithOptions(0, WithQR(16, 52), WithHash128(narrow), WithoutHashCheck())
+	if err != nil {
+		t.Fatal("Unexpected error creating a filter", err)
+	}
+	if err := qf.probeHash(); !errors.Is(err, ErrWeakHash) {
+		t.Fatal("The hash check missed the 42 fixed remainder bits", err)
+	}
+	const n = 30000
+	for i := 0; i < n; i++ {
+		if err := qf.Add(fmt.Sprint("key", i)); err != nil {
+			t.Fatal("Unexpected error adding", i, err)
+		}
+	}
+	const absent = 200000
+	fps := 0
+	for i := 0; i < absent; i++ {
+		if qf.Contains(fmt.Sprint("absent", i)) {
+			fps++
+		}
+	}
+	rate, want := float64(fps)/absent, fpProbability(n, qf.Cap(), 10)
+	if math.Abs(rate-want) > want/2 {
+		t.Fatal("False positive rate", rate, "want about", want)
+	}
+}
+
+func TestHash128WideSparse(t *testing.T) {
+	qf, err := NewWithOptions(0, WithQR(30, 40), WithHash128(sha128), WithSparse())
+	if err != nil {
+		t.Fatal("Unexpected error creating a sparse filter of 70 bits", err)
+	}
+	for i := 0; i < 1000; i++ {
+		if err := qf.Add(fmt.Sprint("key", i)); err != nil {
+			t.Fatal("Unexpected error adding", i, err)
+		}
+	}
+	for i := 0; i < 1000; i++ {
+		if !qf.Contains(fmt.Sprint("key", i)) {
+			t.Fatal("False negative", i)
+		}
+		if qf.Contains(fmt.Sprint("absent", i)) {
+			t.Fatal("False positive", i)
+		}
+	}
+}
@@ -0,0 +1,406 @@
+package qf
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/fnv"
+	"hash/maphash"
+	"net"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// seededFNV is a custom hash function, FNV-64 with the seed written first.
+func seededFNV(seed []byte) func() hash.Hash64 {
+	return func() hash.Hash64 {
+		h := fnv.New64()
+		h.Write(seed)
+		return resetTo{h, append([]byte(nil), seed...)}
+	}
+}
+
+// resetTo writes the seed again on Reset.
+type resetTo struct {
+	hash.Hash64
+	seed []byte
+}
+
+func (h resetTo) Reset() {
+	h.Hash64.Reset()
+	h.Hash64.Write(h.seed)
+}
+
+func TestNamedHash(t *testing.T) {
+	seed := []byte("seed")
+	named, err := NewWithOptions(0, WithQR(10, 10), WithNamedHash("seeded-fnv", seed, seededFNV(seed)))
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	named.AddAll([]string{"fox", "dog"})
+	if named.Params().Hash != "seeded-fnv" || named.Fingerprint64("fox") == MustNew(10, 10).Fingerprint64("fox") {
+		t.Fatal("Named hash is not used")
+	}
+	b, _ := named.MarshalBinary()
+	path := filepath.Join(t.TempDir(), "filter.qf")
+	named.SaveToFile(path)
+
+	// a zero filter and filters with another seed can't supply the hash.
+	mismatched := []Option{WithNamedHash("seeded-fnv", []byte("other"), seededFNV([]byte("other")))}
+	for _, opts := range [][]Option{nil, mismatched} {
+		if _, err := LoadFromFile(path, opts...); !errors.Is(err, ErrHashMismatch) || !strings.Contains(err.Error(), "seeded-fnv") {
+			t.Fatal("Expected ErrHashMismatch naming the hash, got", err)
+		}
+	}
+	// a filter with the same hash and a resolver can.
+	same, _ := NewWithOptions(0, WithQR(4, 4), WithNamedHash("seeded-fnv", seed, seededFNV(seed)))
+	if err := same.UnmarshalBinary(b); err != nil || !same.ContainsAll([]string{"fox", "dog"}) {
+		t.Fatal("Unexpected result decoding into a filter with the same hash", err)
+	}
+	var resolved []string
+	resolver := WithHashResolver(func(name string, seed []byte) (func() hash.Hash64, error) {
+		resolved = append(resolved, name+"/"+string(seed))
+		if name != "seeded-fnv" {
+			return nil, nil
+		}
+		return seededFNV(seed), nil
+	})
+	loaded, err := LoadFromFile(path, resolver)
+	if err != nil || !loaded.ContainsAll([]string{"fox", "dog"}) || loaded.Contains("cat") {
+		t.Fatal("Unexpected result loading with a resolver", err)
+	}
+	if len(resolved) != 1 || resolved[0] != "seeded-fnv/seed" {
+		t.Fatal("Resolver called with", resolved)
+	}
+	// the decoded filter keeps the name, seed and resolver.
+	if again, _ := loaded.MarshalBinary(); !bytes.Equal(again, b) {
+		t.Fatal("Loaded filter encodes differently")
+	}
+	if err := loaded.UnmarshalBinary(b); err != nil || len(resolved) != 1 {
+		t.Fatal("Unexpected result decoding into the loaded filter", err, resolved)
+	}
+	custom, _ := NewHash(fnv.New64(), 8, 8)
+	cb, _ := custom.MarshalBinary()
+	if err := loaded.UnmarshalBinary(cb); !errors.Is(err, ErrHashMismatch) || len(resolved) != 2 {
+		t.Fatal("Expected ErrHashMismatch from a resolver not knowing the hash, got", err)
+	}
+	failing := WithHashResolver(func(name string, seed []byte) (func() hash.Hash64, error) {
+		return nil, errors.New("no such hash")
+	})
+	if _, err := LoadFromFile(path, failing); !errors.Is(err, ErrHashMismatch) || !strings.Contains(err.Error(), "no such hash") {
+		t.Fatal("Expected ErrHashMismatch wrapping the resolver error, got", err)
+	}
+
+	// built-in hashes are created again whatever hash the filter decoded into has.
+	plain := MustNew(10, 10)
+	plain.Add("fox")
+	pb, _ := plain.MarshalBinary()
+	if err := same.UnmarshalBinary(pb); err != nil || !same.Contains("fox") || same.Params() != plain.Params() {
+		t.Fatal("Unexpected result decoding a default hash filter", err)
+	}
+
+	// the seed survives JSON.
+	j, _ := json.Marshal(named)
+	fromJSON, _ := NewWithOptions(0, WithQR(4, 4), resolver)
+	if err := json.Unmarshal(j, fromJSON); err != nil || !fromJSON.Contains("fox") || fromJSON.hashSeed != "seed" {
+		t.Fatal("Unexpected result decoding JSON", err)
+	}
+	if named.Digest() == same.Digest() {
+		t.Fatal("Digests of filters with different seeds are equal")
+	}
+}
+
+func TestNamedHashErrors(t *testing.T) {
+	for _, name := range []string{"", HashCustom, HashFNV64a, "a\x00b"} {
+		if _, err := NewWithOptions(0, WithQR(8, 8), WithNamedHash(name, nil, fnv.New64)); err == nil {
+			t.Fatalf("Expected an error for hash name %q", name)
+		}
+	}
+	if _, err := NewWithOptions(0, WithQR(8, 8), WithNamedHash("fnv64", nil, nil)); err == nil {
+		t.Fatal("Expected an error for a nil constructor")
+	}
+	if _, err := NewWithOptions(0, WithQR(8, 8), WithHashResolver(nil)); err == nil {
+		t.Fatal("Expected an error for a nil resolver")
+	}
+	// the built-in FNV-64a has no seed.
+	qf := MustNew(8, 8)
+	qf.hashSeed = "seed"
+	b, _ := qf.MarshalBinary()
+	if err := new(QuotientFilter).UnmarshalBinary(b); !errors.Is(err, ErrInvalidEncoding) {
+		t.Fatal("Expected ErrInvalidEncoding for a seeded FNV-64a, got", err)
+	}
+}
+
+func TestNewHashFactory(t *testing.T) {
+	var created atomic.Int64
+	factory := func() hash.Hash64 {
+		created.Add(1)
+		return fnv.New64()
+	}
+	a, err := NewHashFactory(factory, 14, 16)
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	b, _ := NewHashFactory(factory, 14, 16)
+	if a.h == b.h || a.Params() != mustNew(NewHash(fnv.New64(), 14, 16)).Params() {
+		t.Fatal("Filters of one factory share a hasher or have other params")
+	}
+	items := randomItems(2000)
+	a.AddAll(items[:1000])
+	b.AddAll(items[1000:])
+	clone := a.Clone()
+	if clone.h == a.h || created.Load() < 3 {
+		t.Fatal("Clone did not get a hasher of its own")
+	}
+	clone.AddAll(items[1000:])
+
+	// the filters of one factory and the clone hash concurrently without interfering.
+	var wg sync.WaitGroup
+	for _, c := range []struct {
+		qf   *QuotientFilter
+		keys []string
+	}{{a, items[:1000]}, {b, items[1000:]}, {clone, items}} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			n, err := c.qf.AddFromReader(strings.NewReader(strings.Join(c.keys, "\n")))
+			if n != len(c.keys) || err != nil {
+				t.Error("AddFromReader returned", n, err)
+			}
+			for i, found := range c.qf.ContainsBatchParallel(c.keys, 4) {
+				if !found {
+					t.Error("Missing", c.keys[i])
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	// decoding creates the hashers of the decoded filter with the factory.
+	ab, _ := a.MarshalBinary()
+	before := created.Load()
+	if err := b.UnmarshalBinary(ab); err != nil || !b.Contains(items[0]) || b.h == a.h || created.Load() == before {
+		t.Fatal("Decoding did not create a new hasher", err)
+	}
+}
+
+func TestHashFunc(t *testing.T) {
+	oneShot := func(b []byte) uint64 { return fnv64a(fnvOffset64, b) }
+	plain := MustNew(12, 16)
+	f, err := NewWithOptions(0, WithQR(12, 16), WithHashFunc(oneShot))
+	if err != nil || f.hashFunc == nil || f.Params().Hash != HashCustom {
+		t.Fatal("Unexpected result creating a filter with a hash function", err)
+	}
+	// the one-shot FNV-64a is the built-in hash, every kind of key hashes the same.
+	lines := "fox\r\ndog\n\ncat"
+	for _, qf := range []*QuotientFilter{plain, f} {
+		qf.Add("key")
+		qf.AddNS("ns", "key")
+		qf.AddUint64(7)
+		qf.AddIP(net.ParseIP("10.0.0.1"))
+		if _, err := qf.AddFromReader(strings.NewReader(lines)); err != nil {
+			t.Fatal("Unexpected error", err)
+		}
+	}
+	if !slices.Equal(plain.Fingerprints(), f.Fingerprints()) {
+		t.Fatal("Hash function hashes differently than FNV-64a")
+	}
+	if got := f.ContainsBatchParallel([]string{"key", "fox", "cat", "absent"}, 2); !slices.Equal(got, []bool{true, true, true, false}) {
+		t.Fatal("ContainsBatchParallel returned", got)
+	}
+	if clone := f.Clone(); clone.hashFunc == nil || !clone.Contains("dog") {
+		t.Fatal("Clone does not hash with the hash function")
+	}
+
+	// named hash functions are recorded in the encoding and supplied when decoding.
+	named, _ := NewWithOptions(0, WithQR(10, 10), WithNamedHashFunc("fnv64a-func", []byte("s"), oneShot))
+	named.Add("fox")
+	b, _ := named.MarshalBinary()
+	same, _ := NewWithOptions(0, WithQR(4, 4), WithNamedHashFunc("fnv64a-func", []byte("s"), oneShot))
+	resolved, _ := NewWithOptions(0, WithQR(4, 4), WithHashResolver(func(name string, seed []byte) (func() hash.Hash64, error) {
+		if name == "fnv64a-func" && string(seed) == "s" {
+			return HashFunc(oneShot), nil
+		}
+		return nil, nil
+	}))
+	for _, qf := range []*QuotientFilter{same, resolved} {
+		if err := qf.UnmarshalBinary(b); err != nil || qf.hashFunc == nil || !qf.Contains("fox") || qf.Params() != named.Params() {
+			t.Fatal("Unexpected result decoding a filter with a named hash function", err)
+		}
+	}
+	if err := MustNew(4, 4).UnmarshalBinary(b); !errors.Is(err, ErrHashMismatch) {
+		t.Fatal("Expected ErrHashMismatch, got", err)
+	}
+
+	if _, err := NewWithOptions(0, WithHashFunc(nil)); err == nil {
+		t.Fatal("A nil hash function was accepted")
+	}
+	if _, err := NewWithOptions(0, WithNamedHashFunc(HashFNV64a, nil, oneShot)); err == nil {
+		t.Fatal("A hash function with a built-in name was accepted")
+	}
+}
+
+func TestMaphash(t *testing.T) {
+	qf, err := NewWithOptions(1000, WithMaphash())
+	if err != nil || qf.Params().Hash != HashMaphash || qf.hashFunc == nil {
+		t.Fatal("Unexpected result creating a maphash filter", err)
+	}
+	items := randomItems(1000)
+	qf.AddAll(items)
+	key := []byte(items[0])
+	if n := testing.AllocsPerRun(100, func() { qf.ContainsBytes(key) }); n != 0 {
+		t.Fatal("Looking up a byte key allocated", n)
+	}
+
+	// the seed id survives encoding, decoding into any filter of this process works.
+	b, _ := qf.MarshalBinary()
+	path := filepath.Join(t.TempDir(), "maphash.qf")
+	if err := qf.SaveToFile(path); err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	decoded := MustNew(4, 4)
+	if err := decoded.UnmarshalBinary(b); err != nil || decoded.Params() != qf.Params() {
+		t.Fatal("Unexpected result decoding a maphash filter", err)
+	}
+	loaded, err := LoadFromFile(path)
+	if err != nil {
+		t.Fatal("Unexpected error loading a maphash filter", err)
+	}
+	for _, k := range items {
+		if !decoded.Contains(k) || !loaded.Contains(k) {
+			t.Fatal("Missing after decoding", k)
+		}
+	}
+
+	// filters with different seeds store keys differently, with the same seed identically.
+	seed := maphash.MakeSeed()
+	a, _ := NewWithOptions(1000, WithMaphashSeed(seed))
+	same, _ := NewWithOptions(1000, WithMaphashSeed(seed))
+	other, _ := NewWithOptions(1000, WithMaphash())
+	if a.hashIdentity() != same.hashIdentity() || a.hashIdentity() == other.hashIdentity() {
+		t.Fatal("Hash ids of maphash filters don't tell their seeds apart")
+	}
+	differ := 0
+	for _, k := range items[:100] {
+		if a.Fingerprint64(k) != same.Fingerprint64(k) {
+			t.Fatal("Filters with the same seed disagree on", k)
+		}
+		if a.Fingerprint64(k) != other.Fingerprint64(k) {
+			differ++
+		}
+	}
+	if differ < 95 {
+		t.Fatal("Filters with different seeds agree on", 100-differ, "of 100 fingerprints")
+	}
+
+	// seeds of other processes are unknown.
+	foreign := qf.Clone()
+	foreign.hashSeed = strings.Repeat("x", len(qf.hashSeed))
+	fb, _ := foreign.MarshalBinary()
+	if err := MustNew(4, 4).UnmarshalBinary(fb); !errors.Is(err, ErrHashMismatch) {
+		t.Fatal("Expected ErrHashMismatch decoding a foreign seed, got", err)
+	}
+}
+
+// failingHash is a hash.Hash64 whose Write fails.
+type failingHash struct{ hash.Hash64 }
+
+func (failingHash) Write([]byte) (int, error) { return 0, errors.New("broken hash") }
+
+func TestHashWriteError(t *testing.T) {
+	qf, _ := NewWithOptions(0, WithQR(10, 10), WithHash(func() hash.Hash64 { return failingHash{fnv.New64a()} }), WithoutHashCheck())
+	if _, err := qf.AddFromReader(strings.NewReader("fox\ndog")); err == nil || !strings.Contains(err.Error(), "broken hash") {
+		t.Fatal("Expected the Write error from AddFromReader, got", err)
+	}
+	if err := qf.AddReader(strings.NewReader("fox")); err == nil {
+		t.Fatal("Expected the Write error from AddReader")
+	}
+	for name, fn := range map[string]func(){
+		"Add":      func() { qf.Add("fox") },
+		"Contains": func() { qf.Contains("fox") },
+		"AddNS":    func() { qf.AddNS("ns", "fox") },
+		"ContainsBatchParallel": func() {
+			qf.ContainsBatchParallel([]string{"fox"}, 1)
+		},
+	} {
+		func() {
+			defer func() {
+				if r := recover(); r == nil || !strings.Contains(fmt.Sprint(r), "broken hash") {
+					t.Error(name, "did not panic with the Write error:", r)
+				}
+			}()
+			fn()
+		}()
+	}
+}
+
+// TestAddContainsAllocs checks that adding and looking up keys and hashes allocates nothing
+// with the built-in hash functions and those hashing inline, which is part of the API.
+func TestAddContainsAllocs(t *testing.T) {
+	for name, opt := range map[string]Option{
+		"default":  WithMaxLoadFactor(DefaultMaxLoadFactor),
+		"seeded":   WithSeed(7),
+		"xxhash64": WithXXHash(),
+		"maphash":  WithMaphash(),
+		"identity": WithIdentityHash(),
+		"func":     WithHashFunc(func(b []byte) uint64 { return xxhash64(1, b) }),
+		"128":      WithHash128(func(b []byte) (uint64, uint64) { return xxhash64(1, b), xxhash64(2, b) }),
+	} {
+		qf, _ := NewWithOptions(10000, opt)
+		keys := randomItems(2002)
+		hashes := make([]uint64, len(keys))
+		for i, k := range keys {
+			hashes[i] = qf.hashString(k)
+		}
+		for _, c := range []struct {
+			name string
+			op   func(i int)
+		}{
+			{"Add", func(i int) { qf.Add(keys[i]) }},
+			{"Contains", func(i int) { qf.Contains(keys[i]) }},
+			{"AddHash", func(i int) { qf.AddHash(hashes[len(hashes)/2+i]) }},
+			{"ContainsHash", func(i int) { qf.ContainsHash(hashes[len(hashes)/2+i]) }},
+		} {
+			// AllocsPerRun calls op once more than the runs, every call takes a new key.
+			i := 0
+			if n := testing.AllocsPerRun(1000, func() { c.op(i); i++ }); n != 0 {
+				t.Error(name, c.name, "allocated", n)
+			}
+		}
+	}
+}
+
+func TestHashStringAllocs(t *testing.T) {
+	key := "https://www.example.com/catalog/products/42/reviews?page=7"
+	for name, opt := range map[string]Option{
+		"fnv64a":   WithQR(16, 16),
+		"seeded":   WithSeed(7),
+		"xxhash64": WithXXHash(),
+		"maphash":  WithMaphash(),
+		"pooled":   WithHash(func() hash.Hash64 { return fnv.New64() }),
+		"func":     WithHashFunc(func(b []byte) uint64 { return xxhash64(1, b) }),
+		"128":      WithHash128(func(b []byte) (uint64, uint64) { return xxhash64(1, b), xxhash64(2, b) }),
+	} {
+		qf, _ := NewWithOptions(1000, opt)
+		if err := qf.Add(key); err != nil {
+			t.Fatal(name, "Unexpected error", err)
+		}
+		// string keys hash like their bytes.
+		if qf.hashString(key) != qf.hash([]byte(key)) || !qf.ContainsBytes([]byte(key)) {
+			t.Fatal(name, "String and []byte keys hash differently")
+		}
+		if n := testing.AllocsPerRun(100, func() { qf.Contains(key) }); n != 0 {
+			t.Error(name, "Contains allocated", n)
+		}
+		if n := testing.AllocsPerRun(100, func() { qf.ContainsOrAdd(key) }); n != 0 {
+			t.Error(name, "ContainsOrAdd allocated", n)
+		}
+	}
+}
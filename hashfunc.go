@@ -0,0 +1,63 @@
+package qf
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash"
+)
+
+// HashFunc returns a hash constructor for the one-shot hash function fn, for hash
+// functions like xxh3 or wyhash that hash a whole key at once. Filters whose hash
+// constructor comes from HashFunc call fn directly on the keys, without a hash.Hash64 in
+// between, the hashers it returns buffer what is written to them for streamed keys. Like
+// the Write method of a hash.Hash, fn must not modify the key or keep it. A
+// HashResolver can return it for hash functions named with WithNamedHashFunc.
+func HashFunc(fn func([]byte) uint64) func() hash.Hash64 {
+	return hashFuncs(fn, nil)
+}
+
+// hashFuncs is HashFunc for built-in hash functions, which also hash strings with
+// sumString.
+func hashFuncs(sum func([]byte) uint64, sumString func(string) uint64) func() hash.Hash64 {
+	return func() hash.Hash64 { return &funcHash{sum: sum, sumString: sumString} }
+}
+
+// WithHashFunc replaces the default hash function with the one-shot hash function fn, it
+// is the faster equivalent of WithHash for hash functions that hash a whole key at once.
+func WithHashFunc(fn func([]byte) uint64) Option {
+	if fn == nil {
+		return func(*config) error { return errors.New("hash function is nil") }
+	}
+	return WithHash(HashFunc(fn))
+}
+
+// WithNamedHashFunc names the one-shot hash function fn like WithNamedHash, so that encoded
+// filters record which hash function they use.
+func WithNamedHashFunc(name string, seed []byte, fn func([]byte) uint64) Option {
+	if fn == nil {
+		return func(*config) error { return errors.New("hash function is nil") }
+	}
+	return WithNamedHash(name, seed, HashFunc(fn))
+}
+
+// funcHash is the hash.Hash64 of a one-shot hash function, it hashes everything written
+// since the last Reset.
+type funcHash struct {
+	sum       func([]byte) uint64
+	sumString func(string) uint64
+	buf       []byte
+}
+
+func (h *funcHash) Write(b []byte) (int, error) {
+	h.buf = append(h.buf, b...)
+	return len(b), nil
+}
+
+func (h *funcHash) Sum(b []byte) []byte {
+	return binary.BigEndian.AppendUint64(b, h.Sum64())
+}
+
+func (h *funcHash) Sum64() uint64  { return h.sum(h.buf) }
+func (h *funcHash) Reset()         { h.buf = h.buf[:0] }
+func (h *funcHash) Size() int      { return 8 }
+func (h *funcHash) BlockSize() int { return 1 }
@@ -0,0 +1,32 @@
+package qf
+
+import "hash"
+
+// WithIdentityHash uses keys as their own hash, for keys that are uniform 64 bit values
+// already, such as the output of a hash function upstream. AddUint64, AddHash and their
+// lookups take the value as is. Byte and string keys are read as a little-endian integer
+// of up to 8 bytes, zero-padded like the encodings of AddUint64, longer keys only count
+// with their first 8 bytes. Encodings record the identity hash, so that a decoded filter
+// keeps taking keys as they are.
+func WithIdentityHash() Option {
+	return func(c *config) error {
+		c.newHash, c.hashID, c.hashSeed, c.hash128 = newIdentityHash, HashIdentity, "", nil
+		return nil
+	}
+}
+
+func newIdentityHash() hash.Hash64 {
+	return &funcHash{sum: le64Padded[[]byte], sumString: le64Padded[string]}
+}
+
+// le64Padded decodes up to 8 bytes of b as a little-endian integer.
+func le64Padded[T string | []byte](b T) uint64 {
+	if len(b) >= 8 {
+		return le64(b)
+	}
+	var v uint64
+	for i := len(b) - 1; i >= 0; i-- {
+		v = v<<8 | uint64(b[i])
+	}
+	return v
+}
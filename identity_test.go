@@ -0,0 +1,83 @@
+package qf
+
+import (
+	"encoding/binary"
+	"math/rand/v2"
+	"testing"
+)
+
+func TestIdentityHash(t *testing.T) {
+	qf, err := NewWithOptions(0, WithQR(16, 20), WithIdentityHash())
+	if err != nil || qf.Params().Hash != HashIdentity {
+		t.Fatal("Unexpected result creating an identity hash filter", err)
+	}
+	keys := make([]uint64, 10000)
+	for i := range keys {
+		keys[i] = rand.Uint64()
+		qf.AddUint64(keys[i])
+	}
+	for _, k := range keys {
+		var b [8]byte
+		binary.LittleEndian.PutUint64(b[:], k)
+		if !qf.ContainsUint64(k) || !qf.ContainsHash(k) || !qf.ContainsBytes(b[:]) || !qf.Contains(string(b[:])) {
+			t.Fatal("Missing", k)
+		}
+		if q, r := qf.quotientAndRemainder(k); qf.hashUint64(k) != k || !qf.contains(q, r) {
+			t.Fatal("The key is not its own hash", k)
+		}
+	}
+	// short keys are zero-padded, long keys only count with their first 8 bytes.
+	qf.Add("abc")
+	if !qf.ContainsUint64(0x636261) || !qf.Contains("abc\x00") || !qf.Contains("abc\x00\x00\x00\x00\x00 and more") {
+		t.Fatal("Short key not read as a zero-padded integer")
+	}
+
+	// decoded filters keep the identity hash.
+	b, _ := qf.MarshalBinary()
+	decoded := MustNew(4, 4)
+	if err := decoded.UnmarshalBinary(b); err != nil || decoded.Params() != qf.Params() || !decoded.ContainsUint64(keys[0]) {
+		t.Fatal("Unexpected result decoding an identity hash filter", err)
+	}
+	if _, err := NewWithOptions(0, WithQR(16, 20), WithIdentityHash(), WithSeed(1)); err == nil {
+		t.Fatal("The identity hash was seeded")
+	}
+}
+
+// BenchmarkContainsUint64 looks up integer keys with the default hash and as their own
+// hash, next to looking up hashes directly.
+func BenchmarkContainsUint64(b *testing.B) {
+	for _, c := range []struct {
+		name string
+		opt  Option
+	}{{"FNV64a", WithQR(20, 16)}, {"Identity", WithIdentityHash()}} {
+		b.Run(c.name, func(b *testing.B) {
+			qf, _ := NewWithOptions(0, WithQR(20, 16), c.opt)
+			keys := make([]uint64, 1<<16)
+			for i := range keys {
+				keys[i] = rand.Uint64()
+			}
+			for _, k := range keys[:1<<15] {
+				qf.AddUint64(k)
+			}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				qf.ContainsUint64(keys[i&(1<<16-1)])
+			}
+		})
+	}
+	b.Run("ContainsHash", func(b *testing.B) {
+		qf, _ := New(20, 16)
+		keys := make([]uint64, 1<<16)
+		for i := range keys {
+			keys[i] = rand.Uint64()
+		}
+		for _, k := range keys[:1<<15] {
+			qf.AddHash(k)
+		}
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			qf.ContainsHash(keys[i&(1<<16-1)])
+		}
+	})
+}
@@ -0,0 +1,133 @@
+package qf
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// jsonVersion is the version of the JSON encoding written by MarshalJSON. The data of versions
+// 1 and 2 holds the slots packed like the binary encodings before version 4, and the data of
+// version 1 has 8 times the words of version 2 like the binary encodings before version 3,
+// the words past the table are zero and decoding drops them.
+const jsonVersion = 3
+
+var (
+	_ json.Marshaler   = (*QuotientFilter)(nil)
+	_ json.Unmarshaler = (*QuotientFilter)(nil)
+)
+
+// jsonFilter is the JSON encoding of a filter, the data words are little-endian
+// and base64 encoded.
+type jsonFilter struct {
+	Version        int      `json:"version"`
+	Q              uint8    `json:"q"`
+	R              uint8    `json:"r"`
+	Len            uint64   `json:"len"`
+	MaxLoadFactor  float64  `json:"max_load_factor"`
+	Hash           string   `json:"hash"`
+	HashSeed       []byte   `json:"hash_seed,omitempty"`
+	KeyTransformer string   `json:"key_transformer,omitempty"`
+	Namespaces     []string `json:"namespaces,omitempty"`
+	StashSize      uint64   `json:"stash_size,omitempty"`
+	ProbeLimit     uint64   `json:"probe_limit,omitempty"`
+	Stash          []uint64 `json:"stash,omitempty"`
+	// the blocks padded to cache lines, see WithCacheLineBlocks.
+	CacheLineBlocks bool `json:"cache_line_blocks,omitempty"`
+	// the remainders stored in 8, 16, 32 or 64 bits, see WithAlignedSlots.
+	AlignedSlots bool   `json:"aligned_slots,omitempty"`
+	Data         []byte `json:"data"`
+}
+
+// MarshalJSON encodes the filter as a JSON object with its parameters and the data
+// as base64, meant for small filters.
+func (qf *QuotientFilter) MarshalJSON() ([]byte, error) {
+	return json.Marshal(jsonFilter{
+		Version:        jsonVersion,
+		Q:              qf.qbits,
+		R:              qf.rbits,
+		Len:            qf.len,
+		MaxLoadFactor:  qf.maxLoad,
+		Hash:           qf.hashID,
+		HashSeed:       []byte(qf.hashSeed),
+		KeyTransformer: qf.transformID,
+		Namespaces:     qf.Namespaces(),
+		StashSize:      uint64(cap(qf.stash)),
+		ProbeLimit:     qf.probeLimit,
+		Stash:          qf.stash,
+
+		CacheLineBlocks: qf.cacheLineBlocks,
+		AlignedSlots:    qf.alignedSlots,
+		Data:            qf.denseData(),
+	})
+}
+
+// jsonFlags returns the flags of the binary encoding for the layout of j.
+func jsonFlags(j jsonFilter) uint64 {
+	var flags uint64
+	if j.CacheLineBlocks {
+		flags |= flagCacheLineBlocks
+	}
+	if j.AlignedSlots {
+		flags |= flagAlignedSlots
+	}
+	return flags
+}
+
+// UnmarshalJSON replaces the filter with one encoded by MarshalJSON, unknown fields are
+// ignored. Like UnmarshalBinary it leaves the filter unchanged if the encoding is invalid.
+func (qf *QuotientFilter) UnmarshalJSON(b []byte) error {
+	var j jsonFilter
+	if err := json.Unmarshal(b, &j); err != nil {
+		return err
+	}
+	if j.Version < 1 || j.Version > jsonVersion {
+		return fmt.Errorf("%w: unsupported JSON version %d", ErrInvalidEncoding, j.Version)
+	}
+	decoded, err := qf.fromHeader(header{
+		q:           j.Q,
+		r:           j.R,
+		maxLoad:     j.MaxLoadFactor,
+		len:         j.Len,
+		hashID:      j.Hash,
+		hashSeed:    string(j.HashSeed),
+		transformID: j.KeyTransformer,
+		namespaces:  j.Namespaces,
+		stashSize:   j.StashSize,
+		probeLimit:  j.ProbeLimit,
+		stash:       j.Stash,
+		flags:       jsonFlags(j),
+	})
+	if err != nil {
+		return err
+	}
+	if j.CacheLineBlocks && j.Version < jsonVersion {
+		return fmt.Errorf("%w: version %d data can't have padded blocks", ErrInvalidEncoding, j.Version)
+	}
+	if j.AlignedSlots && j.Version < jsonVersion {
+		return fmt.Errorf("%w: version %d data can't have aligned slots", ErrInvalidEncoding, j.Version)
+	}
+	words, _ := uint64Size(j.Q, decoded.rwidth, j.CacheLineBlocks)
+	encoded := words
+	if j.Version == 1 {
+		encoded, _ = legacyUint64Size(j.Q, j.R)
+	}
+	if uint64(len(j.Data)) != encoded*8 {
+		return fmt.Errorf("%w: data is %d bytes, q %d and r %d need %d", ErrInvalidEncoding, len(j.Data), j.Q, j.R, encoded*8)
+	}
+	if !isZero(j.Data[words*8:]) {
+		return fmt.Errorf("%w: data past the table is not zero", ErrInvalidEncoding)
+	}
+	if err := decoded.setData(j.Data[: words*8 : words*8]); err != nil {
+		return err
+	}
+	if j.Version < jsonVersion {
+		decoded.unpack()
+	}
+	if err := decoded.checkTable(); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidEncoding, err)
+	}
+	decoded.alignData()
+	decoded.buildOffsets()
+	*qf = *decoded
+	return nil
+}
@@ -0,0 +1,93 @@
+package qf
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestJSON(t *testing.T) {
+	qf, _ := NewWithOptions(0, WithQR(8, 7), WithStash(2, 0))
+	items := randomItems(200)
+	qf.AddAll(items)
+	qf.AddNS("users", "42")
+	b, err := json.Marshal(qf)
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	var fields map[string]interface{}
+	json.Unmarshal(b, &fields)
+	if fields["version"] != 3.0 || fields["q"] != 8.0 || fields["r"] != 7.0 || fields["len"] != float64(qf.len) {
+		t.Fatal("Unexpected JSON fields", string(b))
+	}
+	var decoded QuotientFilter
+	if err := json.Unmarshal(b, &decoded); err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	if decoded.Stats() != qf.Stats() || decoded.Params() != qf.Params() || !decoded.ContainsAll(items) || !decoded.ContainsNS("users", "42") {
+		t.Fatal("Decoded filter differs", decoded.Stats(), qf.Stats())
+	}
+
+	// unknown fields are ignored so that newer encoders can add fields.
+	extended := strings.Replace(string(b), `"version":3,`, `"version":3,"comment":"from the future","extra":[1,2],`, 1)
+	if err := json.Unmarshal([]byte(extended), &decoded); err != nil || !decoded.ContainsAll(items) {
+		t.Fatal("Unexpected result with unknown fields", err)
+	}
+
+	// version 2 packs the slots one after the other, and version 1 has 8 times the data
+	// words, the ones past the table are zero.
+	var legacy jsonFilter
+	json.Unmarshal(b, &legacy)
+	legacy.Version, legacy.Data = 2, qf.packed()
+	v2, _ := json.Marshal(legacy)
+	if err := json.Unmarshal(v2, &decoded); err != nil || decoded.Stats() != qf.Stats() || !bytes.Equal(decoded.data, qf.data) {
+		t.Fatal("Unexpected result decoding version 2", err)
+	}
+	words, _ := legacyUint64Size(qf.qbits, qf.rbits)
+	legacy.Version, legacy.Data = 1, append(legacy.Data, make([]byte, int(words)*8-len(legacy.Data))...)
+	v1, _ := json.Marshal(legacy)
+	if err := json.Unmarshal(v1, &decoded); err != nil || decoded.Stats() != qf.Stats() || !bytes.Equal(decoded.data, qf.data) {
+		t.Fatal("Unexpected result decoding version 1", err)
+	}
+	legacy.Data[len(legacy.Data)-1] = 1
+	v1, _ = json.Marshal(legacy)
+	if err := json.Unmarshal(v1, MustNew(6, 4)); !errors.Is(err, ErrInvalidEncoding) {
+		t.Fatal("Version 1 with data past the table returned", err)
+	}
+}
+
+func TestJSONInvalid(t *testing.T) {
+	qf := MustNew(6, 4)
+	qf.AddAll(generateItems(40))
+	b, _ := json.Marshal(qf)
+	data := string(b[strings.Index(string(b), `"data":"`)+8 : len(b)-2])
+	tests := []struct {
+		Name    string
+		JSON    string
+		Invalid bool
+	}{
+		{"truncated base64", strings.Replace(string(b), data, data[:len(data)-3], 1), false},
+		{"short data", strings.Replace(string(b), data, data[:len(data)-12], 1), true},
+		{"wrong q", strings.Replace(string(b), `"q":6`, `"q":7`, 1), true},
+		{"wrong r", strings.Replace(string(b), `"r":4`, `"r":40`, 1), true},
+		{"missing version", strings.Replace(string(b), `"version":3,`, ``, 1), true},
+		{"future version", strings.Replace(string(b), `"version":3`, `"version":4`, 1), true},
+		{"version 1 data", strings.Replace(string(b), `"version":3`, `"version":1`, 1), true},
+		{"stash overflow", strings.Replace(string(b), `"data"`, `"stash":[1,2],"data"`, 1), true},
+	}
+	for _, test := range tests {
+		if test.JSON == string(b) {
+			t.Fatal(test.Name, "did not change the JSON")
+		}
+		decoded := MustNew(6, 4)
+		err := json.Unmarshal([]byte(test.JSON), decoded)
+		if err == nil || test.Invalid && !errors.Is(err, ErrInvalidEncoding) {
+			t.Fatal(test.Name, "unexpected error", err)
+		}
+		if decoded.Len() != 0 {
+			t.Fatal(test.Name, "failed decode changed the filter")
+		}
+	}
+}
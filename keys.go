@@ -0,0 +1,71 @@
+package qf
+
+import (
+	"encoding"
+	"errors"
+	"net"
+)
+
+// ErrInvalidIP is returned by AddIP for an IP that is neither 4 nor 16 bytes long.
+var ErrInvalidIP = errors.New("invalid IP address")
+
+// Add16 adds a 16 byte key such as an UUID to the filter, the key is hashed as the 16 bytes.
+func (qf *QuotientFilter) Add16(b [16]byte) error {
+	return qf.addFingerprint(qf.fingerprintBytes(b[:]))
+}
+
+// Contains16 checks if a 16 byte key is present in the filter, see Add16.
+func (qf *QuotientFilter) Contains16(b [16]byte) bool {
+	return qf.containsFingerprint(qf.fingerprintBytes(b[:]))
+}
+
+// AddIP adds an IP address to the filter. IPv4 addresses are hashed in their 16 byte
+// IPv4-mapped IPv6 form, so the 4 and 16 byte representations of an IPv4 address
+// are the same key.
+func (qf *QuotientFilter) AddIP(ip net.IP) error {
+	b, ok := ip16(ip)
+	if !ok {
+		return ErrInvalidIP
+	}
+	return qf.addFingerprint(qf.fingerprintBytes(b[:]))
+}
+
+// ContainsIP checks if an IP address is present in the filter, see AddIP.
+// It returns false for invalid IPs.
+func (qf *QuotientFilter) ContainsIP(ip net.IP) bool {
+	b, ok := ip16(ip)
+	return ok && qf.containsFingerprint(qf.fingerprintBytes(b[:]))
+}
+
+// ip16 returns the 16 byte form of ip.
+func ip16(ip net.IP) (b [16]byte, ok bool) {
+	if ip4 := ip.To4(); ip4 != nil {
+		b = [16]byte{10: 0xff, 11: 0xff}
+		copy(b[12:], ip4)
+		return b, true
+	}
+	if len(ip) != net.IPv6len {
+		return b, false
+	}
+	copy(b[:], ip)
+	return b, true
+}
+
+// AddBinary adds the binary encoding of v to the filter, errors from MarshalBinary are returned.
+// The key is only as stable as the encoding v produces.
+func (qf *QuotientFilter) AddBinary(v encoding.BinaryMarshaler) error {
+	b, err := v.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	return qf.AddBytes(b)
+}
+
+// ContainsBinary checks if the binary encoding of v is present in the filter, see AddBinary.
+func (qf *QuotientFilter) ContainsBinary(v encoding.BinaryMarshaler) (bool, error) {
+	b, err := v.MarshalBinary()
+	if err != nil {
+		return false, err
+	}
+	return qf.ContainsBytes(b), nil
+}
@@ -0,0 +1,85 @@
+package qf
+
+import (
+	"errors"
+	"math/rand"
+	"net"
+	"testing"
+	"time"
+)
+
+func Test16(t *testing.T) {
+	qf := MustNew(10, 16)
+	var keys [][16]byte
+	for i := 0; i < 200; i++ {
+		var b [16]byte
+		rand.Read(b[:])
+		keys = append(keys, b)
+	}
+	for _, k := range keys[:100] {
+		qf.Add16(k)
+	}
+	for i, k := range keys {
+		if qf.Contains16(k) != (i < 100) || qf.ContainsBytes(k[:]) != (i < 100) {
+			t.Fatal("Unexpected membership for", k)
+		}
+	}
+}
+
+func TestIP(t *testing.T) {
+	qf := MustNew(10, 16)
+	v4 := net.IPv4(192, 168, 1, 1)
+	if len(v4) != net.IPv6len {
+		t.Fatal("Expected the 16 byte form")
+	}
+	if err := qf.AddIP(v4.To4()); err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	qf.AddIP(net.ParseIP("2001:db8::1"))
+	if !qf.ContainsIP(v4) || !qf.ContainsIP(v4.To4()) || !qf.ContainsIP(net.ParseIP("::ffff:192.168.1.1")) {
+		t.Fatal("IPv4 address not found in all its forms")
+	}
+	if !qf.ContainsIP(net.ParseIP("2001:db8::1")) || qf.ContainsIP(net.ParseIP("2001:db8::2")) {
+		t.Fatal("Unexpected IPv6 membership")
+	}
+	if qf.ContainsIP(net.ParseIP("192.168.1.2")) {
+		t.Fatal("Filter returned true for not added IP")
+	}
+	if !qf.ContainsBytes(net.ParseIP("192.168.1.1").To16()) {
+		t.Fatal("IPv4 should be hashed in its 16 byte form")
+	}
+	if err := qf.AddIP(net.IP{1, 2, 3}); err != ErrInvalidIP || qf.ContainsIP(nil) {
+		t.Fatal("Expected ErrInvalidIP, got", err)
+	}
+}
+
+type failingMarshaler struct{}
+
+var errMarshal = errors.New("marshal failed")
+
+func (failingMarshaler) MarshalBinary() ([]byte, error) {
+	return nil, errMarshal
+}
+
+func TestBinary(t *testing.T) {
+	qf := MustNew(10, 16)
+	now := time.Now()
+	if err := qf.AddBinary(now); err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	if found, err := qf.ContainsBinary(now); !found || err != nil {
+		t.Fatal("Added value not found", found, err)
+	}
+	if found, _ := qf.ContainsBinary(now.Add(time.Second)); found {
+		t.Fatal("Filter returned true for not added value")
+	}
+	if err := qf.AddBinary(failingMarshaler{}); err != errMarshal {
+		t.Fatal("Expected the marshal error, got", err)
+	}
+	if _, err := qf.ContainsBinary(failingMarshaler{}); err != errMarshal {
+		t.Fatal("Expected the marshal error, got", err)
+	}
+	if qf.Len() != 1 {
+		t.Fatal("Unexpected len", qf.Len())
+	}
+}
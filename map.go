@@ -0,0 +1,131 @@
+package qf
+
+import "fmt"
+
+// Map is a quotient filter that stores a small value with every fingerprint, making it an
+// approximate key to value map. The value bits are stored below the remainder in each slot
+// so they move with it when slots are shifted. Keys sharing a fingerprint share a value,
+// so Get can return the value of another key with the false positive probability of the filter.
+type Map struct {
+	qf    *QuotientFilter
+	rbits uint8
+	vbits uint8
+	rMask uint64
+	vMask uint64
+}
+
+// NewMap returns a Map with q quotient, r remainder and v value bits, the slots are
+// r + v + 3 bits wide so q + r + v has to be 64 bits or less.
+func NewMap(q, r, v uint8) (*Map, error) {
+	if err := validateQR(q, r); err != nil {
+		return nil, err
+	}
+	switch bits := uint(q) + uint(r) + uint(v); {
+	case v < 1:
+		return nil, fmt.Errorf("v %d has to be at least 1", v)
+	case bits > 64:
+		return nil, fmt.Errorf("q + r + v %d has to be 64 bits or less", bits)
+	}
+	qf, err := New(q, r+v)
+	if err != nil {
+		return nil, err
+	}
+	return &Map{qf: qf, rbits: r, vbits: v, rMask: maskLower(uint64(r)), vMask: maskLower(uint64(v))}, nil
+}
+
+// Len returns the number of fingerprints in the map.
+func (m *Map) Len() uint64 {
+	return m.qf.len
+}
+
+// Put sets the value of the key, replacing the value of an existing fingerprint.
+// The value has to fit in v bits.
+func (m *Map) Put(key string, value uint64) error {
+	if value > m.vMask {
+		return fmt.Errorf("value %d does not fit in %d bits", value, m.vbits)
+	}
+	q, r := m.fingerprint(key)
+	return m.put(q, r, value)
+}
+
+// Get returns the value of the key and reports whether the key was found.
+func (m *Map) Get(key string) (value uint64, ok bool) {
+	q, r := m.fingerprint(key)
+	return m.get(q, r)
+}
+
+// Contains checks if the key has a value.
+func (m *Map) Contains(key string) bool {
+	_, ok := m.Get(key)
+	return ok
+}
+
+// Delete removes the value of the key and reports whether it was present.
+func (m *Map) Delete(key string) bool {
+	q, r := m.fingerprint(key)
+	if !m.qf.getSlot(q).isOccupied() {
+		return false
+	}
+	start, index, found := m.find(q, r)
+	if found {
+		m.qf.removeAt(q, start, index)
+	}
+	return found
+}
+
+func (m *Map) fingerprint(key string) (q, r uint64) {
+	h := m.qf.hashString(key)
+	return (h >> m.rbits) & m.qf.qMask, h & m.rMask
+}
+
+func (m *Map) put(q, r, value uint64) error {
+	qf := m.qf
+	entry := r<<m.vbits | value
+	if !qf.getSlot(q).isOccupied() {
+		_, err := qf.insert(q, entry)
+		return err
+	}
+	start, index, found := m.find(q, r)
+	if found {
+		// the remainder does not change, so the run stays sorted.
+		qf.setSlot(index, qf.getSlot(index)&7|newSlot(entry))
+		return nil
+	}
+	if qf.len >= qf.maxLen {
+		return ErrFull
+	}
+	qf.insertAt(q, start, index, entry, false)
+	return nil
+}
+
+func (m *Map) get(q, r uint64) (value uint64, ok bool) {
+	if !m.qf.getSlot(q).isOccupied() {
+		return 0, false
+	}
+	_, index, found := m.find(q, r)
+	if !found {
+		return 0, false
+	}
+	return m.qf.getSlot(index).remainder() & m.vMask, true
+}
+
+// find locates remainder r in the run of quotient q, which has to be occupied. If not found,
+// index is where the remainder would be inserted. Runs are sorted by remainder and value,
+// and every remainder has a single value.
+func (m *Map) find(q, r uint64) (start, index uint64, found bool) {
+	qf := m.qf
+	start = qf.findRun(q)
+	index = start
+	for {
+		x := qf.getSlot(index).remainder() >> m.vbits
+		if x == r {
+			return start, index, true
+		} else if x > r {
+			return start, index, false
+		}
+		index = qf.next(index)
+		if !qf.getSlot(index).isContinuation() {
+			return start, index, false
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package qf
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMap(t *testing.T) {
+	// slot sizes from a few bits up to a full word, most cross word boundaries.
+	tests := []struct{ Q, R, V uint8 }{{8, 4, 1}, {8, 8, 5}, {8, 13, 11}, {8, 30, 26}, {6, 40, 18}, {4, 45, 15}}
+	for _, test := range tests {
+		m, err := NewMap(test.Q, test.R, test.V)
+		if err != nil {
+			t.Fatal("Unexpected error", err, "test", test)
+		}
+		// values are only exact for keys with a fingerprint of their own.
+		fps := map[[2]uint64]int{}
+		items := generateItems(int(m.qf.maxLen))
+		for _, s := range items {
+			q, r := m.fingerprint(s)
+			fps[[2]uint64{q, r}]++
+		}
+		want := map[string]uint64{}
+		for i, s := range items {
+			v := uint64(i*7919) & m.vMask
+			if err := m.Put(s, v); err != nil {
+				t.Fatal("Unexpected error", err, "test", test)
+			}
+			if q, r := m.fingerprint(s); fps[[2]uint64{q, r}] == 1 {
+				want[s] = v
+			}
+		}
+		if m.Len() != uint64(len(fps)) {
+			t.Fatal("Unexpected len", m.Len(), "expected", len(fps), "test", test)
+		}
+		for s, v := range want {
+			if got, ok := m.Get(s); !ok || got != v {
+				t.Fatal("Get for", s, "returned", got, ok, "expected", v, "test", test)
+			}
+		}
+		// overwrite every other value and delete the rest.
+		i := 0
+		for s, v := range want {
+			if i%2 == 0 {
+				want[s] = ^v & m.vMask
+				m.Put(s, want[s])
+			} else {
+				if !m.Delete(s) {
+					t.Fatal("Delete returned false for", s, "test", test)
+				}
+				delete(want, s)
+				if m.Contains(s) {
+					t.Fatal("Key still present after delete", s, "test", test)
+				}
+			}
+			i++
+		}
+		for s, v := range want {
+			if got, ok := m.Get(s); !ok || got != v {
+				t.Fatal("Get for", s, "returned", got, ok, "expected", v, "after update, test", test)
+			}
+		}
+	}
+}
+
+func TestMapFull(t *testing.T) {
+	m, _ := NewMap(4, 8, 4)
+	var err error
+	for _, s := range generateItems(100) {
+		if err = m.Put(s, 1); err != nil {
+			break
+		}
+	}
+	if err != ErrFull || m.Len() != m.qf.maxLen {
+		t.Fatal("Expected ErrFull at max len, got", err, m.Len())
+	}
+}
+
+func TestMapInvalid(t *testing.T) {
+	tests := []struct {
+		Q, R, V uint8
+		Param   string
+	}{{1, 8, 4, "q "}, {8, 0, 4, "r "}, {8, 8, 0, "v "}, {8, 50, 7, "q + r + v "}, {8, 8, 250, "q + r + v "}}
+	for _, test := range tests {
+		if _, err := NewMap(test.Q, test.R, test.V); err == nil || !strings.HasPrefix(err.Error(), test.Param) {
+			t.Fatal("Expected an error naming", test.Param, "got", err, "test", test)
+		}
+	}
+	m, _ := NewMap(8, 8, 4)
+	if err := m.Put("fox", 16); err == nil {
+		t.Fatal("Expected an error for a value wider than 4 bits")
+	}
+}
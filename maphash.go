@@ -0,0 +1,111 @@
+package qf
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"hash/maphash"
+	"math/rand/v2"
+	"runtime"
+	"sync"
+	"weak"
+)
+
+// WithMaphash hashes keys with hash/maphash and a random seed of the filter's own, so that
+// nobody can craft keys colliding in it.
+//
+// Hashes of maphash are only stable within a process. Encodings record an id of the seed
+// instead of the seed, decoding them works in the process that created the filter while the
+// filter or another one with its seed is alive, and returns ErrHashMismatch otherwise.
+func WithMaphash() Option {
+	return WithMaphashSeed(maphash.MakeSeed())
+}
+
+// WithMaphashSeed hashes keys with hash/maphash and seed, see WithMaphash. Filters created
+// with the same seed store keys identically and can be merged.
+func WithMaphashSeed(seed maphash.Seed) Option {
+	s := registerMaphashSeed(seed)
+	return func(c *config) error {
+		c.newHash, c.hash128 = s.newHash(), nil
+		c.hashID, c.hashSeed = HashMaphash, s.id
+		return nil
+	}
+}
+
+// maphashSeed is a seed of maphash filters with the id their encodings record. The hash
+// functions of the filters hold it, and maphashSeeds finds it by id and seed for as long
+// as one of them is alive.
+type maphashSeed struct {
+	seed maphash.Seed
+	id   string
+}
+
+// newHash returns the hash constructor of the seed, which keeps the seed registered.
+func (s *maphashSeed) newHash() func() hash.Hash64 {
+	return hashFuncs(func(b []byte) uint64 { return maphash.Bytes(s.seed, b) },
+		func(key string) uint64 { return maphash.String(s.seed, key) })
+}
+
+var maphashSeeds = struct {
+	sync.Mutex
+	// process is the random first half of the ids of this process.
+	process [8]byte
+	next    uint64
+	byID    map[string]weak.Pointer[maphashSeed]
+	bySeed  map[maphash.Seed]weak.Pointer[maphashSeed]
+}{
+	byID:   make(map[string]weak.Pointer[maphashSeed]),
+	bySeed: make(map[maphash.Seed]weak.Pointer[maphashSeed]),
+}
+
+func init() {
+	binary.LittleEndian.PutUint64(maphashSeeds.process[:], rand.Uint64())
+}
+
+// registerMaphashSeed returns the registered maphashSeed of seed, registering it with a new
+// id if it has none.
+func registerMaphashSeed(seed maphash.Seed) *maphashSeed {
+	m := &maphashSeeds
+	m.Lock()
+	defer m.Unlock()
+	if s := m.bySeed[seed].Value(); s != nil {
+		return s
+	}
+	m.next++
+	s := &maphashSeed{seed: seed, id: string(binary.LittleEndian.AppendUint64(m.process[:], m.next))}
+	p := weak.Make(s)
+	m.byID[s.id], m.bySeed[seed] = p, p
+	runtime.AddCleanup(s, unregisterMaphashSeed, maphashSeed{seed, s.id})
+	return s
+}
+
+// unregisterMaphashSeed removes a seed no filter uses anymore, unless it has been registered
+// again since.
+func unregisterMaphashSeed(s maphashSeed) {
+	m := &maphashSeeds
+	m.Lock()
+	defer m.Unlock()
+	if m.byID[s.id].Value() == nil {
+		delete(m.byID, s.id)
+	}
+	if m.bySeed[s.seed].Value() == nil {
+		delete(m.bySeed, s.seed)
+	}
+}
+
+// lookupMaphashSeed returns the registered seed of id, nil if it is not known to this process.
+func lookupMaphashSeed(id string) *maphashSeed {
+	m := &maphashSeeds
+	m.Lock()
+	defer m.Unlock()
+	return m.byID[id].Value()
+}
+
+// resolveMaphash returns the hash function of a maphash seed id of this process.
+func resolveMaphash(name string, id []byte) (func() hash.Hash64, error) {
+	s := lookupMaphashSeed(string(id))
+	if s == nil {
+		return nil, fmt.Errorf("%w: the %s seed of the filter is not known to this process, maphash filters can only be decoded by the process that created them", ErrHashMismatch, name)
+	}
+	return s.newHash(), nil
+}
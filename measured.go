@@ -0,0 +1,48 @@
+package qf
+
+// MeasuredStats counts the results of lookups checked by the verifier set with SetVerifier.
+type MeasuredStats struct {
+	// Lookups is the number of keys looked up, TruePositives and FalsePositives
+	// the positive results the verifier confirmed and rejected.
+	Lookups        uint64
+	TruePositives  uint64
+	FalsePositives uint64
+}
+
+// FalsePositiveRate returns the measured false positive rate, the fraction of lookups
+// of keys that are not present that returned true.
+func (m MeasuredStats) FalsePositiveRate() float64 {
+	negatives := m.Lookups - m.TruePositives
+	if negatives == 0 {
+		return 0
+	}
+	return float64(m.FalsePositives) / float64(negatives)
+}
+
+// SetVerifier sets a function that tells whether a key is really present, for example by
+// checking the backing store. When set, every positive result of Contains, ContainsBytes,
+// ContainsAll, ContainsAny and ContainsEach is verified and counted in Measured, which
+// measures the real false positive rate as opposed to the FPProbability estimate. The
+// results of the lookups are not changed. Setting a verifier resets the counters,
+// nil disables verification.
+func (qf *QuotientFilter) SetVerifier(verify func(key string) bool) {
+	qf.verifier = verify
+	qf.measured = MeasuredStats{}
+}
+
+// Measured returns the counters of the lookups checked by the verifier.
+func (qf *QuotientFilter) Measured() MeasuredStats {
+	return qf.measured
+}
+
+func (qf *QuotientFilter) measure(key string, found bool) {
+	qf.measured.Lookups++
+	if !found {
+		return
+	}
+	if qf.verifier(key) {
+		qf.measured.TruePositives++
+	} else {
+		qf.measured.FalsePositives++
+	}
+}
@@ -0,0 +1,46 @@
+package qf
+
+import (
+	"math/rand"
+	"strconv"
+	"testing"
+)
+
+func TestMeasured(t *testing.T) {
+	for _, load := range []float64{0.25, 0.5, 0.75} {
+		qf := MustNew(12, 6)
+		exact := NewExact()
+		// random keys, the measured rate of FNV-64a on similar keys is off.
+		for uint64(len(exact.keys)) < uint64(load*float64(qf.Cap())) {
+			key := strconv.FormatUint(rand.Uint64(), 36)
+			qf.Add(key)
+			exact.Add(key)
+		}
+		qf.SetVerifier(exact.Contains)
+		for key := range exact.keys {
+			qf.Contains(key)
+		}
+		for i := 0; i < 50000; i++ {
+			qf.ContainsBytes([]byte(strconv.FormatUint(rand.Uint64(), 36)))
+		}
+		m := qf.Measured()
+		if m.Lookups != uint64(len(exact.keys))+50000 || m.TruePositives != uint64(len(exact.keys)) {
+			t.Fatal("Unexpected counters", m)
+		}
+		if want := qf.FPProbability(); m.FalsePositiveRate() < want/2 || m.FalsePositiveRate() > want*2 {
+			t.Fatal("Measured rate", m.FalsePositiveRate(), "expected about", want, "at load", load)
+		}
+	}
+	qf := MustNew(8, 8)
+	qf.Add("fox")
+	qf.SetVerifier(func(string) bool { return false })
+	qf.ContainsEach([]string{"fox", "dog"}, nil)
+	if m := qf.Measured(); m.Lookups != 2 || m.FalsePositives != 1 {
+		t.Fatal("Unexpected counters for ContainsEach", m)
+	}
+	qf.SetVerifier(nil)
+	qf.Contains("fox")
+	if m := qf.Measured(); m != (MeasuredStats{}) {
+		t.Fatal("Counters changed without a verifier", m)
+	}
+}
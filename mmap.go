@@ -0,0 +1,90 @@
+package qf
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+)
+
+// OpenMmap opens a filter saved with SaveToFile or WriteTo by memory mapping the file instead
+// of reading it into memory. Lookups decode the slots straight from the mapped data, so only
+// the pages a lookup touches are read from disk. The filter is read-only, adding returns
+// ErrReadOnly and deleting does nothing. Clone returns an ordinary in-memory copy.
+//
+// The header is validated like UnmarshalBinary does, but the checksum and the table are not,
+// that would read the whole file. Use LoadFromFile on files that may be corrupt. Like decoding
+// into a zero QuotientFilter, files with a custom hash or a key transformer can't be opened.
+//
+// The file must not be truncated or modified in place while it is mapped, SaveToFile replaces
+// files with a rename, which leaves existing mappings intact. Close releases the mapping.
+// Memory mapping is only supported on unix systems.
+func OpenMmap(path string) (*QuotientFilter, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if fi.Size() == 0 {
+		return nil, fmt.Errorf("%s: %w: empty file", path, ErrInvalidEncoding)
+	}
+	if uint64(fi.Size()) > math.MaxInt {
+		return nil, fmt.Errorf("%s: %d bytes can't be mapped", path, fi.Size())
+	}
+	mapping, err := mmap(f, fi.Size())
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	qf, err := fromMapping(mapping)
+	if err != nil {
+		munmap(mapping)
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	qf.unmap = func() error { return munmap(mapping) }
+	return qf, nil
+}
+
+// fromMapping returns a read-only filter viewing the data words of the encoded filter b.
+func fromMapping(b []byte) (*QuotientFilter, error) {
+	r := bytes.NewReader(b)
+	version, _, _, err := readPrefix(r)
+	if err != nil {
+		return nil, err
+	}
+	h, _, err := decodeHeader(r, version)
+	if err != nil {
+		return nil, err
+	}
+	if h.flags&flagCompressed != 0 {
+		return nil, errors.New("compressed filters can't be memory mapped, load them with LoadFromFile")
+	}
+	if version <= encodingVersionPacked {
+		return nil, fmt.Errorf("version %d encodings hold the slots packed and can't be memory mapped, load them with LoadFromFile and save them again", version)
+	}
+	qf, err := new(QuotientFilter).fromHeader(h)
+	if err != nil {
+		return nil, err
+	}
+	size, _ := dataBytes(h.q, h.width(), h.flags&flagCacheLineBlocks != 0)
+	if uint64(r.Len()) != size {
+		return nil, fmt.Errorf("%w: %d bytes of data, q %d and r %d need %d", ErrInvalidEncoding, r.Len(), h.q, h.r, size)
+	}
+	qf.data, qf.readOnly = b[len(b)-r.Len():], true
+	return qf, nil
+}
+
+// Close releases the memory mapping of a filter opened with OpenMmap, the filter must not be
+// used afterwards. It does nothing for other filters.
+func (qf *QuotientFilter) Close() error {
+	if qf.unmap == nil {
+		return nil
+	}
+	err := qf.unmap()
+	qf.unmap = nil
+	return err
+}
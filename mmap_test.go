@@ -0,0 +1,111 @@
+//go:build unix
+
+package qf
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestOpenMmap(t *testing.T) {
+	for _, params := range [][2]uint8{{12, 9}, {10, 29}, {3, 61}} {
+		qf, _ := NewWithOptions(0, WithQR(params[0], params[1]), WithStash(4, 2))
+		items := randomItems(int(qf.maxLen))
+		qf.AddAll(items)
+		qf.AddNS("users", "42")
+		path := filepath.Join(t.TempDir(), "filter.qf")
+		if err := qf.SaveToFile(path); err != nil {
+			t.Fatal(err)
+		}
+		mapped, err := OpenMmap(path)
+		if err != nil {
+			t.Fatal(params, "unexpected error", err)
+		}
+		if mapped.Stats() != qf.Stats() || mapped.Params() != qf.Params() || !mapped.readOnly || mapped.SizeInBytes() != qf.SizeInBytes() {
+			t.Fatal(params, "mapped filter differs", mapped.Stats(), qf.Stats())
+		}
+		for _, s := range append(items, randomItems(5000)...) {
+			if mapped.Contains(s) != qf.Contains(s) {
+				t.Fatal(params, "membership differs for", s)
+			}
+		}
+		if !mapped.ContainsNS("users", "42") {
+			t.Fatal(params, "namespaced key missing")
+		}
+		b, _ := qf.MarshalBinary()
+		if mb, _ := mapped.MarshalBinary(); !reflect.DeepEqual(mb, b) {
+			t.Fatal(params, "mapped filter encodes differently")
+		}
+
+		// the mapping is never modified.
+		if err := mapped.Add("fox"); !errors.Is(err, ErrReadOnly) {
+			t.Fatal(params, "expected ErrReadOnly, got", err)
+		}
+		if _, err := mapped.AddAll([]string{items[0], "fox"}); !errors.Is(err, ErrReadOnly) {
+			t.Fatal(params, "expected ErrReadOnly from AddAll, got", err)
+		}
+		if _, err := mapped.DeleteAll(items[:1]); !errors.Is(err, ErrReadOnly) {
+			t.Fatal(params, "expected ErrReadOnly from DeleteAll, got", err)
+		}
+		mapped.Reset()
+		if mapped.Delete(items[0]) || !mapped.Contains(items[0]) || mapped.Len() != qf.Len() {
+			t.Fatal(params, "read-only filter was modified")
+		}
+
+		// a clone is an ordinary filter.
+		clone := mapped.Clone()
+		if !reflect.DeepEqual(clone.data, qf.data) || clone.readOnly || &clone.data[0] == &mapped.data[0] || clone.Close() != nil {
+			t.Fatal(params, "clone differs from the original")
+		}
+		if !clone.Delete(items[0]) || clone.Add("fox") != nil || !mapped.Contains(items[0]) {
+			t.Fatal(params, "clone is not independent")
+		}
+		if err := mapped.Close(); err != nil {
+			t.Fatal(params, "unexpected error", err)
+		}
+		if err := mapped.Close(); err != nil {
+			t.Fatal(params, "closing twice returned", err)
+		}
+	}
+}
+
+func TestOpenMmapInvalid(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := OpenMmap(filepath.Join(dir, "missing.qf")); !errors.Is(err, os.ErrNotExist) {
+		t.Fatal("Expected ErrNotExist, got", err)
+	}
+	qf := MustNew(8, 8)
+	qf.AddAll(generateItems(100))
+	b, _ := qf.MarshalBinary()
+	for _, test := range []struct {
+		Name string
+		Data []byte
+		Err  error
+	}{
+		{"empty", nil, ErrInvalidEncoding},
+		{"magic", []byte("not a filter"), ErrBadMagic},
+		{"truncated", b[:len(b)-8], ErrInvalidEncoding},
+		{"trailing", append(b, 0), ErrInvalidEncoding},
+	} {
+		path := filepath.Join(dir, test.Name)
+		os.WriteFile(path, test.Data, 0644)
+		if _, err := OpenMmap(path); !errors.Is(err, test.Err) || !errors.Is(err, ErrInvalidEncoding) {
+			t.Fatal(test.Name, "expected", test.Err, "got", err)
+		}
+	}
+	var buf bytes.Buffer
+	qf.WriteToCompressed(&buf)
+	path := filepath.Join(dir, "compressed")
+	os.WriteFile(path, buf.Bytes(), 0644)
+	if _, err := OpenMmap(path); err == nil {
+		t.Fatal("Expected an error mapping a compressed filter")
+	}
+	// version 3 packs the slots, which lookups can't read from the mapping.
+	if _, err := OpenMmap(filepath.Join("testdata", goldenVectors[0].V3)); err == nil {
+		t.Fatal("Expected an error mapping a version 3 filter")
+	}
+}
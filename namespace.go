@@ -0,0 +1,81 @@
+package qf
+
+import (
+	"encoding/binary"
+	"sort"
+)
+
+// AddNS adds the key to the namespace ns. The same key in different namespaces are different
+// keys, the namespace is hashed with a length prefix so ("ab", "c") and ("a", "bc") differ.
+// Namespaced keys should not be mixed with plain keys that may start with the same bytes.
+func (qf *QuotientFilter) AddNS(ns, key string) error {
+	if err := qf.addFingerprint(qf.fingerprintNS(ns, key)); err != nil {
+		return err
+	}
+	qf.addNamespace(ns)
+	return nil
+}
+
+// addNamespace records that keys have been added to ns.
+func (qf *QuotientFilter) addNamespace(ns string) {
+	if _, ok := qf.namespaces[ns]; !ok {
+		qf.mu.Lock()
+		defer qf.mu.Unlock()
+		if qf.namespaces == nil {
+			qf.namespaces = make(map[string]struct{})
+		}
+		qf.namespaces[ns] = struct{}{}
+	}
+}
+
+// ContainsNS checks if the key is present in the namespace ns, see AddNS.
+func (qf *QuotientFilter) ContainsNS(ns, key string) bool {
+	return qf.containsFingerprint(qf.fingerprintNS(ns, key))
+}
+
+// DeleteNS removes the key from the namespace ns and reports whether it was found.
+func (qf *QuotientFilter) DeleteNS(ns, key string) bool {
+	_, q, r := qf.fingerprintNS(ns, key)
+	return qf.deleteFingerprint(q, r)
+}
+
+// Namespaces returns the sorted namespaces keys have been added to with AddNS.
+func (qf *QuotientFilter) Namespaces() []string {
+	out := make([]string, 0, len(qf.namespaces))
+	for ns := range qf.namespaces {
+		out = append(out, ns)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// fingerprintNS is fingerprintString for the key in the namespace ns, see hashNS.
+func (qf *QuotientFilter) fingerprintNS(ns, key string) (h, q, r uint64) {
+	if !qf.wide {
+		return qf.fingerprintHash(qf.hashNS(ns, key))
+	}
+	if qf.transform != nil {
+		key = qf.transform(key)
+	}
+	b := binary.AppendUvarint(make([]byte, 0, binary.MaxVarintLen64+len(ns)+len(key)), uint64(len(ns)))
+	return qf.fingerprint128(append(append(b, ns...), key...))
+}
+
+// hashNS hashes the uvarint length of ns, ns and the transformed key.
+func (qf *QuotientFilter) hashNS(ns, key string) uint64 {
+	if qf.transform != nil {
+		key = qf.transform(key)
+	}
+	var n [binary.MaxVarintLen64]byte
+	prefix := n[:binary.PutUvarint(n[:], uint64(len(ns)))]
+	if qf.inlineFNV {
+		return fnv64a(fnv64a(fnv64a(fnvOffset64, prefix), ns), key)
+	}
+	h := qf.getHasher()
+	writeHash(h, prefix)
+	writeHashString(h, ns)
+	writeHashString(h, key)
+	sum := h.Sum64()
+	qf.putHasher(h)
+	return sum
+}
@@ -0,0 +1,50 @@
+package qf
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNamespaces(t *testing.T) {
+	qf := MustNew(12, 30)
+	items := generateItems(500)
+	for _, s := range items {
+		qf.AddNS("users", s)
+	}
+	for _, s := range items[:250] {
+		qf.AddNS("devices", s)
+	}
+	if qf.Len() != 750 {
+		t.Fatal("The same key in two namespaces should be two members, len", qf.Len())
+	}
+	for i, s := range items {
+		if !qf.ContainsNS("users", s) || qf.ContainsNS("devices", s) != (i < 250) || qf.Contains(s) {
+			t.Fatal("Unexpected membership for", s)
+		}
+	}
+	for _, s := range items[:250] {
+		if !qf.DeleteNS("devices", s) {
+			t.Fatal("DeleteNS returned false for", s)
+		}
+	}
+	for _, s := range items {
+		if !qf.ContainsNS("users", s) || qf.ContainsNS("devices", s) {
+			t.Fatal("Deleting from one namespace affected the other for", s)
+		}
+	}
+	// the namespace is length prefixed, not concatenated.
+	qf.AddNS("ab", "c")
+	if qf.ContainsNS("a", "bc") || qf.ContainsNS("", "abc") {
+		t.Fatal("Namespaces are not separated from the key")
+	}
+	if ns := qf.Namespaces(); !reflect.DeepEqual(ns, []string{"ab", "devices", "users"}) {
+		t.Fatal("Unexpected namespaces", ns)
+	}
+	if clone := qf.Clone(); !reflect.DeepEqual(clone.Namespaces(), qf.Namespaces()) || !clone.ContainsNS("ab", "c") {
+		t.Fatal("Clone lost the namespaces")
+	}
+	qf.Reset()
+	if len(qf.Namespaces()) != 0 {
+		t.Fatal("Reset should forget the namespaces")
+	}
+}
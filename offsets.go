@@ -0,0 +1,130 @@
+package qf
+
+import (
+	"fmt"
+	"math/bits"
+)
+
+// The runs of a cluster belong to its occupied quotients in order, so the run of a quotient
+// is found by counting the occupied quotients and the run starts from the start of its
+// cluster. The offset of a block is that count at the first slot of the block: the number of
+// runs of the quotients before the slot, in its cluster, that start at the slot or after it.
+// With the offsets findRun starts counting at the block of the quotient instead of at the
+// start of its cluster, and counts a block of metadata words at a time.
+//
+// The offset of the block after block b is the offset of b, plus the occupied quotients of b,
+// minus the runs starting in b. A run starts at every slot that is used and doesn't continue
+// a run.
+
+// newOffsets returns the offsets of an empty table, all zero, or nil for tables of fewer than
+// blockSlots slots and sparse filters.
+func (qf *QuotientFilter) newOffsets() []uint64 {
+	if qf.blockLen < blockSlots || qf.sparse != nil {
+		return nil
+	}
+	return make([]uint64, qf.cap/blockSlots)
+}
+
+// buildOffsets computes the offsets of the whole table into a new slice, after the table has
+// been replaced. The table has to be valid, see checkTable.
+func (qf *QuotientFilter) buildOffsets() {
+	if qf.offsets = qf.newOffsets(); qf.offsets != nil {
+		qf.fillOffsets()
+	}
+}
+
+func (qf *QuotientFilter) fillOffsets() {
+	qf.offsets[0] = qf.pendingRuns(0)
+	for b := uint64(0); b+1 < uint64(len(qf.offsets)); b++ {
+		qf.linkOffset(b)
+	}
+}
+
+// linkOffset computes the offset of the block after block b from the offset of b and returns
+// the index of that block.
+func (qf *QuotientFilter) linkOffset(b uint64) uint64 {
+	occupied, starts := qf.blockRuns(b * qf.stride)
+	next := (b + 1) % uint64(len(qf.offsets))
+	qf.offsets[next] = qf.offsets[b] + uint64(bits.OnesCount64(occupied)) - uint64(bits.OnesCount64(starts))
+	return next
+}
+
+// blockRuns returns the is_occupied bits of the block of 64 slots starting at data word w and
+// the bits of the slots of the block that start a run.
+func (qf *QuotientFilter) blockRuns(w uint64) (occupied, starts uint64) {
+	occupied = qf.word(w + metaOccupied)
+	return occupied, (occupied | qf.word(w+metaShifted)) &^ qf.word(w+metaContinuation)
+}
+
+// pendingRuns computes the offset of the slot f from the start of its cluster, slot by slot.
+func (qf *QuotientFilter) pendingRuns(f uint64) uint64 {
+	start := qf.lastUnshifted(f)
+	runs := uint64(0)
+	for i := start; i != f; i = qf.next(i) {
+		s := qf.metaBits(i)
+		if s.isOccupied() {
+			runs++
+		}
+		if !s.isEmpty() && !s.isContinuation() {
+			runs--
+		}
+	}
+	return runs
+}
+
+// updateOffsets brings the offsets up to date after adding or deleting a fingerprint changed
+// the slots from the quotient from to the slot to, wrapping around the end of the table. The
+// offset of the block of from only depends on the slots before the block, and those after the
+// block of to don't change, as to is the slot at the end of the cluster that was or became
+// empty. If the changed slots wrap around into the block of from, all offsets are computed
+// again.
+func (qf *QuotientFilter) updateOffsets(from, to uint64) {
+	if qf.offsets == nil {
+		return
+	}
+	first := from &^ (blockSlots - 1)
+	span := (to - first) & qf.qMask
+	if span < from-first {
+		qf.fillOffsets()
+		return
+	}
+	b := from / blockSlots
+	for range span / blockSlots {
+		b = qf.linkOffset(b)
+	}
+}
+
+// jumpRun is findRun with the offsets. The runs before the one of quotient are the runs the
+// offset of its block counts and those of the occupied quotients before it in the block,
+// counted from the first slot of the block on. If the cluster of quotient starts in the
+// block, they are counted from the start of the cluster instead: the runs the offset counts
+// end before it, or come after quotient in a cluster wrapping around the whole table.
+func (qf *QuotientFilter) jumpRun(quotient uint64) uint64 {
+	j := quotient % blockSlots
+	start, skip := quotient-j, qf.offsets[quotient/blockSlots]
+	if starts := qf.metaWord(quotient, metaOccupied) &^ qf.metaWord(quotient, metaShifted) & maskLower(j+1); starts != 0 {
+		start, skip = quotient-j+63-uint64(bits.LeadingZeros64(starts)), 0
+	}
+	return qf.runFrom(start, quotient, skip+qf.countOccupied(start, quotient))
+}
+
+// Validate checks that the metadata bits of the table agree with each other and with len, and
+// that the offsets findRun jumps with agree with the table. It returns the first
+// inconsistency found, which is a bug in the filter or corrupt data under a filter that
+// doesn't own it, such as a memory mapping.
+func (qf *QuotientFilter) Validate() error {
+	return qf.validate(true)
+}
+
+// validate is Validate, which also checks that the runs are sorted if sorted is true.
+func (qf *QuotientFilter) validate(sorted bool) error {
+	if err := qf.checkSlots(sorted); err != nil {
+		return err
+	}
+	for b, offset := range qf.offsets {
+		if want := qf.pendingRuns(uint64(b) * blockSlots); offset != want {
+			return fmt.Errorf("offset of block %d is %d, the table has %d", b, offset, want)
+		}
+	}
+	return nil
+}
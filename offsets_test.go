@@ -0,0 +1,186 @@
+package qf
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// checkOffsets validates qf and checks that findRun finds the same runs with the offsets as
+// by walking the clusters.
+func checkOffsets(t *testing.T, name string, qf *QuotientFilter) {
+	t.Helper()
+	checkValid(t, name, qf, qf.Validate)
+}
+
+// checkValid is checkOffsets with the Validate method of the filter wrapping qf.
+func checkValid(t *testing.T, name string, qf *QuotientFilter, validate func() error) {
+	t.Helper()
+	if err := validate(); err != nil {
+		t.Fatal(name, "invalid table", err)
+	}
+	if qf.offsets == nil {
+		return
+	}
+	walked := *qf
+	walked.offsets = nil
+	for q := range qf.cap {
+		if qf.isOccupied(q) && qf.findRun(q) != walked.findRun(q) {
+			t.Fatal(name, "run of", q, "at", qf.findRun(q), "want", walked.findRun(q))
+		}
+	}
+}
+
+// churn adds and deletes random keys of a small set, so that the load stays high and keys
+// are added and deleted again, and checks the offsets of qf with validate after every change.
+func churn(t *testing.T, name string, qf *QuotientFilter, validate func() error, add func(string) error, del func(string) bool) {
+	keys := make([]string, qf.cap)
+	for i := range keys {
+		keys[i] = strconv.Itoa(i)
+	}
+	for i := 0; i < 3000; i++ {
+		k := keys[rand.Intn(len(keys))]
+		if rand.Intn(3) == 0 {
+			del(k)
+		} else {
+			add(k)
+		}
+		checkValid(t, fmt.Sprint(name, " op ", i), qf, validate)
+	}
+}
+
+func TestOffsets(t *testing.T) {
+	for _, q := range []uint8{6, 7, 9} {
+		qf := newFull(q, 5)
+		churn(t, fmt.Sprint("QuotientFilter q ", q), qf, qf.Validate, qf.Add, qf.Delete)
+	}
+
+	c, _ := NewCounting(8, 6)
+	churn(t, "CountingFilter", c.qf, c.Validate, c.Add, c.Delete)
+	m, _ := NewMap(8, 6, 4)
+	churn(t, "Map", m.qf, m.qf.Validate, func(k string) error { return m.Put(k, uint64(len(k))) }, m.Delete)
+
+	clock := &fakeClock{time.Unix(0, 0)}
+	e, _ := NewExpiring(8, 6, 4*time.Second, time.Second)
+	e.SetClock(clock.now)
+	for i, k := range randomItems(1000) {
+		e.Add(k)
+		if i%50 == 0 {
+			clock.t = clock.t.Add(time.Second)
+			e.Vacuum()
+		}
+		checkOffsets(t, fmt.Sprint("Expiring op ", i), e.m.qf)
+	}
+
+	inner, _ := NewWithOptions(0, WithQR(10, 8))
+	s, _ := NewStriped(inner, 16)
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 2000; i++ {
+				// the hashes are spread over the first quarter of the table, so that the
+				// clusters grow long and reach across stripes.
+				h := uint64(rand.Intn(256))<<8 | uint64(rand.Intn(256))
+				s.AddHash(h)
+				if i%3 == 0 {
+					s.deleteHash(h)
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	s.Update(func(qf *QuotientFilter) {
+		checkOffsets(t, "Striped", qf)
+	})
+
+	fps := sortedFingerprints(newFull(8, 8), 220)
+	built, err := BuildFromSorted(8, 8, fps)
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	checkOffsets(t, "BuildFromSorted", built)
+	b, _ := built.MarshalBinary()
+	decoded := new(QuotientFilter)
+	if err := decoded.UnmarshalBinary(b); err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	checkOffsets(t, "UnmarshalBinary", decoded)
+	checkOffsets(t, "Clone", built.Clone())
+	built.Reset()
+	checkOffsets(t, "Reset", built)
+}
+
+// sortedFingerprints returns the ascending fingerprints of n random keys added to qf.
+func sortedFingerprints(qf *QuotientFilter, n int) []uint64 {
+	qf.AddAll(randomItems(n))
+	return qf.Fingerprints()
+}
+
+func TestValidate(t *testing.T) {
+	qf := MustNew(8, 8)
+	qf.AddAll(randomItems(150))
+	if err := qf.Validate(); err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	qf.offsets[2]++
+	if err := qf.Validate(); err == nil {
+		t.Fatal("Validate missed a wrong offset")
+	}
+	qf.offsets[2]--
+	qf.len++
+	if err := qf.Validate(); err == nil {
+		t.Fatal("Validate missed a wrong len")
+	}
+}
+
+// BenchmarkContainsOffsets looks up keys at growing loads, with the offsets and by walking
+// the clusters. The walk slows down with the length of the clusters, the jumps much less.
+func BenchmarkContainsOffsets(b *testing.B) {
+	for _, walk := range []bool{false, true} {
+		for _, load := range []int{50, 75, 90} {
+			name := fmt.Sprint("jump/", load)
+			if walk {
+				name = fmt.Sprint("walk/", load)
+			}
+			b.Run(name, func(b *testing.B) {
+				qf := newFull(16, 9)
+				items := randomItems(int(qf.cap) * load / 100)
+				qf.AddAll(items)
+				if walk {
+					qf.offsets = nil
+				}
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					qf.Contains(items[i%len(items)])
+				}
+			})
+		}
+	}
+}
+
+// BenchmarkContainsAtLoad looks up keys in a filter of a million slots filled to growing
+// loads, alternating keys in the filter and keys that aren't. Lookups slow down as the
+// clusters grow long, the offsets keep that to a small factor up to the default max load.
+func BenchmarkContainsAtLoad(b *testing.B) {
+	for _, load := range []int{50, 70, 85, 90} {
+		b.Run(fmt.Sprint(load), func(b *testing.B) {
+			qf := newFull(20, 9)
+			items := randomItems(int(qf.cap) * load / 100)
+			qf.AddAll(items)
+			missing := randomItems(len(items))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if i%2 == 0 {
+					qf.Contains(items[i/2%len(items)])
+				} else {
+					qf.Contains(missing[i/2%len(missing)])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,100 @@
+package qf
+
+import (
+	"encoding/binary"
+	"math"
+	"math/bits"
+	"sync/atomic"
+	"unsafe"
+)
+
+// WithOptimisticReads makes lookups run without locks while one goroutine adds and deletes
+// keys. The writer makes a sequence counter odd before it changes the table and even again
+// after, lookups probe the table and probe again if the counter was odd or changed meanwhile,
+// so they never return the result of a table in the middle of an update. The data words are
+// read and written atomically, which makes every method somewhat slower.
+//
+// Only one goroutine may change the filter at a time. Any number of goroutines can call the
+// lookups and Len concurrently with it, Len returns the count of the last completed update.
+// The other methods, including reporting false positives, verifiers of lookups, ApplyDiff and
+// decoding into the filter, need the writer and the readers to be idle. Filters with optimistic
+// reads can't have a stash, filters decoded into them keep optimistic reads and clones don't.
+func WithOptimisticReads() Option {
+	return func(c *config) error {
+		c.optimistic = true
+		return nil
+	}
+}
+
+// hostLittleEndian reports whether atomic loads of the data words see them little-endian.
+var hostLittleEndian = binary.NativeEndian.Uint16([]byte{1, 0}) == 1
+
+// loadWord reads the data word i atomically, the data is 8 byte aligned as allocated.
+func (qf *QuotientFilter) loadWord(i uint64) uint64 {
+	w := atomic.LoadUint64((*uint64)(unsafe.Pointer(&qf.data[i*8])))
+	if !hostLittleEndian {
+		w = bits.ReverseBytes64(w)
+	}
+	return w
+}
+
+// storeWord writes the data word i atomically.
+func (qf *QuotientFilter) storeWord(i, w uint64) {
+	if !hostLittleEndian {
+		w = bits.ReverseBytes64(w)
+	}
+	atomic.StoreUint64((*uint64)(unsafe.Pointer(&qf.data[i*8])), w)
+}
+
+// probe is inTable for lookups without locks, which may see a table in the middle of an
+// update. A torn table can lack the slots the scans stop at, so they give up after visiting
+// more slots than a consistent table needs, and the lookup probes again.
+func (qf *QuotientFilter) probe(q, r uint64) bool {
+	left := min(qf.cap, math.MaxUint64/4) * 4
+	if !qf.isOccupied(q) {
+		return false
+	}
+	// findRun, counting the slots visited.
+	index := q
+	for qf.isShifted(index) {
+		if left--; left == 0 {
+			return false
+		}
+		index = qf.previous(index)
+	}
+	run := index
+	for index != q {
+		for {
+			if left--; left == 0 {
+				return false
+			}
+			run = qf.next(run)
+			if !qf.isContinuation(run) {
+				break
+			}
+		}
+		for {
+			if left--; left == 0 {
+				return false
+			}
+			index = qf.next(index)
+			if qf.isOccupied(index) {
+				break
+			}
+		}
+	}
+	for {
+		if remainder := qf.remainder(run); remainder == r {
+			return true
+		} else if remainder > r {
+			return false
+		}
+		if left--; left == 0 {
+			return false
+		}
+		run = qf.next(run)
+		if !qf.isContinuation(run) {
+			return false
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package qf
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestOptimisticReads(t *testing.T) {
+	qf, err := NewWithOptions(0, WithQR(12, 20), WithOptimisticReads())
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	fixed := randomItems(1000)
+	for _, k := range fixed {
+		if err := qf.Add(k); err != nil {
+			t.Fatal("Unexpected error", err)
+		}
+	}
+	fixedLen := qf.Len()
+	const churn = 2000
+	var done atomic.Bool
+	var lookups atomic.Uint64
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer done.Store(true)
+		for round := 0; round < 3; round++ {
+			for i := 0; i < churn; i++ {
+				if err := qf.Add(fmt.Sprint("churn", i)); err != nil {
+					t.Error("Unexpected error", err)
+					return
+				}
+			}
+			for i := 0; i < churn; i++ {
+				qf.Delete(fmt.Sprint("churn", i))
+			}
+		}
+	}()
+	for r := 0; r < 16; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for !done.Load() {
+				for _, k := range fixed {
+					if !qf.Contains(k) {
+						t.Error("Missing", k)
+						return
+					}
+				}
+				if n := qf.Len(); n < fixedLen || n > fixedLen+churn {
+					t.Error("Len", n, "outside", fixedLen, "to", fixedLen+churn)
+					return
+				}
+				lookups.Add(uint64(len(fixed)))
+			}
+		}()
+	}
+	wg.Wait()
+	if qf.Len() != fixedLen {
+		t.Fatal("Len", qf.Len(), "after deleting the churn, want", fixedLen)
+	}
+	t.Log(lookups.Load(), "lookups during the updates")
+
+	// decoding keeps optimistic reads, clones and stashes don't have them.
+	b, _ := qf.MarshalBinary()
+	target, _ := NewWithOptions(0, WithQR(4, 8), WithOptimisticReads())
+	if err := target.UnmarshalBinary(b); err != nil || !target.optimistic() || target.Len() != fixedLen || !target.Contains(fixed[0]) {
+		t.Fatal("Decoding into a filter with optimistic reads returned", err)
+	}
+	if clone := qf.Clone(); clone.optimistic() || !clone.Contains(fixed[0]) {
+		t.Fatal("Clone kept optimistic reads")
+	}
+	if _, err := NewWithOptions(100, WithOptimisticReads(), WithStash(8, 4)); err == nil {
+		t.Fatal("A stash was accepted with optimistic reads")
+	}
+	stashed, _ := NewWithOptions(100, WithStash(8, 4))
+	b, _ = stashed.MarshalBinary()
+	if err := target.UnmarshalBinary(b); err == nil {
+		t.Fatal("Decoding a filter with a stash into a filter with optimistic reads was accepted")
+	}
+}
+
+func TestProbeTornTable(t *testing.T) {
+	qf, _ := NewWithOptions(0, WithQR(4, 8), WithOptimisticReads())
+	qf.Add("key")
+	// a table no update leaves behind: every slot shifted and none occupied, the scans of
+	// inTable would not end.
+	for i := uint64(0); i < qf.cap; i++ {
+		qf.setSlot(i, slot(0).setShifted())
+	}
+	qf.setSlot(0, slot(0).setShifted().setOccupied())
+	if qf.probe(0, 1) {
+		t.Fatal("Probe of a torn table found a key")
+	}
+}
@@ -0,0 +1,420 @@
+package qf
+
+import (
+	"errors"
+	"fmt"
+	"hash"
+	"iter"
+	"math"
+	"reflect"
+	"sync"
+)
+
+// DefaultFalsePositiveRate is the false positive rate NewWithOptions sizes
+// the filter for when WithFalsePositiveRate is not given.
+const DefaultFalsePositiveRate = 0.01
+
+// DefaultMaxLoadFactor is the fraction of slots that can be used before Add returns ErrFull.
+// Operations slow down as clusters grow long when the filter approaches full. Lookups jump
+// over the clusters with the offsets of the blocks and take about 1.5 times as long at
+// this load as at half of it.
+const DefaultMaxLoadFactor = 0.9
+
+// DefaultMaxMemory is the largest data slice in bytes NewWithOptions allocates
+// unless changed with WithMaxMemory.
+const DefaultMaxMemory = 1 << 40
+
+// Option configures a filter created with NewWithOptions.
+type Option func(*config) error
+
+type config struct {
+	capacity    int
+	probability float64
+	maxLoad     float64
+	maxMemory   uint64
+	// explicit quotient and remainder bits, set by WithQR.
+	q, r  uint8
+	hasQR bool
+	// hash function constructor, its identifier and seed, and the resolver of decoded ones.
+	// The constructor of a 128-bit hash function is made by newFilter for the filter's r.
+	hash128  Hash128
+	newHash  func() hash.Hash64
+	hashID   string
+	hashSeed string
+	resolver HashResolver
+	// stash size and the displacement that sends insertions to it.
+	stashSize  int
+	probeLimit uint64
+	// size of the reported false positive set.
+	adaptiveEntries int
+	// key transformer and its name.
+	transform   func(string) string
+	transformID string
+	// leave the data nil, the decoders allocate it as they read it.
+	noData bool
+	// block size of dirty tracking, zero without, see WithDirtyTracking.
+	dirtyBlockSize int
+	// lookups without locks, see WithOptimisticReads.
+	optimistic bool
+	// don't check custom hash functions, see WithoutHashCheck.
+	skipHashCheck bool
+	// seed of the built-in hash function, see WithSeed.
+	seed    uint64
+	hasSeed bool
+	// displacement flagging the filter and the keys rebuilding it, see WithDisplacementLimit.
+	displacementLimit uint64
+	rehashKeys        iter.Seq[string]
+	// blocks padded to cache lines, see WithCacheLineBlocks.
+	cacheLineBlocks bool
+	// remainders stored in 8, 16, 32 or 64 bits, see WithAlignedSlots.
+	alignedSlots bool
+	// data in pages allocated on first write, see WithSparse.
+	sparse bool
+}
+
+// WithFalsePositiveRate sizes the filter so that the false positive rate stays below
+// p when the filter holds capacity keys. p has to be between 0 and 1.
+func WithFalsePositiveRate(p float64) Option {
+	return func(c *config) error {
+		if !(p > 0 && p < 1) {
+			return fmt.Errorf("false positive rate %v has to be between 0 and 1", p)
+		}
+		c.probability = p
+		return nil
+	}
+}
+
+// WithMaxLoadFactor sets the fraction of slots that can be used before Add returns ErrFull,
+// f has to be greater than 0 and at most 1. The filter is sized so that capacity keys fit
+// under the limit.
+func WithMaxLoadFactor(f float64) Option {
+	return func(c *config) error {
+		if !(f > 0 && f <= 1) {
+			return fmt.Errorf("max load factor %v has to be greater than 0 and at most 1", f)
+		}
+		c.maxLoad = f
+		return nil
+	}
+}
+
+// WithMaxMemory limits the size of the data slice the filter allocates to bytes,
+// NewWithOptions returns an error instead of allocating more. Sparse filters count their
+// pages and the index of the pages against it, see WithSparse. The limit also applies to
+// filters decoded into the filter with UnmarshalBinary, ReadFrom or UnmarshalJSON, together
+// with their stash, so it should be set when decoding data from untrusted sources.
+func WithMaxMemory(bytes uint64) Option {
+	return func(c *config) error {
+		c.maxMemory = bytes
+		return nil
+	}
+}
+
+// WithQR sets the quotient and remainder bits explicitly, the capacity and false positive
+// rate are then ignored when sizing the filter. q + r can only be above 64 with WithHash128.
+func WithQR(q, r uint8) Option {
+	return func(c *config) error {
+		if err := validateQRBits(q, r, 128); err != nil {
+			return err
+		}
+		c.q, c.r, c.hasQR = q, r, true
+		return nil
+	}
+}
+
+// WithHash replaces the default FNV-64a hash function, h is called to create the hash.Hash64 instance.
+// Hash functions hashing a whole key at once are faster with WithHashFunc. The instances are
+// pooled, so Add and Contains allocate one when the pool is empty, after a garbage collection.
+func WithHash(h func() hash.Hash64) Option {
+	return func(c *config) error {
+		if h == nil {
+			return errors.New("hash function constructor is nil")
+		}
+		c.newHash, c.hash128 = h, nil
+		c.hashID, c.hashSeed = HashCustom, ""
+		return nil
+	}
+}
+
+// WithKeyTransformer normalizes keys with t before they are hashed, for example lowercasing
+// them, so every Add and Contains sees the same form of a key. The name identifies the
+// transformer in Params, filters with different transformers store keys differently.
+// Only string and []byte keys, including the encodings of AddBinary, are transformed,
+// fixed size and streamed keys are not.
+func WithKeyTransformer(name string, t func(string) string) Option {
+	return func(c *config) error {
+		if name == "" || t == nil {
+			return errors.New("key transformer needs a name and a function")
+		}
+		c.transform, c.transformID = t, name
+		return nil
+	}
+}
+
+// WithStash adds a stash of size fingerprints that takes insertions which would land more
+// than probeLimit slots after their canonical slot, bounding the length of the scans through
+// clusters at high load. The stash also takes insertions when the table is at max load.
+// Keys in the stash are checked after the table, so the stash should be small.
+func WithStash(size int, probeLimit uint64) Option {
+	return func(c *config) error {
+		if size < 1 {
+			return fmt.Errorf("stash size %d has to be positive", size)
+		}
+		c.stashSize, c.probeLimit = size, probeLimit
+		return nil
+	}
+}
+
+// WithAdaptiveEntries sets the number of false positives ReportFalsePositive remembers,
+// the least recently reported ones are forgotten first.
+func WithAdaptiveEntries(n int) Option {
+	return func(c *config) error {
+		if n < 1 {
+			return fmt.Errorf("adaptive entries %d has to be positive", n)
+		}
+		c.adaptiveEntries = n
+		return nil
+	}
+}
+
+// WithCacheLineBlocks pads every block of 64 slots to whole cache lines of 64 bytes and
+// allocates the table at a cache line boundary, so that every block starts at a cache line
+// and the metadata words of a block share the line of its first remainders. A block of r
+// remainder bits takes r + 3 words, the padding rounds that up to a multiple of 8 words:
+// nothing for r 5 and 13, 60% more memory for r 7, the default, and 7% for r 12. The
+// encodings record the layout, and filters decoded from them keep it. Memory mapped filters
+// keep the layout but start where their data starts in the file, see OpenMmap.
+func WithCacheLineBlocks() Option {
+	return func(c *config) error {
+		c.cacheLineBlocks = true
+		return nil
+	}
+}
+
+// WithAlignedSlots stores every remainder in the next of 8, 16, 32 and 64 bits, so that
+// reading and writing a slot of a block of 64 slots is a load or store of a byte, a 16, 32
+// or 64 bit word instead of extracting the bits of the remainder. r 8, 16 and 32 take the
+// aligned path without the option. A block of 64 slots takes a word for each bit of the
+// width and 3 more: r 13 takes 19 words instead of 16, r 7 11 instead of 10, and r 5 11
+// instead of 8. The false positive rate is that of r bits. The encodings record the layout,
+// and filters decoded from them keep it.
+func WithAlignedSlots() Option {
+	return func(c *config) error {
+		c.alignedSlots = true
+		return nil
+	}
+}
+
+// NewWithOptions returns a QuotientFilter that can hold capacity keys while maintaining
+// the false positive rate, DefaultFalsePositiveRate unless changed with an option.
+func NewWithOptions(capacity int, opts ...Option) (*QuotientFilter, error) {
+	c := defaultConfig()
+	c.capacity = capacity
+	for _, opt := range opts {
+		if err := opt(c); err != nil {
+			return nil, err
+		}
+	}
+	if !c.hasQR {
+		if err := c.sizeQR(); err != nil {
+			return nil, err
+		}
+	} else if err := validateQRBits(c.q, c.r, c.hashBits()); err != nil {
+		return nil, err
+	}
+	// the size is computed before allocating, so that absurd q and r values fail
+	// with an error rather than by running out of memory.
+	width := remainderWidth(c.r, c.alignedSlots)
+	size, ok := dataBytes(c.q, width, c.cacheLineBlocks)
+	if !ok || size/8 > math.MaxInt {
+		return nil, fmt.Errorf("q %d and r %d need more memory than can be addressed", c.q, c.r)
+	}
+	if c.sparse {
+		// only the index of the pages is allocated up front.
+		size = sparseIndexBytes(c.q, width, c.cacheLineBlocks)
+	}
+	if size > c.maxMemory {
+		return nil, fmt.Errorf("q %d and r %d need %d bytes, more than the limit of %d bytes", c.q, c.r, size, c.maxMemory)
+	}
+	if c.optimistic && c.stashSize > 0 {
+		return nil, errors.New("filters with optimistic reads can't have a stash")
+	}
+	if c.q+c.r > 64 && c.stashSize > 0 {
+		return nil, errWideStash
+	}
+	if c.sparse && (c.optimistic || c.dirtyBlockSize > 0) {
+		return nil, errSparseOptions
+	}
+	if c.rehashKeys != nil && c.displacementLimit == 0 {
+		return nil, errors.New("WithRehash needs a WithDisplacementLimit")
+	}
+	if err := c.applySeed(); err != nil {
+		return nil, err
+	}
+	return newFilter(c), nil
+}
+
+// decodeTarget returns a filter without data configured with opts to decode into.
+func decodeTarget(opts []Option) (*QuotientFilter, error) {
+	if len(opts) == 0 {
+		return &QuotientFilter{}, nil
+	}
+	c := defaultConfig()
+	for _, opt := range opts {
+		if err := opt(c); err != nil {
+			return nil, err
+		}
+	}
+	if c.sparse && (c.optimistic || c.dirtyBlockSize > 0) {
+		return nil, errSparseOptions
+	}
+	c.q, c.r, c.noData = minQ, minR, true
+	return newFilter(c), nil
+}
+
+// sizeQR sets q and r for the capacity and false positive rate of c.
+func (c *config) sizeQR() error {
+	if c.capacity <= 0 {
+		return fmt.Errorf("capacity %d has to be positive", c.capacity)
+	}
+	// size to double asked capacity so that probability is maintained
+	// at capacity num keys (at 50% fill rate), or more if the max load
+	// factor would not allow capacity keys.
+	c.q = uint8(math.Ceil(math.Log2(float64(c.capacity) * math.Max(2, 1/c.maxLoad))))
+	// round r up, truncating would give up to twice the asked false positive rate.
+	c.r = uint8(math.Ceil(-math.Log2(c.probability)))
+	// tiny capacities and probabilities close to 1 round below the minimum.
+	if c.q < minQ {
+		c.q = minQ
+	}
+	if c.r < minR {
+		c.r = minR
+	}
+	if err := validateQRBits(c.q, c.r, c.hashBits()); err != nil {
+		return fmt.Errorf("capacity %d with false positive rate %v: %v", c.capacity, c.probability, err)
+	}
+	return nil
+}
+
+func defaultConfig() *config {
+	return &config{
+		probability: DefaultFalsePositiveRate,
+		maxLoad:     DefaultMaxLoadFactor,
+		maxMemory:   DefaultMaxMemory,
+		newHash:     newFNV64a,
+		hashID:      HashFNV64a,
+
+		adaptiveEntries: DefaultAdaptiveEntries,
+	}
+}
+
+// smallest supported quotient and remainder bits, smaller tables and remainders
+// without any bits are not supported.
+const (
+	minQ = 2
+	minR = 1
+)
+
+func validateQR(q, r uint8) error {
+	return validateQRBits(q, r, 64)
+}
+
+// validateQRBits is validateQR for fingerprints drawn from a hash of bits bits, the 128 bits
+// of WithHash128 hold the largest q and r.
+func validateQRBits(q, r uint8, bits int) error {
+	switch {
+	case q < minQ || q > 63:
+		return fmt.Errorf("q %d has to be between %d and 63", q, minQ)
+	case r < minR || r > 61:
+		return fmt.Errorf("r %d has to be between %d and 61", r, minR)
+	case int(q)+int(r) > bits:
+		return fmt.Errorf("q + r %d has to be %d bits or less", int(q)+int(r), bits)
+	}
+	return nil
+}
+
+// hashBits returns the bits of the hash of c the fingerprints are drawn from.
+func (c *config) hashBits() int {
+	if c.hash128 != nil {
+		return 128
+	}
+	return 64
+}
+
+// sameHasher reports whether newHash returns h again, the hash function it returned before,
+// as the constructor of NewHash does. Keys are then hashed one at a time.
+func sameHasher(h hash.Hash64, newHash func() hash.Hash64) bool {
+	return reflect.TypeOf(h).Comparable() && h == newHash()
+}
+
+// newHasherPool returns the pool of the hashers of a filter, created with newHash.
+func newHasherPool(newHash func() hash.Hash64) *sync.Pool {
+	return &sync.Pool{New: func() any { return newHash() }}
+}
+
+func newFilter(c *config) *QuotientFilter {
+	if c.hash128 != nil {
+		c.newHash = HashFunc(fold128(c.hash128, c.r))
+	}
+	h := c.newHash()
+	qf := &QuotientFilter{
+		qbits:   c.q,
+		rbits:   c.r,
+		ssize:   c.r + 3,
+		len:     0,
+		cap:     1 << c.q,
+		h:       h,
+		hashers: newHasherPool(c.newHash),
+		hash128: c.hash128,
+		newHash: c.newHash,
+		hashID:  c.hashID,
+		maxLoad: c.maxLoad,
+
+		maxMemory:       c.maxMemory,
+		adaptiveEntries: c.adaptiveEntries,
+		transform:       c.transform,
+		transformID:     c.transformID,
+		mu:              new(sync.Mutex),
+		hashSeed:        c.hashSeed,
+		inlineFNV:       c.hashID == HashFNV64a && c.hashSeed == "",
+		sharedHasher:    sameHasher(h, c.newHash),
+		resolver:        c.resolver,
+		comparableSeed:  comparableSeed(c.hashID, c.hashSeed),
+
+		displacementLimit: c.displacementLimit,
+		rehashKeys:        c.rehashKeys,
+	}
+	if fh, ok := h.(*funcHash); ok {
+		qf.hashFunc, qf.hashStr = fh.sum, fh.sumString
+	}
+	if builtinHashes[c.hashID] == nil && !c.skipHashCheck {
+		qf.hashCheck = new(hashCheck)
+	}
+	if c.stashSize > 0 {
+		qf.stash = make([]uint64, 0, c.stashSize)
+		qf.probeLimit = c.probeLimit
+	}
+	qf.wide = c.hash128 != nil && c.q+c.r > 64
+	qf.maxLen = uint64(c.maxLoad * float64(qf.cap))
+	qf.qMask = maskLower(uint64(c.q))
+	qf.rMask = maskLower(uint64(c.r))
+	qf.sMask = maskLower(uint64(qf.ssize))
+	qf.blockLen = min(blockSlots, qf.cap)
+	qf.rwidth, qf.alignedSlots = remainderWidth(c.r, c.alignedSlots), c.alignedSlots
+	qf.stride, qf.cacheLineBlocks = blockStride(qf.rwidth, c.cacheLineBlocks), c.cacheLineBlocks
+	if c.sparse {
+		words, _ := uint64Size(c.q, qf.rwidth, c.cacheLineBlocks)
+		qf.sparse = newSparseData(words, sparsePageWords(c.q, words, qf.stride), c.maxMemory, c.cacheLineBlocks)
+	} else if !c.noData {
+		size, _ := uint64Size(c.q, qf.rwidth, c.cacheLineBlocks)
+		qf.data = qf.makeData(size * 8)
+		qf.offsets = qf.newOffsets()
+	}
+	if c.dirtyBlockSize > 0 {
+		size, _ := uint64Size(c.q, qf.rwidth, c.cacheLineBlocks)
+		qf.dirty = newDirtyBlocks(c.dirtyBlockSize, size*8)
+	}
+	if c.optimistic {
+		qf.seq, qf.atomicWords = &seqlock{gen: new(uint64), len: new(uint64)}, true
+	}
+	return qf
+}
@@ -0,0 +1,389 @@
+package qf
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"hash/fnv"
+	"math"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestNewWithOptions(t *testing.T) {
+	tests := []struct {
+		P float64
+		S int
+	}{{0.001, 10}, {0.01, 1000}, {0.1, 10000}, {0.3, 100000}}
+	for _, test := range tests {
+		qf, err := NewWithOptions(test.S, WithFalsePositiveRate(test.P))
+		if err != nil {
+			t.Fatal("Unexpected error", err, "test", test)
+		}
+		if qf.Params() != mustNew(NewProbability(test.S, test.P)).Params() {
+			t.Fatal("NewWithOptions and NewProbability differ", qf.Params(), "test", test)
+		}
+	}
+
+	qf, err := NewWithOptions(1000)
+	if err != nil || qf.Params() != mustNew(NewProbability(1000, DefaultFalsePositiveRate)).Params() {
+		t.Fatal("Unexpected default sizing", qf.Params(), err)
+	}
+	qf, err = NewWithOptions(0, WithQR(10, 7))
+	if err != nil || qf.Params() != MustNew(10, 7).Params() {
+		t.Fatal("WithQR and New differ", qf.Params(), err)
+	}
+	qf, err = NewWithOptions(0, WithQR(10, 7), WithHash(func() hash.Hash64 { return fnv.New64() }))
+	if err != nil || qf.Params() != mustNew(NewHash(fnv.New64(), 10, 7)).Params() {
+		t.Fatal("WithHash and NewHash differ", qf.Params(), err)
+	}
+}
+
+func TestNewWithOptionsSameKeys(t *testing.T) {
+	qf, _ := NewWithOptions(0, WithQR(10, 16), WithHash(func() hash.Hash64 { return fnv.New64() }))
+	hqf, _ := NewHash(fnv.New64(), 10, 16)
+	items := generateItems(500)
+	qf.AddAll(items)
+	hqf.AddAll(items)
+	for i := range qf.data {
+		if qf.data[i] != hqf.data[i] {
+			t.Fatal("Filter data differs at", i)
+		}
+	}
+}
+
+func TestNewWithOptionsInvalid(t *testing.T) {
+	tests := []struct {
+		Name     string
+		Capacity int
+		Opts     []Option
+	}{
+		{"zero capacity", 0, nil},
+		{"negative capacity", -1, nil},
+		{"zero rate", 100, []Option{WithFalsePositiveRate(0)}},
+		{"rate one", 100, []Option{WithFalsePositiveRate(1)}},
+		{"negative rate", 100, []Option{WithFalsePositiveRate(-0.1)}},
+		{"too many bits", 0, []Option{WithQR(40, 25)}},
+		{"nil hash", 100, []Option{WithHash(nil)}},
+		{"zero load factor", 100, []Option{WithMaxLoadFactor(0)}},
+		{"load factor above one", 100, []Option{WithMaxLoadFactor(1.1)}},
+	}
+	for _, test := range tests {
+		if _, err := NewWithOptions(test.Capacity, test.Opts...); err == nil {
+			t.Fatal("Expected an error for", test.Name)
+		}
+	}
+}
+
+func TestKeyTransformer(t *testing.T) {
+	normalize := func(s string) string { return strings.TrimSuffix(strings.ToLower(s), ".") }
+	qf, err := NewWithOptions(1000, WithKeyTransformer("hostname", normalize))
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	qf.Add("FOO.example.")
+	if !qf.Contains("foo.example") || !qf.ContainsBytes([]byte("Foo.Example")) || !qf.ContainsKey(qf.NewKey("foo.EXAMPLE.")) {
+		t.Fatal("Transformed key not found")
+	}
+	if !qf.Delete("foo.example") || qf.Contains("FOO.example.") {
+		t.Fatal("Delete did not use the transformer")
+	}
+	if qf.Params().KeyTransformer != "hostname" || MustNew(10, 8).Params().KeyTransformer != "" {
+		t.Fatal("Unexpected key transformer in Params", qf.Params())
+	}
+	// keys hashed by a filter without the transformer are rehashed.
+	plain, _ := NewWithOptions(1000)
+	qf.AddKey(plain.NewKey("BAR.example."))
+	if !qf.Contains("bar.example") {
+		t.Fatal("Key of a filter without the transformer was not rehashed")
+	}
+	// fixed size keys are not transformed.
+	upper := func(s string) string { return strings.ToUpper(s) }
+	qf, _ = NewWithOptions(1000, WithKeyTransformer("upper", upper))
+	plain.AddUint64(42)
+	qf.AddUint64(42)
+	if qf.Fingerprint64("a") == plain.Fingerprint64("a") || qf.Fingerprint64("a") != plain.Fingerprint64("A") {
+		t.Fatal("String keys were not transformed")
+	}
+	if !qf.ContainsHash(plain.hashUint64(42)) {
+		t.Fatal("Fixed size key was transformed")
+	}
+}
+
+func TestMaxMemory(t *testing.T) {
+	tests := []struct {
+		Q, R  uint8
+		Limit uint64
+		Fails bool
+	}{{40, 8, DefaultMaxMemory, true}, {63, 1, DefaultMaxMemory, true}, {62, 2, math.MaxUint64, true},
+		{10, 8, 1024, true}, {10, 8, EstimateSizeBytes(10, 8) - filterOverhead, false}, {20, 8, DefaultMaxMemory, false}}
+	for _, test := range tests {
+		_, err := NewWithOptions(0, WithQR(test.Q, test.R), WithMaxMemory(test.Limit))
+		if (err != nil) != test.Fails {
+			t.Fatal("Unexpected error", err, "for test", test)
+		}
+	}
+	if EstimateSizeBytes(63, 1) != math.MaxUint64 {
+		t.Fatal("EstimateSizeBytes should saturate, got", EstimateSizeBytes(63, 1))
+	}
+	if _, err := New(40, 8); err == nil {
+		t.Fatal("Expected an error for q 40 and r 8 with the default memory limit")
+	}
+}
+
+func TestCacheLineBlocks(t *testing.T) {
+	for _, qr := range [][2]uint8{{4, 7}, {10, 7}, {10, 5}, {9, 12}} {
+		name := fmt.Sprint("q ", qr[0], " r ", qr[1])
+		plain, _ := NewWithOptions(0, WithQR(qr[0], qr[1]))
+		qf, err := NewWithOptions(0, WithQR(qr[0], qr[1]), WithCacheLineBlocks())
+		if err != nil {
+			t.Fatal(name, "unexpected error", err)
+		}
+		if !qf.Params().CacheLineBlocks || plain.Params().CacheLineBlocks {
+			t.Fatal(name, "Params don't report the layout")
+		}
+		if !isAligned(qf.data) {
+			t.Fatal(name, "data doesn't start at a cache line")
+		}
+		size, _ := dataBytes(qr[0], qr[1], true)
+		if uint64(len(qf.data)) != size || qf.cap >= blockSlots && qf.stride%cacheLineWords != 0 {
+			t.Fatal(name, "data of", len(qf.data), "bytes with blocks of", qf.stride, "words")
+		}
+		items := randomItems(int(qf.maxLen))
+		for i, k := range items {
+			plain.Add(k)
+			qf.Add(k)
+			if i%3 == 0 {
+				plain.Delete(items[i/2])
+				qf.Delete(items[i/2])
+			}
+		}
+		if !slices.Equal(qf.Fingerprints(), plain.Fingerprints()) {
+			t.Fatal(name, "the layouts hold different fingerprints")
+		}
+		checkOffsets(t, name, qf)
+
+		var decoded []*QuotientFilter
+		b, _ := qf.MarshalBinary()
+		unmarshaled := new(QuotientFilter)
+		if err := unmarshaled.UnmarshalBinary(b); err != nil {
+			t.Fatal(name, "unexpected error", err)
+		}
+		var compressed bytes.Buffer
+		qf.WriteToCompressed(&compressed)
+		decompressed := new(QuotientFilter)
+		if _, err := decompressed.ReadFrom(&compressed); err != nil {
+			t.Fatal(name, "unexpected error", err)
+		}
+		j, _ := json.Marshal(qf)
+		fromJSON := new(QuotientFilter)
+		if err := json.Unmarshal(j, fromJSON); err != nil {
+			t.Fatal(name, "unexpected error", err)
+		}
+		path := filepath.Join(t.TempDir(), "filter.qf")
+		if err := qf.SaveToFile(path); err != nil {
+			t.Fatal(name, "unexpected error", err)
+		}
+		mapped, err := OpenMmap(path)
+		if err != nil {
+			t.Fatal(name, "unexpected error", err)
+		}
+		defer mapped.Close()
+		receiver := qf.Clone()
+		digest := receiver.Checkpoint()
+		qf.Add("fox")
+		diff, _ := qf.Diff(digest)
+		if err := receiver.ApplyDiff(diff); err != nil {
+			t.Fatal(name, "unexpected error", err)
+		}
+		qf.Delete("fox")
+		receiver.Delete("fox")
+		decoded = append(decoded, unmarshaled, decompressed, fromJSON, mapped, receiver)
+		for i, d := range decoded {
+			if d.Params() != qf.Params() || !bytes.Equal(d.data, qf.data) {
+				t.Fatal(name, "decoded filter", i, "differs")
+			}
+			if d != mapped && !isAligned(d.data) {
+				t.Fatal(name, "data of decoded filter", i, "doesn't start at a cache line")
+			}
+			checkOffsets(t, name, d)
+		}
+		if qf.blockLen == blockSlots && qf.stride > uint64(qf.ssize) {
+			// the padding of an encoding has to stay zero.
+			qf.data[int(qf.ssize)*8] = 1
+			b, _ = qf.MarshalBinary()
+			if err := new(QuotientFilter).UnmarshalBinary(b); err == nil {
+				t.Fatal(name, "decoded data with padding that is not zero")
+			}
+		}
+	}
+}
+
+func TestAlignedSlots(t *testing.T) {
+	for _, test := range []struct {
+		q, r, width uint8
+		cacheLine   bool
+	}{{4, 5, 8, false}, {4, 20, 32, false}, {10, 5, 8, false}, {10, 8, 8, false}, {10, 13, 16, false},
+		{9, 20, 32, false}, {8, 33, 64, false}, {10, 13, 16, true}} {
+		name := fmt.Sprint("q ", test.q, " r ", test.r, " cache lines ", test.cacheLine)
+		opts := []Option{WithQR(test.q, test.r)}
+		if test.cacheLine {
+			opts = append(opts, WithCacheLineBlocks())
+		}
+		plain, _ := NewWithOptions(0, opts...)
+		qf, err := NewWithOptions(0, append(opts, WithAlignedSlots())...)
+		if err != nil {
+			t.Fatal(name, "unexpected error", err)
+		}
+		if !qf.Params().AlignedSlots || plain.Params().AlignedSlots || qf.rwidth != test.width {
+			t.Fatal(name, "remainders of", qf.rwidth, "bits, Params", qf.Params())
+		}
+		size, _ := dataBytes(test.q, test.width, test.cacheLine)
+		if uint64(len(qf.data)) != size {
+			t.Fatal(name, "data of", len(qf.data), "bytes, expected", size)
+		}
+		// both paths see the same keys, and give the same results for them and others.
+		items := randomItems(int(qf.maxLen))
+		for i, k := range items {
+			if plain.Add(k) != qf.Add(k) {
+				t.Fatal(name, "Add returned a different error for", k)
+			}
+			if i%3 == 0 && plain.Delete(items[i/2]) != qf.Delete(items[i/2]) {
+				t.Fatal(name, "Delete returned a different result for", items[i/2])
+			}
+		}
+		if qf.Len() != plain.Len() || !slices.Equal(qf.Fingerprints(), plain.Fingerprints()) {
+			t.Fatal(name, "the layouts hold different fingerprints")
+		}
+		for _, k := range append(items, randomItems(1000)...) {
+			if qf.Contains(k) != plain.Contains(k) {
+				t.Fatal(name, "the layouts give different results for", k)
+			}
+		}
+		checkOffsets(t, name, qf)
+
+		b, _ := qf.MarshalBinary()
+		unmarshaled := new(QuotientFilter)
+		if err := unmarshaled.UnmarshalBinary(b); err != nil {
+			t.Fatal(name, "unexpected error", err)
+		}
+		var compressed bytes.Buffer
+		qf.WriteToCompressed(&compressed)
+		decompressed := new(QuotientFilter)
+		if _, err := decompressed.ReadFrom(&compressed); err != nil {
+			t.Fatal(name, "unexpected error", err)
+		}
+		j, _ := json.Marshal(qf)
+		fromJSON := new(QuotientFilter)
+		if err := json.Unmarshal(j, fromJSON); err != nil {
+			t.Fatal(name, "unexpected error", err)
+		}
+		path := filepath.Join(t.TempDir(), "filter.qf")
+		if err := qf.SaveToFile(path); err != nil {
+			t.Fatal(name, "unexpected error", err)
+		}
+		mapped, err := OpenMmap(path)
+		if err != nil {
+			t.Fatal(name, "unexpected error", err)
+		}
+		defer mapped.Close()
+		receiver := qf.Clone()
+		digest := receiver.Checkpoint()
+		qf.Add("fox")
+		diff, _ := qf.Diff(digest)
+		if err := receiver.ApplyDiff(diff); err != nil {
+			t.Fatal(name, "unexpected error", err)
+		}
+		qf.Delete("fox")
+		receiver.Delete("fox")
+		for i, d := range []*QuotientFilter{unmarshaled, decompressed, fromJSON, mapped, receiver} {
+			if d.Params() != qf.Params() || d.rwidth != qf.rwidth || !bytes.Equal(d.data, qf.data) {
+				t.Fatal(name, "decoded filter", i, "differs")
+			}
+			checkOffsets(t, name, d)
+		}
+
+		if test.width > test.r {
+			// the bits of a remainder past r have to stay zero.
+			w, bit := qf.remainderBit(qf.cap - 1)
+			qf.setWord(w, qf.word(w)|1<<(bit+uint64(test.r)))
+			b, _ = qf.MarshalBinary()
+			if err := new(QuotientFilter).UnmarshalBinary(b); err == nil {
+				t.Fatal(name, "decoded data with remainder padding that is not zero")
+			}
+		}
+	}
+}
+
+// BenchmarkAlignedSlots adds and looks up keys and reads and writes slots, with r 5 taking
+// the generic path, r 13 packed and in 16 bits and r 16 aligned by itself, and reports the
+// bytes the table takes per slot.
+func BenchmarkAlignedSlots(b *testing.B) {
+	for _, test := range []struct {
+		r       uint8
+		aligned bool
+	}{{5, false}, {13, false}, {13, true}, {16, false}} {
+		opts := []Option{WithQR(16, test.r), WithMaxLoadFactor(1)}
+		if test.aligned {
+			opts = append(opts, WithAlignedSlots())
+		}
+		qf, _ := NewWithOptions(0, opts...)
+		items := randomItems(int(qf.cap) * 3 / 4)
+		name := fmt.Sprintf("r=%d/aligned=%v", test.r, test.aligned)
+		b.Run("Add/"+name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if i%len(items) == 0 {
+					b.StopTimer()
+					qf.Reset()
+					b.StartTimer()
+				}
+				qf.Add(items[i%len(items)])
+			}
+			b.ReportMetric(float64(len(qf.data))/float64(qf.cap), "bytes/slot")
+		})
+		qf.Reset()
+		qf.AddAll(items)
+		b.Run("Contains/"+name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				qf.Contains(items[i%len(items)])
+			}
+			b.ReportMetric(float64(len(qf.data))/float64(qf.cap), "bytes/slot")
+		})
+		b.Run("Slots/"+name, func(b *testing.B) {
+			var s slot
+			for i := 0; i < b.N; i++ {
+				index := uint64(i) * 7919 & qf.qMask
+				s ^= qf.getSlot(index)
+				qf.setSlot(index, s&slot(qf.sMask))
+			}
+			sink = uint64(s)
+		})
+	}
+}
+
+// BenchmarkCacheLineBlocks looks up keys in tables too large for the caches, with the blocks
+// packed and padded to cache lines, and reports the bytes the table takes per slot. With r 5
+// and 13 a block is whole cache lines either way and only the alignment of the data differs.
+func BenchmarkCacheLineBlocks(b *testing.B) {
+	for _, r := range []uint8{5, 7, 13} {
+		for _, padded := range []bool{false, true} {
+			b.Run(fmt.Sprintf("r=%d/padded=%v", r, padded), func(b *testing.B) {
+				opts := []Option{WithQR(22, r)}
+				if padded {
+					opts = append(opts, WithCacheLineBlocks())
+				}
+				qf, _ := NewWithOptions(0, opts...)
+				items := randomItems(int(qf.cap) * 3 / 4)
+				qf.AddAll(items)
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					qf.Contains(items[i%len(items)])
+				}
+				b.ReportMetric(float64(len(qf.data))/float64(qf.cap), "bytes/slot")
+			})
+		}
+	}
+}
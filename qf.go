@@ -1,82 +1,458 @@
 package qf
 
 import (
+	"context"
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"hash"
-	"hash/fnv"
+	"hash/maphash"
+	"io"
+	"iter"
 	"math"
-	"math/rand"
-	"time"
+	"math/bits"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"unsafe"
 )
 
-func init() {
-	rand.Seed(time.Now().UTC().UnixNano())
+// ErrFull is returned when Add is called while the filter is at max capacity,
+// the max load factor times the number of slots.
+var ErrFull = errors.New("filter is at its max capacity")
+
+// ErrReadOnly is returned when adding to or deleting from a read-only filter, such as one
+// opened with OpenMmap.
+var ErrReadOnly = errors.New("filter is read-only")
+
+// BatchError is returned by the batch methods when they stop before processing all keys.
+type BatchError struct {
+	// Index of the key that failed, keys before it have been processed.
+	Index int
+	Err   error
 }
 
-// ErrFull is returned when Add is called while the filter is at max capacity.
-var ErrFull = errors.New("filter is at its max capacity")
+func (e *BatchError) Error() string {
+	return fmt.Sprintf("key %d: %v", e.Index, e.Err)
+}
+
+// Unwrap returns the underlying error, such as ErrFull.
+func (e *BatchError) Unwrap() error {
+	return e.Err
+}
+
+// Hash identifiers reported in Params.
+const (
+	// HashFNV64a identifies the default FNV-64a hash function.
+	HashFNV64a = "fnv64a"
+	// HashMaphash identifies hash/maphash, see WithMaphash.
+	HashMaphash = "maphash"
+	// HashXXHash64 identifies xxHash64, see WithXXHash.
+	HashXXHash64 = "xxhash64"
+	// HashIdentity identifies keys that are their own hash, see WithIdentityHash.
+	HashIdentity = "identity"
+	// HashCustom identifies a hash function passed to NewHashFactory, NewHash or WithHash.
+	HashCustom = "custom"
+)
+
+// Params describes the structure of a filter, two filters with equal Params
+// store keys identically.
+type Params struct {
+	// Q is the number of quotient bits, the filter has 1 << Q slots.
+	Q uint8
+	// R is the number of remainder bits.
+	R uint8
+	// SlotSize is the number of bits a slot takes, R + 3 metadata bits.
+	SlotSize uint8
+	// Hash identifies the hash function.
+	Hash string
+	// KeyTransformer names the key transformer, empty without one.
+	KeyTransformer string
+	// Seed is the seed of the built-in hash function, see WithSeed.
+	Seed uint64
+	// CacheLineBlocks reports whether the blocks of slots start at cache lines, see
+	// WithCacheLineBlocks.
+	CacheLineBlocks bool
+	// AlignedSlots reports whether the remainders are stored in 8, 16, 32 or 64 bits, see
+	// WithAlignedSlots.
+	AlignedSlots bool
+	// Sparse reports whether the data is allocated in pages on first write, see WithSparse.
+	Sparse bool
+}
 
 // QuotientFilter is a basic quotient filter implementation.
-// None of the methods are thread safe.
+// Lookups write nothing, so any number of goroutines can look keys up while none changes
+// the filter, except in filters created with NewHash, whose lookups share one hash.Hash64,
+// and filters with a verifier, which count lookups. Len, Counters, Snapshot and Fingerprints
+// can be called while keys are added and deleted. The other methods are not thread safe,
+// Safe wraps a filter for use from many goroutines.
 type QuotientFilter struct {
+	// first, so that they are 64 bit aligned for atomic access on 32 bit platforms.
+	counts counters
 	// quotient and remainder bits
 	qbits uint8
 	rbits uint8
 	// total slot size, qbits + 3 metadata bits
 	ssize uint8
+	// slots of a block, blockSlots or the number of slots of smaller tables.
+	blockLen uint64
+	// bits a remainder is stored in, rbits or a power of two with alignedSlots, see
+	// WithAlignedSlots.
+	rwidth       uint8
+	alignedSlots bool
+	// data words of a block of blockSlots slots, rwidth + 3, or whole cache lines with
+	// cacheLineBlocks, see WithCacheLineBlocks.
+	stride          uint64
+	cacheLineBlocks bool
 	// how many elements does the filter contain and capacity 1 << qbits
 	len uint64
 	cap uint64
-	// data
-	data []uint64
+	// max load factor and the number of elements it allows
+	maxLoad float64
+	maxLen  uint64
+	// data words holding the slots in blocks, see blockSlots, little-endian so that the bytes
+	// are the data section of the binary encoding.
+	// They can be a caller's buffer or a memory mapping, unmap releases the mapping.
+	data []byte
+	// pages of the data words of filters created WithSparse, which have nil data.
+	sparse *sparseData
+	// the number of runs of the quotients before the first slot of a block that start at
+	// that slot or after it, for every block, see jumpRun. Nil for tables of fewer than
+	// blockSlots slots, for sparse filters, and for filters over a memory mapping, which
+	// computing them would read whole, or over a shared memory segment another process writes.
+	offsets  []uint64
+	readOnly bool
+	// sealed filters are read-only for good, see Seal.
+	sealed bool
+	unmap  func() error
+	// blocks of the data modified since the last Sync, nil without dirty tracking.
+	dirty *dirtyBlocks
+	// held while keys are added or deleted, so that Snapshot sees a single point in time.
+	mu *sync.Mutex
+	// sequence counter of lookups without locks, in the shared memory segment holding the
+	// data for NewShared, see WithOptimisticReads. atomicWords reads and writes the data words
+	// atomically.
+	seq         *seqlock
+	atomicWords bool
+	// limit of the memory decoding into the filter allocates, see WithMaxMemory.
+	// Zero in a zero QuotientFilter, which uses DefaultMaxMemory.
+	maxMemory uint64
+	// fingerprints, q << r | r, of insertions past the probe limit, nil without a stash.
+	stash      []uint64
+	probeLimit uint64
+	// hashes of reported false positives, nil until the first report.
+	reported        *reportedSet
+	adaptiveEntries int
+	// verifier of positive lookups and its counters, see SetVerifier.
+	verifier func(key string) bool
+	measured MeasuredStats
+	// namespaces used with AddNS.
+	namespaces map[string]struct{}
 	// precalculated masks for slot, quotient and remainder
 	sMask uint64
 	qMask uint64
 	rMask uint64
-	// hash function
-	h hash.Hash64
+	// hash function, its constructor, identifier and seed, see WithNamedHash, and the
+	// resolver of the hash functions of decoded filters. Lookups hash with the built-in
+	// hash inline, with the one-shot hash function of HashFunc, or its string variant for
+	// the built-in ones, or with one of the pooled hashers, h streams the lines of
+	// AddFromReader.
+	h         hash.Hash64
+	hashers   *sync.Pool
+	inlineFNV bool
+	hashFunc  func([]byte) uint64
+	hashStr   func(string) uint64
+	hash128   Hash128
+	hashCheck *hashCheck
+	newHash   func() hash.Hash64
+	hashID    string
+	hashSeed  string
+	resolver  HashResolver
+	// the fingerprints of q + r above 64 bits are drawn from the halves of hash128, see
+	// fingerprint128, they don't fit in the 64-bit hashes of the other hash functions.
+	wide bool
+	// the constructor returns one shared hash function, as with NewHash, see sameHasher.
+	sharedHasher bool
+	// seed of the values of AddComparable.
+	comparableSeed maphash.Seed
+	// distance of a fingerprint added from its quotient flagging the filter, and the keys
+	// rebuilding it, see WithDisplacementLimit.
+	displacementLimit uint64
+	needsRehash       bool
+	rehashKeys        iter.Seq[string]
+	// key transformer applied before hashing and its name, see WithKeyTransformer.
+	transform   func(string) string
+	transformID string
 }
 
 // NewProbability returns a quotient filter that can accomidate capacity number of elements
 // and maintain the probability passed.
-func NewProbability(capacity int, probability float64) *QuotientFilter {
-	// size to double asked capacity so that probability is maintained
-	// at capacity num keys (at 50% fill rate)
-	q := uint8(math.Ceil(math.Log2(float64(capacity * 2))))
-	r := uint8(-math.Log2(probability))
-	return New(q, r)
+func NewProbability(capacity int, probability float64) (*QuotientFilter, error) {
+	return NewWithOptions(capacity, WithFalsePositiveRate(probability))
+}
+
+// NewHashFactory returns a QuotientFilter with q quotient and r remainder bits backed by a
+// different hash function than the default FNV-64a. newHash is called for every hasher the
+// filter needs, for concurrent lookups, clones and decoded filters, and has to return a new
+// instance each time.
+func NewHashFactory(newHash func() hash.Hash64, q, r uint8) (*QuotientFilter, error) {
+	return NewWithOptions(0, WithQR(q, r), WithHash(newHash))
 }
 
 // NewHash returns a QuotientFilter backed by a different hash function.
 // Default hash function is FNV-64a
-func NewHash(h hash.Hash64, q, r uint8) *QuotientFilter {
-	qf := New(q, r)
-	qf.h = h
-	return qf
+//
+// The filter hashes every key with h, and so do its clones and snapshots, so lookups are
+// hashed one at a time and the filter can't be used concurrently with its clones. Filters
+// created with the same h interfere the same way.
+//
+// Deprecated: use NewHashFactory, which gives every hasher its own instance.
+func NewHash(h hash.Hash64, q, r uint8) (*QuotientFilter, error) {
+	return NewHashFactory(func() hash.Hash64 { return h }, q, r)
 }
 
 // New returns a QuotientFilter with q quotient bits and r remainder bits.
-// it can hold 1 << q elements.
-func New(q, r uint8) *QuotientFilter {
-	if q+r > 64 {
-		panic("q + r has to be less 64 bits or less")
-	}
-	qf := &QuotientFilter{
-		qbits: q,
-		rbits: r,
-		ssize: r + 3,
-		len:   0,
-		cap:   1 << q,
-		h:     fnv.New64a(),
-	}
-	qf.qMask = maskLower(uint64(q))
-	qf.rMask = maskLower(uint64(r))
-	qf.sMask = maskLower(uint64(qf.ssize))
-	qf.data = make([]uint64, uint64Size(q, r))
+// it can hold 1 << q elements. q has to be between 2 and 63, r between 1 and 61
+// and q + r at most 64, with q + r = 64 the fingerprint uses every bit of the hash.
+func New(q, r uint8) (*QuotientFilter, error) {
+	return NewWithOptions(0, WithQR(q, r))
+}
+
+// NewFromBuffer returns a filter with q quotient and r remainder bits that keeps its slots
+// in buf instead of allocating them, buf has to be exactly BufferSize(q, r) bytes and is
+// cleared. The data words are stored little-endian, so buf holds the data section of the
+// binary encoding. The filter reads and writes buf until it is no longer used, Clone
+// returns a copy that does not.
+func NewFromBuffer(q, r uint8, buf []byte) (*QuotientFilter, error) {
+	size, err := BufferSize(q, r)
+	if err != nil {
+		return nil, err
+	}
+	if len(buf) != size {
+		return nil, fmt.Errorf("buffer of %d bytes, q %d and r %d need %d", len(buf), q, r, size)
+	}
+	c := defaultConfig()
+	c.q, c.r, c.noData = q, r, true
+	qf := newFilter(c)
+	qf.data = buf
+	clear(qf.data)
+	qf.offsets = qf.newOffsets()
+	return qf, nil
+}
+
+// BufferSize returns the size of the buffer NewFromBuffer needs for q and r.
+func BufferSize(q, r uint8) (int, error) {
+	if err := validateQR(q, r); err != nil {
+		return 0, err
+	}
+	size, ok := dataBytes(q, r, false)
+	if !ok || size > math.MaxInt {
+		return 0, fmt.Errorf("q %d and r %d need more memory than can be addressed", q, r)
+	}
+	return int(size), nil
+}
+
+// MustNew is like New but panics if the parameters are invalid.
+func MustNew(q, r uint8) *QuotientFilter {
+	return mustNew(New(q, r))
+}
+
+func mustNew(qf *QuotientFilter, err error) *QuotientFilter {
+	if err != nil {
+		panic(err)
+	}
 	return qf
 }
 
+// filterOverhead is the size of the filter struct itself, excluding the data slice.
+const filterOverhead = uint64(unsafe.Sizeof(QuotientFilter{}))
+
+// Reset removes all keys from the filter, reusing the allocated memory, except for the pages
+// of sparse filters, which it releases. It does nothing on a read-only filter.
+func (qf *QuotientFilter) Reset() {
+	if qf.readOnly {
+		return
+	}
+	qf.mu.Lock()
+	defer qf.mu.Unlock()
+	qf.beginUpdate()
+	defer qf.endUpdate()
+	switch {
+	case qf.atomicWords:
+		for i := range qf.words() {
+			qf.storeWord(uint64(i), 0)
+		}
+	case qf.sparse != nil:
+		qf.sparse.reset()
+	default:
+		clear(qf.data)
+	}
+	clear(qf.offsets)
+	if qf.dirty != nil {
+		qf.dirty.reset()
+	}
+	qf.len = 0
+	qf.stash = qf.stash[:0]
+	qf.reported = nil
+	qf.namespaces = nil
+	qf.needsRehash = false
+}
+
+// Clone returns an independent copy of the filter, with hashers of its own from the hash
+// constructor. Filters created with NewHash share the hash.Hash64 instance with their clones,
+// so the original and the clone can't be used concurrently.
+// The clone of a read-only filter or one over a caller's buffer or a shared memory segment
+// is an ordinary filter
+// holding a copy of its data.
+func (qf *QuotientFilter) Clone() *QuotientFilter {
+	clone := *qf
+	if qf.sparse != nil {
+		clone.sparse = qf.sparse.clone()
+	} else {
+		clone.data = qf.makeData(uint64(len(qf.data)))
+		copy(clone.data, qf.data)
+	}
+	clone.readOnly, clone.sealed, clone.unmap, clone.seq, clone.atomicWords = false, false, nil, nil, false
+	clone.mu = new(sync.Mutex)
+	clone.buildOffsets()
+	if qf.dirty != nil {
+		clone.dirty = newDirtyBlocks(qf.dirty.size, uint64(len(qf.data)))
+	}
+	if qf.stash != nil {
+		clone.stash = append(make([]uint64, 0, cap(qf.stash)), qf.stash...)
+	}
+	if qf.reported != nil {
+		clone.reported = qf.reported.clone()
+	}
+	if qf.namespaces != nil {
+		clone.namespaces = make(map[string]struct{}, len(qf.namespaces))
+		for ns := range qf.namespaces {
+			clone.namespaces[ns] = struct{}{}
+		}
+	}
+	clone.h, clone.hashers = qf.newHash(), newHasherPool(qf.newHash)
+	return &clone
+}
+
+// EstimateSizeBytes returns the number of bytes a filter created with New(q, r) uses,
+// or math.MaxUint64 if the size does not fit in an uint64.
+func EstimateSizeBytes(q, r uint8) uint64 {
+	size, ok := dataBytes(q, r, false)
+	if !ok || size > math.MaxUint64-filterOverhead {
+		return math.MaxUint64
+	}
+	return size + filterOverhead
+}
+
+// SizeInBytes returns the number of bytes the filter uses, the backing slice, the mapped data
+// of a read-only filter, or the allocated pages and their index of a sparse filter, plus the
+// fixed size of the filter struct.
+func (qf *QuotientFilter) SizeInBytes() uint64 {
+	if qf.sparse != nil {
+		return qf.sparse.sizeInBytes() + filterOverhead
+	}
+	return uint64(len(qf.data)) + filterOverhead
+}
+
+// Params returns the parameters the filter was created with.
+func (qf *QuotientFilter) Params() Params {
+	return Params{
+		Q:        qf.qbits,
+		R:        qf.rbits,
+		SlotSize: qf.ssize,
+		Hash:     qf.hashID,
+
+		KeyTransformer:  qf.transformID,
+		Seed:            qf.seed(),
+		CacheLineBlocks: qf.cacheLineBlocks,
+		AlignedSlots:    qf.alignedSlots,
+		Sparse:          qf.sparse != nil,
+	}
+}
+
+// Len returns the number of fingerprints stored in the filter, including the stash. It can
+// be called from any goroutine while keys are added and deleted, see Counters.
+func (qf *QuotientFilter) Len() uint64 {
+	if qf.optimistic() {
+		return atomic.LoadUint64(qf.seq.len)
+	}
+	return atomic.LoadUint64(&qf.counts.len)
+}
+
+// Cap returns the number of slots in the filter, 1 << q.
+func (qf *QuotientFilter) Cap() uint64 {
+	return qf.cap
+}
+
+// LoadFactor returns the fraction of the slots of the table in use, the fingerprints in the
+// table over Cap. Stashed fingerprints take no slot and are not counted, unlike in Len.
+func (qf *QuotientFilter) LoadFactor() float64 {
+	return float64(qf.len) / float64(qf.cap)
+}
+
+// RemainingCapacity returns the number of fingerprints that can be added before Add returns
+// ErrFull, the free slots under the max load factor plus the free space in the stash.
+func (qf *QuotientFilter) RemainingCapacity() uint64 {
+	var n uint64
+	if qf.len < qf.maxLen {
+		n = qf.maxLen - qf.len
+	}
+	return n + uint64(cap(qf.stash)-len(qf.stash))
+}
+
+// WillFit reports whether n new keys can be added without Add returning ErrFull.
+// Keys that are already present don't take any space.
+func (qf *QuotientFilter) WillFit(n int) bool {
+	return n <= 0 || uint64(n) <= qf.RemainingCapacity()
+}
+
+// Stats describes the occupancy of a filter.
+type Stats struct {
+	// Len is the number of fingerprints, Cap the number of slots in the table.
+	Len uint64
+	Cap uint64
+	// LoadFactor is the fraction of slots in use, without the stash, see
+	// QuotientFilter.LoadFactor.
+	LoadFactor float64
+	// StashLen is the number of fingerprints in the stash and StashCap its size,
+	// zero without a stash.
+	StashLen int
+	StashCap int
+	// MaxDisplacement is the largest number of slots a fingerprint in the table is after its
+	// quotient, Stats scans the table for it. NeedsRehash reports that a key added since the
+	// filter was created, decoded or reset went past the limit of WithDisplacementLimit.
+	MaxDisplacement uint64
+	NeedsRehash     bool
+	// Pages is the number of pages of the data of a sparse filter and AllocatedPages the number
+	// allocated by adding keys, see WithSparse, zero for other filters.
+	Pages          uint64
+	AllocatedPages uint64
+}
+
+// Stats returns the current occupancy of the filter. A stash that is filling up means
+// clusters are growing long, the filter should be resized.
+func (qf *QuotientFilter) Stats() Stats {
+	st := Stats{
+		Len:        qf.Len(),
+		Cap:        qf.cap,
+		LoadFactor: qf.LoadFactor(),
+		StashLen:   len(qf.stash),
+		StashCap:   cap(qf.stash),
+
+		MaxDisplacement: qf.maxDisplacement(),
+		NeedsRehash:     qf.needsRehash,
+	}
+	if qf.sparse != nil {
+		st.Pages, st.AllocatedPages = uint64(len(qf.sparse.pages)), qf.sparse.allocated
+	}
+	return st
+}
+
+// MaxLoadFactor returns the load factor at which Add starts returning ErrFull.
+func (qf *QuotientFilter) MaxLoadFactor() float64 {
+	return qf.maxLoad
+}
+
 // FPProbability returns the probability for false positive with the current fillrate
 // n = length
 // m = capacity
@@ -85,8 +461,12 @@ func New(q, r uint8) *QuotientFilter {
 // then probability for false positive is
 // 1 - e^(-a/2^r) <= 2^-r
 func (qf *QuotientFilter) FPProbability() float64 {
-	a := float64(qf.len) / float64(qf.cap)
-	return 1.0 - math.Pow(math.E, -(a/math.Pow(2, float64(qf.rbits))))
+	return fpProbability(qf.len, qf.cap, qf.rbits)
+}
+
+func fpProbability(n, m uint64, r uint8) float64 {
+	a := float64(n) / float64(m)
+	return 1.0 - math.Pow(math.E, -(a/math.Pow(2, float64(r))))
 }
 
 func (qf *QuotientFilter) info() {
@@ -102,50 +482,255 @@ func (qf *QuotientFilter) info() {
 	fmt.Printf("\n")
 }
 
+// Key is a key with its hash precomputed by NewKey. Using a Key with several filters
+// that use the same hash function saves hashing the key for every filter.
+type Key struct {
+	key         string
+	hash        uint64
+	hashID      string
+	hashSeed    string
+	transformID string
+}
+
+// NewKey hashes the key with the filters hash function.
+func (qf *QuotientFilter) NewKey(key string) Key {
+	return Key{key: key, hash: qf.hashString(key), hashID: qf.hashID, hashSeed: qf.hashSeed, transformID: qf.transformID}
+}
+
+// String returns the key.
+func (k Key) String() string {
+	return k.key
+}
+
+// keyHash returns the hash of the key for this filter, the cached hash is only used
+// if the key was created by a filter with the same hash function and key transformer.
+// Custom hash functions can't be told apart, and the hashes of 128-bit ones depend on r, so
+// keys are always rehashed for them.
+func (qf *QuotientFilter) keyHash(k Key) uint64 {
+	if k.hashID != qf.hashID || k.hashSeed != qf.hashSeed || k.hashID == HashCustom || qf.hash128 != nil || k.transformID != qf.transformID {
+		return qf.hashString(k.key)
+	}
+	return k.hash
+}
+
+// keyFingerprint is fingerprintString for a key created with NewKey, see keyHash.
+func (qf *QuotientFilter) keyFingerprint(k Key) (h, q, r uint64) {
+	if qf.wide {
+		return qf.fingerprintString(k.key)
+	}
+	return qf.fingerprintHash(qf.keyHash(k))
+}
+
+// AddKey adds a key created with NewKey to the filter.
+func (qf *QuotientFilter) AddKey(k Key) error {
+	return qf.addFingerprint(qf.keyFingerprint(k))
+}
+
+// ContainsKey checks if a key created with NewKey is present in the filter.
+func (qf *QuotientFilter) ContainsKey(k Key) bool {
+	return qf.containsFingerprint(qf.keyFingerprint(k))
+}
+
+// Fingerprint returns the quotient and remainder the key is stored as.
+// The quotient is the index of the canonical slot of the key.
+func (qf *QuotientFilter) Fingerprint(key string) (quotient, remainder uint64) {
+	_, quotient, remainder = qf.fingerprintString(key)
+	return quotient, remainder
+}
+
+// Fingerprint64 returns the fingerprint of the key as a single value, quotient << r | remainder.
+// Filters of q + r above 64 bits only return its low 64 bits, see WithHash128.
+func (qf *QuotientFilter) Fingerprint64(key string) uint64 {
+	q, r := qf.Fingerprint(key)
+	return q<<qf.rbits | r
+}
+
 func (qf *QuotientFilter) quotientAndRemainder(h uint64) (uint64, uint64) {
 	return (h >> qf.rbits) & qf.qMask, h & qf.rMask
 }
 
-func (qf *QuotientFilter) hash(key string) uint64 {
-	defer qf.h.Reset()
-	qf.h.Write([]byte(key))
-	return qf.h.Sum64()
+// fingerprintHash returns h with the quotient and remainder of the hash h, the form the key
+// methods pass fingerprints on in. The hash identifies reported false positives.
+func (qf *QuotientFilter) fingerprintHash(h uint64) (uint64, uint64, uint64) {
+	q, r := qf.quotientAndRemainder(h)
+	return h, q, r
+}
+
+// fingerprintString returns the hash of a string key, transformed like in hashString, and the
+// quotient and remainder it is stored as, see fingerprint128 for filters of more than 64 bits.
+func (qf *QuotientFilter) fingerprintString(key string) (h, q, r uint64) {
+	if !qf.wide {
+		return qf.fingerprintHash(qf.hashString(key))
+	}
+	if qf.transform != nil {
+		key = qf.transform(key)
+	}
+	return qf.fingerprint128(stringBytes(key))
+}
+
+// fingerprint is fingerprintString for []byte keys, see hash.
+func (qf *QuotientFilter) fingerprint(key []byte) (h, q, r uint64) {
+	if !qf.wide {
+		return qf.fingerprintHash(qf.hash(key))
+	}
+	if qf.transform != nil {
+		key = []byte(qf.transform(string(key)))
+	}
+	return qf.fingerprint128(key)
+}
+
+// fingerprintBytes is fingerprint for fixed size and binary keys, which are not transformed,
+// see hashBytes.
+func (qf *QuotientFilter) fingerprintBytes(key []byte) (h, q, r uint64) {
+	if !qf.wide {
+		return qf.fingerprintHash(qf.hashBytes(key))
+	}
+	return qf.fingerprint128(key)
 }
 
-func (qf *QuotientFilter) getSlot(index uint64) slot {
-	_, sliceIndex, bitOffset, nextBits := qf.slotIndex(index)
-	s := (qf.data[sliceIndex] >> bitOffset) & qf.sMask
-	// does the slot span to next slice index, if so, capture rest of the bits from there
-	if nextBits > 0 {
-		sliceIndex++
-		s |= (qf.data[sliceIndex] & maskLower(uint64(nextBits))) << (uint64(qf.ssize) - uint64(nextBits))
+// hash hashes a string or []byte key, applying the key transformer.
+func (qf *QuotientFilter) hash(key []byte) uint64 {
+	if qf.transform != nil {
+		key = []byte(qf.transform(string(key)))
 	}
-	return slot(s)
+	return qf.hashBytes(key)
 }
 
-func (qf *QuotientFilter) setSlot(index uint64, s slot) {
-	// slot starts at bit data[sliceIndex][bitoffset:]
-	// if the slot crosses slice boundary, nextBits contains
-	// the number of bits the slot spans over to next slice item.
-	_, sliceIndex, bitOffset, nextBits := qf.slotIndex(index)
-	// remove everything but remainder and meta bits.
-	s &= slot(qf.sMask)
-	qf.data[sliceIndex] &= ^(qf.sMask << bitOffset)
-	qf.data[sliceIndex] |= uint64(s) << bitOffset
-	// the slot spans slice boundary, write the rest of the element to next index.
-	if nextBits > 0 {
-		sliceIndex++
-		qf.data[sliceIndex] &^= maskLower(uint64(nextBits))
-		qf.data[sliceIndex] |= uint64(s) >> (uint64(qf.ssize) - uint64(nextBits))
+// hashString hashes a string key like hash, without copying it: hash functions get a view
+// of the memory of the string.
+func (qf *QuotientFilter) hashString(key string) uint64 {
+	if qf.transform != nil {
+		key = qf.transform(key)
+	}
+	switch {
+	case qf.inlineFNV:
+		return fnv64a(fnvOffset64, key)
+	case qf.hashStr != nil:
+		return qf.hashStr(key)
 	}
+	return qf.hashBytes(stringBytes(key))
 }
 
-func (qf *QuotientFilter) slotIndex(index uint64) (uint64, uint64, uint64, int) {
-	bitIndex := uint64(qf.ssize) * index
-	bitOffset := bitIndex % 64
-	sliceIndex := bitIndex / 64
-	bitsInNextSlot := int(bitOffset) + int(qf.ssize) - 64
-	return bitIndex, sliceIndex, bitOffset, bitsInNextSlot
+// hashBytes hashes the bytes of a fixed size or binary key as is. It writes no state of the
+// filter, so lookups can run concurrently.
+func (qf *QuotientFilter) hashBytes(key []byte) uint64 {
+	if qf.inlineFNV {
+		return fnv64a(fnvOffset64, key)
+	}
+	if qf.hashFunc != nil {
+		return qf.hashFunc(key)
+	}
+	h := qf.getHasher()
+	writeHash(h, key)
+	sum := h.Sum64()
+	qf.putHasher(h)
+	return sum
+}
+
+// writeHash writes b to the hasher h. hash.Hash documents that Write never returns an error,
+// a hash function that does can't hash keys and panics.
+func writeHash(h hash.Hash64, b []byte) {
+	if _, err := h.Write(b); err != nil {
+		panic(fmt.Sprintf("qf: hash function failed to hash a key: %v", err))
+	}
+}
+
+// writeHashString is writeHash for strings.
+func writeHashString(h hash.Hash64, s string) {
+	if _, err := io.WriteString(h, s); err != nil {
+		panic(fmt.Sprintf("qf: hash function failed to hash a key: %v", err))
+	}
+}
+
+// getHasher returns a hash function of the filter's constructor from the pool, to be returned
+// with putHasher.
+func (qf *QuotientFilter) getHasher() hash.Hash64 {
+	return qf.hashers.Get().(hash.Hash64)
+}
+
+func (qf *QuotientFilter) putHasher(h hash.Hash64) {
+	h.Reset()
+	qf.hashers.Put(h)
+}
+
+// hashReader hashes everything read from r.
+func (qf *QuotientFilter) hashReader(r io.Reader) (uint64, error) {
+	h := qf.getHasher()
+	if _, err := io.Copy(h, r); err != nil {
+		qf.putHasher(h)
+		return 0, err
+	}
+	sum := h.Sum64()
+	qf.putHasher(h)
+	return sum, nil
+}
+
+// fingerprintReader is fingerprintString for everything read from r, which the 128-bit hash
+// functions of filters of more than 64 bits hash at once.
+func (qf *QuotientFilter) fingerprintReader(r io.Reader) (h, q, rem uint64, err error) {
+	if !qf.wide {
+		h, err = qf.hashReader(r)
+		h, q, rem = qf.fingerprintHash(h)
+		return h, q, rem, err
+	}
+	key, err := io.ReadAll(r)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	h, q, rem = qf.fingerprint128(key)
+	return h, q, rem, nil
+}
+
+func (qf *QuotientFilter) hashUint64(k uint64) uint64 {
+	if qf.hashID == HashIdentity {
+		return k
+	}
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], k)
+	return qf.hashBytes(b[:])
+}
+
+// fingerprintUint64 is fingerprintString for the integer key k, see hashUint64.
+func (qf *QuotientFilter) fingerprintUint64(k uint64) (h, q, r uint64) {
+	if !qf.wide {
+		return qf.fingerprintHash(qf.hashUint64(k))
+	}
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], k)
+	return qf.fingerprint128(b[:])
+}
+
+// word returns the data word i.
+func (qf *QuotientFilter) word(i uint64) uint64 {
+	if qf.atomicWords {
+		return qf.loadWord(i)
+	}
+	if qf.sparse != nil {
+		return qf.sparse.word(i)
+	}
+	return binary.LittleEndian.Uint64(qf.data[i*8:])
+}
+
+func (qf *QuotientFilter) setWord(i, w uint64) {
+	switch {
+	case qf.atomicWords:
+		qf.storeWord(i, w)
+	case qf.sparse != nil:
+		qf.sparse.setWord(i, w)
+	default:
+		binary.LittleEndian.PutUint64(qf.data[i*8:i*8+8], w)
+	}
+	if qf.dirty != nil {
+		qf.dirty.mark(i)
+	}
+}
+
+// words returns the number of data words.
+func (qf *QuotientFilter) words() int {
+	if qf.sparse != nil {
+		return int(qf.sparse.words)
+	}
+	return len(qf.data) / 8
 }
 
 func (qf *QuotientFilter) previous(index uint64) uint64 {
@@ -160,36 +745,332 @@ func (qf *QuotientFilter) next(index uint64) uint64 {
 // false negatives are not possible, unless Delete is used in conjunction with a hash function
 // that yields more that q+r bits.
 func (qf *QuotientFilter) Contains(key string) bool {
-	q, r := qf.quotientAndRemainder(qf.hash(key))
+	found := qf.containsFingerprint(qf.fingerprintString(key))
+	if qf.verifier != nil {
+		qf.measure(key, found)
+	}
+	return found
+}
 
-	if !qf.getSlot(q).isOccupied() {
+// ContainsBytes checks if key is present in the filter, it is the []byte equivalent of Contains.
+func (qf *QuotientFilter) ContainsBytes(key []byte) bool {
+	found := qf.containsFingerprint(qf.fingerprint(key))
+	if qf.verifier != nil {
+		qf.measure(string(key), found)
+	}
+	return found
+}
+
+// ContainsUint64 checks if the integer key k is present in the filter, see AddUint64.
+func (qf *QuotientFilter) ContainsUint64(k uint64) bool {
+	return qf.containsFingerprint(qf.fingerprintUint64(k))
+}
+
+// ContainsReader checks if the content read from r until EOF is present in the filter, see AddReader.
+func (qf *QuotientFilter) ContainsReader(r io.Reader) (bool, error) {
+	h, q, rem, err := qf.fingerprintReader(r)
+	if err != nil {
+		return false, err
+	}
+	return qf.containsFingerprint(h, q, rem), nil
+}
+
+// ContainsHash checks if a key with the 64 bit hash h is present in the filter.
+// The result is only meaningful if h was computed with the same hash function
+// that was used for adding the keys, see AddHash. It returns false for filters of q + r
+// above 64 bits, whose fingerprints don't fit in h.
+func (qf *QuotientFilter) ContainsHash(h uint64) bool {
+	if qf.wide {
+		return false
+	}
+	return qf.containsFingerprint(qf.fingerprintHash(h))
+}
+
+// containsFingerprint is ContainsHash for h with quotient q and remainder r.
+func (qf *QuotientFilter) containsFingerprint(h, q, r uint64) bool {
+	return qf.contains(q, r) && (qf.reported == nil || !qf.reported.contains(h))
+}
+
+func (qf *QuotientFilter) contains(q, r uint64) bool {
+	if qf.optimistic() {
+		return qf.seq.read(func() bool { return qf.probe(q, r) })
+	}
+	return qf.inTable(q, r) || len(qf.stash) > 0 && qf.stashIndex(q, r) >= 0
+}
+
+func (qf *QuotientFilter) inTable(q, r uint64) bool {
+	if !qf.isOccupied(q) {
 		return false
 	}
 
 	index := qf.findRun(q)
-	slot := qf.getSlot(index)
 	for {
-		remainder := slot.remainder()
+		remainder := qf.remainder(index)
 		if remainder == r {
 			return true
 		} else if remainder > r {
 			return false
 		}
 		index = qf.next(index)
-		slot = qf.getSlot(index)
-		if !slot.isContinuation() {
+		if !qf.isContinuation(index) {
 			break
 		}
 	}
 	return false
 }
 
+// ContainsWithConfidence checks if the key is present and returns the confidence of the
+// result. A negative result is always right and has confidence 1, a positive result has
+// confidence 1 - FPProbability() at the current fill rate. The confidence is an estimate over
+// all keys not in the filter, it says nothing about how likely this particular key is present.
+func (qf *QuotientFilter) ContainsWithConfidence(key string) (bool, float64) {
+	if !qf.Contains(key) {
+		return false, 1
+	}
+	return true, 1 - fpProbability(qf.len, qf.cap, qf.rbits)
+}
+
+// ContainsAll reports whether all of the keys are present in the filter,
+// it stops at the first key that is not.
+func (qf *QuotientFilter) ContainsAll(keys []string) bool {
+	for _, k := range keys {
+		found := qf.containsFingerprint(qf.fingerprintString(k))
+		if qf.verifier != nil {
+			qf.measure(k, found)
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// ContainsAny reports whether any of the keys is present in the filter,
+// it stops at the first key that is.
+func (qf *QuotientFilter) ContainsAny(keys []string) bool {
+	for _, k := range keys {
+		found := qf.containsFingerprint(qf.fingerprintString(k))
+		if qf.verifier != nil {
+			qf.measure(k, found)
+		}
+		if found {
+			return true
+		}
+	}
+	return false
+}
+
+// ContainsEach checks every key and returns the results in the same order as keys. The
+// results are written to out if it has room for them, otherwise to a new slice, so that
+// checking batch after batch into the same slice allocates nothing. The keys are hashed one
+// after the other with one hash function, it is the sequential form of ContainsBatchParallel.
+func (qf *QuotientFilter) ContainsEach(keys []string, out []bool) []bool {
+	out = results(out, len(keys))
+	qf.containsInto(keys, out)
+	if qf.verifier != nil {
+		for i, k := range keys {
+			qf.measure(k, out[i])
+		}
+	}
+	return out
+}
+
+// results returns out resliced to n results, or a new slice if it is too short.
+func results(out []bool, n int) []bool {
+	if cap(out) < n {
+		return make([]bool, n)
+	}
+	return out[:n]
+}
+
+// ContainsBatchParallel checks every key like ContainsEach, with the keys split between
+// workers goroutines that each hash with their own hash function and write their results
+// straight into the returned slice, without allocating per key. Like the other lookups it
+// works on read-only and sealed filters, and must not run concurrently with adding or
+// deleting keys. With workers <= 1, and for filters created with NewHash whose lookups share
+// one hash.Hash64, the keys are checked one at a time by the calling goroutine. A verifier
+// checks the results after all workers are done.
+func (qf *QuotientFilter) ContainsBatchParallel(keys []string, workers int) []bool {
+	if qf.sharedHasher {
+		workers = 1
+	}
+	out := qf.containsParallel(keys, workers)
+	if qf.verifier != nil {
+		for i, k := range keys {
+			qf.measure(k, out[i])
+		}
+	}
+	return out
+}
+
+// containsParallel looks the keys up in workers parts in parallel.
+func (qf *QuotientFilter) containsParallel(keys []string, workers int) []bool {
+	out := make([]bool, len(keys))
+	if workers <= 1 {
+		qf.containsInto(keys, out)
+		return out
+	}
+	part := max(1, (len(keys)+workers-1)/workers)
+	var wg sync.WaitGroup
+	for i := 0; i < len(keys); i += part {
+		j := min(i+part, len(keys))
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			qf.containsInto(keys[i:j], out[i:j])
+		}()
+	}
+	wg.Wait()
+	return out
+}
+
+// containsInto looks the keys up into out, hashing them with one hash function of the pool.
+// It is ContainsEach without the verifier, which the workers of ContainsBatchParallel run on
+// their parts of the keys.
+func (qf *QuotientFilter) containsInto(keys []string, out []bool) {
+	if qf.inlineFNV || qf.hashFunc != nil {
+		for i, k := range keys {
+			out[i] = qf.containsFingerprint(qf.fingerprintString(k))
+		}
+		return
+	}
+	h := qf.getHasher()
+	defer qf.putHasher(h)
+	for i, k := range keys {
+		if qf.transform != nil {
+			k = qf.transform(k)
+		}
+		h.Reset()
+		writeHash(h, stringBytes(k))
+		out[i] = qf.ContainsHash(h.Sum64())
+	}
+}
+
 // Add adds the key to the filter.
 func (qf *QuotientFilter) Add(key string) error {
-	if qf.len >= qf.cap {
-		return ErrFull
+	return qf.addFingerprint(qf.fingerprintString(key))
+}
+
+// AddBytes adds the key to the filter, it is the []byte equivalent of Add.
+func (qf *QuotientFilter) AddBytes(key []byte) error {
+	return qf.addFingerprint(qf.fingerprint(key))
+}
+
+// AddUint64 adds the integer key k to the filter. The key is hashed as its
+// 8 byte little-endian encoding, so AddUint64(k) is equivalent to AddBytes
+// of binary.LittleEndian.PutUint64 output for k.
+func (qf *QuotientFilter) AddUint64(k uint64) error {
+	return qf.addFingerprint(qf.fingerprintUint64(k))
+}
+
+// AddReader adds the content read from r until EOF as a key. The content is streamed
+// through the hash function, so AddReader(r) is equivalent to AddBytes of all of it
+// without holding it in memory. Errors from r are returned without adding anything.
+func (qf *QuotientFilter) AddReader(r io.Reader) error {
+	h, q, rem, err := qf.fingerprintReader(r)
+	if err != nil {
+		return err
+	}
+	return qf.addFingerprint(h, q, rem)
+}
+
+// AddHash adds a key that has already been hashed to h, skipping the filters own hash function.
+// The caller is responsible for supplying a well distributed 64 bit value, the quotient and
+// remainder are taken from the lower q+r bits of h. Mixing AddHash with the key based
+// methods only works if h is computed with the same hash function the filter uses. Filters of
+// q + r above 64 bits have no 64 bit hashes and return an error.
+func (qf *QuotientFilter) AddHash(h uint64) error {
+	if qf.wide {
+		return errWideHash
+	}
+	return qf.addFingerprint(qf.fingerprintHash(h))
+}
+
+// addFingerprint adds the quotient q and remainder r of a key with the hash h.
+func (qf *QuotientFilter) addFingerprint(h, q, r uint64) error {
+	if qf.readOnly {
+		return qf.errReadOnly()
+	}
+	qf.unreport(h)
+	_, err := qf.insert(q, r)
+	return err
+}
+
+// unreport forgets h as a reported false positive and reports whether it was one.
+func (qf *QuotientFilter) unreport(h uint64) bool {
+	if qf.reported == nil {
+		return false
+	}
+	qf.mu.Lock()
+	defer qf.mu.Unlock()
+	return qf.reported.remove(h)
+}
+
+// ContainsOrAdd adds the key to the filter and reports whether it was already present.
+// It is equivalent to calling Contains followed by Add, but hashes the key and scans
+// its run only once. Like Add it returns ErrFull if the filter is at max capacity.
+func (qf *QuotientFilter) ContainsOrAdd(key string) (existed bool, err error) {
+	return qf.containsOrAddFingerprint(qf.fingerprintString(key))
+}
+
+// containsOrAddFingerprint is ContainsOrAdd for the quotient q and remainder r of a key with
+// the hash h.
+func (qf *QuotientFilter) containsOrAddFingerprint(h, q, r uint64) (existed bool, err error) {
+	if qf.readOnly {
+		return qf.containsFingerprint(h, q, r), qf.errReadOnly()
+	}
+	if qf.len >= qf.maxLen && len(qf.stash) == cap(qf.stash) {
+		qf.mu.Lock()
+		qf.counts.add(false, ErrFull)
+		qf.mu.Unlock()
+		return qf.containsFingerprint(h, q, r), ErrFull
+	}
+	if qf.unreport(h) {
+		// a reported false positive is now added for real.
+		_, err := qf.insert(q, r)
+		return false, err
+	}
+	return qf.insert(q, r)
+}
+
+// insert adds the fingerprint to the filter, existed is true if it was already present.
+func (qf *QuotientFilter) insert(q, r uint64) (existed bool, err error) {
+	if qf.readOnly {
+		return false, qf.errReadOnly()
+	}
+	if err := qf.checkHash(); err != nil {
+		return false, err
+	}
+	qf.mu.Lock()
+	defer qf.mu.Unlock()
+	qf.beginUpdate()
+	defer qf.endUpdate()
+	existed, err = qf.place(q, r)
+	qf.counts.add(existed, err)
+	if err == nil && !existed {
+		err = qf.checkDisplacement()
+	}
+	return existed, err
+}
+
+// place is insert with the filter locked.
+func (qf *QuotientFilter) place(q, r uint64) (existed bool, err error) {
+	if len(qf.stash) > 0 && qf.stashIndex(q, r) >= 0 {
+		return true, nil
+	}
+	if qf.len >= qf.maxLen {
+		if len(qf.stash) == cap(qf.stash) {
+			return false, ErrFull
+		}
+		if qf.inTable(q, r) {
+			return true, nil
+		}
+		qf.stash = append(qf.stash, q<<qf.rbits|r)
+		return false, nil
+	}
+	if err := qf.reservePages(q); err != nil {
+		return false, err
 	}
-	q, r := qf.quotientAndRemainder(qf.hash(key))
 	slot := qf.getSlot(q)
 	new := newSlot(r)
 
@@ -197,7 +1078,7 @@ func (qf *QuotientFilter) Add(key string) error {
 	if slot.isEmpty() {
 		qf.setSlot(q, new.setOccupied())
 		qf.len++
-		return nil
+		return false, nil
 	}
 
 	if !slot.isOccupied() {
@@ -208,20 +1089,42 @@ func (qf *QuotientFilter) Add(key string) error {
 	index := start
 
 	if slot.isOccupied() {
-		runSlot := qf.getSlot(index)
 		for {
-			remainder := runSlot.remainder()
+			remainder := qf.remainder(index)
 			if r == remainder {
-				return nil
+				return true, nil
 			} else if remainder > r {
 				break
 			}
 			index = qf.next(index)
-			runSlot = qf.getSlot(index)
-			if !runSlot.isContinuation() {
+			if !qf.isContinuation(index) {
 				break
 			}
 		}
+	}
+	if cap(qf.stash) > len(qf.stash) && (index-q)&qf.qMask > qf.probeLimit {
+		if !slot.isOccupied() {
+			qf.setSlot(q, qf.getSlot(q).clearOccupied())
+		}
+		qf.stash = append(qf.stash, q<<qf.rbits|r)
+		return false, nil
+	}
+	qf.noteDisplacement((index - q) & qf.qMask)
+	qf.insertAt(q, start, index, r, !slot.isOccupied())
+	return false, nil
+}
+
+// insertAt inserts remainder r at index into the run of quotient q starting at start.
+// For a new run start == index, is_occupied has to be set for q before calling findRun.
+func (qf *QuotientFilter) insertAt(q, start, index, r uint64, newRun bool) {
+	qf.insertRunSlot(q, start, index, r, newRun)
+	qf.len++
+}
+
+// insertRunSlot is insertAt without counting the fingerprint.
+func (qf *QuotientFilter) insertRunSlot(q, start, index, r uint64, newRun bool) {
+	new := newSlot(r)
+	if !newRun {
 		if index == start {
 			old := qf.getSlot(start)
 			qf.setSlot(start, old.setContinuation())
@@ -232,69 +1135,331 @@ func (qf *QuotientFilter) Add(key string) error {
 	if index != q {
 		new = new.setShifted()
 	}
-	qf.insertSlot(index, new)
-	qf.len++
+	qf.updateOffsets(q, qf.insertSlot(index, new))
+}
+
+// Delete removes the key from the filter and reports whether it was found.
+// As the filter only stores fingerprints, deleting a key that was never added
+// but shares a fingerprint with one that was removes the other key.
+// Delete always returns false on a read-only filter.
+func (qf *QuotientFilter) Delete(key string) bool {
+	_, q, r := qf.fingerprintString(key)
+	return qf.deleteFingerprint(q, r)
+}
+
+func (qf *QuotientFilter) deleteHash(h uint64) bool {
+	return qf.deleteFingerprint(qf.quotientAndRemainder(h))
+}
 
-	return nil
+// deleteFingerprint removes the quotient q and remainder r from the table or the stash.
+func (qf *QuotientFilter) deleteFingerprint(q, r uint64) bool {
+	return qf.remove(q, r) || qf.unstash(q, r)
 }
 
-func (qf *QuotientFilter) insertSlot(index uint64, s slot) {
-	curr := s
+// DeleteAll deletes multiple keys from the filter and returns the number of keys
+// that were found and removed, keys that are not present are skipped.
+// It returns ErrReadOnly for a read-only filter.
+func (qf *QuotientFilter) DeleteAll(keys []string) (removed int, err error) {
+	if qf.readOnly {
+		return 0, qf.errReadOnly()
+	}
+	for _, k := range keys {
+		if qf.Delete(k) {
+			removed++
+		}
+	}
+	return removed, nil
+}
+
+func (qf *QuotientFilter) remove(q, r uint64) bool {
+	if qf.readOnly || qf.len == 0 || !qf.isOccupied(q) {
+		return false
+	}
+	qf.mu.Lock()
+	defer qf.mu.Unlock()
+	qf.beginUpdate()
+	defer qf.endUpdate()
+
+	start, index, found := qf.findInRun(q, r)
+	if !found {
+		return false
+	}
+	qf.removeAt(q, start, index)
+	return true
+}
+
+// findInRun returns the start of the run of the occupied quotient q and the index of
+// remainder r in it, or the index r belongs at if it is not in the run.
+func (qf *QuotientFilter) findInRun(q, r uint64) (start, index uint64, found bool) {
+	start = qf.findRun(q)
+	index = start
 	for {
-		prev := qf.getSlot(index)
-		empty := prev.isEmpty()
-		if !empty {
-			prev = prev.setShifted()
-			if prev.isOccupied() {
-				curr = curr.setOccupied()
-				prev = prev.clearOccupied()
-			}
+		remainder := qf.remainder(index)
+		if remainder == r {
+			return start, index, true
+		} else if remainder > r {
+			return start, index, false
 		}
-		qf.setSlot(index, curr)
-		curr = prev
 		index = qf.next(index)
-		if empty {
-			break
+		if !qf.isContinuation(index) {
+			return start, index, false
+		}
+	}
+}
+
+// removeAt removes the slot at index from the run of quotient q starting at start.
+func (qf *QuotientFilter) removeAt(q, start, index uint64) {
+	qf.removeRunSlot(q, start, index)
+	qf.len--
+}
+
+// removeRunSlot is removeAt without counting the fingerprint.
+func (qf *QuotientFilter) removeRunSlot(q, start, index uint64) {
+	runStart := index == start
+	// deleting the only element of the run, the quotient is no longer occupied.
+	if runStart && !qf.isContinuation(qf.next(index)) {
+		qf.setSlot(q, qf.getSlot(q).clearOccupied())
+	}
+	end := qf.deleteSlot(index, q)
+	// the next element of the run took the place of the deleted run start.
+	if runStart {
+		slot := qf.getSlot(index)
+		if slot.isContinuation() {
+			slot = slot.clearContinuation()
+			if index == q {
+				slot = slot.clearShifted()
+			}
+			qf.setSlot(index, slot)
+		}
+	}
+	qf.updateOffsets(q, end)
+}
+
+// stashIndex returns the index of the fingerprint in the stash, or -1.
+func (qf *QuotientFilter) stashIndex(q, r uint64) int {
+	fp := q<<qf.rbits | r
+	for i, s := range qf.stash {
+		if s == fp {
+			return i
 		}
 	}
+	return -1
 }
 
-func (qf *QuotientFilter) findRun(quotient uint64) (run uint64) {
-	var slot slot
-	index := quotient
+// unstash removes the fingerprint from the stash and reports whether it was found.
+func (qf *QuotientFilter) unstash(q, r uint64) bool {
+	if qf.readOnly || len(qf.stash) == 0 {
+		return false
+	}
+	qf.mu.Lock()
+	defer qf.mu.Unlock()
+	i := qf.stashIndex(q, r)
+	if i < 0 {
+		return false
+	}
+	last := len(qf.stash) - 1
+	qf.stash[i] = qf.stash[last]
+	qf.stash = qf.stash[:last]
+	qf.publishLen()
+	return true
+}
+
+// deleteSlot removes the slot at index by shifting the rest of the cluster left,
+// quotient is the canonical slot of the run the deleted slot belongs to. It returns the slot
+// at the end of the cluster that became empty.
+func (qf *QuotientFilter) deleteSlot(index, quotient uint64) (end uint64) {
+	curr := index
 	for {
-		slot = qf.getSlot(index)
-		if !slot.isShifted() {
+		next := qf.next(curr)
+		s := qf.getSlot(next)
+		// stop at the end of the cluster, slots that are not shifted can't move left.
+		if next == index || !s.isShifted() {
 			break
 		}
-		index = qf.previous(index)
-	}
-	run = index
-	for index != quotient {
-		for {
-			run = qf.next(run)
-			slot = qf.getSlot(run)
-			if !slot.isContinuation() {
-				break
+		if !s.isContinuation() {
+			// start of the next run, find the canonical slot it belongs to.
+			for {
+				quotient = qf.next(quotient)
+				if qf.isOccupied(quotient) {
+					break
+				}
 			}
-		}
-		for {
-			index = qf.next(index)
-			slot = qf.getSlot(index)
-			if slot.isOccupied() {
-				break
+			if quotient == curr {
+				s = s.clearShifted()
 			}
 		}
+		// is_occupied belongs to the slot index, not to the element being moved.
+		if qf.isOccupied(curr) {
+			s = s.setOccupied()
+		} else {
+			s = s.clearOccupied()
+		}
+		qf.setSlot(curr, s)
+		curr = next
 	}
-	return
+	qf.setSlot(curr, 0)
+	return curr
 }
 
-// AddAll adds multiple keys to the filter
-func (qf *QuotientFilter) AddAll(keys []string) error {
-	for _, k := range keys {
-		if err := qf.Add(k); err != nil {
-			return err
+// insertSlot inserts s at index and shifts the slots from index to the next empty slot right
+// by one. The shifted slots take their remainder and is_continuation bit along and become
+// shifted, the is_occupied bits belong to the slot index and stay. The slots are moved a block
+// at a time with word operations, their is_shifted bits are set afterwards. It returns the
+// slot that was empty.
+func (qf *QuotientFilter) insertSlot(index uint64, s slot) (end uint64) {
+	end = qf.nextEmpty(index)
+	if end < index {
+		// the slots wrap around the end of the table.
+		qf.moveUp(0, end)
+		last := qf.cap - 1
+		qf.setRemainder(0, qf.remainder(last))
+		qf.setMeta(0, metaContinuation, qf.isContinuation(last))
+		qf.moveUp(index, last)
+		qf.setShifted(0, end)
+		if index < last {
+			qf.setShifted(index+1, last)
 		}
+	} else if end > index {
+		qf.moveUp(index, end)
+		qf.setShifted(index+1, end)
+	}
+	qf.setRemainder(index, s.remainder())
+	qf.setMeta(index, metaContinuation, s.isContinuation())
+	qf.setMeta(index, metaShifted, s.isShifted())
+	return end
+}
+
+// findRun returns the start of the run of the occupied quotient, reading the metadata words
+// of the slots alone. Filters with offsets count the runs before it from the block of the
+// quotient, see jumpRun, the others from the start of its cluster.
+func (qf *QuotientFilter) findRun(quotient uint64) uint64 {
+	if qf.offsets != nil {
+		return qf.jumpRun(quotient)
+	}
+	start := qf.lastUnshifted(quotient)
+	return qf.runFrom(start, quotient, qf.countOccupied(start, quotient))
+}
+
+// runFrom returns the start of the run of quotient, after skip runs starting from the slot
+// start on, which is at most quotient. The run starts at the first slot after the last of
+// those runs that doesn't continue it, or at quotient if they end before it, and also where
+// it goes if quotient has just become occupied. The run starts are counted a block at a time.
+func (qf *QuotientFilter) runFrom(start, quotient, skip uint64) uint64 {
+	pos := (quotient - start) & qf.qMask
+	for index := start; skip > 0; {
+		j := index % blockSlots
+		starts := (qf.metaWord(index, metaOccupied) | qf.metaWord(index, metaShifted)) &^ qf.metaWord(index, metaContinuation) &^ maskLower(j)
+		if n := uint64(bits.OnesCount64(starts)); skip > n {
+			skip -= n
+			index = (index - j + qf.blockLen) & qf.qMask
+			continue
+		}
+		for ; skip > 1; skip-- {
+			starts &= starts - 1
+		}
+		pos = max(pos, (index-j+uint64(bits.TrailingZeros64(starts))+1-start)&qf.qMask)
+		break
+	}
+	index := (start + pos) & qf.qMask
+	for {
+		j := index % blockSlots
+		if ends := ^qf.metaWord(index, metaContinuation) & maskLower(qf.blockLen) &^ maskLower(j); ends != 0 {
+			return index - j + uint64(bits.TrailingZeros64(ends))
+		}
+		index = (index - j + qf.blockLen) & qf.qMask
+	}
+}
+
+// AddAll adds multiple keys to the filter and returns the number of keys that were not
+// already present. Duplicates are skipped, if a key does not fit AddAll stops and returns
+// a *BatchError wrapping ErrFull, all keys before BatchError.Index have been processed.
+func (qf *QuotientFilter) AddAll(keys []string) (inserted int, err error) {
+	return qf.AddAllContext(context.Background(), keys, nil)
+}
+
+// AddAllBulk adds the keys like AddAll, but hashes them all first and inserts their
+// fingerprints in ascending order, so that the table fills from its first cluster to its last.
+// Every fingerprint then goes to the end of its cluster with hardly anything to shift, and the
+// insertions walk the table sequentially instead of jumping around it, which is much faster
+// for large batches. Duplicates in keys are inserted once and inserted counts the keys that
+// were not already present. If the new keys don't all fit, the keys are added one by one like
+// AddAll, which stops at the first key that doesn't fit, so that the same keys end up in the
+// filter either way. Read-only filters, filters with a displacement limit, filters with
+// reported false positives, sparse filters, whose pages can run into the memory limit, and
+// filters of q + r above 64 bits, whose fingerprints don't fit the 64 bit values sorted, add
+// the keys one by one as well. It allocates 16 bytes per key.
+func (qf *QuotientFilter) AddAllBulk(keys []string) (inserted int, err error) {
+	if qf.readOnly || qf.displacementLimit > 0 || qf.reported != nil || qf.sparse != nil || qf.wide || len(keys) == 0 {
+		return qf.AddAll(keys)
+	}
+	if err := qf.checkHash(); err != nil {
+		return 0, &BatchError{Index: 0, Err: err}
+	}
+	fps := make([]uint64, len(keys))
+	for i, k := range keys {
+		q, r := qf.quotientAndRemainder(qf.hashString(k))
+		fps[i] = q<<qf.rbits | r
+	}
+	sortFingerprints(fps, qf.qbits+qf.rbits)
+	fps = slices.Compact(fps)
+	if room := qf.RemainingCapacity(); uint64(len(fps)) > room && qf.countNew(fps) > room {
+		return qf.AddAll(keys)
+	}
+	qf.mu.Lock()
+	defer qf.mu.Unlock()
+	qf.beginUpdate()
+	defer qf.endUpdate()
+	for _, fp := range fps {
+		// the new fingerprints fit, place can't fail.
+		if existed, _ := qf.place(fp>>qf.rbits&qf.qMask, fp&qf.rMask); !existed {
+			inserted++
+		}
+	}
+	atomic.AddUint64(&qf.counts.adds, uint64(inserted))
+	atomic.AddUint64(&qf.counts.duplicates, uint64(len(keys)-inserted))
+	return inserted, nil
+}
+
+// countNew returns the number of the ascending fingerprints fps that are not in the filter.
+func (qf *QuotientFilter) countNew(fps []uint64) uint64 {
+	n := uint64(0)
+	for _, fp := range fps {
+		if !qf.contains(fp>>qf.rbits&qf.qMask, fp&qf.rMask) {
+			n++
+		}
+	}
+	return n
+}
+
+// batchCheckInterval is the number of keys AddAllContext adds between checking the
+// context and reporting progress.
+const batchCheckInterval = 4096
+
+// AddAllContext is like AddAll but stops with ctx.Err() when ctx is cancelled, returning the
+// number of keys inserted until then. The context is checked before the first key and every
+// few thousand keys after it, and progress, if not nil, is called at the same interval and
+// once at the end with the number of keys processed. The filter holds every processed key when AddAllContext returns.
+func (qf *QuotientFilter) AddAllContext(ctx context.Context, keys []string, progress func(done, total int)) (inserted int, err error) {
+	for i, k := range keys {
+		if i%batchCheckInterval == 0 {
+			if progress != nil && i > 0 {
+				progress(i, len(keys))
+			}
+			if err := ctx.Err(); err != nil {
+				return inserted, err
+			}
+		}
+		existed, err := qf.ContainsOrAdd(k)
+		if existed {
+			continue
+		}
+		if err != nil {
+			return inserted, &BatchError{Index: i, Err: err}
+		}
+		inserted++
+	}
+	if progress != nil {
+		progress(len(keys), len(keys))
 	}
-	return nil
+	return inserted, nil
 }
@@ -1,9 +1,27 @@
 package qf
 
 import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/binary"
+	"errors"
+	"flag"
 	"fmt"
+	"hash"
+	"hash/fnv"
+	"io"
+	"math"
 	"math/rand"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"testing/iotest"
 	"time"
 )
 
@@ -14,7 +32,7 @@ func TestNewProbability(t *testing.T) {
 	}{{0.001, 10000}, {0.01, 10000}, {0.1, 10000}, {0.3, 10000},
 		{0.001, 100000}, {0.01, 100000}, {0.1, 100000}, {0.3, 100000}}
 	for _, test := range tests {
-		qf := NewProbability(test.S, test.P)
+		qf, _ := NewProbability(test.S, test.P)
 		qf.AddAll(generateItems(test.S))
 		if qf.FPProbability() > test.P {
 			t.Fatal("False positive rate too high, asked", test.P, "got", qf.FPProbability(), "test", test)
@@ -22,8 +40,344 @@ func TestNewProbability(t *testing.T) {
 	}
 }
 
+func TestNewInvalid(t *testing.T) {
+	tests := []struct {
+		Q, R  uint8
+		Param string
+	}{{0, 8, "q "}, {1, 8, "q "}, {64, 0, "q "}, {255, 1, "q "}, {8, 0, "r "}, {2, 62, "r "}, {3, 255, "r "},
+		{10, 55, "q + r "}, {40, 25, "q + r "}, {63, 2, "q + r "}}
+	for _, test := range tests {
+		_, err := New(test.Q, test.R)
+		if err == nil || !strings.HasPrefix(err.Error(), test.Param) {
+			t.Fatal("Expected an error naming", test.Param, "got", err, "test", test)
+		}
+		_, err = NewHash(fnv.New64(), test.Q, test.R)
+		if err == nil || !strings.HasPrefix(err.Error(), test.Param) {
+			t.Fatal("Expected NewHash error naming", test.Param, "got", err, "test", test)
+		}
+	}
+	probabilityTests := []struct {
+		P     float64
+		S     int
+		Param string
+	}{{0.01, 0, "capacity "}, {0.01, -5, "capacity "}, {0, 100, "false positive rate "},
+		{1, 100, "false positive rate "}, {1e-30, 1 << 40, "capacity "}}
+	for _, test := range probabilityTests {
+		_, err := NewProbability(test.S, test.P)
+		if err == nil || !strings.HasPrefix(err.Error(), test.Param) {
+			t.Fatal("Expected an error naming", test.Param, "got", err, "test", test)
+		}
+	}
+}
+
+func TestQuotientAndRemainder(t *testing.T) {
+	tests := []struct {
+		Q, R    uint8
+		H       uint64
+		Quo, Re uint64
+	}{{40, 24, 0xfedcba9876543210, 0xfedcba9876, 0x543210},
+		{32, 32, 0xfedcba9876543210, 0xfedcba98, 0x76543210},
+		{32, 32, ^uint64(0), 1<<32 - 1, 1<<32 - 1},
+		{16, 48, 0x8000000000000001, 0x8000, 1},
+		{8, 8, 0xfedcba9876543210, 0x32, 0x10}}
+	for _, test := range tests {
+		// quotient and remainder extraction only depends on the masks, so the
+		// data slice is not allocated for the large tables.
+		qf := &QuotientFilter{qbits: test.Q, rbits: test.R, qMask: maskLower(uint64(test.Q)), rMask: maskLower(uint64(test.R))}
+		if quo, re := qf.quotientAndRemainder(test.H); quo != test.Quo || re != test.Re {
+			t.Fatalf("Unexpected quotient %x and remainder %x, test %x", quo, re, test)
+		}
+	}
+	if maskLower(64) != ^uint64(0) || maskLower(63) != 1<<63-1 || maskLower(0) != 0 {
+		t.Fatal("Unexpected masks")
+	}
+}
+
+func TestFullWidthFingerprints(t *testing.T) {
+	for _, q := range []uint8{12, 16} {
+		qf := MustNew(q, 64-q)
+		items := generateItems(int(qf.Cap()) * 3 / 4)
+		if _, err := qf.AddAll(items); err != nil {
+			t.Fatal("Unexpected error", err)
+		}
+		if !qf.ContainsAll(items) {
+			t.Fatal("False negative with q", q, "r", 64-q)
+		}
+		if qf.ContainsAny(generateItems(1000)) {
+			t.Fatal("False positive with 64 bit fingerprints, q", q)
+		}
+	}
+}
+
+// TestSlotRoundTrip sets every slot of tables of every remainder size and layout to random
+// slots, in random order, with the words of the blocks written directly and through setWord,
+// and reads them back both ways.
+func TestSlotRoundTrip(t *testing.T) {
+	for r := uint8(1); r <= 61; r++ {
+		for _, q := range []uint8{4, 7} {
+			q = min(q, 64-r)
+			for _, mode := range []string{"plain", "atomic", "cache line", "aligned"} {
+				opts := []Option{WithQR(q, r)}
+				switch mode {
+				case "cache line":
+					opts = append(opts, WithCacheLineBlocks())
+				case "aligned":
+					opts = append(opts, WithAlignedSlots())
+				}
+				qf, _ := NewWithOptions(0, opts...)
+				qf.atomicWords = mode == "atomic"
+				want := make([]slot, qf.cap)
+				for round := 0; round < 3; round++ {
+					for _, i := range rand.Perm(int(qf.cap)) {
+						want[i] = slot(rand.Uint64() & qf.sMask)
+						if rand.Intn(2) == 0 {
+							qf.setSlot(uint64(i), want[i])
+						} else {
+							qf.setSlotWords(uint64(i), want[i])
+						}
+					}
+					for i := range want {
+						s, words := qf.getSlot(uint64(i)), slot(qf.remainder(uint64(i))<<3)|qf.metaBits(uint64(i))
+						if s != want[i] || words != want[i] {
+							t.Fatalf("Slot %d with q %d r %d %s: got %x and %x expected %x", i, q, r, mode, s, words, want[i])
+						}
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestBlockLayout(t *testing.T) {
+	qf := MustNew(7, 5)
+	qf.setSlot(64+3, newSlot(0x1f).setOccupied().setShifted())
+	qf.setSlot(64+63, newSlot(0x11).setContinuation())
+	// the second block starts with the is_occupied, is_continuation and is_shifted bits of its
+	// slots, followed by the remainders.
+	first := uint64(qf.ssize)
+	for i, want := range []uint64{1 << 3, 1 << 63, 1 << 3, 0x1f << 15, 0, 0, 0, 0x11 << 59} {
+		if w := qf.word(first + uint64(i)); w != want {
+			t.Fatalf("Word %d of the block is %x, expected %x", i, w, want)
+		}
+	}
+	if !qf.isOccupied(64+3) || !qf.isShifted(64+3) || qf.isContinuation(64+3) || !qf.isContinuation(64+63) || qf.isEmpty(64+63) || !qf.isEmpty(64+4) {
+		t.Fatal("Unexpected metadata bits")
+	}
+	for i := uint64(0); i < first; i++ {
+		if qf.word(i) != 0 {
+			t.Fatal("Slots of the second block changed the first", i)
+		}
+	}
+}
+
+// insertSlotBySlot is the reference for insertSlot, it moves the slots one at a time.
+func insertSlotBySlot(qf *QuotientFilter, index uint64, s slot) {
+	curr := s
+	for {
+		prev := qf.getSlot(index)
+		empty := prev.isEmpty()
+		if !empty {
+			prev = prev.setShifted()
+			if prev.isOccupied() {
+				curr = curr.setOccupied()
+				prev = prev.clearOccupied()
+			}
+		}
+		qf.setSlot(index, curr)
+		curr = prev
+		index = qf.next(index)
+		if empty {
+			break
+		}
+	}
+}
+
+func TestInsertSlot(t *testing.T) {
+	for _, params := range [][2]uint8{{2, 1}, {3, 61}, {4, 20}, {6, 7}, {7, 5}, {8, 13}, {9, 55}, {10, 29}} {
+		fast, ref := MustNew(params[0], params[1]), MustNew(params[0], params[1])
+		empty := uint64(0)
+		for round := 0; round < 2000; round++ {
+			// random slots that don't have to form a valid table, up to a random load.
+			if empty < 2 {
+				for i := range fast.cap {
+					s := slot(0)
+					if rand.Intn(4) > 0 {
+						s = slot(rand.Uint64() & fast.sMask)
+					}
+					fast.setSlot(i, s)
+					ref.setSlot(i, s)
+				}
+				empty = 0
+				for i := range fast.cap {
+					if fast.isEmpty(i) {
+						empty++
+					}
+				}
+				continue
+			}
+			index := rand.Uint64() & fast.qMask
+			s := slot(rand.Uint64()&fast.sMask).clearOccupied() | 2
+			fast.insertSlot(index, s)
+			insertSlotBySlot(ref, index, s)
+			if !bytes.Equal(fast.data, ref.data) {
+				t.Fatalf("Inserting %x at %d with q %d r %d moved the slots differently", s, index, params[0], params[1])
+			}
+			empty--
+		}
+	}
+}
+
+func TestNextEmpty(t *testing.T) {
+	for _, q := range []uint8{2, 3, 5, 6, 7, 9} {
+		qf := MustNew(q, 4)
+		for round := 0; round < 50; round++ {
+			// from mostly empty tables to tables with a single empty slot.
+			for i := range qf.cap {
+				s := slot(0)
+				if rand.Intn(50) < round {
+					s = slot(1 + rand.Intn(7))
+				}
+				qf.setSlot(i, s)
+			}
+			if round == 49 {
+				qf.setSlot(rand.Uint64()&qf.qMask, 0)
+			}
+			empty := -1
+			for i := range qf.cap {
+				if qf.isEmpty(i) {
+					empty = int(i)
+				}
+			}
+			if empty < 0 {
+				continue
+			}
+			for index := range qf.cap {
+				want := index
+				for !qf.isEmpty(want) {
+					want = qf.next(want)
+				}
+				if got := qf.nextEmpty(index); got != want {
+					t.Fatal("q", q, "first empty slot from", index, "is", got, "want", want)
+				}
+			}
+		}
+	}
+}
+
+func TestScanSlots(t *testing.T) {
+	for _, q := range []uint8{2, 3, 5, 6, 7, 9} {
+		qf := MustNew(q, 4)
+		for round := 0; round < 50; round++ {
+			// random metadata, from mostly empty tables to mostly used ones. A single slot
+			// of each kind makes the scans wrap around the end of the table.
+			for i := range qf.cap {
+				s := slot(0)
+				if rand.Intn(50) < round {
+					s = slot(1 + rand.Intn(7))
+				}
+				qf.setSlot(i, s)
+			}
+			if round%10 == 9 {
+				for i := range qf.cap {
+					qf.setSlot(i, 6)
+				}
+				qf.setSlot(rand.Uint64()&qf.qMask, 1)
+			}
+			scans := []struct {
+				name  string
+				scan  func(index uint64) uint64
+				match func(s slot) bool
+				back  bool
+			}{
+				{"used", qf.nextUsed, func(s slot) bool { return !s.isEmpty() }, false},
+				{"occupied", qf.nextOccupied, slot.isOccupied, false},
+				{"cluster start", qf.nextClusterStart, func(s slot) bool { return s.isOccupied() && !s.isShifted() }, false},
+				{"unshifted", qf.lastUnshifted, func(s slot) bool { return !s.isShifted() }, true},
+			}
+			for _, sc := range scans {
+				found := false
+				for i := range qf.cap {
+					found = found || sc.match(qf.getSlot(i))
+				}
+				if !found {
+					continue
+				}
+				for index := range qf.cap {
+					want := index
+					for !sc.match(qf.getSlot(want)) {
+						if sc.back {
+							want = qf.previous(want)
+						} else {
+							want = qf.next(want)
+						}
+					}
+					if got := sc.scan(index); got != want {
+						t.Fatal("q", q, sc.name, "slot from", index, "is", got, "want", want)
+					}
+				}
+			}
+			for from := range qf.cap {
+				to := rand.Uint64() & qf.qMask
+				want := uint64(0)
+				for i := from; i != to; i = qf.next(i) {
+					if qf.isOccupied(i) {
+						want++
+					}
+				}
+				if got := qf.countOccupied(from, to); got != want {
+					t.Fatal("q", q, "occupied quotients from", from, "to", to, "are", got, "want", want)
+				}
+			}
+		}
+	}
+}
+
+func TestWordSizedSlots(t *testing.T) {
+	// r = 61 makes every slot exactly one 64 bit word.
+	qf := newFull(3, 61)
+	if qf.ssize != 64 || qf.sMask != ^uint64(0) {
+		t.Fatal("Unexpected slot size", qf.ssize, "mask", qf.sMask)
+	}
+	items := generateItems(8)
+	if _, err := qf.AddAll(items); err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	if !qf.ContainsAll(items) || qf.ContainsAny(generateItems(100)) {
+		t.Fatal("Unexpected membership with 64 bit slots")
+	}
+	for _, s := range items[:4] {
+		qf.Delete(s)
+	}
+	if !qf.ContainsAll(items[4:]) || qf.ContainsAny(items[:4]) {
+		t.Fatal("Unexpected membership after delete with 64 bit slots")
+	}
+}
+
+func TestTinyCapacity(t *testing.T) {
+	tests := []struct {
+		P float64
+		S int
+	}{{0.5, 1}, {0.9, 1}, {0.99, 2}, {0.01, 1}, {0.3, 3}}
+	for _, test := range tests {
+		qf, err := NewProbability(test.S, test.P)
+		if err != nil {
+			t.Fatal("Unexpected error", err, "test", test)
+		}
+		if qf.qbits < 2 || qf.rbits < 1 {
+			t.Fatal("Parameters below the minimum", qf.Params(), "test", test)
+		}
+		items := generateItems(test.S)
+		if n, err := qf.AddAll(items); n != test.S || err != nil {
+			t.Fatal("Capacity keys did not fit", n, err, "test", test)
+		}
+		if !qf.ContainsAll(items) {
+			t.Fatal("False negative, test", test)
+		}
+	}
+}
+
 func TestAddBasic(t *testing.T) {
-	qf := New(8, 3)
+	qf := MustNew(8, 3)
 
 	added := generateItems(100) // []string{"brown", "fox", "jump"}
 	not := []string{"turbo", "negro"}
@@ -35,9 +389,17 @@ func TestAddBasic(t *testing.T) {
 		}
 	}
 
+	// with 3 remainder bits a key that was not added is a false positive whenever an added key
+	// has its fingerprint, which depends on the generated keys.
+	fps := make(map[[2]uint64]bool)
+	for _, s := range added {
+		q, r := qf.quotientAndRemainder(qf.hashString(s))
+		fps[[2]uint64{q, r}] = true
+	}
 	for _, s := range not {
-		if qf.Contains(s) {
-			t.Fatal("Filter returned true for not added item")
+		q, r := qf.quotientAndRemainder(qf.hashString(s))
+		if qf.Contains(s) != fps[[2]uint64{q, r}] {
+			t.Fatal("Filter returned", qf.Contains(s), "for not added item", s)
 		}
 	}
 }
@@ -48,7 +410,7 @@ func TestFalseNegatives(t *testing.T) {
 		S int
 	}{{0.01, 1000}, {0.01, 10000}, {0.01, 100000}}
 	for _, test := range tests {
-		qf := NewProbability(test.S, test.P)
+		qf, _ := NewProbability(test.S, test.P)
 		items := generateItems(test.S / 2)
 		qf.AddAll(items)
 		for _, item := range items {
@@ -67,9 +429,11 @@ func TestFalsePositives(t *testing.T) {
 		{0.001, 10000}, {0.01, 10000}, {0.1, 10000}, {0.3, 10000},
 		{0.001, 100000}, {0.01, 100000}, {0.1, 100000}, {0.3, 100000}}
 	for _, test := range tests {
-		qf := NewProbability(test.S, test.P)
+		qf, _ := NewProbability(test.S, test.P)
 		items := generateItems(test.S / 2)
-		itemsB := generateItems(test.S / 2)
+		// FNV-64a collides more than expected between keys differing in a few digits,
+		// like the keys of two generated sets, so the probes are random.
+		itemsB := randomItems(test.S / 2)
 		qf.AddAll(items)
 		var positives int
 		for _, item := range itemsB {
@@ -89,46 +453,1435 @@ func TestFalsePositives(t *testing.T) {
 	}
 }
 
-func BenchmarkAdd(b *testing.B) {
-	qf := NewProbability(b.N*2, 0.01)
-	items := generateItems(b.N)
-	b.ReportAllocs()
-	b.ResetTimer()
-	for i := 0; i < b.N; i++ {
-		qf.Add(items[i])
+func TestBytes(t *testing.T) {
+	qf := MustNew(10, 8)
+	items := generateItems(200)
+	for i, s := range items {
+		if i%2 == 0 {
+			qf.Add(s)
+		} else {
+			qf.AddBytes([]byte(s))
+		}
+	}
+	for _, s := range items {
+		if !qf.Contains(s) || !qf.ContainsBytes([]byte(s)) {
+			t.Fatal("Filter returned false for an added item", s)
+		}
+	}
+	if qf.ContainsBytes([]byte("turbo")) {
+		t.Fatal("Filter returned true for not added item")
 	}
-	b.StopTimer()
 }
 
-func BenchmarkContains(b *testing.B) {
-	qf := NewProbability(b.N*2, 0.01)
-	items := generateItems(b.N)
-	for i := 0; i < b.N; i++ {
-		if i%2 == 0 {
-			qf.Add(items[i])
+func TestHash(t *testing.T) {
+	qf := MustNew(10, 8)
+	hqf := MustNew(10, 8)
+	items := generateItems(500)
+	for _, s := range items {
+		qf.Add(s)
+		hqf.AddHash(qf.hash([]byte(s)))
+	}
+	if qf.len != hqf.len {
+		t.Fatal("Filter lengths differ", qf.len, hqf.len)
+	}
+	for i := range qf.data {
+		if qf.data[i] != hqf.data[i] {
+			t.Fatal("Filter data differs at", i)
 		}
 	}
-	b.ReportAllocs()
-	b.ResetTimer()
-	for i := 0; i < b.N; i++ {
-		qf.Contains(items[i])
+	for _, s := range items {
+		if !hqf.Contains(s) || !qf.ContainsHash(qf.hash([]byte(s))) {
+			t.Fatal("Filter returned false for an added item", s)
+		}
 	}
-	b.StopTimer()
 }
 
-var generatedSet int
+func TestUint64(t *testing.T) {
+	qf := MustNew(10, 16)
+	for k := uint64(0); k < 500; k += 2 {
+		qf.AddUint64(k)
+	}
+	var buf [8]byte
+	for k := uint64(0); k < 500; k++ {
+		binary.LittleEndian.PutUint64(buf[:], k)
+		if qf.ContainsUint64(k) != (k%2 == 0) || qf.ContainsBytes(buf[:]) != (k%2 == 0) {
+			t.Fatal("Unexpected membership for", k)
+		}
+	}
+}
 
-func init() {
-	rand.Seed(time.Now().UTC().UnixNano())
-	generatedSet = rand.Int()
+func TestLen(t *testing.T) {
+	qf := MustNew(4, 16)
+	if qf.Len() != 0 || qf.Cap() != 16 || qf.LoadFactor() != 0 {
+		t.Fatal("Unexpected empty filter len", qf.Len(), "cap", qf.Cap(), "load factor", qf.LoadFactor())
+	}
+	items := generateItems(10)
+	qf.AddAll(items)
+	qf.AddAll(items[:5])
+	if qf.Len() != 10 || qf.LoadFactor() != 10.0/16 {
+		t.Fatal("Duplicates should not change len, got", qf.Len(), "load factor", qf.LoadFactor())
+	}
+	qf.Delete(items[0])
+	if qf.Len() != 9 {
+		t.Fatal("Delete should decrement len, got", qf.Len())
+	}
+	if _, err := qf.AddAll(generateItems(10)); !errors.Is(err, ErrFull) {
+		t.Fatal("Expected ErrFull, got", err)
+	}
+	if qf.Len() != 14 {
+		t.Fatal("Filter should be at max load factor after ErrFull, len", qf.Len())
+	}
 }
 
-func generateItems(len int) []string {
-	setNum := generatedSet
-	generatedSet++
-	out := make([]string, 0, len)
-	for i := 0; i < len; i++ {
-		out = append(out, fmt.Sprintf("item:%d:%d", setNum, i))
+func TestParams(t *testing.T) {
+	tests := []struct {
+		Q, R uint8
+	}{{2, 1}, {8, 3}, {16, 16}, {12, 40}}
+	for _, test := range tests {
+		p := MustNew(test.Q, test.R).Params()
+		want := Params{Q: test.Q, R: test.R, SlotSize: test.R + 3, Hash: HashFNV64a}
+		if p != want {
+			t.Fatal("Unexpected params", p, "expected", want)
+		}
+		if MustNew(p.Q, p.R).Params() != p {
+			t.Fatal("Params did not round trip", p)
+		}
+	}
+	if p := mustNew(NewHash(fnv.New64(), 8, 8)).Params(); p.Hash != HashCustom {
+		t.Fatal("Unexpected hash identifier", p.Hash)
+	}
+}
+
+func TestSizeInBytes(t *testing.T) {
+	// the data holds the slots in whole words, and one word more.
+	tests := []struct {
+		Q, R  uint8
+		Bytes int
+	}{{2, 1, 16}, {8, 3, 200}, {10, 5, 1032}, {16, 16, 155656}, {12, 40, 22024}, {3, 61, 72}}
+	for _, test := range tests {
+		qf := MustNew(test.Q, test.R)
+		if len(qf.data) != test.Bytes {
+			t.Fatal("Data of", len(qf.data), "bytes, expected", test.Bytes, "test", test)
+		}
+		// the last slot is read whole, without running off the end.
+		last := newSlot(qf.rMask).setOccupied().setContinuation().setShifted()
+		qf.setSlot(qf.cap-1, last)
+		if qf.getSlot(qf.cap-1) != last || qf.getSlot(qf.cap-2) != 0 || qf.word(uint64(qf.words()-1)) != 0 && qf.cap*uint64(qf.ssize)%64 == 0 {
+			t.Fatal("Last slot not stored in the data", test)
+		}
+		if qf.SizeInBytes() != uint64(len(qf.data))+filterOverhead {
+			t.Fatal("SizeInBytes", qf.SizeInBytes(), "does not match data length", len(qf.data), "test", test)
+		}
+		if EstimateSizeBytes(test.Q, test.R) != qf.SizeInBytes() {
+			t.Fatal("EstimateSizeBytes", EstimateSizeBytes(test.Q, test.R), "expected", qf.SizeInBytes(), "test", test)
+		}
+	}
+	if size := EstimateSizeBytes(28, 8) - filterOverhead; size != 1<<28*11/8+8 {
+		t.Fatal("q 28 and r 8 take", size, "bytes")
+	}
+}
+
+func TestReset(t *testing.T) {
+	qf := MustNew(10, 16)
+	items := generateItems(500)
+	qf.AddAll(items)
+	qf.Reset()
+	if qf.Len() != 0 {
+		t.Fatal("Len after Reset", qf.Len())
+	}
+	for _, s := range items {
+		if qf.Contains(s) {
+			t.Fatal("Filter returned true after Reset", s)
+		}
+	}
+	fresh := MustNew(10, 16)
+	items = generateItems(500)
+	qf.AddAll(items)
+	fresh.AddAll(items)
+	for i := range qf.data {
+		if qf.data[i] != fresh.data[i] {
+			t.Fatal("Reset filter differs from a fresh one at", i)
+		}
+	}
+}
+
+func TestClone(t *testing.T) {
+	empty := MustNew(8, 16)
+	clone := empty.Clone()
+	clone.Add("fox")
+	if empty.Contains("fox") || empty.Len() != 0 || !clone.Contains("fox") {
+		t.Fatal("Adding to a clone of an empty filter changed the original")
+	}
+
+	qf := newFull(8, 16)
+	items := generateItems(250)
+	qf.AddAll(items)
+	clone = qf.Clone()
+	if clone.h == qf.h {
+		t.Fatal("Clone shares the hash function state")
+	}
+	more := generateItems(6)
+	qf.AddAll(more[:3])
+	clone.AddAll(more[3:])
+	clone.Delete(items[0])
+	if !qf.Contains(items[0]) || clone.Contains(items[0]) {
+		t.Fatal("Delete on the clone affected the original")
+	}
+	for i, s := range more {
+		if qf.Contains(s) != (i < 3) || clone.Contains(s) != (i >= 3) {
+			t.Fatal("Clone and original did not diverge", s)
+		}
+	}
+	if qf.Len() != 253 || clone.Len() != 252 {
+		t.Fatal("Unexpected lengths", qf.Len(), clone.Len())
+	}
+	for _, s := range items[1:] {
+		if !qf.Contains(s) || !clone.Contains(s) {
+			t.Fatal("Filter returned false for an added item", s)
+		}
+	}
+}
+
+func TestNewFromBuffer(t *testing.T) {
+	for _, params := range [][2]uint8{{8, 13}, {10, 5}, {3, 61}, {6, 58}} {
+		q, r := params[0], params[1]
+		size, err := BufferSize(q, r)
+		if err != nil || uint64(size) != EstimateSizeBytes(q, r)-filterOverhead {
+			t.Fatal(params, "unexpected buffer size", size, err)
+		}
+		// two buffers in one array with guard bytes around them.
+		guard := bytes.Repeat([]byte{0xa5}, 8)
+		backing := bytes.Repeat([]byte{0xff}, 3*8+2*size)
+		copy(backing, guard)
+		copy(backing[8+size:], guard)
+		copy(backing[16+2*size:], guard)
+		a, err := NewFromBuffer(q, r, backing[8:8+size])
+		if err != nil {
+			t.Fatal(params, "unexpected error", err)
+		}
+		b, _ := NewFromBuffer(q, r, backing[16+size:16+2*size])
+		heap := MustNew(q, r)
+		heap.maxLen, a.maxLen, b.maxLen = heap.cap, a.cap, b.cap
+		items := randomItems(int(heap.cap))
+		for _, f := range []*QuotientFilter{a, b, heap} {
+			f.AddAll(items)
+			f.Delete(items[0])
+		}
+		// a key sharing the fingerprint of the deleted one is gone too, membership is compared.
+		if a.Len() != heap.Len() || a.ContainsAll(items[1:]) != heap.ContainsAll(items[1:]) {
+			t.Fatal(params, "buffer filter differs", a.Len(), heap.Len())
+		}
+		if !bytes.Equal(a.data, b.data) || !bytes.Equal(a.data, heap.data) {
+			t.Fatal(params, "buffer contents differ after the same insertions")
+		}
+		for _, off := range []int{0, 8 + size, 16 + 2*size} {
+			if !bytes.Equal(backing[off:off+8], guard) {
+				t.Fatal(params, "write outside of the buffer at", off)
+			}
+		}
+		last := items[len(items)-1]
+		if clone := a.Clone(); &clone.data[0] == &a.data[0] || !bytes.Equal(clone.data, a.data) || clone.Delete(last) && !a.Contains(last) {
+			t.Fatal(params, "clone shares the buffer")
+		}
+		a.Reset()
+		if a.Len() != 0 || !bytes.Equal(a.data, make([]byte, size)) {
+			t.Fatal(params, "Reset did not clear the buffer")
+		}
+	}
+	if _, err := NewFromBuffer(8, 8, make([]byte, 7)); err == nil {
+		t.Fatal("Expected an error for a buffer of the wrong size")
+	}
+	if _, err := BufferSize(1, 8); err == nil {
+		t.Fatal("Expected an error for invalid q")
+	}
+	if _, err := BufferSize(63, 1); err == nil {
+		t.Fatal("Expected an error for a size that can't be addressed")
+	}
+}
+
+func TestContainsOrAdd(t *testing.T) {
+	qf := newFull(4, 16)
+	items := generateItems(20)
+	for i, s := range items[:16] {
+		if existed, err := qf.ContainsOrAdd(s); existed || err != nil {
+			t.Fatal("Unexpected result for new key", i, existed, err)
+		}
+		if existed, err := qf.ContainsOrAdd(s); i < 15 && (!existed || err != nil) {
+			t.Fatal("Unexpected result for added key", i, existed, err)
+		}
+	}
+	if existed, err := qf.ContainsOrAdd(items[0]); !existed || err != ErrFull {
+		t.Fatal("Expected ErrFull for added key in a full filter, got", existed, err)
+	}
+	if existed, err := qf.ContainsOrAdd(items[16]); existed || err != ErrFull {
+		t.Fatal("Expected ErrFull for new key in a full filter, got", existed, err)
+	}
+	for _, s := range items[:16] {
+		if !qf.Contains(s) {
+			t.Fatal("Filter returned false for an added item", s)
+		}
+	}
+}
+
+func TestContainsBatch(t *testing.T) {
+	qf := MustNew(10, 16)
+	added := generateItems(100)
+	not := generateItems(100)
+	qf.AddAll(added)
+	mixed := append(append([]string{}, added[:50]...), not[:50]...)
+
+	if !qf.ContainsAll(added) || qf.ContainsAll(mixed) || qf.ContainsAll(not) {
+		t.Fatal("Unexpected ContainsAll result")
+	}
+	if !qf.ContainsAll(nil) {
+		t.Fatal("ContainsAll should be true for no keys")
+	}
+	if !qf.ContainsAny(added) || !qf.ContainsAny(mixed) || qf.ContainsAny(not) || qf.ContainsAny(nil) {
+		t.Fatal("Unexpected ContainsAny result")
+	}
+	results := qf.ContainsEach(mixed, nil)
+	if len(results) != len(mixed) {
+		t.Fatal("ContainsEach returned", len(results), "results for", len(mixed), "keys")
+	}
+	for i, found := range results {
+		if found != (i < 50) {
+			t.Fatal("Unexpected ContainsEach result", i, found)
+		}
+	}
+
+	// results go to the slice passed in if it has room for them.
+	buf := make([]bool, 3, len(mixed))
+	if out := qf.ContainsEach(mixed, buf); &out[0] != &buf[0] || !slices.Equal(out, results) {
+		t.Fatal("ContainsEach did not reuse the slice with room for the results")
+	}
+	if out := qf.ContainsEach(mixed, buf[:0:10]); &out[0] == &buf[0] || !slices.Equal(out, results) {
+		t.Fatal("ContainsEach wrote past the capacity of the slice")
+	}
+	if out := qf.ContainsEach(nil, buf); len(out) != 0 {
+		t.Fatal("ContainsEach returned", len(out), "results for no keys")
+	}
+	safe, sealed := NewSafe(qf.Clone()), qf.Clone().Seal()
+	for name, each := range map[string]func([]string, []bool) []bool{"filter": qf.ContainsEach, "Safe": safe.ContainsEach, "Sealed": sealed.ContainsEach} {
+		if !slices.Equal(each(mixed, buf), results) {
+			t.Fatal(name, "results differ from ContainsEach")
+		}
+		if n := testing.AllocsPerRun(100, func() { each(mixed, buf) }); n != 0 {
+			t.Fatal(name, "checking keys into a slice with room allocated", n)
+		}
+	}
+}
+
+func TestContainsBatchParallel(t *testing.T) {
+	shared, _ := NewHash(fnv.New64a(), 14, 16)
+	named, _ := NewWithOptions(0, WithQR(14, 16), WithNamedHash("fnv64", nil, func() hash.Hash64 { return fnv.New64() }))
+	transformed, _ := NewWithOptions(0, WithQR(14, 16), WithKeyTransformer("lower", strings.ToLower))
+	if !shared.sharedHasher || !shared.Clone().sharedHasher || named.sharedHasher || newSharedHash(named).hashMu != nil {
+		t.Fatal("Shared hash function not told apart")
+	}
+	keys := append(randomItems(5000), randomItems(5000)...)
+	for name, qf := range map[string]*QuotientFilter{"default": MustNew(14, 16), "NewHash": shared, "named": named, "transformed": transformed} {
+		qf.AddAll(keys[:5000])
+		want := qf.ContainsEach(keys, nil)
+		check := func(name string, got []bool) {
+			t.Helper()
+			if !slices.Equal(got, want) {
+				t.Fatal(name, "results differ from ContainsEach")
+			}
+		}
+		for _, workers := range []int{-1, 0, 1, 3, 16, len(keys) + 1} {
+			check(fmt.Sprint(name, " with ", workers, " workers"), qf.ContainsBatchParallel(keys, workers))
+		}
+		check(name+" snapshot", qf.Snapshot().ContainsBatchParallel(keys, 8))
+		check(name+" sealed", qf.Seal().ContainsBatchParallel(keys, 8))
+	}
+	if out := MustNew(4, 8).ContainsBatchParallel(nil, 8); len(out) != 0 {
+		t.Fatal("Results for no keys", out)
+	}
+}
+
+func TestConcurrentContains(t *testing.T) {
+	named, _ := NewWithOptions(0, WithQR(14, 20), WithNamedHash("fnv64", nil, func() hash.Hash64 { return fnv.New64() }))
+	transformed, _ := NewWithOptions(0, WithQR(14, 20), WithKeyTransformer("lower", strings.ToLower))
+	for name, qf := range map[string]*QuotientFilter{"default": MustNew(14, 20), "named": named, "transformed": transformed} {
+		items := randomItems(5000)
+		qf.AddAll(items)
+		for i := 0; i < 100; i++ {
+			qf.AddUint64(uint64(i))
+			qf.Add16([16]byte{byte(i)})
+			qf.AddNS("ns", items[i])
+		}
+		var wg sync.WaitGroup
+		for g := 0; g < 16; g++ {
+			wg.Add(1)
+			go func(g int) {
+				defer wg.Done()
+				for i := g; i < len(items); i += 4 {
+					key := items[i]
+					r, _ := qf.ContainsReader(strings.NewReader(key))
+					if !r || !qf.Contains(key) || !qf.ContainsBytes([]byte(key)) || !qf.ContainsKey(qf.NewKey(key)) {
+						t.Error(name, "missing", key)
+						return
+					}
+					if i < 100 && (!qf.ContainsUint64(uint64(i)) || !qf.Contains16([16]byte{byte(i)}) || !qf.ContainsNS("ns", key)) {
+						t.Error(name, "missing fixed size or namespaced key", i)
+						return
+					}
+				}
+			}(g)
+		}
+		wg.Wait()
+	}
+}
+
+func TestAddAll(t *testing.T) {
+	qf := newFull(5, 16)
+	items := generateItems(40)
+	if n, err := qf.AddAll(items[:20]); n != 20 || err != nil {
+		t.Fatal("Unexpected AddAll result", n, err)
+	}
+	if n, err := qf.AddAll(items[:20]); n != 0 || err != nil {
+		t.Fatal("Expected no insertions for duplicates, got", n, err)
+	}
+	// duplicates mixed in the batch are skipped and don't count towards the insertions.
+	batch := append(append([]string{}, items[:10]...), items[20:]...)
+	n, err := qf.AddAll(batch)
+	var berr *BatchError
+	if !errors.As(err, &berr) || !errors.Is(err, ErrFull) {
+		t.Fatal("Expected a BatchError wrapping ErrFull, got", err)
+	}
+	if n != 12 || berr.Index != 22 || qf.Len() != qf.Cap() {
+		t.Fatal("Unexpected AddAll result", n, berr.Index, qf.Len())
+	}
+	for _, s := range batch[:berr.Index] {
+		if !qf.Contains(s) {
+			t.Fatal("Filter returned false for a processed item", s)
+		}
+	}
+	if n, err := qf.AddAll(items[:32]); n != 0 || err != nil {
+		t.Fatal("Duplicates should not fail on a full filter, got", n, err)
+	}
+}
+
+func TestAddAllBulk(t *testing.T) {
+	items := randomItems(3000)
+	// a batch with duplicates, in random order and sorted by fingerprint.
+	batch := append(slices.Clone(items[:2000]), items[500:1000]...)
+	rand.Shuffle(len(batch), func(i, j int) { batch[i], batch[j] = batch[j], batch[i] })
+	sorted := slices.Clone(batch)
+	qf := MustNew(12, 8)
+	slices.SortFunc(sorted, func(a, b string) int { return cmp.Compare(qf.Fingerprint64(a), qf.Fingerprint64(b)) })
+	tests := []struct {
+		Name string
+		Opts []Option
+	}{
+		{"default", []Option{WithQR(12, 8)}},
+		{"stash", []Option{WithQR(12, 8), WithStash(8, 4)}},
+		{"small", []Option{WithQR(5, 8), WithMaxLoadFactor(1)}},
+		{"cache line blocks", []Option{WithQR(12, 8), WithCacheLineBlocks()}},
+		{"aligned slots", []Option{WithQR(12, 5), WithAlignedSlots()}},
+	}
+	for _, test := range tests {
+		one, _ := NewWithOptions(0, test.Opts...)
+		bulk, _ := NewWithOptions(0, test.Opts...)
+		fromSorted, _ := NewWithOptions(0, test.Opts...)
+		// the filters already hold some of the keys.
+		for _, qf := range []*QuotientFilter{one, bulk, fromSorted} {
+			qf.AddAll(items[1500:1510])
+		}
+		n, err := one.AddAll(batch)
+		nb, errb := bulk.AddAllBulk(batch)
+		ns, errs := fromSorted.AddAllBulk(sorted)
+		var berr, berrb *BatchError
+		if n != nb || (err == nil) != (errb == nil) || errors.As(err, &berr) && (!errors.As(errb, &berrb) || berr.Index != berrb.Index) {
+			t.Fatal(test.Name, "AddAll inserted", n, err, "AddAllBulk", nb, errb)
+		}
+		if !slices.Equal(bulk.Fingerprints(), one.Fingerprints()) {
+			t.Fatal(test.Name, "AddAllBulk added other fingerprints than AddAll")
+		}
+		if err != nil {
+			// the keys that fit depend on their order.
+			continue
+		}
+		if ns != n || errs != nil || !slices.Equal(fromSorted.Fingerprints(), one.Fingerprints()) {
+			t.Fatal(test.Name, "the sorted keys inserted", ns, errs, "AddAll", n)
+		}
+		if bulk.Counters() != one.Counters() {
+			t.Fatal(test.Name, "counters differ", bulk.Counters(), one.Counters())
+		}
+		if len(one.stash)+len(bulk.stash) == 0 && (!bytes.Equal(bulk.data, one.data) || !bytes.Equal(fromSorted.data, one.data)) {
+			t.Fatal(test.Name, "AddAllBulk laid out another table than AddAll")
+		}
+		checkOffsets(t, test.Name, bulk)
+		if n, err := bulk.AddAllBulk(batch); n != 0 || err != nil {
+			t.Fatal(test.Name, "adding the batch again inserted", n, err)
+		}
+	}
+
+	// a full filter stops at the same key as AddAll.
+	full := newFull(5, 16)
+	full.AddAllBulk(items[:20])
+	n, err := full.AddAllBulk(append(slices.Clone(items[:10]), items[20:]...))
+	var berr *BatchError
+	if !errors.As(err, &berr) || !errors.Is(err, ErrFull) || n != 12 || berr.Index != 22 || full.Len() != full.Cap() {
+		t.Fatal("Unexpected AddAllBulk result on a full filter", n, err)
+	}
+	if n, err := newFull(5, 16).AddAllBulk(nil); n != 0 || err != nil {
+		t.Fatal("Unexpected AddAllBulk result for no keys", n, err)
+	}
+}
+
+func TestAddAllContext(t *testing.T) {
+	qf := MustNew(16, 20)
+	items := generateItems(20000)
+	ctx, cancel := context.WithCancel(context.Background())
+	var calls, done int
+	n, err := qf.AddAllContext(ctx, items, func(d, total int) {
+		if total != len(items) || d <= done {
+			t.Fatal("Unexpected progress", d, total)
+		}
+		calls++
+		done = d
+		if d >= 10000 {
+			cancel()
+		}
+	})
+	if err != context.Canceled || done != 3*batchCheckInterval || calls != 3 {
+		t.Fatal("Expected cancellation at the third check, got", err, done, calls)
+	}
+	if n > done || uint64(n) != qf.Len() {
+		t.Fatal("Unexpected inserted count", n, qf.Len())
+	}
+	for _, s := range items[:done] {
+		if !qf.Contains(s) {
+			t.Fatal("Processed key missing after cancellation", s)
+		}
+	}
+	if _, err := qf.AddAllContext(context.Background(), items, nil); err != nil || !qf.ContainsAll(items) {
+		t.Fatal("Unexpected error resuming", err)
+	}
+	// a context cancelled before the call adds nothing.
+	fresh := MustNew(16, 20)
+	if n, err := fresh.AddAllContext(ctx, items, func(int, int) { t.Fatal("Progress of a cancelled context") }); n != 0 || err != context.Canceled || fresh.Len() != 0 {
+		t.Fatal("Unexpected result with a cancelled context", n, err, fresh.Len())
+	}
+	full := newFull(4, 8)
+	if _, err := full.AddAllContext(context.Background(), items, nil); !errors.Is(err, ErrFull) {
+		t.Fatal("Expected ErrFull, got", err)
+	}
+}
+
+func TestMaxLoadFactor(t *testing.T) {
+	for _, f := range []float64{0.25, 0.5, 0.75, 0.9, 1} {
+		qf, err := NewWithOptions(0, WithQR(8, 16), WithMaxLoadFactor(f))
+		if err != nil {
+			t.Fatal("Unexpected error", err)
+		}
+		items := generateItems(300)
+		n, err := qf.AddAll(items)
+		if !errors.Is(err, ErrFull) || uint64(n) != uint64(f*256) || qf.Len() != uint64(n) {
+			t.Fatal("Expected inserts to stop at", f*256, "got", n, err)
+		}
+		if !qf.ContainsAll(items[:n]) {
+			t.Fatal("False negative at max load factor", f)
+		}
+		if qf.MaxLoadFactor() != f {
+			t.Fatal("Unexpected max load factor", qf.MaxLoadFactor())
+		}
+	}
+	// probability based sizing leaves room for capacity keys under the limit.
+	for _, f := range []float64{0.1, 0.3, 0.9} {
+		qf, _ := NewWithOptions(1000, WithMaxLoadFactor(f))
+		items := generateItems(1000)
+		if _, err := qf.AddAll(items); err != nil || !qf.ContainsAll(items) {
+			t.Fatal("Capacity keys did not fit with max load factor", f, err)
+		}
+	}
+}
+
+func TestContainsWithConfidence(t *testing.T) {
+	qf := MustNew(10, 4)
+	items := generateItems(900)
+	for i := 0; i < 900; i += 300 {
+		qf.AddAll(items[i : i+300])
+		a := float64(qf.Len()) / 1024
+		found, confidence := qf.ContainsWithConfidence(items[i])
+		if !found || confidence != 1-qf.FPProbability() || math.Abs(confidence-math.Exp(-a/16)) > 1e-9 {
+			t.Fatal("Unexpected confidence", found, confidence, "at fill rate", a)
+		}
+		// the confidence drops as the filter fills.
+		if i > 0 && confidence >= math.Exp(-float64(i)/1024/16) {
+			t.Fatal("Confidence did not drop", confidence)
+		}
+		for j := 0; ; j++ {
+			if found, confidence := qf.ContainsWithConfidence(fmt.Sprint("probe:", j)); !found {
+				if confidence != 1 {
+					t.Fatal("Expected confidence 1 for a negative, got", confidence)
+				}
+				break
+			}
+		}
+	}
+}
+
+func TestRemainingCapacity(t *testing.T) {
+	for _, opts := range [][]Option{{WithQR(8, 20)}, {WithQR(8, 20), WithStash(4, 2)}, {WithQR(8, 20), WithMaxLoadFactor(0.5)}} {
+		qf, _ := NewWithOptions(0, opts...)
+		items := generateItems(100)
+		qf.AddAll(items)
+		// deleted keys free their slots.
+		qf.DeleteAll(items[:30])
+		n := qf.RemainingCapacity()
+		if !qf.WillFit(int(n)) || qf.WillFit(int(n)+1) {
+			t.Fatal("WillFit disagrees with RemainingCapacity", n)
+		}
+		// insert exactly n new fingerprints.
+		for i := 0; uint64(i) < n; {
+			existed, err := qf.ContainsOrAdd(fmt.Sprintf("fit:%d", rand.Int63()))
+			if err != nil {
+				t.Fatal("Unexpected error with", n-uint64(i), "remaining", err)
+			}
+			if !existed {
+				i++
+			}
+		}
+		if qf.RemainingCapacity() != 0 || qf.WillFit(1) || !qf.WillFit(0) {
+			t.Fatal("Expected no remaining capacity", qf.RemainingCapacity())
+		}
+		for _, s := range generateItems(100) {
+			if existed, err := qf.ContainsOrAdd(s); !existed && err != ErrFull {
+				t.Fatal("Expected ErrFull after filling the remaining capacity", err)
+			}
+		}
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	qf := MustNew(10, 16)
+	items := generateItems(500)
+	qf.AddAll(items)
+	for _, s := range items {
+		q, r := qf.Fingerprint(s)
+		if q >= qf.Cap() || r > qf.rMask {
+			t.Fatal("Fingerprint out of range", q, r)
+		}
+		if !qf.getSlot(q).isOccupied() || !qf.contains(q, r) {
+			t.Fatal("Added key not found under its fingerprint", s, q, r)
+		}
+		if qf.Fingerprint64(s) != q<<16|r || qf.Fingerprint64(s) != qf.hash([]byte(s))&maskLower(26) {
+			t.Fatal("Unexpected combined fingerprint", qf.Fingerprint64(s))
+		}
+	}
+}
+
+func TestKey(t *testing.T) {
+	filters := []*QuotientFilter{MustNew(10, 16), MustNew(12, 8), mustNew(NewHash(fnv.New64(), 10, 16))}
+	items := generateItems(300)
+	keys := make([]Key, len(items))
+	for i, s := range items {
+		keys[i] = filters[0].NewKey(s)
+		if keys[i].String() != s {
+			t.Fatal("Key does not match the string", keys[i].String(), s)
+		}
+	}
+	for _, qf := range filters {
+		for _, k := range keys[:200] {
+			qf.AddKey(k)
+		}
+		for i, s := range items {
+			if qf.Contains(s) != (i < 200) || qf.ContainsKey(keys[i]) != (i < 200) {
+				t.Fatal("Unexpected membership for key", s, "params", qf.Params())
+			}
+		}
+	}
+}
+
+func TestReader(t *testing.T) {
+	qf := MustNew(10, 16)
+	content := make([]byte, 1<<20)
+	rand.Read(content)
+	path := filepath.Join(t.TempDir(), "key")
+	if err := os.WriteFile(path, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := qf.AddReader(f); err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	if !qf.ContainsBytes(content) {
+		t.Fatal("Content added with AddReader not found with ContainsBytes")
+	}
+	other := content[:1000]
+	qf.AddBytes(other)
+	if found, err := qf.ContainsReader(bytes.NewReader(other)); !found || err != nil {
+		t.Fatal("Content added with AddBytes not found with ContainsReader", found, err)
+	}
+
+	failing := io.MultiReader(bytes.NewReader([]byte("partial")), iotest.ErrReader(io.ErrUnexpectedEOF))
+	if err := qf.AddReader(failing); err != io.ErrUnexpectedEOF {
+		t.Fatal("Expected the reader error, got", err)
+	}
+	if qf.Len() != 2 {
+		t.Fatal("Failed AddReader should not add anything, len", qf.Len())
+	}
+	// the partial content must not leak into the hash of the next key.
+	if found, err := qf.ContainsReader(bytes.NewReader(other)); !found || err != nil {
+		t.Fatal("Hash state was not reset after a reader error", found, err)
+	}
+}
+
+func TestDelete(t *testing.T) {
+	qf := MustNew(10, 16)
+	added := generateItems(100)
+	qf.AddAll(added)
+	for i, s := range added {
+		if i%2 == 0 && !qf.Delete(s) {
+			t.Fatal("Delete returned false for an added item", s)
+		}
+	}
+	for i, s := range added {
+		if i%2 == 1 && !qf.Contains(s) {
+			t.Fatal("False negative after delete, key:", s)
+		}
+	}
+	if qf.Delete("turbo") {
+		t.Fatal("Delete returned true for not added item")
+	}
+}
+
+func TestDeleteAll(t *testing.T) {
+	for round := 0; round < 20; round++ {
+		qf := MustNew(10, 30)
+		items := generateItems(900)
+		qf.AddAll(items)
+		rand.Shuffle(len(items), func(i, j int) { items[i], items[j] = items[j], items[i] })
+		batch := append(append([]string{}, items[:450]...), generateItems(50)...)
+		if n, err := qf.DeleteAll(batch); n != 450 || err != nil {
+			t.Fatal("Unexpected DeleteAll result", n, err)
+		}
+		if qf.Len() != 450 {
+			t.Fatal("Unexpected len after DeleteAll", qf.Len())
+		}
+		if qf.ContainsAny(items[:450]) {
+			t.Fatal("Filter returned true for a deleted item")
+		}
+		if !qf.ContainsAll(items[450:]) {
+			t.Fatal("False negative after DeleteAll")
+		}
+	}
+}
+
+func TestDeleteWrapAround(t *testing.T) {
+	qf := MustNew(4, 6)
+	// a cluster that starts at the last slot and wraps to the beginning of the table.
+	fps := [][2]uint64{{15, 1}, {15, 2}, {15, 3}, {0, 4}, {0, 5}, {1, 6}, {14, 7}}
+	for _, fp := range fps {
+		qf.insert(fp[0], fp[1])
+	}
+	for i, fp := range fps {
+		if !qf.remove(fp[0], fp[1]) {
+			t.Fatal("remove returned false for", fp)
+		}
+		want := map[[2]uint64]bool{}
+		for _, rest := range fps[i+1:] {
+			want[rest] = true
+		}
+		checkContents(t, qf, want)
+	}
+	if qf.len != 0 {
+		t.Fatal("Filter not empty after deleting everything, len", qf.len)
+	}
+}
+
+func TestDeleteRandomized(t *testing.T) {
+	for round := 0; round < 200; round++ {
+		q := uint8(3 + rand.Intn(5))
+		qf := MustNew(q, 4)
+		want := map[[2]uint64]bool{}
+		for ops := 0; ops < 1000; ops++ {
+			fp := [2]uint64{uint64(rand.Intn(1 << q)), uint64(rand.Intn(16))}
+			if rand.Intn(3) > 0 {
+				if _, err := qf.insert(fp[0], fp[1]); err == nil {
+					want[fp] = true
+				}
+			} else if qf.remove(fp[0], fp[1]) != want[fp] {
+				t.Fatal("remove returned", !want[fp], "for", fp)
+			} else {
+				delete(want, fp)
+			}
+			checkContents(t, qf, want)
+		}
+	}
+}
+
+func TestStash(t *testing.T) {
+	qf, err := NewWithOptions(0, WithQR(6, 20), WithStash(4, 0))
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	items := generateItems(100)
+	n, err := qf.AddAll(items)
+	if !errors.Is(err, ErrFull) {
+		t.Fatal("Expected ErrFull, got", err)
+	}
+	// with a probe limit of 0 the first shifted insertions go to the stash.
+	if st := qf.Stats(); st.StashLen != 4 || st.StashCap != 4 || st.Len != uint64(n) || qf.len != qf.maxLen {
+		t.Fatal("Unexpected stats when full", st, n)
+	}
+	// the stashed fingerprints take no slots.
+	if qf.LoadFactor() != float64(qf.maxLen)/float64(qf.cap) {
+		t.Fatal("Unexpected load factor with a stash", qf.LoadFactor())
+	}
+	for _, s := range items[:n] {
+		if !qf.Contains(s) {
+			t.Fatal("False negative for", s)
+		}
+	}
+	for _, s := range items[:n] {
+		if q, r := qf.Fingerprint(s); qf.stashIndex(q, r) >= 0 && !qf.Delete(s) {
+			t.Fatal("Delete returned false for a stashed key", s)
+		}
+	}
+	if st := qf.Stats(); st.StashLen != 0 || st.Len != qf.maxLen {
+		t.Fatal("Unexpected stats after deleting the stash", st)
+	}
+	if _, err := NewWithOptions(100, WithStash(0, 4)); err == nil {
+		t.Fatal("Expected an error for an empty stash")
+	}
+}
+
+func TestStashRandomized(t *testing.T) {
+	for round := 0; round < 100; round++ {
+		qf, _ := NewWithOptions(0, WithQR(4, 4), WithMaxLoadFactor(1), WithStash(3, 1))
+		want := map[[2]uint64]bool{}
+		for ops := 0; ops < 500; ops++ {
+			fp := [2]uint64{uint64(rand.Intn(16)), uint64(rand.Intn(16))}
+			if rand.Intn(3) > 0 {
+				existed, err := qf.insert(fp[0], fp[1])
+				if err != nil && (len(qf.stash) < 3 || qf.len < qf.maxLen) {
+					t.Fatal("Unexpected error with room left", err)
+				} else if err == nil && existed != want[fp] {
+					t.Fatal("insert returned", existed, "for", fp)
+				} else if err == nil {
+					want[fp] = true
+				}
+			} else if (qf.remove(fp[0], fp[1]) || qf.unstash(fp[0], fp[1])) != want[fp] {
+				t.Fatal("remove returned", !want[fp], "for", fp)
+			} else {
+				delete(want, fp)
+			}
+			table := map[[2]uint64]bool{}
+			for fp := range want {
+				if qf.stashIndex(fp[0], fp[1]) < 0 {
+					table[fp] = true
+				}
+			}
+			if qf.Len() != uint64(len(want)) || len(want)-len(table) != len(qf.stash) {
+				t.Fatal("Unexpected len", qf.Len(), "stash", len(qf.stash), "expected", len(want))
+			}
+			checkContents(t, qf, table)
+		}
+	}
+}
+
+// checkContents verifies that the filter holds exactly the wanted fingerprints.
+func checkContents(t *testing.T, qf *QuotientFilter, want map[[2]uint64]bool) {
+	t.Helper()
+	if qf.len != uint64(len(want)) {
+		qf.info()
+		t.Fatal("Filter len", qf.len, "expected", len(want))
+	}
+	for fp := range want {
+		if !qf.contains(fp[0], fp[1]) {
+			qf.info()
+			t.Fatal("False negative, fingerprint:", fp)
+		}
+	}
+	// walk the table from a cluster start and decode every stored fingerprint.
+	start := uint64(0)
+	for start < qf.cap && !qf.getSlot(start).isClusterStart() {
+		start++
+	}
+	var found int
+	quotient := start
+	for i := uint64(0); i < qf.cap && len(want) > 0; i++ {
+		index := (start + i) & qf.qMask
+		s := qf.getSlot(index)
+		if s.isEmpty() {
+			continue
+		}
+		if s.isClusterStart() {
+			quotient = index
+		} else if !s.isContinuation() {
+			for quotient = qf.next(quotient); !qf.getSlot(quotient).isOccupied(); quotient = qf.next(quotient) {
+			}
+		}
+		if !want[[2]uint64{quotient, s.remainder()}] {
+			qf.info()
+			t.Fatal("Unexpected fingerprint", quotient, s.remainder(), "at slot", index)
+		}
+		found++
+	}
+	if found != len(want) {
+		qf.info()
+		t.Fatal("Found", found, "fingerprints, expected", len(want))
+	}
+}
+
+// newFull returns a filter that can be filled up to its last slot.
+func newFull(q, r uint8) *QuotientFilter {
+	return mustNew(NewWithOptions(0, WithQR(q, r), WithMaxLoadFactor(1)))
+}
+
+// BenchmarkAddAllBulk loads ten million keys into an empty filter with AddAll and
+// AddAllBulk, reporting the time per key.
+func BenchmarkAddAllBulk(b *testing.B) {
+	const n = 10_000_000
+	items := randomItems(n)
+	qf, _ := NewProbability(n, 0.01)
+	for name, add := range map[string]func([]string) (int, error){"AddAll": qf.AddAll, "AddAllBulk": qf.AddAllBulk} {
+		b.Run(name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				qf.Reset()
+				b.StartTimer()
+				if _, err := add(items); err != nil {
+					b.Fatal("Unexpected error", err)
+				}
+			}
+			b.ReportMetric(float64(b.Elapsed().Nanoseconds())/float64(b.N)/n, "ns/key")
+		})
+	}
+}
+
+func BenchmarkAdd(b *testing.B) {
+	qf, _ := NewProbability(b.N*2, 0.01)
+	items := generateItems(b.N)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		qf.Add(items[i])
+	}
+	b.StopTimer()
+}
+
+func BenchmarkContains(b *testing.B) {
+	qf, _ := NewProbability(b.N*2, 0.01)
+	items := generateItems(b.N)
+	for i := 0; i < b.N; i++ {
+		if i%2 == 0 {
+			qf.Add(items[i])
+		}
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		qf.Contains(items[i])
+	}
+	b.StopTimer()
+}
+
+// BenchmarkContainsLongKeys looks up string keys too long to be converted to []byte on the
+// stack, they are hashed without copying.
+func BenchmarkContainsLongKeys(b *testing.B) {
+	qf, _ := NewWithOptions(1 << 16)
+	items := urlKeys(1 << 16)
+	qf.AddAll(items[:1<<15])
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		qf.Contains(items[i&(1<<16-1)])
+	}
+}
+
+// BenchmarkContainsParallel looks keys up from all CPUs, with the inline built-in hash and
+// with pooled hash functions. Both should not allocate, the keys are []byte to leave out
+// the conversion of string keys.
+func BenchmarkContainsParallel(b *testing.B) {
+	named, _ := NewWithOptions(1<<20, WithNamedHash("fnv64", nil, func() hash.Hash64 { return fnv.New64() }))
+	for _, c := range []struct {
+		name string
+		qf   *QuotientFilter
+	}{{"inline", mustNew(NewWithOptions(1 << 20))}, {"pooled", named}} {
+		b.Run(c.name, func(b *testing.B) {
+			items := generateByteItems(1 << 16)
+			for _, k := range items[:1<<15] {
+				c.qf.AddBytes(k)
+			}
+			var next atomic.Uint64
+			b.ReportAllocs()
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					c.qf.ContainsBytes(items[next.Add(1)&(1<<16-1)])
+				}
+			})
+		})
+	}
+}
+
+// BenchmarkHashFunc looks keys up in filters with the same FNV-64a hash, as a hash.Hash64
+// from the pool and as a one-shot hash function called directly.
+func BenchmarkHashFunc(b *testing.B) {
+	for _, c := range []struct {
+		name string
+		opt  Option
+	}{
+		{"Hash64", WithHash(func() hash.Hash64 { return fnv.New64a() })},
+		{"HashFunc", WithHashFunc(func(b []byte) uint64 { return fnv64a(fnvOffset64, b) })},
+	} {
+		b.Run(c.name, func(b *testing.B) {
+			qf, _ := NewWithOptions(1<<20, c.opt)
+			items := generateByteItems(1 << 16)
+			for _, k := range items[:1<<15] {
+				qf.AddBytes(k)
+			}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				qf.ContainsBytes(items[i&(1<<16-1)])
+			}
+		})
+	}
+}
+
+// BenchmarkHashBytes hashes keys with the pooled hashers of a custom hash function.
+func BenchmarkHashBytes(b *testing.B) {
+	qf, _ := NewWithOptions(1<<10, WithHash(func() hash.Hash64 { return fnv.New64a() }))
+	items := generateByteItems(1 << 10)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		qf.hashBytes(items[i&(1<<10-1)])
+	}
+}
+
+// BenchmarkAddParallel adds keys from all CPUs to the wrappers for concurrent writers.
+func BenchmarkAddParallel(b *testing.B) {
+	b.Run("Safe", func(b *testing.B) {
+		benchmarkParallelAdd(b, NewSafe(mustNew(NewWithOptions(1<<21))).Add)
+	})
+	b.Run("Striped", func(b *testing.B) {
+		s, _ := NewStriped(mustNew(NewWithOptions(1<<21)), 1024)
+		benchmarkParallelAdd(b, s.Add)
+	})
+	b.Run("Sharded", func(b *testing.B) {
+		s, _ := NewSharded(64, 1<<21)
+		benchmarkParallelAdd(b, s.Add)
+	})
+}
+
+// sink keeps benchmark results alive.
+var sink uint64
+
+// BenchmarkFindRun looks up keys in filters at high load, where the lookups spend their time
+// finding runs in long clusters.
+func BenchmarkFindRun(b *testing.B) {
+	for _, load := range []int{70, 85} {
+		b.Run(fmt.Sprint(load), func(b *testing.B) {
+			qf := newFull(16, 9)
+			items := randomItems(int(qf.cap) * load / 100)
+			qf.AddAll(items)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				qf.Contains(items[i%len(items)])
+			}
+		})
+	}
+}
+
+// BenchmarkAddHighLoad adds keys to a filter at 85% load, where the new fingerprints shift long
+// clusters. The filter is put back to 85% whenever the keys run out.
+func BenchmarkAddHighLoad(b *testing.B) {
+	qf := newFull(16, 9)
+	qf.AddAll(randomItems(int(qf.cap) * 85 / 100))
+	loaded := qf.Clone()
+	items := randomItems(int(qf.cap) / 100)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if i%len(items) == 0 && i > 0 {
+			b.StopTimer()
+			copy(qf.data, loaded.data)
+			qf.len = loaded.len
+			b.StartTimer()
+		}
+		qf.Add(items[i%len(items)])
+	}
+}
+
+// sweepLoads are the loads in percent BenchmarkLoadSweep and TestFalsePositivesAtLoad fill
+// filters to.
+var sweepLoads = []int{10, 25, 50, 75, 85, 90, 95}
+
+// BenchmarkLoadSweep adds, looks up and deletes keys in a filter of 2^16 slots at each of
+// sweepLoads, and reports the bits of the table per key at the load. Adds and deletes
+// change batches of 1% of the slots, which are deleted or added back outside of the timer,
+// so that the load stays within a percent. The keys are the same in every run, so that the
+// results of two commits can be compared with benchstat.
+func BenchmarkLoadSweep(b *testing.B) {
+	for _, load := range sweepLoads {
+		rnd := rand.New(rand.NewSource(int64(load)))
+		keys := func(n int) []string {
+			out := make([]string, n)
+			for i := range out {
+				out[i] = strconv.FormatUint(rnd.Uint64(), 36)
+			}
+			return out
+		}
+		qf := newFull(16, 9)
+		items := keys(int(qf.cap) * load / 100)
+		qf.AddAll(items)
+		missing, batch := keys(len(items)), keys(int(qf.cap)/100)
+		bits := float64(len(qf.data)*8) / float64(qf.Len())
+		run := func(name string, op func(i int)) {
+			b.Run(fmt.Sprint(name, "/", load), func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					op(i)
+				}
+				b.ReportMetric(bits, "bits/entry")
+			})
+		}
+		// batched runs op on the keys of batch in turn and undo on all of them after each pass,
+		// with the timer stopped.
+		batched := func(b *testing.B, op, undo func(string)) {
+			for i := 0; i < b.N; i++ {
+				if i%len(batch) == 0 && i > 0 {
+					b.StopTimer()
+					for _, k := range batch {
+						undo(k)
+					}
+					b.StartTimer()
+				}
+				op(batch[i%len(batch)])
+			}
+			b.StopTimer()
+			for _, k := range batch[:(b.N-1)%len(batch)+1] {
+				undo(k)
+			}
+			b.ReportMetric(bits, "bits/entry")
+		}
+		add, del := func(k string) { qf.Add(k) }, func(k string) { qf.Delete(k) }
+		b.Run(fmt.Sprint("Add/", load), func(b *testing.B) { batched(b, add, del) })
+		run("ContainsHit", func(i int) { qf.Contains(items[i%len(items)]) })
+		run("ContainsMiss", func(i int) { qf.Contains(missing[i%len(missing)]) })
+		b.Run(fmt.Sprint("Delete/", load), func(b *testing.B) {
+			qf.AddAll(batch)
+			batched(b, del, add)
+			qf.DeleteAll(batch)
+		})
+	}
+}
+
+// TestFalsePositivesAtLoad checks the false positive rate of random keys against
+// FPProbability at each of sweepLoads, with a tolerance of 20% over the bound.
+func TestFalsePositivesAtLoad(t *testing.T) {
+	if testing.Short() {
+		t.Skip("looks up millions of keys")
+	}
+	const probes = 1 << 20
+	for _, load := range sweepLoads {
+		rnd := rand.New(rand.NewSource(int64(load)))
+		qf := newFull(16, 8)
+		for qf.Len() < qf.cap*uint64(load)/100 {
+			qf.Add(strconv.FormatUint(rnd.Uint64(), 36))
+		}
+		positives := 0
+		for range probes {
+			// the probes end in a dash, unlike the keys added.
+			if qf.Contains(strconv.FormatUint(rnd.Uint64()>>1, 36) + "-") {
+				positives++
+			}
+		}
+		rate, bound := float64(positives)/probes, qf.FPProbability()
+		t.Logf("load %d%%: false positive rate %.5f, bound %.5f", load, rate, bound)
+		if rate > bound*1.2 {
+			t.Fatalf("False positive rate %.5f at load %d%% exceeds the bound %.5f", rate, load, bound)
+		}
+	}
+}
+
+// BenchmarkSparse adds keys to and lists the fingerprints of a large filter at 5% load, where
+// most blocks of the table are empty.
+func BenchmarkSparse(b *testing.B) {
+	qf := MustNew(22, 9)
+	items := randomItems(int(qf.cap) / 20)
+	b.Run("Add", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if i%len(items) == 0 {
+				qf.Reset()
+			}
+			qf.Add(items[i%len(items)])
+		}
+	})
+	qf.Reset()
+	qf.AddAll(items)
+	b.Run("Fingerprints", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			qf.Fingerprints()
+		}
+	})
+}
+
+func BenchmarkGetSlot(b *testing.B) {
+	qf := MustNew(16, 9)
+	for i := uint64(0); i < qf.cap; i++ {
+		qf.setSlot(i, slot(rand.Uint64()))
+	}
+	var s slot
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s ^= qf.getSlot(uint64(i) & qf.qMask)
+	}
+	sink = uint64(s)
+}
+
+func BenchmarkSetSlot(b *testing.B) {
+	qf := MustNew(16, 9)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		qf.setSlot(uint64(i)&qf.qMask, slot(i))
+	}
+}
+
+func BenchmarkAddBytes(b *testing.B) {
+	qf, _ := NewProbability(b.N*2, 0.01)
+	items := generateByteItems(b.N)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		qf.AddBytes(items[i])
+	}
+	b.StopTimer()
+}
+
+func BenchmarkContainsBytes(b *testing.B) {
+	qf, _ := NewProbability(b.N*2, 0.01)
+	items := generateByteItems(b.N)
+	for i := 0; i < b.N; i++ {
+		if i%2 == 0 {
+			qf.AddBytes(items[i])
+		}
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		qf.ContainsBytes(items[i])
+	}
+	b.StopTimer()
+}
+
+func BenchmarkAddUint64(b *testing.B) {
+	qf, _ := NewProbability(b.N*2, 0.01)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		qf.AddUint64(uint64(i))
+	}
+	b.StopTimer()
+}
+
+func BenchmarkAddUint64String(b *testing.B) {
+	qf, _ := NewProbability(b.N*2, 0.01)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		qf.Add(strconv.FormatUint(uint64(i), 10))
+	}
+	b.StopTimer()
+}
+
+func BenchmarkContainsOrAdd(b *testing.B) {
+	qf, _ := NewProbability(b.N, 0.01)
+	items := generateItems(b.N)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		qf.ContainsOrAdd(items[i])
+	}
+	b.StopTimer()
+}
+
+func BenchmarkContainsThenAdd(b *testing.B) {
+	qf, _ := NewProbability(b.N, 0.01)
+	items := generateItems(b.N)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if !qf.Contains(items[i]) {
+			qf.Add(items[i])
+		}
+	}
+	b.StopTimer()
+}
+
+func BenchmarkContainsEach(b *testing.B) {
+	qf, _ := NewProbability(b.N*2, 0.01)
+	items := generateItems(b.N)
+	for i := 0; i < b.N; i += 2 {
+		qf.Add(items[i])
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	qf.ContainsEach(items, nil)
+	b.StopTimer()
+}
+
+// BenchmarkContainsBatchParallel checks b.N keys in one batch, the time per key should drop
+// with the number of workers up to the number of CPUs.
+func BenchmarkContainsBatchParallel(b *testing.B) {
+	for _, workers := range []int{1, 4, 16} {
+		b.Run(fmt.Sprint("workers=", workers), func(b *testing.B) {
+			qf, _ := NewProbability(b.N*2, 0.01)
+			items := generateItems(b.N)
+			for i := 0; i < b.N; i += 2 {
+				qf.Add(items[i])
+			}
+			b.ReportAllocs()
+			b.ResetTimer()
+			qf.ContainsBatchParallel(items, workers)
+		})
+	}
+}
+
+// BenchmarkContainsBatch checks batches of a million keys, half of them present, with
+// ContainsEach into the same slice and with a loop of Contains, reporting the time per key.
+func BenchmarkContainsBatch(b *testing.B) {
+	const n = 1 << 20
+	qf, _ := NewProbability(n, 0.01)
+	items := generateItems(n)
+	for i := 0; i < n; i += 2 {
+		qf.Add(items[i])
+	}
+	out := make([]bool, n)
+	b.Run("ContainsEach", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			out = qf.ContainsEach(items, out)
+		}
+		b.ReportMetric(float64(b.Elapsed().Nanoseconds())/float64(b.N)/n, "ns/key")
+	})
+	b.Run("loop", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			for j, k := range items {
+				out[j] = qf.Contains(k)
+			}
+		}
+		b.ReportMetric(float64(b.Elapsed().Nanoseconds())/float64(b.N)/n, "ns/key")
+	})
+}
+
+func BenchmarkContainsEachLoop(b *testing.B) {
+	qf, _ := NewProbability(b.N*2, 0.01)
+	items := generateItems(b.N)
+	for i := 0; i < b.N; i += 2 {
+		qf.Add(items[i])
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	out := make([]bool, len(items))
+	for i, k := range items {
+		out[i] = qf.Contains(k)
+	}
+	b.StopTimer()
+}
+
+func BenchmarkContainsShards(b *testing.B) {
+	shards := []*QuotientFilter{MustNew(16, 8), MustNew(16, 8), MustNew(16, 8), MustNew(16, 8)}
+	items := generateItems(1000)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, qf := range shards {
+			qf.Contains(items[i%len(items)])
+		}
+	}
+}
+
+func BenchmarkContainsKeyShards(b *testing.B) {
+	shards := []*QuotientFilter{MustNew(16, 8), MustNew(16, 8), MustNew(16, 8), MustNew(16, 8)}
+	keys := make([]Key, 1000)
+	for i, s := range generateItems(len(keys)) {
+		keys[i] = shards[0].NewKey(s)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, qf := range shards {
+			qf.ContainsKey(keys[i%len(keys)])
+		}
+	}
+}
+
+func BenchmarkReset(b *testing.B) {
+	qf := MustNew(20, 8)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		qf.Reset()
+	}
+}
+
+func BenchmarkResetNew(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		MustNew(20, 8)
+	}
+}
+
+// seed seeds the random keys of the tests, a run fails with it so that -seed repeats the keys.
+var seed = flag.Int64("seed", time.Now().UnixNano(), "seed of the random keys of the tests")
+
+// keyRand generates the random keys of the tests, seeded with seed. The tests use the random
+// keys from several goroutines.
+var (
+	keyMu   sync.Mutex
+	keyRand *rand.Rand
+)
+
+var generatedSet int
+
+func TestMain(m *testing.M) {
+	flag.Parse()
+	keyRand = rand.New(rand.NewSource(*seed))
+	generatedSet = keyRand.Int()
+	code := m.Run()
+	if code != 0 || testing.Verbose() {
+		fmt.Println("random keys seeded with -seed", *seed)
+	}
+	os.Exit(code)
+}
+
+func generateItems(len int) []string {
+	keyMu.Lock()
+	setNum := generatedSet
+	generatedSet++
+	keyMu.Unlock()
+	out := make([]string, 0, len)
+	for i := 0; i < len; i++ {
+		out = append(out, fmt.Sprintf("item:%d:%d", setNum, i))
+	}
+	return out
+}
+
+// randomItems returns len random keys without the shared structure of generateItems.
+func randomItems(len int) []string {
+	keyMu.Lock()
+	defer keyMu.Unlock()
+	out := make([]string, len)
+	for i := range out {
+		out[i] = strconv.FormatUint(keyRand.Uint64(), 36)
+	}
+	return out
+}
+
+func generateByteItems(len int) [][]byte {
+	out := make([][]byte, 0, len)
+	for _, item := range generateItems(len) {
+		out = append(out, []byte(item))
 	}
 	return out
 }
@@ -0,0 +1,116 @@
+package qf
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// NewFromReader returns a filter sized like NewProbability(capacity, p) holding the keys read
+// from r, one per line, see AddFromReader.
+func NewFromReader(r io.Reader, capacity int, p float64) (*QuotientFilter, error) {
+	qf, err := NewProbability(capacity, p)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := qf.AddFromReader(r); err != nil {
+		return nil, err
+	}
+	return qf, nil
+}
+
+// AddFromReader adds the lines read from r until EOF as keys and returns the number of keys
+// read, counting the ones already in the filter. Lines end with "\n" or "\r\n", the last
+// line does not need to, and empty lines are skipped. Lines are streamed through hash
+// functions that hash what is written as it comes, such as the default FNV-64a, so they can
+// be of any length. The one-shot hash functions of HashFunc and WithHash128, the built-in
+// xxHash, maphash and identity hashes, and key transformers need the whole key and hold the
+// line in memory. Errors, such as ErrFull or errors from r, are returned with the number of
+// the line, all lines before it have been added.
+func (qf *QuotientFilter) AddFromReader(r io.Reader) (n int, err error) {
+	if qf.readOnly {
+		return 0, qf.errReadOnly()
+	}
+	br := bufio.NewReaderSize(r, 64<<10)
+	var key []byte
+	for line := 1; ; line++ {
+		empty, err := qf.readLine(br, &key)
+		if err == io.EOF {
+			return n, nil
+		}
+		if err != nil {
+			return n, fmt.Errorf("line %d: %w", line, err)
+		}
+		if empty {
+			continue
+		}
+		if err := qf.addFingerprint(qf.lineFingerprint(key)); err != nil {
+			return n, fmt.Errorf("line %d: %w", line, err)
+		}
+		n++
+	}
+}
+
+// holdsLines reports whether readLine holds the lines in memory instead of streaming them
+// through the hash function, for filters with a key transformer or of q + r above 64 bits.
+func (qf *QuotientFilter) holdsLines() bool {
+	return qf.transform != nil || qf.wide
+}
+
+// readLine streams the next line of br without its line ending through the hash function, or
+// into key for filters that hold the lines. It returns io.EOF only if there are no more lines.
+func (qf *QuotientFilter) readLine(br *bufio.Reader, key *[]byte) (empty bool, err error) {
+	qf.h.Reset()
+	*key = (*key)[:0]
+	size := 0
+	var werr error
+	write := func(b []byte) {
+		if qf.holdsLines() {
+			*key = append(*key, b...)
+		} else if _, err := qf.h.Write(b); err != nil && werr == nil {
+			werr = fmt.Errorf("hash function failed: %w", err)
+		}
+		size += len(b)
+	}
+	// a "\r" ending a chunk is held back until it is known whether a "\n" follows it.
+	cr := false
+	for read := 0; ; {
+		chunk, err := br.ReadSlice('\n')
+		read += len(chunk)
+		if err != nil && err != bufio.ErrBufferFull && (err != io.EOF || read == 0) {
+			return false, err
+		}
+		end := err == nil
+		if end {
+			chunk = chunk[:len(chunk)-1]
+		}
+		if cr && (!end || len(chunk) > 0) {
+			write([]byte("\r"))
+		}
+		cr = bytes.HasSuffix(chunk, []byte("\r"))
+		if cr {
+			chunk = chunk[:len(chunk)-1]
+		}
+		write(chunk)
+		if end || err == io.EOF {
+			if !end && cr {
+				write([]byte("\r"))
+			}
+			break
+		}
+	}
+	if werr != nil {
+		return false, werr
+	}
+	return size == 0, nil
+}
+
+// lineFingerprint returns the hash, quotient and remainder of the line readLine read, key
+// holds it for filters that hold the lines.
+func (qf *QuotientFilter) lineFingerprint(key []byte) (h, q, r uint64) {
+	if qf.holdsLines() {
+		return qf.fingerprint(key)
+	}
+	return qf.fingerprintHash(qf.h.Sum64())
+}
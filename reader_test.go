@@ -0,0 +1,91 @@
+package qf
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestNewFromReader(t *testing.T) {
+	keys := randomItems(300000)
+	long := strings.Repeat("fox", 100000)
+	var buf bytes.Buffer
+	for i, key := range keys {
+		switch {
+		case i%1000 == 0:
+			buf.WriteString("\n")
+		case i%100 == 0:
+			buf.WriteString(key + "\r\n")
+			continue
+		}
+		buf.WriteString(key + "\n")
+	}
+	buf.WriteString(long + "\r\n\r\n" + "last")
+	if buf.Len() < 4<<20 {
+		t.Fatal("Test file of only", buf.Len(), "bytes")
+	}
+	input := buf.Bytes()
+	qf, err := NewFromReader(iotest.HalfReader(bytes.NewReader(input)), 400000, 0.001)
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	if !qf.ContainsAll(keys) || !qf.Contains(long) || !qf.Contains("last") {
+		t.Fatal("Keys missing from the filter")
+	}
+	if qf.Contains("") || qf.Contains(keys[100]+"\r") || qf.Contains(long+"\r") {
+		t.Fatal("Line endings or empty lines added as keys")
+	}
+	// the keys are counted, not the fingerprints they add.
+	qf.Reset()
+	if n, err := qf.AddFromReader(bytes.NewReader(input)); err != nil || n != len(keys)+2 {
+		t.Fatal("Unexpected AddFromReader result", n, err)
+	}
+}
+
+func TestAddFromReader(t *testing.T) {
+	tests := []struct {
+		Name  string
+		Input string
+		Keys  []string
+	}{
+		{"empty", "", nil},
+		{"empty lines", "\n\r\n\n", nil},
+		{"no final newline", "a\nb", []string{"a", "b"}},
+		{"carriage returns", "a\r\r\nb\rc\n\r", []string{"a\r", "b\rc", "\r"}},
+	}
+	for _, test := range tests {
+		qf := MustNew(8, 8)
+		n, err := qf.AddFromReader(iotest.OneByteReader(strings.NewReader(test.Input)))
+		if err != nil || n != len(test.Keys) || !qf.ContainsAll(test.Keys) {
+			t.Fatal(test.Name, "unexpected result", n, err)
+		}
+	}
+	// with a key transformer the whole line is transformed.
+	qf, _ := NewWithOptions(0, WithQR(8, 8), WithKeyTransformer("lower", strings.ToLower))
+	if n, err := qf.AddFromReader(strings.NewReader("FOX\r\nDog")); err != nil || n != 2 || !qf.ContainsAll([]string{"fox", "dog"}) {
+		t.Fatal("Unexpected result", n, err)
+	}
+}
+
+func TestAddFromReaderErrors(t *testing.T) {
+	qf := newFull(4, 8)
+	var lines strings.Builder
+	for i := 0; i < 20; i++ {
+		fmt.Fprintf(&lines, "key%d\n\n", i)
+	}
+	n, err := qf.AddFromReader(strings.NewReader(lines.String()))
+	if !errors.Is(err, ErrFull) || !strings.HasPrefix(err.Error(), fmt.Sprint("line ", 2*n+1, ":")) {
+		t.Fatal("Expected ErrFull with the line number, got", n, err)
+	}
+	failing := io.MultiReader(strings.NewReader("a\nb\n"), iotest.ErrReader(errors.New("broken")))
+	if n, err := MustNew(8, 8).AddFromReader(failing); n != 2 || err == nil || err.Error() != "line 3: broken" {
+		t.Fatal("Unexpected result", n, err)
+	}
+	if _, err := NewFromReader(strings.NewReader("a"), 0, 2); err == nil {
+		t.Fatal("Expected an error for an invalid false positive rate")
+	}
+}
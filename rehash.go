@@ -0,0 +1,148 @@
+package qf
+
+import (
+	"errors"
+	"fmt"
+	"iter"
+)
+
+// ErrNeedsRehash is returned by adding keys to a filter flagged by WithDisplacementLimit,
+// after adding the key. The filter keeps working, but lookups in its long clusters are slow
+// until it is rebuilt with Rehash or another seed.
+var ErrNeedsRehash = errors.New("quotient filter clusters grew past the displacement limit")
+
+// rehashAttempts is the number of seeds Rehash tries before giving up.
+const rehashAttempts = 3
+
+// WithDisplacementLimit flags the filter once adding a key puts its fingerprint more than
+// limit slots after its quotient, which happens when the keys interact badly with the hash
+// function and one cluster grows huge. Adding keys to a flagged filter returns
+// ErrNeedsRehash until Rehash rebuilds it, unless WithRehash rebuilds it right away. The
+// Striped wrapper adds keys without checking the limit. Stats reports the largest
+// displacement in the table either way.
+func WithDisplacementLimit(limit uint64) Option {
+	return func(c *config) error {
+		if limit == 0 {
+			return errors.New("displacement limit has to be at least 1")
+		}
+		c.displacementLimit = limit
+		return nil
+	}
+}
+
+// WithRehash rebuilds a filter flagged by WithDisplacementLimit with Rehash and keys as
+// soon as it is flagged, in the Add that crossed the limit. keys has to yield every key of
+// the filter, including the one being added, and must not use the filter. Sharded filters
+// can't rebuild their shards one by one. If rebuilding fails, Add returns the error of
+// Rehash and the next Add tries again.
+func WithRehash(keys iter.Seq[string]) Option {
+	return func(c *config) error {
+		c.rehashKeys = keys
+		return nil
+	}
+}
+
+// Rehash rebuilds the filter from keys with a new random seed of its hash function, which
+// has to be one of the built-in seeded ones, see WithSeed. keys has to yield every key of
+// the filter, the filter holds exactly these keys afterwards: keys added with AddHash,
+// AddNS or AddComparable and reported false positives are dropped. If the clusters grow
+// past the displacement limit with rehashAttempts seeds, or adding the keys fails, the
+// filter is left unchanged and the error is returned.
+//
+// Rehash changes the hash function, so it must not run concurrently with lookups, and
+// filters with optimistic reads or in shared memory, whose readers hash keys on their own,
+// can't be rehashed.
+func (qf *QuotientFilter) Rehash(keys iter.Seq[string]) error {
+	if qf.readOnly {
+		return qf.errReadOnly()
+	}
+	qf.mu.Lock()
+	defer qf.mu.Unlock()
+	qf.beginUpdate()
+	defer qf.endUpdate()
+	return qf.rehash(keys)
+}
+
+// rehash is Rehash with the filter locked.
+func (qf *QuotientFilter) rehash(keys iter.Seq[string]) error {
+	newHash := seededHashes[qf.hashID]
+	switch {
+	case qf.seq != nil:
+		return errors.New("filters with optimistic reads or in shared memory can't be rehashed")
+	case newHash == nil:
+		return fmt.Errorf("%w: hash function %q can't be reseeded", ErrNeedsRehash, qf.hashID)
+	}
+	for range rehashAttempts {
+		seed := randomSeed()
+		c := &config{q: qf.qbits, r: qf.rbits, maxLoad: qf.maxLoad, maxMemory: qf.maxMemory,
+			adaptiveEntries: qf.adaptiveEntries, stashSize: cap(qf.stash), probeLimit: qf.probeLimit,
+			transform: qf.transform, transformID: qf.transformID, displacementLimit: qf.displacementLimit,
+			newHash: newHash(seed), hashID: qf.hashID, hashSeed: encodeSeed(seed), resolver: qf.resolver,
+			cacheLineBlocks: qf.cacheLineBlocks, alignedSlots: qf.alignedSlots, sparse: qf.sparse != nil}
+		fresh := newFilter(c)
+		for k := range keys {
+			if err := fresh.Add(k); err != nil && !errors.Is(err, ErrNeedsRehash) {
+				return err
+			}
+			if fresh.needsRehash {
+				break
+			}
+		}
+		if !fresh.needsRehash {
+			qf.adopt(fresh)
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: still with %d new seeds", ErrNeedsRehash, rehashAttempts)
+}
+
+// adopt replaces the table and hash function of the filter with those of fresh, which has
+// the same q and r, in place, so that its data stays where it is. A sparse filter takes the
+// pages of fresh.
+func (qf *QuotientFilter) adopt(fresh *QuotientFilter) {
+	if qf.sparse != nil {
+		qf.sparse = fresh.sparse
+	}
+	copy(qf.data, fresh.data)
+	qf.offsets = fresh.offsets
+	if qf.dirty != nil {
+		qf.dirty.markRange(0, len(qf.data))
+	}
+	qf.len, qf.stash = fresh.len, append(qf.stash[:0], fresh.stash...)
+	qf.h, qf.hashers, qf.newHash = fresh.h, fresh.hashers, fresh.newHash
+	qf.hashFunc, qf.hashStr, qf.inlineFNV = fresh.hashFunc, fresh.hashStr, fresh.inlineFNV
+	qf.sharedHasher = fresh.sharedHasher
+	qf.hashSeed, qf.hashCheck = fresh.hashSeed, fresh.hashCheck
+	qf.needsRehash = false
+	qf.reported, qf.namespaces = nil, nil
+}
+
+// noteDisplacement flags the filter if a fingerprint was put more than the displacement
+// limit after its quotient, d slots.
+func (qf *QuotientFilter) noteDisplacement(d uint64) {
+	if qf.displacementLimit > 0 && d > qf.displacementLimit {
+		qf.needsRehash = true
+	}
+}
+
+// maxDisplacement returns the largest number of slots a fingerprint in the table is after
+// its quotient.
+func (qf *QuotientFilter) maxDisplacement() (d uint64) {
+	qf.forEachSlot(func(i, q, _ uint64) {
+		d = max(d, (i-q)&qf.qMask)
+	})
+	return d
+}
+
+// checkDisplacement returns the response to a filter flagged by WithDisplacementLimit after
+// adding a key: nil without the flag, the error of rebuilding it with WithRehash, or
+// ErrNeedsRehash.
+func (qf *QuotientFilter) checkDisplacement() error {
+	switch {
+	case !qf.needsRehash:
+		return nil
+	case qf.rehashKeys != nil:
+		return qf.rehash(qf.rehashKeys)
+	}
+	return fmt.Errorf("%w: a key was put more than %d slots after its quotient", ErrNeedsRehash, qf.displacementLimit)
+}
@@ -0,0 +1,94 @@
+package qf
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+)
+
+func TestDisplacementLimit(t *testing.T) {
+	// with the identity hash, hashes of one quotient pile up in one cluster.
+	qf, _ := NewWithOptions(0, WithQR(10, 8), WithIdentityHash(), WithDisplacementLimit(16))
+	for i := range uint64(17) {
+		if err := qf.AddHash(5<<8 | i); err != nil {
+			t.Fatal("Unexpected error below the limit", i, err)
+		}
+	}
+	if s := qf.Stats(); s.MaxDisplacement != 16 || s.NeedsRehash {
+		t.Fatal("Unexpected stats below the limit", s)
+	}
+	if err := qf.AddHash(5<<8 | 17); !errors.Is(err, ErrNeedsRehash) || !qf.ContainsHash(5<<8|17) {
+		t.Fatal("Adding past the limit returned", err)
+	}
+	if err := qf.AddHash(1); !errors.Is(err, ErrNeedsRehash) || !qf.Stats().NeedsRehash {
+		t.Fatal("Adding to a flagged filter returned", err)
+	}
+	if err := qf.Rehash(slices.Values([]string{"a"})); !errors.Is(err, ErrNeedsRehash) || !qf.ContainsHash(1) {
+		t.Fatal("Rehashing an identity hash filter returned", err)
+	}
+	qf.Reset()
+	if s := qf.Stats(); s.MaxDisplacement != 0 || s.NeedsRehash {
+		t.Fatal("Reset kept the displacement", s)
+	}
+	if _, err := NewWithOptions(0, WithQR(10, 8), WithRehash(slices.Values([]string{"a"}))); err == nil {
+		t.Fatal("WithRehash without a limit was accepted")
+	}
+	if _, err := NewSharded(2, 1000, WithDisplacementLimit(16), WithRehash(slices.Values([]string{"a"}))); err == nil {
+		t.Fatal("A sharded filter with WithRehash was created")
+	}
+}
+
+func TestRehash(t *testing.T) {
+	// keys whose unseeded FNV-64a hashes share quotient 0 grow one cluster.
+	probe := MustNew(10, 8)
+	var keys []string
+	for i := 0; len(keys) < 40; i++ {
+		k := fmt.Sprint("key", i)
+		if q, _ := probe.quotientAndRemainder(probe.hashString(k)); q == 0 {
+			keys = append(keys, k)
+		}
+	}
+	for _, auto := range []bool{false, true} {
+		opts := []Option{WithQR(10, 8), WithDisplacementLimit(16)}
+		if auto {
+			opts = append(opts, WithRehash(slices.Values(keys)))
+		}
+		qf, _ := NewWithOptions(0, opts...)
+		for _, k := range keys {
+			if err := qf.Add(k); err != nil && (auto || !errors.Is(err, ErrNeedsRehash)) {
+				t.Fatal("Unexpected error adding", k, auto, err)
+			}
+		}
+		if !auto {
+			if !qf.Stats().NeedsRehash {
+				t.Fatal("Crafted keys did not flag the filter")
+			}
+			if err := qf.Rehash(slices.Values(keys)); err != nil {
+				t.Fatal("Unexpected error rehashing", err)
+			}
+		}
+		// keys can share a fingerprint under the random seed.
+		fps := make(map[uint64]bool)
+		for _, k := range keys {
+			fps[qf.Fingerprint64(k)] = true
+		}
+		s := qf.Stats()
+		if s.NeedsRehash || s.MaxDisplacement > 16 || qf.Params().Seed == 0 || s.Len != uint64(len(fps)) {
+			t.Fatal("Unexpected result rehashing", auto, s, qf.Params())
+		}
+		for _, k := range keys {
+			if !qf.Contains(k) {
+				t.Fatal("Missing", k, auto)
+			}
+		}
+		if err := qf.checkTable(); err != nil {
+			t.Fatal("Invalid table", err)
+		}
+		b, _ := qf.MarshalBinary()
+		decoded := MustNew(4, 4)
+		if err := decoded.UnmarshalBinary(b); err != nil || decoded.Params() != qf.Params() || !decoded.Contains(keys[0]) {
+			t.Fatal("Unexpected result decoding a rehashed filter", err)
+		}
+	}
+}
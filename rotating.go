@@ -0,0 +1,90 @@
+package qf
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Rotating is a filter of several generations for sliding window deduplication. Keys are
+// added to the newest generation and Contains checks all of them, Rotate drops the oldest
+// generation and starts a new one, so a key is forgotten after generations rotations.
+// Unlike QuotientFilter, Rotating is safe for concurrent use.
+type Rotating struct {
+	mu sync.Mutex
+	// generations, newest last.
+	gens     []*QuotientFilter
+	capacity int
+	p        float64
+}
+
+// NewRotating returns a Rotating filter with generations generations, each sized like
+// NewProbability(perGenCapacity, p).
+func NewRotating(generations int, perGenCapacity int, p float64) (*Rotating, error) {
+	if generations < 1 {
+		return nil, fmt.Errorf("generations %d has to be positive", generations)
+	}
+	r := &Rotating{gens: make([]*QuotientFilter, generations), capacity: perGenCapacity, p: p}
+	for i := range r.gens {
+		qf, err := NewProbability(perGenCapacity, p)
+		if err != nil {
+			return nil, err
+		}
+		r.gens[i] = qf
+	}
+	return r, nil
+}
+
+// Add adds the key to the newest generation, keys present in older generations are added
+// again so they are kept for another generations rotations. It returns ErrFull if the
+// newest generation is full.
+func (r *Rotating) Add(key string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.gens[len(r.gens)-1].Add(key)
+}
+
+// Contains checks if the key has been added to any live generation.
+func (r *Rotating) Contains(key string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := len(r.gens) - 1; i >= 0; i-- {
+		if r.gens[i].Contains(key) {
+			return true
+		}
+	}
+	return false
+}
+
+// Len returns the number of fingerprints in all generations.
+func (r *Rotating) Len() uint64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var n uint64
+	for _, qf := range r.gens {
+		n += qf.Len()
+	}
+	return n
+}
+
+// SizeInBytes returns the number of bytes used by all generations.
+func (r *Rotating) SizeInBytes() uint64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var n uint64
+	for _, qf := range r.gens {
+		n += qf.SizeInBytes()
+	}
+	return n
+}
+
+// Rotate drops the oldest generation and starts a new empty one. The new generation is
+// allocated before taking the lock, so concurrent calls are only blocked while the
+// generations are swapped.
+func (r *Rotating) Rotate() {
+	// the parameters were validated by NewRotating.
+	fresh := mustNew(NewProbability(r.capacity, r.p))
+	r.mu.Lock()
+	copy(r.gens, r.gens[1:])
+	r.gens[len(r.gens)-1] = fresh
+	r.mu.Unlock()
+}
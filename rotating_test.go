@@ -0,0 +1,73 @@
+package qf
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestRotating(t *testing.T) {
+	r, err := NewRotating(3, 1000, 0.001)
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	size := r.SizeInBytes()
+	var gens [][]string
+	for i := 0; i < 50; i++ {
+		items := randomItems(500)
+		for _, s := range items {
+			if err := r.Add(s); err != nil {
+				t.Fatal("Unexpected error", err)
+			}
+		}
+		gens = append(gens, items)
+		for age, items := range gens[len(gens)-min(len(gens), 3):] {
+			for _, s := range items {
+				if !r.Contains(s) {
+					t.Fatal("False negative for", s, "generation", age)
+				}
+			}
+		}
+		if len(gens) > 3 {
+			// the keys of a dropped generation are gone, apart from false positives.
+			var fps int
+			for _, s := range gens[len(gens)-4] {
+				if r.Contains(s) {
+					fps++
+				}
+			}
+			if fps > 5 {
+				t.Fatal("Too many keys of a dropped generation present", fps)
+			}
+		}
+		r.Rotate()
+		if r.SizeInBytes() != size {
+			t.Fatal("Size changed after rotating", r.SizeInBytes(), size)
+		}
+	}
+	if _, err := NewRotating(0, 1000, 0.01); err == nil {
+		t.Fatal("Expected an error for zero generations")
+	}
+	if _, err := NewRotating(2, 1000, 2); err == nil {
+		t.Fatal("Expected an error for an invalid rate")
+	}
+}
+
+func TestRotatingConcurrent(t *testing.T) {
+	r, _ := NewRotating(2, 1000, 0.01)
+	items := generateItems(1000)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for _, s := range items[i*250 : (i+1)*250] {
+				r.Add(s)
+				r.Contains(s)
+			}
+		}(i)
+	}
+	for i := 0; i < 10; i++ {
+		r.Rotate()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,343 @@
+package qf
+
+import (
+	"io"
+	"sync"
+)
+
+// Safe is a filter that can be used from any number of goroutines, see NewSafe. Lookups take
+// a read lock and run in parallel, adding and deleting keys take the write lock. Keys are
+// hashed outside the lock, lookups of the filter write no hash state.
+type Safe struct {
+	mu sync.RWMutex
+	sharedHash
+}
+
+// NewSafe wraps inner, which must not be used directly afterwards. If the hash constructor
+// inner was created with returns the same instance every time, as with NewHash, keys are
+// hashed one at a time.
+func NewSafe(inner *QuotientFilter) *Safe {
+	return &Safe{sharedHash: newSharedHash(inner)}
+}
+
+// sharedHash hashes keys for a filter used from many goroutines.
+type sharedHash struct {
+	qf *QuotientFilter
+	// held while hashing for filters whose constructor returns one shared hash function,
+	// such as the filters of NewHash.
+	hashMu *sync.Mutex
+}
+
+func newSharedHash(qf *QuotientFilter) sharedHash {
+	s := sharedHash{qf: qf}
+	if qf.sharedHasher {
+		s.hashMu = new(sync.Mutex)
+	}
+	return s
+}
+
+// lockHash serializes hashing for filters with a shared hash function, unlockHash ends it.
+func (s *sharedHash) lockHash() {
+	if s.hashMu != nil {
+		s.hashMu.Lock()
+	}
+}
+
+func (s *sharedHash) unlockHash() {
+	if s.hashMu != nil {
+		s.hashMu.Unlock()
+	}
+}
+
+func (s *sharedHash) hash(key []byte) uint64 {
+	s.lockHash()
+	defer s.unlockHash()
+	return s.qf.hash(key)
+}
+
+func (s *sharedHash) hashString(key string) uint64 {
+	s.lockHash()
+	defer s.unlockHash()
+	return s.qf.hashString(key)
+}
+
+func (s *sharedHash) fingerprint(key []byte) (h, q, r uint64) {
+	s.lockHash()
+	defer s.unlockHash()
+	return s.qf.fingerprint(key)
+}
+
+func (s *sharedHash) fingerprintString(key string) (h, q, r uint64) {
+	s.lockHash()
+	defer s.unlockHash()
+	return s.qf.fingerprintString(key)
+}
+
+func (s *sharedHash) fingerprintUint64(k uint64) (h, q, r uint64) {
+	s.lockHash()
+	defer s.unlockHash()
+	return s.qf.fingerprintUint64(k)
+}
+
+func (s *sharedHash) fingerprintNS(ns, key string) (h, q, r uint64) {
+	s.lockHash()
+	defer s.unlockHash()
+	return s.qf.fingerprintNS(ns, key)
+}
+
+// hashedKey is the hash of a key and the quotient and remainder it is stored as.
+type hashedKey struct{ h, q, r uint64 }
+
+// hashAll hashes the keys in order. It holds hashMu alone, so that it doesn't wait for the
+// lock of the filter while holding the hash function, which Update holds after it.
+func (s *sharedHash) hashAll(keys []string) []hashedKey {
+	hashed := make([]hashedKey, len(keys))
+	s.lockHash()
+	defer s.unlockHash()
+	for i, k := range keys {
+		hashed[i].h, hashed[i].q, hashed[i].r = s.qf.fingerprintString(k)
+	}
+	return hashed
+}
+
+// contains looks the quotient q and remainder r of a key with the hash h up under the read
+// lock. Filters with a verifier count the lookup of key under the write lock, see
+// SetVerifier.
+func (s *Safe) contains(key string, h, q, r uint64) bool {
+	s.mu.RLock()
+	found := s.qf.containsFingerprint(h, q, r)
+	measure := s.qf.verifier != nil
+	s.mu.RUnlock()
+	if measure {
+		s.mu.Lock()
+		s.qf.measure(key, found)
+		s.mu.Unlock()
+	}
+	return found
+}
+
+// Contains checks if key is present in the filter, see QuotientFilter.Contains.
+func (s *Safe) Contains(key string) bool {
+	h, q, r := s.fingerprintString(key)
+	return s.contains(key, h, q, r)
+}
+
+// ContainsBytes checks if key is present in the filter, see QuotientFilter.ContainsBytes.
+func (s *Safe) ContainsBytes(key []byte) bool {
+	h, q, r := s.fingerprint(key)
+	return s.contains(string(key), h, q, r)
+}
+
+// ContainsUint64 checks if the integer key k is present in the filter, see AddUint64.
+func (s *Safe) ContainsUint64(k uint64) bool {
+	return s.containsFingerprint(s.fingerprintUint64(k))
+}
+
+// ContainsHash checks if a key with the 64 bit hash h is present in the filter.
+func (s *Safe) ContainsHash(h uint64) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.qf.ContainsHash(h)
+}
+
+// containsFingerprint is ContainsHash for the quotient q and remainder r of a key with the
+// hash h.
+func (s *Safe) containsFingerprint(h, q, r uint64) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.qf.containsFingerprint(h, q, r)
+}
+
+// ContainsNS checks if the key is present in the namespace ns, see AddNS.
+func (s *Safe) ContainsNS(ns, key string) bool {
+	return s.containsFingerprint(s.fingerprintNS(ns, key))
+}
+
+// ContainsEach checks every key and returns the results in the same order as keys, in out if
+// it has room for them, see QuotientFilter.ContainsEach. The keys are looked up under one
+// read lock, with a shared hash function they are hashed before it is taken.
+func (s *Safe) ContainsEach(keys []string, out []bool) []bool {
+	out = results(out, len(keys))
+	if s.hashMu != nil {
+		hashed := s.hashAll(keys)
+		s.mu.RLock()
+		for i, k := range hashed {
+			out[i] = s.qf.containsFingerprint(k.h, k.q, k.r)
+		}
+	} else {
+		s.mu.RLock()
+		s.qf.containsInto(keys, out)
+	}
+	measure := s.qf.verifier != nil
+	s.mu.RUnlock()
+	if measure {
+		s.mu.Lock()
+		for i, k := range keys {
+			s.qf.measure(k, out[i])
+		}
+		s.mu.Unlock()
+	}
+	return out
+}
+
+// Add adds the key to the filter.
+func (s *Safe) Add(key string) error {
+	return s.addFingerprint(s.fingerprintString(key))
+}
+
+// AddBytes adds the key to the filter, it is the []byte equivalent of Add.
+func (s *Safe) AddBytes(key []byte) error {
+	return s.addFingerprint(s.fingerprint(key))
+}
+
+// AddUint64 adds the integer key k to the filter, see QuotientFilter.AddUint64.
+func (s *Safe) AddUint64(k uint64) error {
+	return s.addFingerprint(s.fingerprintUint64(k))
+}
+
+// AddHash adds a key that has already been hashed to h, see QuotientFilter.AddHash.
+func (s *Safe) AddHash(h uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.qf.AddHash(h)
+}
+
+// addFingerprint is AddHash for the quotient q and remainder r of a key with the hash h.
+func (s *Safe) addFingerprint(h, q, r uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.qf.addFingerprint(h, q, r)
+}
+
+// AddNS adds the key to the namespace ns, see QuotientFilter.AddNS.
+func (s *Safe) AddNS(ns, key string) error {
+	h, q, r := s.fingerprintNS(ns, key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.qf.addFingerprint(h, q, r); err != nil {
+		return err
+	}
+	s.qf.addNamespace(ns)
+	return nil
+}
+
+// AddAll adds the keys under one write lock, see QuotientFilter.AddAll. The keys are hashed
+// before the lock is taken.
+func (s *Safe) AddAll(keys []string) (inserted int, err error) {
+	hashed := s.hashAll(keys)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, k := range hashed {
+		existed, err := s.qf.containsOrAddFingerprint(k.h, k.q, k.r)
+		if existed {
+			continue
+		}
+		if err != nil {
+			return inserted, &BatchError{Index: i, Err: err}
+		}
+		inserted++
+	}
+	return inserted, nil
+}
+
+// ContainsOrAdd adds the key to the filter and reports whether it was already present.
+func (s *Safe) ContainsOrAdd(key string) (existed bool, err error) {
+	h, q, r := s.fingerprintString(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.qf.containsOrAddFingerprint(h, q, r)
+}
+
+// Delete removes the key from the filter and reports whether it was found.
+func (s *Safe) Delete(key string) bool {
+	_, q, r := s.fingerprintString(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.qf.deleteFingerprint(q, r)
+}
+
+// Reset removes all keys from the filter.
+func (s *Safe) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.qf.Reset()
+}
+
+// Len returns the number of keys in the filter. It takes no lock, so it does not wait for
+// adding and deleting keys.
+func (s *Safe) Len() uint64 {
+	return s.qf.Len()
+}
+
+// Counters returns the counters of the filter without taking the lock, see
+// QuotientFilter.Counters.
+func (s *Safe) Counters() Counters {
+	return s.qf.Counters()
+}
+
+// LoadFactor returns the fraction of slots in use.
+func (s *Safe) LoadFactor() float64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.qf.LoadFactor()
+}
+
+// Stats returns the statistics of the filter, see QuotientFilter.Stats.
+func (s *Safe) Stats() Stats {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.qf.Stats()
+}
+
+// Diagnostics returns the report of how evenly the fingerprints spread, see
+// QuotientFilter.Diagnostics.
+func (s *Safe) Diagnostics() Diagnostics {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.qf.Diagnostics()
+}
+
+// Fingerprints returns the sorted fingerprints of the filter at one point in time. Adding and
+// deleting keys waits while they are collected, the slice is not affected by them later.
+func (s *Safe) Fingerprints() []uint64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.qf.Fingerprints()
+}
+
+// Snapshot returns a read-only copy of the filter, see QuotientFilter.Snapshot.
+func (s *Safe) Snapshot() *QuotientFilter {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.qf.Snapshot()
+}
+
+// WriteTo writes the filter to w in the binary format of MarshalBinary. Adding and deleting
+// keys waits until the filter is written.
+func (s *Safe) WriteTo(w io.Writer) (int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.qf.WriteTo(w)
+}
+
+// View calls fn with the filter under the read lock, for the methods Safe has no equivalent
+// of and for iterating over the filter. fn must only call methods that don't change the
+// filter, lookups of filters with a shared hash function or a verifier change it. Adding and
+// deleting keys waits until fn returns.
+func (s *Safe) View(fn func(qf *QuotientFilter)) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	fn(s.qf)
+}
+
+// Update calls fn with the filter under the write lock, fn can call any method of the filter
+// but must not keep it after returning. Decoding into the filter has to keep its hash
+// function, which Safe hashes keys with.
+func (s *Safe) Update(fn func(qf *QuotientFilter)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// fn may hash with the hash function the lookups share.
+	s.lockHash()
+	defer s.unlockHash()
+	fn(s.qf)
+}
@@ -0,0 +1,127 @@
+package qf
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sync"
+	"testing"
+)
+
+func TestSafe(t *testing.T) {
+	plain, _ := New(16, 20)
+	fnvShared, _ := NewHash(fnv.New64a(), 16, 20)
+	for name, inner := range map[string]*QuotientFilter{"default": plain, "NewHash": fnvShared} {
+		t.Run(name, func(t *testing.T) {
+			s := NewSafe(inner)
+			base := randomItems(2000)
+			if _, err := s.AddAll(base); err != nil {
+				t.Fatal("Unexpected error", err)
+			}
+			baseLen := s.Len()
+			const writers, readers, perWriter = 8, 8, 1000
+			var wg sync.WaitGroup
+			for w := 0; w < writers; w++ {
+				wg.Add(1)
+				go func(w int) {
+					defer wg.Done()
+					for i := 0; i < perWriter; i++ {
+						key := fmt.Sprint("writer", w, "key", i)
+						if err := s.Add(key); err != nil {
+							t.Error("Unexpected error", err)
+							return
+						}
+						if !s.Contains(key) {
+							t.Error("Key just added is missing", key)
+							return
+						}
+						if i%10 == 0 {
+							s.AddUint64(uint64(w<<32 | i))
+							s.AddNS("writer", key)
+							s.Delete(fmt.Sprint("writer", w, "deleted", i))
+						}
+					}
+				}(w)
+			}
+			for r := 0; r < readers; r++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for i := 0; i < 5; i++ {
+						for j, found := range s.ContainsEach(base, nil) {
+							if !found {
+								t.Error("Missing", base[j])
+								return
+							}
+						}
+						if s.Len() < baseLen || uint64(len(s.Fingerprints())) < baseLen {
+							t.Error("Filter shrank below", baseLen)
+							return
+						}
+						s.View(func(qf *QuotientFilter) { qf.Stats() })
+					}
+				}()
+			}
+			wg.Wait()
+			s.Update(func(qf *QuotientFilter) {
+				if qf.Len() != s.qf.len {
+					t.Fatal("Update got another filter")
+				}
+			})
+			for w := 0; w < writers; w++ {
+				for i := 0; i < perWriter; i++ {
+					key := fmt.Sprint("writer", w, "key", i)
+					if !inner.Contains(key) {
+						t.Fatal("Missing", key)
+					}
+					if i%10 == 0 && (!s.ContainsUint64(uint64(w<<32|i)) || !s.ContainsNS("writer", key)) {
+						t.Fatal("Missing uint64 or namespaced key", w, i)
+					}
+				}
+			}
+			if existed, err := s.ContainsOrAdd(base[0]); !existed || err != nil {
+				t.Fatal("ContainsOrAdd of a present key returned", existed, err)
+			}
+			if !s.Delete(base[0]) || s.ContainsBytes([]byte(base[0])) {
+				t.Fatal("Delete did not remove", base[0])
+			}
+			s.Reset()
+			if s.Len() != 0 || s.Contains(base[1]) {
+				t.Fatal("Reset left keys")
+			}
+		})
+	}
+}
+
+// TestSafeContainsEachUpdate runs ContainsEach and Update together on a filter of NewHash,
+// which both hold the shared hash function.
+func TestSafeContainsEachUpdate(t *testing.T) {
+	inner, _ := NewHash(fnv.New64a(), 12, 8)
+	s := NewSafe(inner)
+	keys := randomItems(500)
+	s.AddAll(keys)
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				for j, found := range s.ContainsEach(keys, nil) {
+					if !found {
+						t.Error("Missing", keys[j])
+						return
+					}
+				}
+			}
+		}()
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				s.Update(func(qf *QuotientFilter) { qf.Add(fmt.Sprint("update", g, i)) })
+			}
+		}(g)
+	}
+	wg.Wait()
+	if s.Len() < uint64(len(keys)) {
+		t.Fatal("Filter shrank to", s.Len())
+	}
+}
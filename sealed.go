@@ -0,0 +1,141 @@
+package qf
+
+import (
+	"fmt"
+	"io"
+)
+
+// ErrSealed is returned when changing a filter that has been sealed, see Seal. It wraps
+// ErrReadOnly.
+var ErrSealed = fmt.Errorf("%w: it has been sealed", ErrReadOnly)
+
+// errReadOnly returns the error of changing the read-only filter.
+func (qf *QuotientFilter) errReadOnly() error {
+	if qf.sealed {
+		return ErrSealed
+	}
+	return ErrReadOnly
+}
+
+// Sealed is a filter that can't change anymore, returned by Seal. It has the lookups of a
+// filter but no methods changing it, and any number of goroutines can use it concurrently.
+type Sealed struct {
+	sharedHash
+}
+
+// Seal makes the filter read-only for good, for filters that are loaded once and then only
+// looked up. Adding keys to the filter afterwards returns ErrSealed, as do decoding into it
+// and ApplyDiff, deleting keys returns false and Reset and ReportFalsePositive do nothing.
+// Clone still returns a writable copy.
+//
+// Seal returns the filter as a Sealed filter whose lookups never change anything: they don't
+// count in Measured, and keys are hashed one at a time if the hash constructor returns one
+// shared instance as with NewHash. Seal must not run concurrently with other methods, the
+// Sealed filter it returns can be published to other goroutines with an atomic.Pointer or a
+// channel.
+func (qf *QuotientFilter) Seal() *Sealed {
+	qf.readOnly, qf.sealed = true, true
+	return &Sealed{newSharedHash(qf)}
+}
+
+// Contains checks if key is present in the filter.
+func (s *Sealed) Contains(key string) bool {
+	return s.qf.containsFingerprint(s.fingerprintString(key))
+}
+
+// ContainsBytes checks if key is present in the filter, it is the []byte equivalent of Contains.
+func (s *Sealed) ContainsBytes(key []byte) bool {
+	return s.qf.containsFingerprint(s.fingerprint(key))
+}
+
+// ContainsUint64 checks if the integer key k is present in the filter, see AddUint64.
+func (s *Sealed) ContainsUint64(k uint64) bool {
+	return s.qf.containsFingerprint(s.fingerprintUint64(k))
+}
+
+// ContainsHash checks if a key with the 64 bit hash h is present in the filter.
+func (s *Sealed) ContainsHash(h uint64) bool {
+	return s.qf.ContainsHash(h)
+}
+
+// ContainsNS checks if the key is present in the namespace ns, see AddNS.
+func (s *Sealed) ContainsNS(ns, key string) bool {
+	return s.qf.containsFingerprint(s.fingerprintNS(ns, key))
+}
+
+// ContainsEach checks every key and returns the results in the same order as keys, in out if
+// it has room for them, see QuotientFilter.ContainsEach.
+func (s *Sealed) ContainsEach(keys []string, out []bool) []bool {
+	out = results(out, len(keys))
+	s.lockHash()
+	defer s.unlockHash()
+	s.qf.containsInto(keys, out)
+	return out
+}
+
+// ContainsBatchParallel checks every key with workers goroutines, see
+// QuotientFilter.ContainsBatchParallel.
+func (s *Sealed) ContainsBatchParallel(keys []string, workers int) []bool {
+	if s.hashMu != nil {
+		s.lockHash()
+		defer s.unlockHash()
+		workers = 1
+	}
+	return s.qf.containsParallel(keys, workers)
+}
+
+// Len returns the number of keys in the filter.
+func (s *Sealed) Len() uint64 {
+	return s.qf.Len()
+}
+
+// Cap returns the number of slots of the filter.
+func (s *Sealed) Cap() uint64 {
+	return s.qf.Cap()
+}
+
+// LoadFactor returns the fraction of slots in use.
+func (s *Sealed) LoadFactor() float64 {
+	return s.qf.LoadFactor()
+}
+
+// FPProbability returns the estimated false positive rate, see QuotientFilter.FPProbability.
+func (s *Sealed) FPProbability() float64 {
+	return s.qf.FPProbability()
+}
+
+// Params returns the parameters of the filter.
+func (s *Sealed) Params() Params {
+	return s.qf.Params()
+}
+
+// Stats returns the statistics of the filter, see QuotientFilter.Stats.
+func (s *Sealed) Stats() Stats {
+	return s.qf.Stats()
+}
+
+// Diagnostics returns the report of how evenly the fingerprints spread, see
+// QuotientFilter.Diagnostics.
+func (s *Sealed) Diagnostics() Diagnostics {
+	return s.qf.Diagnostics()
+}
+
+// Fingerprints returns the sorted fingerprints of the filter.
+func (s *Sealed) Fingerprints() []uint64 {
+	return s.qf.Fingerprints()
+}
+
+// WriteTo writes the filter to w in the binary format of MarshalBinary.
+func (s *Sealed) WriteTo(w io.Writer) (int64, error) {
+	return s.qf.WriteTo(w)
+}
+
+// MarshalBinary encodes the filter, see QuotientFilter.MarshalBinary.
+func (s *Sealed) MarshalBinary() ([]byte, error) {
+	return s.qf.MarshalBinary()
+}
+
+// Clone returns a writable copy of the filter.
+func (s *Sealed) Clone() *QuotientFilter {
+	return s.qf.Clone()
+}
@@ -0,0 +1,77 @@
+package qf
+
+import (
+	"errors"
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestSeal(t *testing.T) {
+	qf, _ := NewWithOptions(10000)
+	items := randomItems(5000)
+	qf.AddAll(items)
+	want := qf.Len()
+	b, _ := qf.MarshalBinary()
+	s := qf.Seal()
+	absent := "new"
+	for qf.Contains(absent) {
+		absent += "!"
+	}
+
+	if err := qf.Add(absent); !errors.Is(err, ErrSealed) || !errors.Is(err, ErrReadOnly) {
+		t.Fatal("Add to a sealed filter returned", err)
+	}
+	if _, err := qf.AddAll([]string{absent}); !errors.Is(err, ErrSealed) {
+		t.Fatal("AddAll to a sealed filter returned", err)
+	}
+	if _, err := qf.ContainsOrAdd(absent); !errors.Is(err, ErrSealed) {
+		t.Fatal("ContainsOrAdd on a sealed filter returned", err)
+	}
+	if qf.Delete(items[0]) {
+		t.Fatal("Delete from a sealed filter succeeded")
+	}
+	qf.Reset()
+	if err := qf.UnmarshalBinary(b); !errors.Is(err, ErrSealed) {
+		t.Fatal("Decoding into a sealed filter returned", err)
+	}
+	if qf.Len() != want || !s.Contains(items[0]) || s.Contains(absent) {
+		t.Fatal("Sealed filter changed")
+	}
+	clone := s.Clone()
+	if err := clone.Add(absent); err != nil || !clone.Contains(absent) || s.Contains(absent) {
+		t.Fatal("Clone of a sealed filter is not writable and independent", err)
+	}
+
+	shared, _ := NewHash(fnv.New64a(), 16, 20)
+	shared.AddAll(items)
+	lens := map[string]uint64{"default": want, "NewHash": shared.Len()}
+	for name, f := range map[string]*QuotientFilter{"default": qf, "NewHash": shared} {
+		t.Run(name, func(t *testing.T) {
+			var published atomic.Pointer[Sealed]
+			var wg sync.WaitGroup
+			for g := 0; g < 16; g++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					s := published.Load()
+					for s == nil {
+						s = published.Load()
+					}
+					for i, found := range s.ContainsEach(items, nil) {
+						if !found || !s.Contains(items[i]) || !s.ContainsBytes([]byte(items[i])) {
+							t.Error("Missing", items[i])
+							return
+						}
+					}
+					if s.Len() != lens[name] {
+						t.Error("Len", s.Len())
+					}
+				}()
+			}
+			published.Store(f.Seal())
+			wg.Wait()
+		})
+	}
+}
@@ -0,0 +1,119 @@
+package qf
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"hash"
+)
+
+// WithSeed seeds the built-in hash function with seed, so that keys crafted to collide in
+// filters of one seed spread out in filters of another. FNV-64a hashes the seed before the
+// key and folds it into the result with a final mix, xxHash64 starts from it. Seed 0 is the unseeded hash function, encodings record
+// other seeds and Params reports them, so that compatible filters can be created with the
+// same seed. Custom hash functions can't be seeded, maphash has seeds of its own, see
+// WithMaphashSeed.
+func WithSeed(seed uint64) Option {
+	return func(c *config) error {
+		c.seed, c.hasSeed = seed, true
+		return nil
+	}
+}
+
+// WithRandomSeed seeds the built-in hash function with a random seed from crypto/rand, see
+// WithSeed. The seed is drawn once per call, filters created with the same option share it
+// like the shards of NewSharded.
+func WithRandomSeed() Option {
+	return WithSeed(randomSeed())
+}
+
+// randomSeed returns a nonzero seed from crypto/rand.
+func randomSeed() uint64 {
+	var b [8]byte
+	for binary.LittleEndian.Uint64(b[:]) == 0 {
+		rand.Read(b[:])
+	}
+	return binary.LittleEndian.Uint64(b[:])
+}
+
+// applySeed gives the hash function of c the seed of WithSeed.
+func (c *config) applySeed() error {
+	if !c.hasSeed {
+		return nil
+	}
+	newHash := seededHashes[c.hashID]
+	if newHash == nil {
+		return fmt.Errorf("hash function %q can't be seeded with WithSeed", c.hashID)
+	}
+	c.newHash, c.hashSeed = newHash(c.seed), encodeSeed(c.seed)
+	return nil
+}
+
+// seededHashes are the constructors of the built-in hash functions that take a seed.
+var seededHashes = map[string]func(seed uint64) func() hash.Hash64{
+	HashFNV64a: func(seed uint64) func() hash.Hash64 {
+		if seed == 0 {
+			return newFNV64a
+		}
+		var b [8]byte
+		binary.LittleEndian.PutUint64(b[:], seed)
+		basis := fnv64a(fnvOffset64, b[:])
+		return hashFuncs(func(b []byte) uint64 { return fmix64(fnv64a(basis, b) ^ seed) },
+			func(s string) uint64 { return fmix64(fnv64a(basis, s) ^ seed) })
+	},
+	HashXXHash64: func(seed uint64) func() hash.Hash64 {
+		if seed == 0 {
+			return newXXHash
+		}
+		return hashFuncs(func(b []byte) uint64 { return xxhash64(seed, b) },
+			func(s string) uint64 { return xxhash64(seed, s) })
+	},
+}
+
+// resolveSeeded is the HashResolver of the built-in hash functions in seededHashes.
+func resolveSeeded(name string, seed []byte) (func() hash.Hash64, error) {
+	s, ok := decodeSeed(string(seed))
+	if !ok {
+		return nil, fmt.Errorf("%s seed of %d bytes is not 8 bytes long", name, len(seed))
+	}
+	return seededHashes[name](s), nil
+}
+
+// seed returns the seed of the filter's built-in hash function, 0 for other hash functions.
+func (qf *QuotientFilter) seed() uint64 {
+	if seededHashes[qf.hashID] == nil {
+		return 0
+	}
+	seed, _ := decodeSeed(qf.hashSeed)
+	return seed
+}
+
+// fmix64 is the finalizer of MurmurHash3, it makes every bit of the result depend on every
+// bit of h. FNV-64a alone carries the seed only into the higher bits.
+func fmix64(h uint64) uint64 {
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	h *= 0xc4ceb9fe1a85ec53
+	h ^= h >> 33
+	return h
+}
+
+// encodeSeed and decodeSeed convert between seeds and the seeds of hash ids, which are
+// empty for seed 0 and 8 bytes little-endian otherwise.
+func encodeSeed(seed uint64) string {
+	if seed == 0 {
+		return ""
+	}
+	return string(binary.LittleEndian.AppendUint64(nil, seed))
+}
+
+func decodeSeed(s string) (uint64, bool) {
+	switch len(s) {
+	case 0:
+		return 0, true
+	case 8:
+		return binary.LittleEndian.Uint64([]byte(s)), true
+	}
+	return 0, false
+}
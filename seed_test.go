@@ -0,0 +1,90 @@
+package qf
+
+import (
+	"errors"
+	"fmt"
+	"hash"
+	"hash/fnv"
+	"strings"
+	"testing"
+)
+
+func TestSeed(t *testing.T) {
+	for _, hashOpt := range []Option{WithQR(10, 10), WithXXHash()} {
+		a, _ := NewWithOptions(0, WithQR(10, 10), hashOpt, WithSeed(1))
+		b, _ := NewWithOptions(0, WithQR(10, 10), hashOpt, WithSeed(2))
+		name := a.Params().Hash
+		if a.Params().Seed != 1 || a.Params() == b.Params() {
+			t.Fatal(name, "Params do not report the seed", a.Params())
+		}
+		// keys engineered to land in one cluster with seed 1 spread out with seed 2.
+		var crafted []string
+		for i := 0; len(crafted) < 200; i++ {
+			if k := fmt.Sprint("attack", i); a.Fingerprint64(k)>>10 == 0 {
+				crafted = append(crafted, k)
+			}
+		}
+		quotients := make(map[uint64]int)
+		for _, k := range crafted {
+			q, _ := b.Fingerprint(k)
+			if quotients[q]++; quotients[q] > 4 {
+				t.Fatal(name, "Crafted keys collide with another seed")
+			}
+		}
+		if len(quotients) < 150 {
+			t.Fatal(name, "Crafted keys land in", len(quotients), "quotients with another seed")
+		}
+
+		// the seed survives encoding, and streamed keys hash like the others.
+		if _, err := a.AddFromReader(strings.NewReader(strings.Join(crafted, "\n"))); err != nil {
+			t.Fatal(name, "Unexpected error", err)
+		}
+		a.AddNS("ns", "key")
+		enc, _ := a.MarshalBinary()
+		decoded := MustNew(4, 4)
+		if err := decoded.UnmarshalBinary(enc); err != nil || decoded.Params() != a.Params() {
+			t.Fatal(name, "Unexpected result decoding a seeded filter", err)
+		}
+		for _, k := range crafted {
+			if !decoded.Contains(k) || !a.Contains(k) {
+				t.Fatal(name, "Missing", k)
+			}
+		}
+		if !decoded.ContainsNS("ns", "key") {
+			t.Fatal(name, "Missing namespaced key")
+		}
+		if b.keyHash(a.NewKey(crafted[0])) != b.hash([]byte(crafted[0])) {
+			t.Fatal(name, "Key hashed with another seed was not rehashed")
+		}
+
+		// seed 0 is the unseeded hash function.
+		zero, _ := NewWithOptions(0, WithQR(10, 10), hashOpt, WithSeed(0))
+		plain, _ := NewWithOptions(0, WithQR(10, 10), hashOpt)
+		if zero.Params() != plain.Params() || zero.Fingerprint64("key") != plain.Fingerprint64("key") {
+			t.Fatal(name, "Seed 0 differs from the unseeded hash function")
+		}
+	}
+
+	random := WithRandomSeed()
+	s, err := NewSharded(4, 1000, random)
+	if err != nil || s.shards[0].qf.Params().Seed == 0 {
+		t.Fatal("Unexpected result sharding with a random seed", err)
+	}
+	other, _ := NewWithOptions(1000, WithRandomSeed())
+	if other.Params().Seed == s.shards[0].qf.Params().Seed {
+		t.Fatal("Random seeds are equal")
+	}
+
+	custom := WithHash(func() hash.Hash64 { return fnv.New64() })
+	for _, opt := range []Option{custom, WithMaphash()} {
+		if _, err := NewWithOptions(1000, opt, WithSeed(1)); err == nil {
+			t.Fatal("Seeded a hash function that can't be seeded")
+		}
+	}
+	bad, _ := NewWithOptions(0, WithQR(4, 4), WithSeed(1))
+	bad.hashSeed = "short"
+	enc, _ := bad.MarshalBinary()
+	if err := MustNew(4, 4).UnmarshalBinary(enc); !errors.Is(err, ErrInvalidEncoding) {
+		t.Fatal("Expected ErrInvalidEncoding decoding a bad seed, got", err)
+	}
+}
@@ -0,0 +1,253 @@
+package qf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/bits"
+	"sync"
+)
+
+// The encoding of a Sharded filter is a header followed by the binary encodings of the
+// shards in order, each as written by WriteTo:
+//
+//	magic    "QFSH"
+//	version  2 bytes, 1
+//	shards   4 bytes, a power of two
+const (
+	shardedMagic   = "QFSH"
+	shardedVersion = 1
+	// maxShards is the largest number of shards of a Sharded filter.
+	maxShards = 1 << 16
+)
+
+// Sharded is a filter split into independent shards, each with its own lock, so that keys
+// can be added from many goroutines in parallel. Keys are routed to a shard by the top bits
+// of their hash, which the shards don't store, so each shard holds a random part of the keys
+// with the false positive rate of its own fill rate. Sharded is safe for concurrent use,
+// unless its hash constructor returns one shared instance like the hash of NewHash.
+type Sharded struct {
+	shards []shard
+	// shift leaves the top bits of a hash that select the shard.
+	shift uint8
+	// options the shards are created with, used again for decoding.
+	opts []Option
+}
+
+type shard struct {
+	mu sync.RWMutex
+	qf *QuotientFilter
+	// keeps the locks of the shards on separate cache lines.
+	_ [64]byte
+}
+
+// NewSharded returns a filter of shards shards, a power of two, together sized like
+// NewWithOptions(capacity, opts...): each shard is created with the options for its part of
+// the capacity. The quotient and remainder bits of the shards and the bits selecting the
+// shard have to fit in the 64 bit hash.
+func NewSharded(shards, capacity int, opts ...Option) (*Sharded, error) {
+	if shards < 1 || shards > maxShards || shards&(shards-1) != 0 {
+		return nil, fmt.Errorf("shards %d has to be a power of two up to %d", shards, maxShards)
+	}
+	s := &Sharded{shards: make([]shard, shards), shift: uint8(64 - bits.TrailingZeros(uint(shards))), opts: opts}
+	for i := range s.shards {
+		qf, err := NewWithOptions((capacity+shards-1)/shards, opts...)
+		if err != nil {
+			return nil, err
+		}
+		if qf.rehashKeys != nil {
+			return nil, errors.New("sharded filters can't be rebuilt with WithRehash")
+		}
+		s.shards[i].qf = qf
+	}
+	if err := s.check(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// check returns an error if the shards differ or the bits selecting one overlap their
+// fingerprints.
+func (s *Sharded) check() error {
+	p := s.shards[0].qf.Params()
+	if int(p.Q)+int(p.R)+64-int(s.shift) > 64 {
+		return fmt.Errorf("q + r %d and %d bits selecting one of %d shards don't fit in 64 bits", p.Q+p.R, 64-s.shift, len(s.shards))
+	}
+	for i := range s.shards {
+		if q := s.shards[i].qf.Params(); q != p || s.shards[i].qf.hashSeed != s.shards[0].qf.hashSeed {
+			return fmt.Errorf("shard %d differs from shard 0: %+v and %+v", i, q, p)
+		}
+	}
+	return nil
+}
+
+// shard returns the shard of a key with hash h.
+func (s *Sharded) shard(h uint64) *shard {
+	if s.shift == 64 {
+		return &s.shards[0]
+	}
+	return &s.shards[h>>s.shift]
+}
+
+// Add adds the key to its shard. It returns ErrFull if the shard of the key is full, the
+// other shards can still have room.
+func (s *Sharded) Add(key string) error {
+	return s.AddHash(s.shards[0].qf.hashString(key))
+}
+
+// AddBytes adds the key to its shard, it is the []byte equivalent of Add.
+func (s *Sharded) AddBytes(key []byte) error {
+	return s.AddHash(s.shards[0].qf.hash(key))
+}
+
+// AddHash adds a key that has already been hashed to h, see QuotientFilter.AddHash. The top
+// bits of h select the shard.
+func (s *Sharded) AddHash(h uint64) error {
+	sh := s.shard(h)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	return sh.qf.AddHash(h)
+}
+
+// Contains checks if key is present in its shard.
+func (s *Sharded) Contains(key string) bool {
+	return s.ContainsHash(s.shards[0].qf.hashString(key))
+}
+
+// ContainsBytes checks if key is present in its shard, it is the []byte equivalent of Contains.
+func (s *Sharded) ContainsBytes(key []byte) bool {
+	return s.ContainsHash(s.shards[0].qf.hash(key))
+}
+
+// ContainsHash checks if a key with the 64 bit hash h is present in its shard.
+func (s *Sharded) ContainsHash(h uint64) bool {
+	sh := s.shard(h)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+	return sh.qf.ContainsHash(h)
+}
+
+// Delete removes the key from its shard and reports whether it was found.
+func (s *Sharded) Delete(key string) bool {
+	h := s.shards[0].qf.hashString(key)
+	sh := s.shard(h)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	return sh.qf.deleteHash(h)
+}
+
+// Len returns the number of fingerprints in all shards. Keys added concurrently may or may
+// not be counted.
+func (s *Sharded) Len() uint64 {
+	var n uint64
+	for i := range s.shards {
+		sh := &s.shards[i]
+		sh.mu.RLock()
+		n += sh.qf.Len()
+		sh.mu.RUnlock()
+	}
+	return n
+}
+
+// Cap returns the number of slots of all shards.
+func (s *Sharded) Cap() uint64 {
+	return uint64(len(s.shards)) * s.shards[0].qf.Cap()
+}
+
+// Shards returns the number of shards.
+func (s *Sharded) Shards() int {
+	return len(s.shards)
+}
+
+// WriteTo writes the filter to w, the header followed by each shard in the binary format of
+// MarshalBinary, and returns the number of bytes written. Each shard is locked while it is
+// written, so keys added concurrently may or may not be written.
+func (s *Sharded) WriteTo(w io.Writer) (int64, error) {
+	var head [10]byte
+	copy(head[:], shardedMagic)
+	binary.LittleEndian.PutUint16(head[4:], shardedVersion)
+	binary.LittleEndian.PutUint32(head[6:], uint32(len(s.shards)))
+	written, err := writeFull(w, head[:])
+	if err != nil {
+		return written, err
+	}
+	for i := range s.shards {
+		sh := &s.shards[i]
+		sh.mu.RLock()
+		n, err := sh.qf.WriteTo(w)
+		sh.mu.RUnlock()
+		written += n
+		if err != nil {
+			return written, fmt.Errorf("shard %d: %w", i, err)
+		}
+	}
+	return written, nil
+}
+
+// ReadFrom replaces the filter with one written by WriteTo once all shards have been read,
+// and returns the number of bytes read. Like decoding a QuotientFilter, filters with a custom
+// hash or a key transformer have to be decoded into a Sharded filter created with the same
+// ones, a zero Sharded decodes filters with the default hash. On errors the filter is left
+// unchanged. ReadFrom must not run concurrently with other methods.
+func (s *Sharded) ReadFrom(r io.Reader) (int64, error) {
+	var head [10]byte
+	n, err := io.ReadFull(r, head[:])
+	read := int64(n)
+	switch {
+	case err == io.EOF || err == io.ErrUnexpectedEOF:
+		return read, fmt.Errorf("%w: unexpected end of data", ErrInvalidEncoding)
+	case err != nil:
+		return read, err
+	case string(head[:4]) != shardedMagic:
+		return read, fmt.Errorf("%w: not a sharded filter", ErrInvalidEncoding)
+	case binary.LittleEndian.Uint16(head[4:]) != shardedVersion:
+		return read, fmt.Errorf("%w: %w %d", ErrInvalidEncoding, ErrUnsupportedVersion, binary.LittleEndian.Uint16(head[4:]))
+	}
+	count := binary.LittleEndian.Uint32(head[6:])
+	if count < 1 || count > maxShards || count&(count-1) != 0 {
+		return read, fmt.Errorf("%w: %d shards", ErrInvalidEncoding, count)
+	}
+	decoded := &Sharded{shift: uint8(64 - bits.TrailingZeros32(count)), opts: s.opts}
+	// the shards are appended as they are decoded, so that a short stream can't force a
+	// large allocation.
+	for i := 0; i < int(count); i++ {
+		qf, err := decodeTarget(s.opts)
+		if err != nil {
+			return read, err
+		}
+		n, err := qf.ReadFrom(r)
+		read += n
+		if err != nil {
+			return read, fmt.Errorf("shard %d: %w", i, err)
+		}
+		decoded.shards = append(decoded.shards, shard{qf: qf})
+	}
+	if err := decoded.check(); err != nil {
+		return read, fmt.Errorf("%w: %v", ErrInvalidEncoding, err)
+	}
+	s.shards, s.shift = decoded.shards, decoded.shift
+	return read, nil
+}
+
+// MarshalBinary encodes the filter in the format of WriteTo.
+func (s *Sharded) MarshalBinary() ([]byte, error) {
+	var buf bytes.Buffer
+	if _, err := s.WriteTo(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// UnmarshalBinary replaces the filter with one encoded by MarshalBinary, see ReadFrom.
+func (s *Sharded) UnmarshalBinary(data []byte) error {
+	r := bytes.NewReader(data)
+	if _, err := s.ReadFrom(r); err != nil {
+		return err
+	}
+	if r.Len() > 0 {
+		return fmt.Errorf("%w: %d bytes after the filter", ErrInvalidEncoding, r.Len())
+	}
+	return nil
+}
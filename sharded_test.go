@@ -0,0 +1,171 @@
+package qf
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/fnv"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestSharded(t *testing.T) {
+	s, err := NewSharded(8, 100000)
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	if s.Shards() != 8 || s.Cap() < 100000 {
+		t.Fatal("Sharded filter of", s.Shards(), "shards and", s.Cap(), "slots")
+	}
+	items := randomItems(40000)
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := g; i < len(items); i += 8 {
+				if err := s.Add(items[i]); err != nil {
+					t.Error("Unexpected error", err)
+					return
+				}
+				if !s.Contains(items[i]) {
+					t.Error("Key just added is missing", items[i])
+					return
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	var n uint64
+	for i := range s.shards {
+		l := s.shards[i].qf.Len()
+		if l < 4000 || l > 6000 {
+			t.Fatal("Shard", i, "holds", l, "of", len(items), "keys")
+		}
+		n += l
+	}
+	if s.Len() != n {
+		t.Fatal("Len", s.Len(), "want", n)
+	}
+	for _, k := range items {
+		if !s.Contains(k) || !s.ContainsBytes([]byte(k)) {
+			t.Fatal("Missing", k)
+		}
+	}
+	if !s.Delete(items[0]) || s.Contains(items[0]) {
+		t.Fatal("Delete did not remove", items[0])
+	}
+	if err := s.AddBytes([]byte(items[0])); err != nil || !s.Contains(items[0]) {
+		t.Fatal("AddBytes returned", err)
+	}
+
+	// shards fill up on their own.
+	small, _ := NewSharded(4, 0, WithQR(4, 8))
+	full := 0
+	for i := 0; i < 200; i++ {
+		if err := small.Add(fmt.Sprint(i)); errors.Is(err, ErrFull) {
+			full++
+		} else if err != nil {
+			t.Fatal("Unexpected error", err)
+		}
+	}
+	if full == 0 || small.Len() > small.Cap() {
+		t.Fatal(full, "keys did not fit,", small.Len(), "of", small.Cap(), "slots used")
+	}
+}
+
+func TestShardedEncoding(t *testing.T) {
+	s, _ := NewSharded(4, 10000)
+	items := randomItems(5000)
+	for _, k := range items {
+		s.Add(k)
+	}
+	b, err := s.MarshalBinary()
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	var decoded Sharded
+	if err := decoded.UnmarshalBinary(b); err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	if decoded.Shards() != 4 || decoded.Len() != s.Len() {
+		t.Fatal("Decoded", decoded.Shards(), "shards and", decoded.Len(), "keys")
+	}
+	for _, k := range items {
+		if !decoded.Contains(k) {
+			t.Fatal("Missing", k)
+		}
+	}
+	if again, _ := decoded.MarshalBinary(); !bytes.Equal(again, b) {
+		t.Fatal("Encoding changed by decoding")
+	}
+
+	newHash := func() hash.Hash64 { return fnv.New64() }
+	custom, _ := NewSharded(2, 100, WithNamedHash("fnv64", nil, newHash))
+	custom.Add("key")
+	encoded, _ := custom.MarshalBinary()
+	if err := new(Sharded).UnmarshalBinary(encoded); !errors.Is(err, ErrHashMismatch) {
+		t.Fatal("Decoding a custom hash into a zero Sharded returned", err)
+	}
+	target, _ := NewSharded(1, 10, WithNamedHash("fnv64", nil, newHash))
+	if err := target.UnmarshalBinary(encoded); err != nil || !target.Contains("key") || target.Shards() != 2 {
+		t.Fatal("Decoding into a Sharded with the same hash returned", err)
+	}
+
+	for _, c := range []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"magic", append([]byte("QFGO"), b[4:]...)},
+		{"shards", append(append([]byte(nil), b[:6]...), 3, 0, 0, 0)},
+		{"truncated", b[:len(b)-1]},
+		{"trailing", append(append([]byte(nil), b...), 0)},
+	} {
+		if err := decoded.UnmarshalBinary(c.data); !errors.Is(err, ErrInvalidEncoding) {
+			t.Fatal(c.name, "returned", err)
+		}
+	}
+	if decoded.Shards() != 4 || !decoded.Contains(items[0]) {
+		t.Fatal("Failed decoding changed the filter")
+	}
+	for _, n := range []int{0, 3, -1} {
+		if _, err := NewSharded(n, 100); err == nil {
+			t.Fatal(n, "shards were accepted")
+		}
+	}
+	if _, err := NewSharded(4, 0, WithQR(40, 24)); err == nil {
+		t.Fatal("Shard bits overlapping the fingerprint were accepted")
+	}
+}
+
+// benchmarkParallelAdd adds keys from goroutines goroutines in parallel.
+func benchmarkParallelAdd(b *testing.B, add func(key string) error) {
+	items := randomItems(1 << 20)
+	var next atomic.Uint64
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			add(items[next.Add(1)&(1<<20-1)])
+		}
+	})
+}
+
+func BenchmarkShardedAdd(b *testing.B) {
+	for _, goroutines := range []int{8, 16, 32} {
+		b.Run(fmt.Sprint("goroutines=", goroutines), func(b *testing.B) {
+			b.SetParallelism(max(1, goroutines/runtime.GOMAXPROCS(0)))
+			s, _ := NewSharded(64, 1<<21)
+			benchmarkParallelAdd(b, s.Add)
+		})
+		b.Run(fmt.Sprint("mutex/goroutines=", goroutines), func(b *testing.B) {
+			b.SetParallelism(max(1, goroutines/runtime.GOMAXPROCS(0)))
+			qf, _ := NewWithOptions(1 << 21)
+			benchmarkParallelAdd(b, NewSafe(qf).Add)
+		})
+	}
+}
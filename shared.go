@@ -0,0 +1,152 @@
+package qf
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"sync/atomic"
+	"unsafe"
+)
+
+// A shared memory segment holds a filter written by one process and read by others, see
+// NewShared and AttachReadOnly. The counters are in the byte order of the host:
+//
+//	magic       "QFSM"
+//	q, r        1 byte each
+//	padding     2 bytes
+//	generation  8 bytes, odd while the writer updates the table
+//	len         8 bytes, fingerprints in the table
+//	data        BufferSize(q, r) bytes, the data section of the binary encoding
+const (
+	sharedMagic     = "QFSM"
+	sharedHeaderLen = 24
+)
+
+// seqlock points to the generation counter of lookups without locks and the published len,
+// in the header of a shared memory segment or allocated for WithOptimisticReads.
+type seqlock struct {
+	gen *uint64
+	len *uint64
+}
+
+// SharedSize returns the size of the shared memory segment NewShared needs for q and r.
+func SharedSize(q, r uint8) (int, error) {
+	size, err := BufferSize(q, r)
+	if err != nil {
+		return 0, err
+	}
+	return sharedHeaderLen + size, nil
+}
+
+// NewShared returns a filter with q quotient and r remainder bits kept in buf, a shared memory
+// segment of exactly SharedSize(q, r) bytes such as a MAP_SHARED mapping of a file in
+// /dev/shm, which is cleared. Other processes mapping the segment open it with AttachReadOnly.
+// The filter has the default hash function and max load factor and no stash. Adding and
+// deleting keys make the generation counter in the header odd while the table is updated, so
+// that readers can tell, and the data words are read and written atomically, like those of
+// WithOptimisticReads. Reported false positives and the namespaces are not in the segment,
+// and decoding into the filter replaces it with one with optimistic reads that no longer uses
+// the segment.
+//
+// One process writes the segment, the filter is no more thread safe than any other. buf has
+// to be 8 byte aligned, which mappings are.
+func NewShared(q, r uint8, buf []byte) (*QuotientFilter, error) {
+	size, err := SharedSize(q, r)
+	if err != nil {
+		return nil, err
+	}
+	if len(buf) != size {
+		return nil, fmt.Errorf("shared memory segment of %d bytes, q %d and r %d need %d", len(buf), q, r, size)
+	}
+	if uintptr(unsafe.Pointer(&buf[0]))%8 != 0 {
+		return nil, errors.New("shared memory segment is not 8 byte aligned")
+	}
+	qf, err := NewFromBuffer(q, r, buf[sharedHeaderLen:])
+	if err != nil {
+		return nil, err
+	}
+	clear(buf[:sharedHeaderLen])
+	buf[4], buf[5] = q, r
+	qf.seq, qf.atomicWords = newSharedHeader(buf), true
+	// the magic comes last, so that a reader attaching early does not see a segment in use.
+	copy(buf, sharedMagic)
+	return qf, nil
+}
+
+// AttachReadOnly returns a read-only filter over buf, a shared memory segment written by a
+// filter created with NewShared in this or another process. Lookups read the table straight
+// from the segment and see the keys added until then. A lookup that overlaps an update of the
+// table by the writer is retried, so lookups never see a table in the middle of an update.
+// Like the lookups of any filter they write nothing, so any number of goroutines can call
+// them concurrently, and they read the data words atomically. Len reads the current count
+// from the segment, the other statistics are of the time the filter was attached.
+//
+// The header is validated, the table is not. Methods reading the whole table, MarshalBinary
+// or Clone, don't check for updates and should only be used while the writer is idle. If the
+// writer dies in the middle of an update, lookups wait for it forever.
+func AttachReadOnly(buf []byte) (*QuotientFilter, error) {
+	if len(buf) < sharedHeaderLen || string(buf[:4]) != sharedMagic {
+		return nil, fmt.Errorf("%w: not a shared memory segment of a filter", ErrInvalidEncoding)
+	}
+	q, r := buf[4], buf[5]
+	if err := validateQR(q, r); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidEncoding, err)
+	}
+	if size, _ := SharedSize(q, r); len(buf) != size {
+		return nil, fmt.Errorf("%w: shared memory segment of %d bytes, q %d and r %d need %d", ErrInvalidEncoding, len(buf), q, r, size)
+	}
+	if uintptr(unsafe.Pointer(&buf[0]))%8 != 0 {
+		return nil, errors.New("shared memory segment is not 8 byte aligned")
+	}
+	c := defaultConfig()
+	c.q, c.r, c.noData = q, r, true
+	qf := newFilter(c)
+	qf.data, qf.readOnly = buf[sharedHeaderLen:], true
+	qf.seq, qf.atomicWords = newSharedHeader(buf), true
+	qf.len = atomic.LoadUint64(qf.seq.len)
+	if qf.len > qf.cap {
+		return nil, fmt.Errorf("%w: len %d is more than q %d allows", ErrInvalidEncoding, qf.len, q)
+	}
+	return qf, nil
+}
+
+// optimistic reports whether lookups and Len run without locks, with the writer of the
+// filter updating it concurrently.
+func (qf *QuotientFilter) optimistic() bool {
+	return qf.seq != nil && (qf.readOnly || qf.atomicWords)
+}
+
+func newSharedHeader(buf []byte) *seqlock {
+	return &seqlock{gen: (*uint64)(unsafe.Pointer(&buf[8])), len: (*uint64)(unsafe.Pointer(&buf[16]))}
+}
+
+// beginUpdate makes the generation odd before the writer modifies the table.
+func (qf *QuotientFilter) beginUpdate() {
+	if qf.seq != nil {
+		atomic.AddUint64(qf.seq.gen, 1)
+	}
+}
+
+// endUpdate publishes len and makes the generation even again.
+func (qf *QuotientFilter) endUpdate() {
+	qf.publishLen()
+	if qf.seq != nil {
+		atomic.AddUint64(qf.seq.gen, 1)
+	}
+}
+
+// read returns what probe returns for a table no update was in progress on, probing again
+// while the writer updates it. The probe may see a table in the middle of an update, see
+// QuotientFilter.probe.
+func (s *seqlock) read(probe func() bool) bool {
+	for {
+		gen := atomic.LoadUint64(s.gen)
+		if gen&1 == 0 {
+			found := probe()
+			if atomic.LoadUint64(s.gen) == gen {
+				return found
+			}
+		}
+		runtime.Gosched()
+	}
+}
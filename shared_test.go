@@ -0,0 +1,100 @@
+package qf
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestShared(t *testing.T) {
+	size, _ := SharedSize(12, 9)
+	buf := make([]byte, size)
+	writer, err := NewShared(12, 9, buf)
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	plain := MustNew(12, 9)
+	items := randomItems(2000)
+	writer.AddAll(items[:1000])
+	plain.AddAll(items[:1000])
+	writer.AddNS("users", "42")
+	reader, err := AttachReadOnly(buf)
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	if reader.Len() != writer.Len() || reader.Params() != plain.Params() {
+		t.Fatal("Attached filter differs", reader.Len(), writer.Len())
+	}
+	// the segment is written and read concurrently, one word at a time.
+	if !writer.atomicWords || !reader.atomicWords {
+		t.Fatal("The data words of the segment are not accessed atomically")
+	}
+	// the inline hash is the one of ordinary filters.
+	writer.AddUint64(7)
+	plain.AddUint64(7)
+	for _, s := range append(items, randomItems(3000)...) {
+		if reader.Contains(s) != writer.Contains(s) || writer.Contains(s) != plain.Contains(s) {
+			t.Fatal("Membership differs for", s)
+		}
+	}
+	if !reader.ContainsNS("users", "42") || !reader.ContainsUint64(7) {
+		t.Fatal("Keys missing from the attached filter")
+	}
+
+	// the reader follows the writer.
+	writer.AddAll(items[1000:])
+	writer.Delete(items[0])
+	if !reader.ContainsAll(items[1000:]) || reader.Len() != writer.Len() {
+		t.Fatal("Attached filter does not see the updates")
+	}
+	if err := reader.Add("fox"); !errors.Is(err, ErrReadOnly) {
+		t.Fatal("Expected ErrReadOnly, got", err)
+	}
+	diff, _ := plain.Diff(writer.Checkpoint())
+	if err := writer.ApplyDiff(diff); err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	if !reader.ContainsAll(items[:1000]) || reader.Contains(items[1500]) || reader.Len() != plain.Len() {
+		t.Fatal("Attached filter does not see the applied diff")
+	}
+	writer.Reset()
+	if reader.Len() != 0 || reader.Contains(items[1]) {
+		t.Fatal("Attached filter does not see the reset")
+	}
+	clone := writer.Clone()
+	clone.Add("fox")
+	if reader.Contains("fox") {
+		t.Fatal("Clone writes the segment")
+	}
+}
+
+func TestSharedInvalid(t *testing.T) {
+	size, _ := SharedSize(8, 8)
+	if _, err := NewShared(8, 8, make([]byte, size-1)); err == nil {
+		t.Fatal("Expected an error for a short segment")
+	}
+	if _, err := NewShared(8, 8, make([]byte, size+1)[1:]); err == nil {
+		t.Fatal("Expected an error for an unaligned segment")
+	}
+	buf := make([]byte, size)
+	NewShared(8, 8, buf)
+	corrupt := func(i int, b byte) []byte {
+		c := append([]byte(nil), buf...)
+		c[i] = b
+		return c
+	}
+	for _, test := range []struct {
+		Name string
+		Data []byte
+	}{
+		{"empty", nil},
+		{"magic", corrupt(0, 'X')},
+		{"q", corrupt(4, 1)},
+		{"r", corrupt(5, 9)},
+		{"truncated", buf[:size-8]},
+		{"len", corrupt(23, 1)},
+	} {
+		if _, err := AttachReadOnly(test.Data); !errors.Is(err, ErrInvalidEncoding) {
+			t.Fatal(test.Name, "expected ErrInvalidEncoding, got", err)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+//go:build unix
+
+package qf
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+)
+
+// TestSharedMmap runs a writer and a reader over two mappings of one file, like two processes
+// sharing a segment in /dev/shm.
+func TestSharedMmap(t *testing.T) {
+	size, _ := SharedSize(14, 30)
+	path := filepath.Join(t.TempDir(), "segment")
+	f, _ := os.Create(path)
+	defer f.Close()
+	f.Truncate(int64(size))
+	mapping := func() []byte {
+		b, err := syscall.Mmap(int(f.Fd()), 0, size, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED)
+		if err != nil {
+			t.Fatal("Unexpected error", err)
+		}
+		t.Cleanup(func() { syscall.Munmap(b) })
+		return b
+	}
+	writer, err := NewShared(14, 30, mapping())
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	reader, err := AttachReadOnly(mapping())
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+
+	// the writer adds keys and deletes others, the reader has to find every key added before
+	// it looks, whatever the writer is shifting at the time.
+	items := randomItems(12000)
+	var added atomic.Int64
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		churn := randomItems(2000)
+		for i, s := range items {
+			if err := writer.Add(s); err != nil {
+				t.Error("Unexpected error", err)
+				return
+			}
+			added.Store(int64(i + 1))
+			writer.Add(churn[i%len(churn)])
+			writer.Delete(churn[(i+1000)%len(churn)])
+		}
+	}()
+	for n := 0; n < len(items); {
+		n = int(added.Load())
+		for _, s := range items[max(0, n-500):n] {
+			if !reader.Contains(s) {
+				t.Fatal("Key added by the writer missing")
+			}
+		}
+	}
+	wg.Wait()
+	if reader.Len() != writer.Len() || !reader.ContainsAll(items) {
+		t.Fatal("Attached filter differs from the writer")
+	}
+}
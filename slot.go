@@ -1,5 +1,10 @@
 package qf
 
+import (
+	"encoding/binary"
+	"math/bits"
+)
+
 type slot uint64
 
 func newSlot(remainder uint64) slot {
@@ -52,3 +57,387 @@ func (s slot) isClusterStart() bool {
 func (s slot) isRunStart() bool {
 	return s.isContinuation() && (s.isOccupied() || s.isShifted())
 }
+
+// The slots are stored in blocks of blockSlots consecutive slots, or of all slots of tables
+// with fewer. A block of n slots holds n is_occupied bits, n is_continuation bits and n
+// is_shifted bits, followed by the n remainders, r bits each, least significant bit first, or
+// the next of 8, 16, 32 and 64 bits with WithAlignedSlots.
+// Finding runs and clusters reads the metadata of up to 64 slots from a word without the
+// remainders. A block of 64 slots takes r + 3 whole words, as many as its slots would take
+// packed one after the other, so the table is the same size either way. With
+// WithCacheLineBlocks the blocks are padded with zero words to whole cache lines, so that
+// every block starts at one.
+const blockSlots = 64
+
+// cacheLineWords is the number of data words of a cache line, 64 bytes on the common CPUs.
+const cacheLineWords = 8
+
+// blockWords returns the number of data words holding the first n slots, n a multiple of
+// blockLen.
+func (qf *QuotientFilter) blockWords(n uint64) uint64 {
+	if qf.blockLen < blockSlots {
+		return (n*(uint64(qf.rwidth)+3) + 63) / 64
+	}
+	return n / blockSlots * qf.stride
+}
+
+// makeData returns n zero bytes for the data words, starting at a cache line with
+// cacheLineBlocks.
+func (qf *QuotientFilter) makeData(n uint64) []byte {
+	if qf.cacheLineBlocks {
+		return alignedBytes(n)
+	}
+	return make([]byte, n)
+}
+
+// alignData moves decoded data words to a cache line boundary if the blocks are to start at
+// cache lines and the data doesn't.
+func (qf *QuotientFilter) alignData() {
+	if qf.cacheLineBlocks && qf.sparse == nil && !isAligned(qf.data) {
+		data := alignedBytes(uint64(len(qf.data)))
+		copy(data, qf.data)
+		qf.data = data
+	}
+}
+
+// The metadata bits, in the order of the bits of a slot and of the bit vectors of a block.
+const (
+	metaOccupied = iota
+	metaContinuation
+	metaShifted
+)
+
+// getSlot returns the slot at index. The slots of blocks of 64 slots are read from the words
+// of the block directly, unless the words are to be read atomically. The slots of the pages
+// of sparse data that aren't allocated are empty.
+func (qf *QuotientFilter) getSlot(index uint64) slot {
+	if qf.blockLen < blockSlots || qf.atomicWords {
+		return slot(qf.remainder(index)<<3) | qf.metaBits(index)
+	}
+	w, j := qf.blockWord(index)
+	var d []byte
+	if qf.sparse == nil {
+		d = qf.data[w*8:]
+	} else if d = qf.sparse.page(w); d == nil {
+		return 0
+	}
+	s := binary.LittleEndian.Uint64(d)>>j&1 | binary.LittleEndian.Uint64(d[8:])>>j&1<<1 | binary.LittleEndian.Uint64(d[16:])>>j&1<<2
+	// remainders of 8, 16, 32 and 64 bits are whole bytes of the block.
+	var r uint64
+	switch off := 3*8 + j*uint64(qf.rwidth)/8; qf.rwidth {
+	case 8:
+		r = uint64(d[off])
+	case 16:
+		r = uint64(binary.LittleEndian.Uint16(d[off:]))
+	case 32:
+		r = uint64(binary.LittleEndian.Uint32(d[off:]))
+	case 64:
+		r = binary.LittleEndian.Uint64(d[off:])
+	default:
+		pos := 3*blockSlots + j*uint64(qf.rbits)
+		d, b := d[pos/64*8:], pos%64
+		r = binary.LittleEndian.Uint64(d) >> b
+		if b+uint64(qf.rbits) > 64 {
+			r |= binary.LittleEndian.Uint64(d[8:]) << (64 - b)
+		}
+	}
+	return slot(r&qf.rMask<<3 | s)
+}
+
+// setSlot sets the slot at index to s. Like getSlot, the words of blocks of 64 slots are
+// written directly, unless they are to be written atomically or marked dirty, allocating the
+// page of sparse data.
+func (qf *QuotientFilter) setSlot(index uint64, s slot) {
+	if qf.blockLen < blockSlots || qf.atomicWords || qf.dirty != nil {
+		qf.setSlotWords(index, s)
+		return
+	}
+	w, j := qf.blockWord(index)
+	var d []byte
+	if qf.sparse == nil {
+		d = qf.data[w*8:]
+	} else {
+		d = qf.sparse.writablePage(w)
+	}
+	for kind := range uint64(3) {
+		m := d[kind*8:]
+		binary.LittleEndian.PutUint64(m, binary.LittleEndian.Uint64(m)&^(1<<j)|uint64(s)>>kind&1<<j)
+	}
+	r := s.remainder() & qf.rMask
+	switch off := 3*8 + j*uint64(qf.rwidth)/8; qf.rwidth {
+	case 8:
+		d[off] = byte(r)
+	case 16:
+		binary.LittleEndian.PutUint16(d[off:], uint16(r))
+	case 32:
+		binary.LittleEndian.PutUint32(d[off:], uint32(r))
+	case 64:
+		binary.LittleEndian.PutUint64(d[off:], r)
+	default:
+		pos := 3*blockSlots + j*uint64(qf.rbits)
+		d, b := d[pos/64*8:], pos%64
+		binary.LittleEndian.PutUint64(d, binary.LittleEndian.Uint64(d)&^(qf.rMask<<b)|r<<b)
+		if b+uint64(qf.rbits) > 64 {
+			binary.LittleEndian.PutUint64(d[8:], binary.LittleEndian.Uint64(d[8:])&^(qf.rMask>>(64-b))|r>>(64-b))
+		}
+	}
+}
+
+// setSlotWords is setSlot through word and setWord.
+func (qf *QuotientFilter) setSlotWords(index uint64, s slot) {
+	if qf.blockLen == blockSlots {
+		// the bit vectors of a block of 64 slots are a word each.
+		w, b := qf.metaBit(index, metaOccupied)
+		for kind := range uint64(3) {
+			qf.setWord(w+kind, qf.word(w+kind)&^(1<<b)|uint64(s)>>kind&1<<b)
+		}
+	} else {
+		for kind := range uint64(3) {
+			qf.setMeta(index, kind, s>>kind&1 == 1)
+		}
+	}
+	qf.setRemainder(index, s.remainder())
+}
+
+// metaBit returns the data word holding the metadata bit kind of the slot at index and the
+// position of the bit in the word.
+func (qf *QuotientFilter) metaBit(index, kind uint64) (word, bit uint64) {
+	pos := kind*qf.blockLen + index%blockSlots
+	return index/blockSlots*qf.stride + pos/64, pos % 64
+}
+
+// meta reports whether the metadata bit kind of the slot at index is set.
+func (qf *QuotientFilter) meta(index, kind uint64) bool {
+	w, b := qf.metaBit(index, kind)
+	return qf.word(w)>>b&1 == 1
+}
+
+func (qf *QuotientFilter) setMeta(index, kind uint64, set bool) {
+	w, b := qf.metaBit(index, kind)
+	word := qf.word(w) &^ (1 << b)
+	if set {
+		word |= 1 << b
+	}
+	qf.setWord(w, word)
+}
+
+func (qf *QuotientFilter) isOccupied(index uint64) bool {
+	return qf.meta(index, metaOccupied)
+}
+
+func (qf *QuotientFilter) isContinuation(index uint64) bool {
+	return qf.meta(index, metaContinuation)
+}
+
+func (qf *QuotientFilter) isShifted(index uint64) bool {
+	return qf.meta(index, metaShifted)
+}
+
+// metaBits returns the metadata bits of the slot at index, the slot without its remainder.
+func (qf *QuotientFilter) metaBits(index uint64) slot {
+	if qf.blockLen == blockSlots {
+		w, b := qf.metaBit(index, metaOccupied)
+		return slot(qf.word(w)>>b&1 | qf.word(w+1)>>b&1<<1 | qf.word(w+2)>>b&1<<2)
+	}
+	var s slot
+	for kind := range uint64(3) {
+		if qf.meta(index, kind) {
+			s |= 1 << kind
+		}
+	}
+	return s
+}
+
+// isEmpty reports whether the slot at index is empty, reading its metadata alone.
+func (qf *QuotientFilter) isEmpty(index uint64) bool {
+	return qf.metaBits(index) == 0
+}
+
+// metaWord returns the metadata bits kind of the block of the slot at index, bit j for the slot
+// j of the block. The bits of a block of fewer than 64 slots don't straddle two words, as
+// blockLen is a power of two.
+func (qf *QuotientFilter) metaWord(index, kind uint64) uint64 {
+	if qf.blockLen == blockSlots {
+		return qf.word(index/blockSlots*qf.stride + kind)
+	}
+	w, b := qf.metaBit(index&^(blockSlots-1), kind)
+	return qf.word(w) >> b & maskLower(qf.blockLen)
+}
+
+// usedWord returns the bits of the used slots of the block of the slot at index, see metaWord.
+func (qf *QuotientFilter) usedWord(index uint64) uint64 {
+	return qf.metaWord(index, metaOccupied) | qf.metaWord(index, metaContinuation) | qf.metaWord(index, metaShifted)
+}
+
+// nextEmpty returns the first empty slot from index on, wrapping around the end of the table,
+// reading the metadata words of a block at a time. The table must have an empty slot.
+func (qf *QuotientFilter) nextEmpty(index uint64) uint64 {
+	for {
+		j := index % blockSlots
+		if empty := ^qf.usedWord(index) & maskLower(qf.blockLen) &^ maskLower(j); empty != 0 {
+			return index - j + uint64(bits.TrailingZeros64(empty))
+		}
+		index = (index - j + qf.blockLen) & qf.qMask
+	}
+}
+
+// nextMatch returns the first slot from index on, wrapping around the end of the table, whose
+// bit is set in the word match returns for the block of a slot, see metaWord. A slot of the
+// table has to match.
+func (qf *QuotientFilter) nextMatch(index uint64, match func(index uint64) uint64) uint64 {
+	for {
+		j := index % blockSlots
+		if set := match(index) &^ maskLower(j); set != 0 {
+			return index - j + uint64(bits.TrailingZeros64(set))
+		}
+		index = (index - j + qf.blockLen) & qf.qMask
+	}
+}
+
+// nextUsed returns the first used slot from index on, wrapping around the end of the table.
+// The table must have a used slot.
+func (qf *QuotientFilter) nextUsed(index uint64) uint64 {
+	return qf.nextMatch(index, qf.usedWord)
+}
+
+// nextOccupied returns the first occupied quotient from index on, wrapping around the end of
+// the table. The table must have an occupied quotient.
+func (qf *QuotientFilter) nextOccupied(index uint64) uint64 {
+	return qf.nextMatch(index, func(index uint64) uint64 {
+		return qf.metaWord(index, metaOccupied)
+	})
+}
+
+// nextClusterStart returns the first slot from index on that starts a cluster, occupied and not
+// shifted, wrapping around the end of the table. The table must have a used slot.
+func (qf *QuotientFilter) nextClusterStart(index uint64) uint64 {
+	return qf.nextMatch(index, func(index uint64) uint64 {
+		return qf.metaWord(index, metaOccupied) &^ qf.metaWord(index, metaShifted)
+	})
+}
+
+// lastUnshifted returns the last slot up to index that isn't shifted, the start of the cluster
+// of index if it is used, wrapping around the start of the table. The table must have a slot
+// that isn't shifted.
+func (qf *QuotientFilter) lastUnshifted(index uint64) uint64 {
+	for {
+		j := index % blockSlots
+		if unshifted := ^qf.metaWord(index, metaShifted) & maskLower(j+1); unshifted != 0 {
+			return index - j + 63 - uint64(bits.LeadingZeros64(unshifted))
+		}
+		index = (index - j - 1) & qf.qMask
+	}
+}
+
+// countOccupied returns the number of occupied quotients from from to to-1, wrapping around the
+// end of the table.
+func (qf *QuotientFilter) countOccupied(from, to uint64) uint64 {
+	n := uint64(0)
+	for index := from; index != to; {
+		j := index % blockSlots
+		occupied := qf.metaWord(index, metaOccupied) &^ maskLower(j)
+		if d := (to - index) & qf.qMask; d <= qf.blockLen-j {
+			return n + uint64(bits.OnesCount64(occupied&maskLower(j+d)))
+		}
+		n += uint64(bits.OnesCount64(occupied))
+		index = (index - j + qf.blockLen) & qf.qMask
+	}
+	return n
+}
+
+// remainderBit returns the data word the remainder of the slot at index starts in and the
+// position of its least significant bit in the word.
+func (qf *QuotientFilter) remainderBit(index uint64) (word, bit uint64) {
+	pos := 3*qf.blockLen + index%blockSlots*uint64(qf.rwidth)
+	return index/blockSlots*qf.stride + pos/64, pos % 64
+}
+
+func (qf *QuotientFilter) remainder(index uint64) uint64 {
+	w, b := qf.remainderBit(index)
+	r := qf.word(w) >> b
+	// the remainder spans into the next word.
+	if b+uint64(qf.rbits) > 64 {
+		r |= qf.word(w+1) << (64 - b)
+	}
+	return r & qf.rMask
+}
+
+func (qf *QuotientFilter) setRemainder(index, r uint64) {
+	w, b := qf.remainderBit(index)
+	r &= qf.rMask
+	qf.setWord(w, qf.word(w)&^(qf.rMask<<b)|r<<b)
+	if b+uint64(qf.rbits) > 64 {
+		qf.setWord(w+1, qf.word(w+1)&^(qf.rMask>>(64-b))|r>>(64-b))
+	}
+}
+
+// blockWord returns the first data word of the block of the slot at index and the position of
+// the slot in the block.
+func (qf *QuotientFilter) blockWord(index uint64) (word, j uint64) {
+	return index / blockSlots * qf.stride, index % blockSlots
+}
+
+// moveUp moves the remainders and is_continuation bits of the slots from to to-1 up by one
+// slot, from is at most to, and the slots don't wrap around the end of the table. The blocks
+// are moved from the last one down with word operations, the first slot of a block takes the
+// last one of the block before it, which is moved afterwards.
+func (qf *QuotientFilter) moveUp(from, to uint64) {
+	if from == to {
+		return
+	}
+	r := uint64(qf.rwidth)
+	for first := to / blockSlots * blockSlots; ; first -= blockSlots {
+		base, _ := qf.blockWord(first)
+		ja, jb := max(from+1, first)-first, min(to, first+blockSlots-1)-first
+		// the slots ja-1 to jb-1 of the block move to ja to jb.
+		lo := max(ja, 1) - 1
+		qf.shiftUp(base, metaContinuation*qf.blockLen+lo, metaContinuation*qf.blockLen+jb, 1)
+		qf.shiftUp(base, 3*qf.blockLen+lo*r, 3*qf.blockLen+jb*r, r)
+		if ja == 0 {
+			prev := first - 1
+			qf.setRemainder(first, qf.remainder(prev))
+			qf.setMeta(first, metaContinuation, qf.isContinuation(prev))
+		}
+		if first <= from+1 {
+			return
+		}
+	}
+}
+
+// shiftUp moves the bits lo to hi-1 of the data words from word base on up by k bits, k is
+// from 1 to 64. The bits below lo+k and from hi+k on are kept.
+func (qf *QuotientFilter) shiftUp(base, lo, hi, k uint64) {
+	if lo >= hi {
+		return
+	}
+	dlo, dhi := lo+k, hi+k
+	for w := (dhi - 1) / 64; ; w-- {
+		// the bits of the source that end up in word w, shifts by 64 give 0.
+		src := qf.word(base+w) << k
+		if w > 0 {
+			src |= qf.word(base+w-1) >> (64 - k)
+		}
+		mask := bitRange(max(dlo, w*64)-w*64, min(dhi, w*64+64)-w*64)
+		qf.setWord(base+w, qf.word(base+w)&^mask|src&mask)
+		if w*64 <= dlo {
+			return
+		}
+	}
+}
+
+// setShifted sets the is_shifted bits of the slots from to to, which don't wrap around the
+// end of the table, a block at a time.
+func (qf *QuotientFilter) setShifted(from, to uint64) {
+	for first := from / blockSlots * blockSlots; first <= to; first += blockSlots {
+		base, _ := qf.blockWord(first)
+		lo, hi := metaShifted*qf.blockLen+max(from, first)-first, metaShifted*qf.blockLen+min(to, first+blockSlots-1)-first+1
+		for w := lo / 64; w*64 < hi; w++ {
+			qf.setWord(base+w, qf.word(base+w)|bitRange(max(lo, w*64)-w*64, min(hi, w*64+64)-w*64))
+		}
+	}
+}
+
+// bitRange returns a mask of the bits lo to hi-1, hi is at most 64.
+func bitRange(lo, hi uint64) uint64 {
+	return maskLower(hi) &^ maskLower(lo)
+}
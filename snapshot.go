@@ -0,0 +1,17 @@
+package qf
+
+// Snapshot returns a read-only copy of the filter at a single point in time, which can be
+// serialized with WriteTo while keys are still being added to the filter. Unlike the other
+// methods Snapshot is safe to call concurrently with the methods adding and deleting keys,
+// they are blocked only while the data is copied. Contains and the other lookups work on the
+// snapshot, Clone returns a writable copy of it.
+//
+// The snapshot of a filter created with NewHash shares the hash.Hash64 instance with it, so
+// lookups on the snapshot can't run concurrently with the filter's.
+func (qf *QuotientFilter) Snapshot() *QuotientFilter {
+	qf.mu.Lock()
+	snap := qf.Clone()
+	qf.mu.Unlock()
+	snap.readOnly, snap.dirty = true, nil
+	return snap
+}
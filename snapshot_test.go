@@ -0,0 +1,72 @@
+package qf
+
+import (
+	"bytes"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestSnapshot(t *testing.T) {
+	qf, _ := NewWithOptions(0, WithQR(14, 9), WithStash(16, 64))
+	// more keys than the table takes, the last ones go to the stash.
+	keys := randomItems(int(qf.maxLen) + 8)
+	// keys added and deleted again, with fingerprints no other key has.
+	fps := make(map[uint64]bool)
+	for _, key := range keys {
+		fps[qf.Fingerprint64(key)] = true
+	}
+	var churn []string
+	for _, key := range randomItems(len(keys) / 7) {
+		if !fps[qf.Fingerprint64(key)] {
+			churn = append(churn, key)
+		}
+	}
+	var added atomic.Int64
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i, key := range keys {
+			if err := qf.Add(key); err != nil {
+				t.Error("Unexpected error", err)
+				return
+			}
+			if i%7 == 0 && i/7 < len(churn) {
+				qf.Add(churn[i/7])
+				qf.Delete(churn[i/7])
+			}
+			added.Store(int64(i + 1))
+		}
+	}()
+	snapshots := 0
+	for done := false; !done; snapshots++ {
+		before := added.Load()
+		snap := qf.Snapshot()
+		done = before == int64(len(keys))
+		if err := snap.checkTable(); err != nil {
+			t.Fatal("Inconsistent snapshot", err)
+		}
+		if !snap.ContainsAll(keys[:before]) {
+			t.Fatal("Snapshot is missing keys added before it")
+		}
+		var buf bytes.Buffer
+		if _, err := snap.WriteTo(&buf); err != nil {
+			t.Fatal("Unexpected error", err)
+		}
+		var decoded QuotientFilter
+		if err := decoded.UnmarshalBinary(buf.Bytes()); err != nil || decoded.Stats() != snap.Stats() {
+			t.Fatal("Snapshot did not round trip", err)
+		}
+		if snap.Add("fox") != ErrReadOnly {
+			t.Fatal("Snapshot is not read-only")
+		}
+	}
+	wg.Wait()
+	if snapshots < 2 {
+		t.Fatal("Only", snapshots, "snapshots taken")
+	}
+	if clone := qf.Snapshot().Clone(); clone.Add("fox") != nil || qf.Contains("fox") {
+		t.Fatal("Clone of a snapshot is not an independent filter")
+	}
+}
@@ -0,0 +1,289 @@
+package qf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"unsafe"
+)
+
+// WithSparse divides the data into pages of 32 blocks of 64 slots that are allocated when a
+// key is first added to one of their slots, so that a large filter holding few keys only
+// takes the memory of the pages its keys went to. Pages that were never written read as
+// empty slots, lookups of keys in them stop at the first slot without touching memory.
+// WithMaxMemory limits the pages the filter allocates, together with the index of the pages,
+// and Add returns an error wrapping ErrFull when a key needs a page past the limit. The
+// lookups walk the clusters without the offsets of the blocks, as the clusters stay short at
+// the low loads sparse filters are for. Stats reports the pages allocated. The encodings
+// don't record sparse data, filters decoded into a sparse filter are sparse and allocate the
+// pages holding keys, and the compressed encoding doesn't write the pages that aren't
+// allocated. Sparse filters can't have optimistic reads or dirty tracking, and can't be
+// striped.
+func WithSparse() Option {
+	return func(c *config) error {
+		c.sparse = true
+		return nil
+	}
+}
+
+// errSparseOptions is the error of creating a sparse filter with options it can't have.
+var errSparseOptions = errors.New("sparse filters can't have optimistic reads or dirty tracking")
+
+// sparsePageBlocks is the number of blocks of 64 slots of a page, smaller tables take a
+// single page.
+const sparsePageBlocks = 32
+
+// sparsePageOverhead is the size of the entry of a page in the index of the pages.
+const sparsePageOverhead = uint64(unsafe.Sizeof([]byte(nil)))
+
+// sparseData holds the data words of a sparse filter in pages of whole blocks, allocated on the
+// first write of a word that is not zero. A nil page holds zero words.
+type sparseData struct {
+	pages     [][]byte
+	pageWords uint64
+	words     uint64
+	// pages start at cache lines, see WithCacheLineBlocks.
+	cacheLine bool
+	// number of pages allocated, and the most the memory limit allows.
+	allocated, limit uint64
+}
+
+// newSparseData returns the pages of words data words, none allocated, with pages of pageWords
+// words each and as many as fit in maxMemory bytes with the index.
+func newSparseData(words, pageWords, maxMemory uint64, cacheLine bool) *sparseData {
+	s := &sparseData{pages: make([][]byte, sparsePages(words, pageWords)), pageWords: pageWords, words: words, cacheLine: cacheLine}
+	if index := uint64(len(s.pages)) * sparsePageOverhead; index < maxMemory {
+		s.limit = (maxMemory - index) / (pageWords * 8)
+	}
+	return s
+}
+
+// sparsePages returns the number of pages of pageWords words holding words data words.
+func sparsePages(words, pageWords uint64) uint64 {
+	return (words + pageWords - 1) / pageWords
+}
+
+// sparsePageWords returns the number of data words of a page of a filter of words data words
+// with blocks of stride words, all of them for tables of fewer than blockSlots slots.
+func sparsePageWords(q uint8, words, stride uint64) uint64 {
+	if uint64(1)<<q < blockSlots {
+		return words
+	}
+	return sparsePageBlocks * stride
+}
+
+// sparseIndexBytes returns the size of the index of the pages of a sparse filter of q and
+// remainders of width bits, which it allocates up front.
+func sparseIndexBytes(q, width uint8, cacheLine bool) uint64 {
+	words, _ := uint64Size(q, width, cacheLine)
+	return sparsePages(words, sparsePageWords(q, words, blockStride(width, cacheLine))) * sparsePageOverhead
+}
+
+func (s *sparseData) word(i uint64) uint64 {
+	p := s.pages[i/s.pageWords]
+	if p == nil {
+		return 0
+	}
+	return binary.LittleEndian.Uint64(p[i%s.pageWords*8:])
+}
+
+func (s *sparseData) setWord(i, w uint64) {
+	p := s.pages[i/s.pageWords]
+	if p == nil {
+		if w == 0 {
+			return
+		}
+		p = s.alloc(i / s.pageWords)
+	}
+	binary.LittleEndian.PutUint64(p[i%s.pageWords*8:], w)
+}
+
+// page returns the bytes of the page of data word i from the word on, nil if the page is not
+// allocated. The blocks don't straddle pages, so the block starting at word i is in them.
+func (s *sparseData) page(i uint64) []byte {
+	p := s.pages[i/s.pageWords]
+	if p == nil {
+		return nil
+	}
+	return p[i%s.pageWords*8:]
+}
+
+// writablePage is page, allocating the page if it is not allocated.
+func (s *sparseData) writablePage(i uint64) []byte {
+	p := s.pages[i/s.pageWords]
+	if p == nil {
+		p = s.alloc(i / s.pageWords)
+	}
+	return p[i%s.pageWords*8:]
+}
+
+func (s *sparseData) alloc(n uint64) []byte {
+	s.pages[n] = s.newPage()
+	s.allocated++
+	return s.pages[n]
+}
+
+// newPage returns a page of zero words.
+func (s *sparseData) newPage() []byte {
+	if s.cacheLine {
+		return alignedBytes(s.pageWords * 8)
+	}
+	return make([]byte, s.pageWords*8)
+}
+
+// errMemoryLimit is the error of adding a key that needs a page past the memory limit.
+var errMemoryLimit = fmt.Errorf("%w: the sparse data is at the memory limit", ErrFull)
+
+// reserve allocates the pages from first to last, wrapping around the end of the data, so
+// that the slots in them can be written, or returns errMemoryLimit without allocating any if
+// they don't all fit in the limit.
+func (s *sparseData) reserve(first, last uint64) error {
+	missing := uint64(0)
+	for n := first; ; n = (n + 1) % uint64(len(s.pages)) {
+		if s.pages[n] == nil {
+			missing++
+		}
+		if n == last {
+			break
+		}
+	}
+	if s.allocated+missing > s.limit {
+		return errMemoryLimit
+	}
+	for n := first; missing > 0; n = (n + 1) % uint64(len(s.pages)) {
+		if s.pages[n] == nil {
+			s.alloc(n)
+			missing--
+		}
+	}
+	return nil
+}
+
+// checkLimit returns an error if more pages are allocated than the memory limit allows, after
+// writing decoded data.
+func (s *sparseData) checkLimit() error {
+	if s.allocated > s.limit {
+		return fmt.Errorf("%w: the data needs more than %d pages of %d bytes, the memory limit", ErrInvalidEncoding, s.limit, s.pageWords*8)
+	}
+	return nil
+}
+
+// appendBytes appends the data words from i to j to buf, zero words for pages not allocated.
+func (s *sparseData) appendBytes(buf []byte, i, j uint64) []byte {
+	for i < j {
+		n := min(j, (i/s.pageWords+1)*s.pageWords) - i
+		if p := s.page(i); p != nil {
+			buf = append(buf, p[:n*8]...)
+		} else {
+			buf = append(buf, make([]byte, n*8)...)
+		}
+		i += n
+	}
+	return buf
+}
+
+// write writes the data words b holds from the word at byte offset off on, allocating only the
+// pages of the words that are not zero.
+func (s *sparseData) write(off int, b []byte) {
+	for k := 0; k+8 <= len(b); k += 8 {
+		s.setWord(uint64(off+k)/8, binary.LittleEndian.Uint64(b[k:]))
+	}
+}
+
+// sparseReader reads the data bytes of sparse data from the byte pos on.
+type sparseReader struct {
+	s   *sparseData
+	pos uint64
+}
+
+func (r *sparseReader) Read(b []byte) (int, error) {
+	size, pageSize := r.s.words*8, r.s.pageWords*8
+	if r.pos >= size {
+		return 0, io.EOF
+	}
+	start := r.pos / pageSize * pageSize
+	n := min(uint64(len(b)), min(size, start+pageSize)-r.pos)
+	if p := r.s.pages[r.pos/pageSize]; p != nil {
+		copy(b[:n], p[r.pos-start:])
+	} else {
+		clear(b[:n])
+	}
+	r.pos += n
+	return int(n), nil
+}
+
+func (s *sparseData) clone() *sparseData {
+	c := *s
+	c.pages = make([][]byte, len(s.pages))
+	for n, p := range s.pages {
+		if p != nil {
+			c.pages[n] = s.newPage()
+			copy(c.pages[n], p)
+		}
+	}
+	return &c
+}
+
+// reset drops the allocated pages.
+func (s *sparseData) reset() {
+	clear(s.pages)
+	s.allocated = 0
+}
+
+// sizeInBytes returns the bytes of the allocated pages and the index.
+func (s *sparseData) sizeInBytes() uint64 {
+	return s.allocated*s.pageWords*8 + uint64(len(s.pages))*sparsePageOverhead
+}
+
+// reservePages allocates the pages of the slots an insertion into the run of quotient q of a
+// sparse filter writes, those from q to the next empty slot, before the insertion changes any,
+// so that an insertion past the memory limit fails without changing the table.
+func (qf *QuotientFilter) reservePages(q uint64) error {
+	if qf.sparse == nil {
+		return nil
+	}
+	return qf.sparse.reserve(qf.slotPage(q), qf.slotPage(qf.nextEmpty(q)))
+}
+
+// slotPage returns the page of sparse data holding the slot at index.
+func (qf *QuotientFilter) slotPage(index uint64) uint64 {
+	return index / blockSlots * qf.stride / qf.sparse.pageWords
+}
+
+// setData makes data the data words of a decoded filter, written into the pages of a sparse
+// filter.
+func (qf *QuotientFilter) setData(data []byte) error {
+	if qf.sparse == nil {
+		qf.data = data
+		return nil
+	}
+	qf.sparse.write(0, data)
+	return qf.sparse.checkLimit()
+}
+
+// writeData writes the data words b holds from the word at byte offset off on.
+func (qf *QuotientFilter) writeData(off int, b []byte) {
+	if qf.sparse != nil {
+		qf.sparse.write(off, b)
+		return
+	}
+	copy(qf.data[off:], b)
+}
+
+// dataReader returns a reader of the data words.
+func (qf *QuotientFilter) dataReader() io.Reader {
+	if qf.sparse == nil {
+		return bytes.NewReader(qf.data[:qf.words()*8])
+	}
+	return &sparseReader{s: qf.sparse}
+}
+
+// denseData returns the data words, a copy of all of them for sparse data.
+func (qf *QuotientFilter) denseData() []byte {
+	if qf.sparse == nil {
+		return qf.data
+	}
+	return qf.sparse.appendBytes(nil, 0, qf.sparse.words)
+}
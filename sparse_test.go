@@ -0,0 +1,238 @@
+package qf
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"runtime"
+	"slices"
+	"testing"
+)
+
+func TestSparse(t *testing.T) {
+	for _, test := range []struct {
+		q, r      uint8
+		load      float64
+		cacheLine bool
+		aligned   bool
+	}{{4, 5, 0.9, false, false}, {10, 9, 0.9, false, false}, {10, 13, 0.5, true, false},
+		{10, 13, 0.5, false, true}, {16, 7, 0.02, false, false}, {16, 11, 0.5, false, false}} {
+		name := fmt.Sprint("q ", test.q, " r ", test.r, " load ", test.load, " cache lines ", test.cacheLine, " aligned ", test.aligned)
+		opts := []Option{WithQR(test.q, test.r)}
+		if test.cacheLine {
+			opts = append(opts, WithCacheLineBlocks())
+		}
+		if test.aligned {
+			opts = append(opts, WithAlignedSlots())
+		}
+		dense, _ := NewWithOptions(0, opts...)
+		qf, err := NewWithOptions(0, append(opts, WithSparse())...)
+		if err != nil {
+			t.Fatal(name, "unexpected error", err)
+		}
+		if !qf.Params().Sparse || dense.Params().Sparse || qf.data != nil || qf.offsets != nil {
+			t.Fatal(name, "not sparse, Params", qf.Params())
+		}
+		if st := qf.Stats(); st.Pages == 0 || st.AllocatedPages != 0 {
+			t.Fatal(name, "pages of an empty filter", st)
+		}
+		// both see the same keys, and give the same results for them and others.
+		items := randomItems(int(test.load * float64(qf.cap)))
+		for i, k := range items {
+			if dense.Add(k) != qf.Add(k) {
+				t.Fatal(name, "Add returned a different error for", k)
+			}
+			if i%3 == 0 && dense.Delete(items[i/2]) != qf.Delete(items[i/2]) {
+				t.Fatal(name, "Delete returned a different result for", items[i/2])
+			}
+		}
+		if qf.Len() != dense.Len() || !slices.Equal(qf.Fingerprints(), dense.Fingerprints()) {
+			t.Fatal(name, "the filters hold different fingerprints")
+		}
+		for _, k := range append(items, randomItems(1000)...) {
+			if qf.Contains(k) != dense.Contains(k) {
+				t.Fatal(name, "the filters give different results for", k)
+			}
+		}
+		checkOffsets(t, name, qf)
+
+		// the encodings are those of the dense filter, and decode into either.
+		want, _ := dense.MarshalBinary()
+		b, _ := qf.MarshalBinary()
+		var compressed, wantCompressed bytes.Buffer
+		qf.WriteToCompressed(&compressed)
+		dense.WriteToCompressed(&wantCompressed)
+		j, _ := json.Marshal(qf)
+		wantJSON, _ := json.Marshal(dense)
+		if !bytes.Equal(b, want) || !bytes.Equal(compressed.Bytes(), wantCompressed.Bytes()) || !bytes.Equal(j, wantJSON) {
+			t.Fatal(name, "the encodings differ from those of the dense filter")
+		}
+		target := func() *QuotientFilter {
+			target, _ := NewWithOptions(1, WithSparse())
+			return target
+		}
+		unmarshaled, decompressed, fromJSON := target(), target(), target()
+		if err := unmarshaled.UnmarshalBinary(b); err != nil {
+			t.Fatal(name, "unexpected error", err)
+		}
+		if _, err := decompressed.ReadFrom(&compressed); err != nil {
+			t.Fatal(name, "unexpected error", err)
+		}
+		if err := json.Unmarshal(j, fromJSON); err != nil {
+			t.Fatal(name, "unexpected error", err)
+		}
+		var chunked bytes.Buffer
+		qf.ExportChunks(&chunked, 1000)
+		var wantChunked bytes.Buffer
+		dense.ExportChunks(&wantChunked, 1000)
+		if !bytes.Equal(chunked.Bytes(), wantChunked.Bytes()) {
+			t.Fatal(name, "the chunks differ from those of the dense filter")
+		}
+		receiver := qf.Clone()
+		digest := receiver.Checkpoint()
+		if digest.sum() != dense.Checkpoint().sum() {
+			t.Fatal(name, "the digest differs from that of the dense filter")
+		}
+		qf.Add("fox")
+		diff, _ := qf.Diff(digest)
+		if err := receiver.ApplyDiff(diff); err != nil {
+			t.Fatal(name, "unexpected error", err)
+		}
+		qf.Delete("fox")
+		receiver.Delete("fox")
+		for i, d := range []*QuotientFilter{unmarshaled, decompressed, fromJSON, receiver} {
+			// the pages that "fox" was added to and deleted from stay allocated.
+			st, want := d.Stats(), qf.Stats()
+			if st.AllocatedPages > want.AllocatedPages {
+				t.Fatal(name, "decoded filter", i, "has", st.AllocatedPages, "pages, expected", want.AllocatedPages)
+			}
+			st.AllocatedPages = want.AllocatedPages
+			if d.Params() != qf.Params() || st != want || !bytes.Equal(d.denseData(), dense.data) {
+				t.Fatal(name, "decoded filter", i, "differs", st, want)
+			}
+			checkOffsets(t, name, d)
+		}
+
+		clone := qf.Clone()
+		qf.Reset()
+		if st := qf.Stats(); st.AllocatedPages != 0 || qf.Contains(items[len(items)-1]) {
+			t.Fatal(name, "pages of a reset filter", st)
+		}
+		if clone.Len() != dense.Len() || !slices.Equal(clone.Fingerprints(), dense.Fingerprints()) {
+			t.Fatal(name, "the clone changed with the filter")
+		}
+	}
+}
+
+func TestSparseMemory(t *testing.T) {
+	// the dense table of q 26 and r 13 takes 128 MiB, the pages 4 KiB each.
+	const q, r = 26, 13
+	dense, _ := dataBytes(q, r, false)
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	qf, err := NewWithOptions(0, WithQR(q, r), WithSparse())
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	items := randomItems(1000)
+	qf.AddAll(items)
+	runtime.GC()
+	runtime.ReadMemStats(&after)
+	st := qf.Stats()
+	if st.Pages != sparsePages(dense/8, sparsePageBlocks*16) || st.AllocatedPages == 0 || st.AllocatedPages > uint64(len(items)) {
+		t.Fatal("Unexpected pages", st)
+	}
+	size := qf.SizeInBytes()
+	if want := st.AllocatedPages*4096 + st.Pages*sparsePageOverhead + filterOverhead; size != want {
+		t.Fatal("Size of", size, "bytes, expected", want)
+	}
+	if size > dense/16 || after.HeapAlloc > before.HeapAlloc+2*size {
+		t.Fatal("The filter of", size, "bytes grew the heap by", after.HeapAlloc-before.HeapAlloc, "bytes, the dense table takes", dense)
+	}
+	for _, k := range items {
+		if !qf.Contains(k) {
+			t.Fatal("Added key missing", k)
+		}
+	}
+	runtime.KeepAlive(qf)
+
+	// the memory limit holds the index and 8 pages.
+	limit := st.Pages*sparsePageOverhead + 8*4096
+	if _, err := NewWithOptions(0, WithQR(q, r), WithMaxMemory(limit)); err == nil {
+		t.Fatal("Expected an error for the dense table over the limit")
+	}
+	if _, err := NewWithOptions(0, WithQR(q, r), WithSparse(), WithMaxMemory(st.Pages*sparsePageOverhead-1)); err == nil {
+		t.Fatal("Expected an error for the index over the limit")
+	}
+	limited, err := NewWithOptions(0, WithQR(q, r), WithSparse(), WithMaxMemory(limit))
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	n, err := limited.AddAll(items)
+	if !errors.Is(err, ErrFull) || n == 0 || limited.Stats().AllocatedPages != 8 || limited.Counters().Full != 1 {
+		t.Fatal("Unexpected AddAll result", n, err, limited.Stats())
+	}
+	checkOffsets(t, "limited", limited)
+	for _, k := range items[:n] {
+		if !limited.Contains(k) {
+			t.Fatal("Added key missing", k)
+		}
+	}
+
+	// decoding allocates the pages of the encoding, which have to fit in the limit too.
+	b, _ := qf.MarshalBinary()
+	var compressed bytes.Buffer
+	qf.WriteToCompressed(&compressed)
+	for i, enc := range [][]byte{b, compressed.Bytes()} {
+		decoded, _ := NewWithOptions(1, WithSparse(), WithMaxMemory(limit))
+		if err := decoded.UnmarshalBinary(enc); !errors.Is(err, ErrInvalidEncoding) {
+			t.Fatal("Unexpected error", err, "decoding encoding", i, "over the limit")
+		}
+		decoded, _ = NewWithOptions(1, WithSparse(), WithMaxMemory(qf.SizeInBytes()))
+		if err := decoded.UnmarshalBinary(enc); err != nil || decoded.Stats() != st {
+			t.Fatal("Unexpected error", err, "decoding encoding", i, decoded.Stats())
+		}
+	}
+}
+
+func TestSparseInvalid(t *testing.T) {
+	for i, opts := range [][]Option{{WithSparse(), WithOptimisticReads()}, {WithSparse(), WithDirtyTracking(64)}} {
+		if _, err := NewWithOptions(100, opts...); err == nil {
+			t.Fatal("Expected an error for options", i)
+		}
+		if _, err := LoadFromFile("missing", opts...); err == nil || !errors.Is(err, errSparseOptions) {
+			t.Fatal("Expected an error for options", i, "decoding, got", err)
+		}
+	}
+	qf, _ := NewWithOptions(0, WithQR(12, 8), WithSparse())
+	if _, err := NewStriped(qf, 4); err == nil {
+		t.Fatal("Expected an error striping a sparse filter")
+	}
+}
+
+// BenchmarkSparsePages looks up keys that are not in a large filter holding few keys, with the
+// pages of untouched blocks not allocated, and adds keys, dense and sparse.
+func BenchmarkSparsePages(b *testing.B) {
+	for _, sparse := range []bool{false, true} {
+		opts := []Option{WithQR(24, 13)}
+		name := "dense"
+		if sparse {
+			opts, name = append(opts, WithSparse()), "sparse"
+		}
+		qf, _ := NewWithOptions(0, opts...)
+		qf.AddAll(randomItems(1000))
+		missing := randomItems(1 << 16)
+		b.Run(name+"/ContainsMiss", func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				qf.Contains(missing[i%len(missing)])
+			}
+		})
+		b.Run(name+"/Add", func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				qf.Add(missing[i%len(missing)])
+			}
+		})
+	}
+}
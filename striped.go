@@ -0,0 +1,296 @@
+package qf
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/bits"
+	"sync"
+	"sync/atomic"
+)
+
+// minStripeSlots keeps the stripes of a Striped filter to whole data words, so that no two
+// stripes write the same word.
+const minStripeSlots = 64
+
+// Striped is one filter whose slots are split into stripes of consecutive quotients with a
+// lock each, so that keys in different parts of the table are added and deleted in
+// parallel. Unlike Sharded it stays one filter, with the false positive rate, size and
+// encoding of the filter it wraps.
+//
+// Adding and deleting a key lock the stripes its cluster spans, from the start of the
+// cluster to the first empty slot after the quotient of the key, and lookups take them in
+// read mode. A cluster reaching into another stripe locks that stripe too. The stripes are
+// always locked in ascending order, so that operations needing several don't deadlock.
+type Striped struct {
+	sharedHash
+	stripes []stripe
+	// shift leaves the bits of a quotient that select its stripe.
+	shift uint8
+	// number of fingerprints, the filter's own count is only brought up to date by the
+	// methods locking all stripes.
+	len atomic.Uint64
+}
+
+type stripe struct {
+	mu sync.RWMutex
+	// keeps the locks of the stripes on separate cache lines.
+	_ [64]byte
+}
+
+// stripeRange is count stripes from first on, wrapping around to the start of the table.
+type stripeRange struct {
+	first, count int
+}
+
+// NewStriped wraps inner in stripes stripes, a power of two, of at least 64 slots each.
+// inner must not be used directly afterwards. Filters with a stash, dirty tracking,
+// optimistic reads or reported false positives, sparse filters, filters of q + r above 64
+// bits and read-only filters can't be striped.
+func NewStriped(inner *QuotientFilter, stripes int) (*Striped, error) {
+	maxStripes := max(1, inner.cap/minStripeSlots)
+	if stripes < 1 || uint64(stripes) > maxStripes || stripes&(stripes-1) != 0 {
+		return nil, fmt.Errorf("stripes %d has to be a power of two up to %d for %d slots", stripes, maxStripes, inner.cap)
+	}
+	if inner.readOnly {
+		return nil, inner.errReadOnly()
+	}
+	if cap(inner.stash) > 0 || inner.dirty != nil || inner.seq != nil || inner.reported != nil || inner.sparse != nil {
+		return nil, errors.New("sparse filters and filters with a stash, dirty tracking, optimistic reads or reported false positives can't be striped")
+	}
+	if inner.wide {
+		return nil, errors.New("filters of q + r above 64 bits can't be striped")
+	}
+	s := &Striped{
+		sharedHash: newSharedHash(inner),
+		stripes:    make([]stripe, stripes),
+		shift:      inner.qbits - uint8(bits.TrailingZeros(uint(stripes))),
+	}
+	s.len.Store(inner.len)
+	return s, nil
+}
+
+// lock locks the stripes of rg in ascending order, in write mode for write.
+func (s *Striped) lock(rg stripeRange, write bool) {
+	n := len(s.stripes)
+	for i := 0; i < rg.first+rg.count-n; i++ {
+		s.lockStripe(i, write)
+	}
+	for i := rg.first; i < min(rg.first+rg.count, n); i++ {
+		s.lockStripe(i, write)
+	}
+}
+
+func (s *Striped) lockStripe(i int, write bool) {
+	if write {
+		s.stripes[i].mu.Lock()
+	} else {
+		s.stripes[i].mu.RLock()
+	}
+}
+
+func (s *Striped) unlock(rg stripeRange, write bool) {
+	for i := range rg.count {
+		mu := &s.stripes[(rg.first+i)%len(s.stripes)].mu
+		if write {
+			mu.Unlock()
+		} else {
+			mu.RUnlock()
+		}
+	}
+}
+
+// lockCluster locks the stripes the cluster of quotient q spans and returns them for unlock.
+// If the stripes locked first don't cover the cluster, they are unlocked and one more is
+// locked in ascending order with them, until they do.
+func (s *Striped) lockCluster(q uint64, write bool) stripeRange {
+	rg := stripeRange{int(q >> s.shift), 1}
+	for {
+		s.lock(rg, write)
+		next, ok := s.covers(rg, q)
+		if ok {
+			return rg
+		}
+		s.unlock(rg, write)
+		rg = next
+	}
+}
+
+// covers reports whether rg covers the slots of quotient q that adding or deleting keys
+// read and write: the cluster of q up to the first empty slot after q. Otherwise it returns
+// rg extended by a stripe towards the slot it missed. It only reads slots in rg.
+func (s *Striped) covers(rg stripeRange, q uint64) (stripeRange, bool) {
+	n := len(s.stripes)
+	if rg.count == n {
+		return rg, true
+	}
+	in := func(i uint64) bool {
+		return (int(i>>s.shift)-rg.first+n)%n < rg.count
+	}
+	qf := s.qf
+	for i := q; qf.isShifted(i); {
+		if i = qf.previous(i); !in(i) {
+			return stripeRange{(rg.first + n - 1) % n, rg.count + 1}, false
+		}
+	}
+	for i := q; !qf.isEmpty(i); {
+		if i = qf.next(i); !in(i) {
+			return stripeRange{rg.first, rg.count + 1}, false
+		}
+	}
+	return rg, true
+}
+
+// Add adds the key to the filter.
+func (s *Striped) Add(key string) error {
+	return s.AddHash(s.hashString(key))
+}
+
+// AddBytes adds the key to the filter, it is the []byte equivalent of Add.
+func (s *Striped) AddBytes(key []byte) error {
+	return s.AddHash(s.hash(key))
+}
+
+// AddHash adds a key that has already been hashed to h, see QuotientFilter.AddHash.
+func (s *Striped) AddHash(h uint64) error {
+	_, err := s.insert(s.qf.quotientAndRemainder(h))
+	return err
+}
+
+// ContainsOrAdd adds the key to the filter and reports whether it was already present.
+func (s *Striped) ContainsOrAdd(key string) (existed bool, err error) {
+	return s.insert(s.qf.quotientAndRemainder(s.hashString(key)))
+}
+
+func (s *Striped) insert(q, r uint64) (existed bool, err error) {
+	if err := s.qf.checkHash(); err != nil {
+		return false, err
+	}
+	rg := s.lockCluster(q, true)
+	defer s.unlock(rg, true)
+	qf := s.qf
+	slot := qf.getSlot(q)
+	var start, index uint64
+	if slot.isOccupied() {
+		var found bool
+		if start, index, found = qf.findInRun(q, r); found {
+			return true, nil
+		}
+	}
+	if s.len.Add(1) > qf.maxLen {
+		s.len.Add(^uint64(0))
+		return false, ErrFull
+	}
+	switch {
+	case slot.isEmpty():
+		qf.setSlot(q, newSlot(r).setOccupied())
+	case !slot.isOccupied():
+		qf.setSlot(q, slot.setOccupied())
+		start = qf.findRun(q)
+		qf.insertRunSlot(q, start, start, r, true)
+	default:
+		qf.insertRunSlot(q, start, index, r, false)
+	}
+	return false, nil
+}
+
+// AddAll adds the keys and returns the number of keys that were not already present, see
+// QuotientFilter.AddAll. All keys are hashed before the first is added.
+func (s *Striped) AddAll(keys []string) (inserted int, err error) {
+	hashes := make([]uint64, len(keys))
+	s.lockHash()
+	for i, k := range keys {
+		hashes[i] = s.qf.hashString(k)
+	}
+	s.unlockHash()
+	for i, h := range hashes {
+		existed, err := s.insert(s.qf.quotientAndRemainder(h))
+		if existed {
+			continue
+		}
+		if err != nil {
+			return inserted, &BatchError{Index: i, Err: err}
+		}
+		inserted++
+	}
+	return inserted, nil
+}
+
+// Contains checks if key is present in the filter.
+func (s *Striped) Contains(key string) bool {
+	return s.ContainsHash(s.hashString(key))
+}
+
+// ContainsBytes checks if key is present in the filter, it is the []byte equivalent of Contains.
+func (s *Striped) ContainsBytes(key []byte) bool {
+	return s.ContainsHash(s.hash(key))
+}
+
+// ContainsHash checks if a key with the 64 bit hash h is present in the filter.
+func (s *Striped) ContainsHash(h uint64) bool {
+	q, r := s.qf.quotientAndRemainder(h)
+	rg := s.lockCluster(q, false)
+	defer s.unlock(rg, false)
+	return s.qf.inTable(q, r)
+}
+
+// Delete removes the key from the filter and reports whether it was found.
+func (s *Striped) Delete(key string) bool {
+	return s.deleteHash(s.hashString(key))
+}
+
+func (s *Striped) deleteHash(h uint64) bool {
+	q, r := s.qf.quotientAndRemainder(h)
+	rg := s.lockCluster(q, true)
+	defer s.unlock(rg, true)
+	qf := s.qf
+	if !qf.isOccupied(q) {
+		return false
+	}
+	start, index, found := qf.findInRun(q, r)
+	if !found {
+		return false
+	}
+	qf.removeRunSlot(q, start, index)
+	s.len.Add(^uint64(0))
+	return true
+}
+
+// Len returns the number of keys in the filter, without taking any lock.
+func (s *Striped) Len() uint64 {
+	return s.len.Load()
+}
+
+// Cap returns the number of slots of the filter.
+func (s *Striped) Cap() uint64 {
+	return s.qf.Cap()
+}
+
+// Stripes returns the number of stripes.
+func (s *Striped) Stripes() int {
+	return len(s.stripes)
+}
+
+// Update calls fn with the filter with all stripes locked, fn can call any method of the
+// filter but must not keep it after returning. Decoding into the filter has to keep its q and
+// hash function, and must not give it a stash or dirty tracking.
+func (s *Striped) Update(fn func(qf *QuotientFilter)) {
+	all := stripeRange{0, len(s.stripes)}
+	s.lock(all, true)
+	defer s.unlock(all, true)
+	s.lockHash()
+	defer s.unlockHash()
+	s.qf.len = s.len.Load()
+	s.qf.publishLen()
+	fn(s.qf)
+	s.len.Store(s.qf.len)
+}
+
+// WriteTo writes the filter to w in the binary format of MarshalBinary. Adding and deleting
+// keys waits until the filter is written.
+func (s *Striped) WriteTo(w io.Writer) (n int64, err error) {
+	s.Update(func(qf *QuotientFilter) {
+		n, err = qf.WriteTo(w)
+	})
+	return n, err
+}
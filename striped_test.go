@@ -0,0 +1,129 @@
+package qf
+
+import (
+	"errors"
+	"sync"
+	"testing"
+)
+
+func TestStriped(t *testing.T) {
+	inner, _ := NewWithOptions(0, WithQR(12, 16))
+	s, err := NewStriped(inner, 16)
+	if err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	// the quotients of the keys are around the boundary of the first two stripes and around
+	// the end of the table, so that all goroutines grow the same two clusters, which reach
+	// across several stripes and wrap around.
+	hash := func(g, i int, deleted bool) uint64 {
+		q := uint64(250 + i%12)
+		if i%2 == 1 {
+			q = uint64(4090+i%12) & 4095
+		}
+		r := uint64(g<<10 | i)
+		if deleted {
+			r |= 1 << 15
+		}
+		return q<<16 | r
+	}
+	const goroutines, perGoroutine = 8, 100
+	// the items must not share a fingerprint with the keys that are deleted.
+	deleted := make(map[uint64]bool)
+	for g := 0; g < goroutines; g++ {
+		for i := 0; i < perGoroutine; i++ {
+			deleted[hash(g, i, true)] = true
+		}
+	}
+	var items []string
+	for _, k := range randomItems(600) {
+		if !deleted[inner.hash([]byte(k))&(1<<28-1)] && len(items) < 500 {
+			items = append(items, k)
+		}
+	}
+	var wg sync.WaitGroup
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < perGoroutine; i++ {
+				for _, deleted := range []bool{false, true} {
+					if err := s.AddHash(hash(g, i, deleted)); err != nil {
+						t.Error("Unexpected error", err)
+						return
+					}
+				}
+				if !s.ContainsHash(hash(g, i, false)) {
+					t.Error("Key just added is missing", g, i)
+					return
+				}
+				s.Add(items[(g*perGoroutine+i)%len(items)])
+				if i%3 == 0 {
+					s.Contains(items[i])
+				}
+			}
+			for i := 0; i < perGoroutine; i++ {
+				if !s.ContainsHash(hash(g, i, true)) {
+					t.Error("Key to delete is missing", g, i)
+					return
+				}
+				if !s.deleteHash(hash(g, i, true)) {
+					t.Error("Key to delete was not found", g, i)
+					return
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	for g := 0; g < goroutines; g++ {
+		for i := 0; i < perGoroutine; i++ {
+			if !s.ContainsHash(hash(g, i, false)) {
+				t.Fatal("Missing", g, i)
+			}
+			if s.ContainsHash(hash(g, i, true)) {
+				t.Fatal("Deleted key is present", g, i)
+			}
+		}
+	}
+	for _, k := range items {
+		if !s.Contains(k) || !s.ContainsBytes([]byte(k)) {
+			t.Fatal("Missing", k)
+		}
+	}
+	s.Update(func(qf *QuotientFilter) {
+		if err := qf.checkTable(); err != nil {
+			t.Fatal("Invalid table", err)
+		}
+		if qf.Len() != s.len.Load() {
+			t.Fatal("Len", qf.Len(), "want", s.len.Load())
+		}
+	})
+	if !s.Delete(items[0]) || s.Contains(items[0]) {
+		t.Fatal("Delete did not remove", items[0])
+	}
+	if existed, err := s.ContainsOrAdd(items[0]); existed || err != nil || !s.Contains(items[0]) {
+		t.Fatal("ContainsOrAdd of a deleted key returned", existed, err)
+	}
+
+	small, _ := New(6, 8)
+	for _, n := range []int{0, 3, 2} {
+		if _, err := NewStriped(small, n); err == nil {
+			t.Fatal(n, "stripes of 64 slots were accepted")
+		}
+	}
+	stashed, _ := NewWithOptions(100, WithStash(8, 4))
+	if _, err := NewStriped(stashed, 1); err == nil {
+		t.Fatal("A filter with a stash was striped")
+	}
+	full, _ := NewStriped(small, 1)
+	for i := 0; ; i++ {
+		if err := full.AddHash(uint64(i)); errors.Is(err, ErrFull) {
+			break
+		} else if err != nil {
+			t.Fatal("Unexpected error", err)
+		}
+	}
+	if full.Len() != small.maxLen {
+		t.Fatal("Full at", full.Len(), "want", small.maxLen)
+	}
+}
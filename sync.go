@@ -0,0 +1,212 @@
+package qf
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math/bits"
+	"os"
+)
+
+// WithDirtyTracking makes the filter track which blocks of blockSize bytes of its data have
+// been modified, so that Sync only rewrites those. blockSize has to be a positive multiple
+// of 8. Filters decoded into a filter with dirty tracking keep tracking with the same size.
+func WithDirtyTracking(blockSize int) Option {
+	return func(c *config) error {
+		if blockSize <= 0 || blockSize%8 != 0 {
+			return fmt.Errorf("block size %d has to be a positive multiple of 8", blockSize)
+		}
+		c.dirtyBlockSize = blockSize
+		return nil
+	}
+}
+
+// dirtyBlocks tracks the blocks of the data modified since the last Sync.
+type dirtyBlocks struct {
+	size int
+	// bit per block, set when the block has been modified.
+	bits []uint64
+	// checksums of the blocks, valid for the blocks not marked when valid is set.
+	crcs  []uint32
+	valid bool
+	// file of the last Sync, nil before the first, and the length of the header in it.
+	file      *os.File
+	headerLen int
+}
+
+func newDirtyBlocks(size int, dataLen uint64) *dirtyBlocks {
+	n := (dataLen + uint64(size) - 1) / uint64(size)
+	return &dirtyBlocks{size: size, bits: make([]uint64, (n+63)/64), crcs: make([]uint32, n)}
+}
+
+// mark marks the block holding data word i as modified.
+func (d *dirtyBlocks) mark(i uint64) {
+	b := i * 8 / uint64(d.size)
+	d.bits[b/64] |= 1 << (b % 64)
+}
+
+// markRange marks the blocks holding the n data bytes from offset as modified.
+func (d *dirtyBlocks) markRange(offset, n int) {
+	for b := offset / d.size; b*d.size < offset+n; b++ {
+		d.bits[b/64] |= 1 << (b % 64)
+	}
+}
+
+func (d *dirtyBlocks) isDirty(b int) bool {
+	return d.bits[b/64]&(1<<(b%64)) != 0
+}
+
+// reset forgets the checksums and the file of the last Sync, so the next one writes everything.
+func (d *dirtyBlocks) reset() {
+	d.file, d.valid = nil, false
+}
+
+// Sync writes the filter to f in the binary format of WriteTo, so that f can be loaded with
+// LoadFromFile, and syncs f to disk. With dirty tracking, see WithDirtyTracking, Sync only
+// rewrites the header and the blocks modified since the previous Sync to the same file.
+// The whole filter is written the first time, when f is a different file than last time,
+// and when the length of the header changed, which happens when namespaces are added or
+// the number of stashed fingerprints changes. Without dirty tracking every Sync writes the
+// whole filter.
+//
+// Unlike SaveToFile, Sync updates f in place, a crash during Sync can leave a file that
+// fails to load with ErrChecksum. On errors the modified blocks stay marked as modified.
+func (qf *QuotientFilter) Sync(f *os.File) error {
+	d := qf.dirty
+	head := qf.appendEncodingHeader(make([]byte, prefixLen, 128), 0)
+	if d == nil || d.file != f || len(head) != d.headerLen {
+		return qf.syncAll(f)
+	}
+	offset := int64(len(head))
+	for i, w := range d.bits {
+		for ; w != 0; w &= w - 1 {
+			b := i*64 + bits.TrailingZeros64(w)
+			block := qf.block(b, d.size)
+			if _, err := f.WriteAt(block, offset+int64(b*d.size)); err != nil {
+				return err
+			}
+			d.crcs[b] = crc32.Checksum(block, castagnoli)
+		}
+	}
+	putPrefix(head, encodingVersion, qf.dirtyChecksum(head[prefixLen:]))
+	if _, err := f.WriteAt(head, 0); err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	clear(d.bits)
+	return nil
+}
+
+// syncAll writes the whole filter to f and records the checksums of its blocks.
+func (qf *QuotientFilter) syncAll(f *os.File) error {
+	w := bufio.NewWriterSize(io.NewOffsetWriter(f, 0), 1<<16)
+	n, err := qf.WriteTo(w)
+	if err != nil {
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if err := f.Truncate(n); err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	if d := qf.dirty; d != nil {
+		for b := range d.crcs {
+			d.crcs[b] = crc32.Checksum(qf.block(b, d.size), castagnoli)
+		}
+		d.valid = true
+		d.file, d.headerLen = f, int(n)-len(qf.data)
+		clear(d.bits)
+	}
+	return nil
+}
+
+// block returns the data bytes of block b of size bytes, a copy of them for sparse data.
+func (qf *QuotientFilter) block(b, size int) []byte {
+	if qf.sparse != nil {
+		return qf.sparse.appendBytes(nil, uint64(b*size/8), min(uint64((b+1)*size/8), qf.sparse.words))
+	}
+	return qf.data[b*size : min((b+1)*size, len(qf.data))]
+}
+
+// dirtyChecksum returns the checksum of header followed by the data from the checksums of
+// the blocks, without reading the data.
+func (qf *QuotientFilter) dirtyChecksum(header []byte) uint32 {
+	d := qf.dirty
+	crc := crc32.Checksum(header, castagnoli)
+	shift := crc32Shift(int64(d.size))
+	for b, blockCRC := range d.crcs {
+		if b == len(d.crcs)-1 && len(qf.data)%d.size != 0 {
+			shift = crc32Shift(int64(len(qf.data) % d.size))
+		}
+		crc = crc32MulMod(shift, crc) ^ blockCRC
+	}
+	return crc
+}
+
+var errNoDirtyTracking = errors.New("filter does not track dirty blocks, create it WithDirtyTracking")
+
+// DirtyBlocks returns the number of blocks modified since the last Sync.
+// It returns an error if the filter does not track dirty blocks.
+func (qf *QuotientFilter) DirtyBlocks() (int, error) {
+	if qf.dirty == nil {
+		return 0, errNoDirtyTracking
+	}
+	n := 0
+	for _, w := range qf.dirty.bits {
+		n += bits.OnesCount64(w)
+	}
+	return n, nil
+}
+
+// castagnoliReversed is the reversed CRC-32C polynomial.
+const castagnoliReversed = 0x82f63b78
+
+// crc32MulMod returns a*b modulo the CRC-32C polynomial, in the reversed bit order of the
+// checksums. Together with crc32Shift it combines checksums of concatenated data the way
+// zlib's crc32_combine does.
+func crc32MulMod(a, b uint32) uint32 {
+	var p uint32
+	for m := uint32(1) << 31; m != 0; m >>= 1 {
+		if a&m != 0 {
+			p ^= b
+		}
+		if b&1 != 0 {
+			b = b>>1 ^ castagnoliReversed
+		} else {
+			b >>= 1
+		}
+	}
+	return p
+}
+
+// crc32Shift returns x^(8n) modulo the CRC-32C polynomial, multiplying a checksum by it
+// gives the checksum of the same data followed by n zero bytes, with the initial and final
+// inversion removed. crc(a followed by b) is crc32MulMod(crc32Shift(len(b)), crc(a)) ^ crc(b).
+func crc32Shift(n int64) uint32 {
+	// x^(2^k) starting at x^8 for the bytes of n.
+	x2k := uint32(1) << 30
+	for k := 0; k < 3; k++ {
+		x2k = crc32MulMod(x2k, x2k)
+	}
+	p := uint32(1) << 31
+	for ; n > 0; n >>= 1 {
+		if n&1 != 0 {
+			p = crc32MulMod(x2k, p)
+		}
+		x2k = crc32MulMod(x2k, x2k)
+	}
+	return p
+}
+
+// crc32Combine returns the checksum of data with checksum a followed by n bytes with checksum b.
+func crc32Combine(a, b uint32, n int64) uint32 {
+	return crc32MulMod(crc32Shift(n), a) ^ b
+}
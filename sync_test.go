@@ -0,0 +1,150 @@
+package qf
+
+import (
+	"bytes"
+	"fmt"
+	"hash/crc32"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSync(t *testing.T) {
+	qf, err := NewWithOptions(0, WithQR(14, 9), WithStash(8, 0), WithDirtyTracking(512))
+	if err != nil {
+		t.Fatal(err)
+	}
+	qf.AddAll(randomItems(4000))
+	path := filepath.Join(t.TempDir(), "filter.qf")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	check := func(step string) {
+		t.Helper()
+		loaded, err := LoadFromFile(path)
+		if err != nil {
+			t.Fatal(step, "unexpected error", err)
+		}
+		want, _ := qf.MarshalBinary()
+		if got, _ := loaded.MarshalBinary(); !bytes.Equal(got, want) {
+			t.Fatal(step, "loaded filter differs")
+		}
+	}
+	if err := qf.Sync(f); err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	check("first sync")
+
+	rnd := rand.New(rand.NewSource(1))
+	for step := 0; step < 20; step++ {
+		before, _ := os.ReadFile(path)
+		for i := 0; i < 5; i++ {
+			qf.Add(fmt.Sprint("step", step, "key", rnd.Int()))
+		}
+		if step%3 == 0 {
+			qf.Delete(fmt.Sprint("item", rnd.Intn(4000)))
+		}
+		dirty := append([]uint64(nil), qf.dirty.bits...)
+		if n, _ := qf.DirtyBlocks(); n == 0 || n > 10 {
+			t.Fatal("Unexpected number of dirty blocks", n)
+		}
+		if err := qf.Sync(f); err != nil {
+			t.Fatal("Unexpected error", err)
+		}
+		if n, _ := qf.DirtyBlocks(); n != 0 {
+			t.Fatal("Dirty blocks left after Sync", n)
+		}
+		check(fmt.Sprint("step ", step))
+		// only the header and the dirty blocks were rewritten.
+		after, _ := os.ReadFile(path)
+		headerLen := len(after) - len(qf.data)
+		for i := headerLen; i < len(after); i++ {
+			if b := (i - headerLen) / 512; after[i] != before[i] && dirty[b/64]&(1<<(b%64)) == 0 {
+				t.Fatal("Byte", i, "of a clean block changed")
+			}
+		}
+	}
+
+	// a larger header rewrites the whole file.
+	qf.AddNS("users", "42")
+	if err := qf.Sync(f); err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	check("namespace")
+	qf.Reset()
+	qf.Add("fox")
+	if err := qf.Sync(f); err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	check("reset")
+}
+
+func TestSyncUntracked(t *testing.T) {
+	qf := MustNew(10, 8)
+	qf.AddAll(randomItems(500))
+	path := filepath.Join(t.TempDir(), "filter.qf")
+	f, _ := os.Create(path)
+	defer f.Close()
+	// a longer file is truncated.
+	f.Write(make([]byte, 1<<16))
+	if err := qf.Sync(f); err != nil {
+		t.Fatal("Unexpected error", err)
+	}
+	loaded, err := LoadFromFile(path)
+	if err != nil || loaded.Stats() != qf.Stats() || !bytes.Equal(loaded.data, qf.data) {
+		t.Fatal("Loaded filter differs", err)
+	}
+	if _, err := qf.DirtyBlocks(); err == nil {
+		t.Fatal("Expected an error without dirty tracking")
+	}
+}
+
+func TestSyncDecoded(t *testing.T) {
+	// decoding into a tracking filter keeps tracking, and a clone syncs to its own file.
+	qf := MustNew(10, 8)
+	qf.AddAll(randomItems(500))
+	b, _ := qf.MarshalBinary()
+	decoded, _ := NewWithOptions(0, WithQR(2, 2), WithDirtyTracking(64))
+	if err := decoded.UnmarshalBinary(b); err != nil || decoded.dirty == nil || decoded.dirty.size != 64 {
+		t.Fatal("Decoded filter does not track dirty blocks", err)
+	}
+	dir := t.TempDir()
+	for i, qf := range []*QuotientFilter{decoded, decoded.Clone()} {
+		path := filepath.Join(dir, fmt.Sprint(i))
+		f, _ := os.Create(path)
+		defer f.Close()
+		qf.Sync(f)
+		qf.Add(fmt.Sprint("fox", i))
+		if err := qf.Sync(f); err != nil {
+			t.Fatal("Unexpected error", err)
+		}
+		if loaded, err := LoadFromFile(path); err != nil || !loaded.Contains(fmt.Sprint("fox", i)) {
+			t.Fatal("Loaded filter differs", err)
+		}
+	}
+}
+
+func TestDirtyTrackingInvalid(t *testing.T) {
+	for _, size := range []int{-8, 0, 12} {
+		if _, err := NewWithOptions(0, WithQR(8, 8), WithDirtyTracking(size)); err == nil {
+			t.Fatal("Expected an error for block size", size)
+		}
+	}
+}
+
+func TestCRC32Combine(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	for _, sizes := range [][2]int{{0, 0}, {1, 0}, {0, 1}, {7, 13}, {100, 4096}, {5000, 3}} {
+		a, b := make([]byte, sizes[0]), make([]byte, sizes[1])
+		rnd.Read(a)
+		rnd.Read(b)
+		want := crc32.Checksum(append(a, b...), castagnoli)
+		got := crc32MulMod(crc32Shift(int64(len(b))), crc32.Checksum(a, castagnoli)) ^ crc32.Checksum(b, castagnoli)
+		if got != want {
+			t.Fatalf("Combined checksum %#x of %v bytes, want %#x", got, sizes, want)
+		}
+	}
+}
@@ -0,0 +1,253 @@
+/*
+ * qfdump writes the golden files of the C layout tests, TestImportCGolden in cformat_test.go.
+ * The filter code is that of vedantk/quotient-filter, qf.h and qf.c, reduced to qf_init and
+ * qf_insert. The table is allocated rounded up to whole words, the original reads and writes
+ * the word after the last byte of tables that do not end at a word boundary.
+ *
+ *	cc -O2 -o qfdump qfdump.c && ./qfdump 8 8 200 > ../c-q8-r8.golden
+ *
+ * Keys are "key0", "key1" and so on, hashed with 64 bit FNV-1a. The file is the struct
+ * quotient_filter as written by fwrite, with qf_table set to NULL, followed by the
+ * qf_table_size(q, r) bytes of the table.
+ */
+#include <stdbool.h>
+#include <stdint.h>
+#include <stdio.h>
+#include <stdlib.h>
+#include <string.h>
+
+struct quotient_filter {
+	uint8_t qf_qbits;
+	uint8_t qf_rbits;
+	uint8_t qf_elem_bits;
+	uint32_t qf_entries;
+	uint64_t qf_index_mask;
+	uint64_t qf_rmask;
+	uint64_t qf_elem_mask;
+	uint64_t qf_max_size;
+	uint64_t *qf_table;
+};
+
+#define LOW_MASK(n) ((1ULL << (n)) - 1ULL)
+
+static size_t qf_table_size(uint32_t q, uint32_t r)
+{
+	size_t bits = (1 << q) * (r + 3);
+	size_t bytes = bits / 8;
+	return (bits % 8) ? (bytes + 1) : bytes;
+}
+
+static bool qf_init(struct quotient_filter *qf, uint32_t q, uint32_t r)
+{
+	if (q == 0 || r == 0 || q + r > 64) {
+		return false;
+	}
+	qf->qf_qbits = q;
+	qf->qf_rbits = r;
+	qf->qf_elem_bits = qf->qf_rbits + 3;
+	qf->qf_index_mask = LOW_MASK(q);
+	qf->qf_rmask = LOW_MASK(r);
+	qf->qf_elem_mask = LOW_MASK(qf->qf_elem_bits);
+	qf->qf_entries = 0;
+	qf->qf_max_size = 1 << q;
+	qf->qf_table = calloc((qf_table_size(q, r) + 7) / 8, 8);
+	return qf->qf_table != NULL;
+}
+
+static uint64_t get_elem(struct quotient_filter *qf, uint64_t idx)
+{
+	uint64_t elt = 0;
+	size_t bitpos = qf->qf_elem_bits * idx;
+	size_t tabpos = bitpos / 64;
+	size_t slotpos = bitpos % 64;
+	int spillbits = (slotpos + qf->qf_elem_bits) - 64;
+	elt = (qf->qf_table[tabpos] >> slotpos) & qf->qf_elem_mask;
+	if (spillbits > 0) {
+		++tabpos;
+		uint64_t x = qf->qf_table[tabpos] & LOW_MASK(spillbits);
+		elt |= x << (qf->qf_elem_bits - spillbits);
+	}
+	return elt;
+}
+
+static void set_elem(struct quotient_filter *qf, uint64_t idx, uint64_t elt)
+{
+	size_t bitpos = qf->qf_elem_bits * idx;
+	size_t tabpos = bitpos / 64;
+	size_t slotpos = bitpos % 64;
+	int spillbits = (slotpos + qf->qf_elem_bits) - 64;
+	elt &= qf->qf_elem_mask;
+	qf->qf_table[tabpos] &= ~(qf->qf_elem_mask << slotpos);
+	qf->qf_table[tabpos] |= elt << slotpos;
+	if (spillbits > 0) {
+		++tabpos;
+		qf->qf_table[tabpos] &= ~LOW_MASK(spillbits);
+		qf->qf_table[tabpos] |= elt >> (qf->qf_elem_bits - spillbits);
+	}
+}
+
+static uint64_t incr(struct quotient_filter *qf, uint64_t idx)
+{
+	return (idx + 1) & qf->qf_index_mask;
+}
+
+static uint64_t decr(struct quotient_filter *qf, uint64_t idx)
+{
+	return (idx - 1) & qf->qf_index_mask;
+}
+
+static int is_occupied(uint64_t elt) { return elt & 1; }
+static int is_continuation(uint64_t elt) { return elt & 2; }
+static int is_shifted(uint64_t elt) { return elt & 4; }
+static uint64_t set_occupied(uint64_t elt) { return elt | 1; }
+static uint64_t set_continuation(uint64_t elt) { return elt | 2; }
+static uint64_t set_shifted(uint64_t elt) { return elt | 4; }
+static uint64_t clr_occupied(uint64_t elt) { return elt & ~1; }
+static uint64_t get_remainder(uint64_t elt) { return elt >> 3; }
+static bool is_empty_element(uint64_t elt) { return (elt & 7) == 0; }
+
+static uint64_t hash_to_quotient(struct quotient_filter *qf, uint64_t hash)
+{
+	return (hash >> qf->qf_rbits) & qf->qf_index_mask;
+}
+
+static uint64_t hash_to_remainder(struct quotient_filter *qf, uint64_t hash)
+{
+	return hash & qf->qf_rmask;
+}
+
+static uint64_t find_run_index(struct quotient_filter *qf, uint64_t fq)
+{
+	/* Find the start of the cluster. */
+	uint64_t b = fq;
+	while (is_shifted(get_elem(qf, b))) {
+		b = decr(qf, b);
+	}
+
+	/* Find the start of the run for fq. */
+	uint64_t s = b;
+	while (b != fq) {
+		do {
+			s = incr(qf, s);
+		} while (is_continuation(get_elem(qf, s)));
+
+		do {
+			b = incr(qf, b);
+		} while (!is_occupied(get_elem(qf, b)));
+	}
+	return s;
+}
+
+static void insert_into(struct quotient_filter *qf, uint64_t s, uint64_t elt)
+{
+	uint64_t prev;
+	uint64_t curr = elt;
+	bool empty;
+
+	do {
+		prev = get_elem(qf, s);
+		empty = is_empty_element(prev);
+		if (!empty) {
+			/* Fix up `is_shifted' and `is_occupied'. */
+			prev = set_shifted(prev);
+			if (is_occupied(prev)) {
+				curr = set_occupied(curr);
+				prev = clr_occupied(prev);
+			}
+		}
+		set_elem(qf, s, curr);
+		curr = prev;
+		s = incr(qf, s);
+	} while (!empty);
+}
+
+static bool qf_insert(struct quotient_filter *qf, uint64_t hash)
+{
+	if (qf->qf_entries >= qf->qf_max_size) {
+		return false;
+	}
+
+	uint64_t fq = hash_to_quotient(qf, hash);
+	uint64_t fr = hash_to_remainder(qf, hash);
+	uint64_t T_fq = get_elem(qf, fq);
+	uint64_t entry = (fr << 3) & ~7;
+
+	/* Special-case filling canonical slots to simplify insert_into(). */
+	if (is_empty_element(T_fq)) {
+		set_elem(qf, fq, set_occupied(entry));
+		++qf->qf_entries;
+		return true;
+	}
+
+	if (!is_occupied(T_fq)) {
+		set_elem(qf, fq, set_occupied(T_fq));
+	}
+
+	uint64_t start = find_run_index(qf, fq);
+	uint64_t s = start;
+
+	if (is_occupied(T_fq)) {
+		/* Move the cursor to the insert position in the fq run. */
+		do {
+			uint64_t rem = get_remainder(get_elem(qf, s));
+			if (rem == fr) {
+				return true;
+			} else if (rem > fr) {
+				break;
+			}
+			s = incr(qf, s);
+		} while (is_continuation(get_elem(qf, s)));
+
+		if (s == start) {
+			/* The old start-of-run becomes a continuation. */
+			uint64_t old_head = get_elem(qf, start);
+			set_elem(qf, start, set_continuation(old_head));
+		} else {
+			/* The new element becomes a continuation. */
+			entry = set_continuation(entry);
+		}
+	}
+
+	/* Set the shifted bit if we can't use the canonical slot. */
+	if (s != fq) {
+		entry = set_shifted(entry);
+	}
+
+	insert_into(qf, s, entry);
+	++qf->qf_entries;
+	return true;
+}
+
+static uint64_t fnv1a64(const char *s)
+{
+	uint64_t h = 14695981039346656037ULL;
+	for (; *s; s++) {
+		h ^= (unsigned char)*s;
+		h *= 1099511628211ULL;
+	}
+	return h;
+}
+
+int main(int argc, char **argv)
+{
+	if (argc != 4) {
+		fprintf(stderr, "usage: qfdump q r keys\n");
+		return 2;
+	}
+	struct quotient_filter qf;
+	memset(&qf, 0, sizeof(qf));
+	if (!qf_init(&qf, atoi(argv[1]), atoi(argv[2]))) {
+		return 1;
+	}
+	int n = atoi(argv[3]);
+	char key[32];
+	for (int i = 0; i < n; i++) {
+		snprintf(key, sizeof(key), "key%d", i);
+		qf_insert(&qf, fnv1a64(key));
+	}
+	uint64_t *table = qf.qf_table;
+	qf.qf_table = NULL;
+	fwrite(&qf, sizeof(qf), 1, stdout);
+	fwrite(table, qf_table_size(qf.qf_qbits, qf.qf_rbits), 1, stdout);
+	return 0;
+}
@@ -1,14 +1,106 @@
 package qf
 
+import (
+	"math/bits"
+	"unsafe"
+)
+
+// stringBytes returns the bytes of s without copying them, they must not be modified.
+func stringBytes(s string) []byte {
+	return unsafe.Slice(unsafe.StringData(s), len(s))
+}
+
+// maskLower returns a mask of the e lowest bits, e can be up to 64.
 func maskLower(e uint64) uint64 {
+	if e >= 64 {
+		return ^uint64(0)
+	}
 	return (1 << e) - 1
 }
 
-func uint64Size(q, r uint8) int {
-	var bits int = (1 << q) * int(r+3)
-	bytes := bits / 8
-	if bits%8 != 0 {
-		bytes++
+// uint64Size returns the number of data words for q quotient bits and remainders stored in
+// width bits, see remainderWidth, the words holding the slots and one more, so that reading
+// the word after the one a slot starts in never runs off the end. With cacheLine the blocks
+// of tables of blockSlots slots or more are padded to whole cache lines, see
+// WithCacheLineBlocks. ok is false if the number does not fit in an uint64.
+func uint64Size(q, width uint8, cacheLine bool) (words uint64, ok bool) {
+	if cacheLine && uint64(1)<<q >= blockSlots {
+		hi, n := bits.Mul64(1<<q/blockSlots, blockStride(width, true))
+		return n + 1, hi == 0
+	}
+	hi, n := bits.Mul64(1<<q, uint64(width)+3)
+	if hi != 0 {
+		return 0, false
+	}
+	words = n/64 + 1
+	if n%64 != 0 {
+		words++
+	}
+	return words, true
+}
+
+// legacyUint64Size returns the number of data words of encodings before version 3, which
+// took the number of bytes the slots need as the number of words, 8 times too many.
+func legacyUint64Size(q, r uint8) (words uint64, ok bool) {
+	hi, n := bits.Mul64(1<<q, uint64(r)+3)
+	if hi != 0 {
+		return 0, false
+	}
+	words = n / 8
+	if n%8 != 0 {
+		words++
+	}
+	return words, true
+}
+
+// encodedWords returns the number of data words of an uncompressed encoding of version.
+// Encodings before version 4 store remainders in r bits, width is r for them.
+func encodedWords(version uint16, q, width uint8, cacheLine bool) uint64 {
+	if version < encodingVersionPacked {
+		words, _ := legacyUint64Size(q, width)
+		return words
 	}
-	return int(bytes)
+	words, _ := uint64Size(q, width, cacheLine)
+	return words
+}
+
+// remainderWidth returns the number of bits the data words store a remainder of r bits in:
+// r, or with aligned the next of 8, 16, 32 and 64 bits, see WithAlignedSlots.
+func remainderWidth(r uint8, aligned bool) uint8 {
+	if !aligned {
+		return r
+	}
+	return max(8, uint8(1)<<bits.Len8(r-1))
+}
+
+// blockStride returns the number of data words of a block of blockSlots slots with remainders
+// of width bits, width + 3, rounded up to whole cache lines with cacheLine.
+func blockStride(width uint8, cacheLine bool) uint64 {
+	stride := uint64(width) + 3
+	if cacheLine {
+		stride = (stride + cacheLineWords - 1) / cacheLineWords * cacheLineWords
+	}
+	return stride
+}
+
+// dataBytes returns the number of bytes the data slice for q quotient bits and remainders of
+// width bits takes, ok is false if it does not fit in an uint64.
+func dataBytes(q, width uint8, cacheLine bool) (uint64, bool) {
+	size, ok := uint64Size(q, width, cacheLine)
+	if !ok || size > 1<<61-1 {
+		return 0, false
+	}
+	return size * 8, true
+}
+
+// alignedBytes returns n zero bytes starting at a cache line boundary.
+func alignedBytes(n uint64) []byte {
+	b := make([]byte, n+cacheLineWords*8)
+	off := -uintptr(unsafe.Pointer(unsafe.SliceData(b))) & (cacheLineWords*8 - 1)
+	return b[off : off+uintptr(n) : off+uintptr(n)]
+}
+
+// isAligned reports whether b starts at a cache line boundary.
+func isAligned(b []byte) bool {
+	return uintptr(unsafe.Pointer(unsafe.SliceData(b)))%(cacheLineWords*8) == 0
 }
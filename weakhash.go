@@ -0,0 +1,68 @@
+package qf
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/bits"
+	"sync"
+)
+
+// ErrWeakHash is returned when adding keys to a filter whose custom hash function leaves
+// some of the q + r fingerprint bits unchanged, such as a 32-bit hash widened to 64 bits.
+// Such a filter puts all keys into a few clusters. The hash function is checked once, on
+// the first key added, see WithoutHashCheck.
+var ErrWeakHash = errors.New("hash function does not vary all fingerprint bits")
+
+// hashProbes is the number of values a custom hash function hashes to be checked, a bit of
+// a good hash stays the same for all of them with a probability of 2^-255.
+const hashProbes = 256
+
+// WithoutHashCheck skips checking the custom hash function on the first key added, for
+// hash functions known to vary all fingerprint bits, see ErrWeakHash.
+func WithoutHashCheck() Option {
+	return func(c *config) error {
+		c.skipHashCheck = true
+		return nil
+	}
+}
+
+// hashCheck is the result of checking the hash function of a filter, shared with its clones.
+type hashCheck struct {
+	once sync.Once
+	err  error
+}
+
+// checkHash checks the custom hash function of the filter once and returns an error
+// wrapping ErrWeakHash if it is weak.
+func (qf *QuotientFilter) checkHash() error {
+	c := qf.hashCheck
+	if c == nil {
+		return nil
+	}
+	c.once.Do(func() { c.err = qf.probeHash() })
+	return c.err
+}
+
+// probeHash hashes the 8 byte encodings of the first hashProbes integers and returns an
+// error if a fingerprint bit is the same in all hashes. The quotients and remainders are
+// checked apart, they don't fit one 64-bit value for q + r above 64 bits.
+func (qf *QuotientFilter) probeHash() error {
+	orQ, andQ, orR, andR := uint64(0), ^uint64(0), uint64(0), ^uint64(0)
+	var b [8]byte
+	for i := range uint64(hashProbes) {
+		binary.LittleEndian.PutUint64(b[:], i)
+		_, q, r := qf.fingerprintBytes(b[:])
+		orQ, andQ, orR, andR = orQ|q, andQ&q, orR|r, andR&r
+	}
+	stuckQ, stuckR := ^(orQ&^andQ)&qf.qMask, ^(orR&^andR)&qf.rMask
+	if stuckQ == 0 && stuckR == 0 {
+		return nil
+	}
+	highest := 63 - bits.LeadingZeros64(stuckR)
+	if stuckQ != 0 {
+		highest = int(qf.rbits) + 63 - bits.LeadingZeros64(stuckQ)
+	}
+	return fmt.Errorf("%w: %d of the %d fingerprint bits are the same for %d keys, the highest is bit %d",
+		ErrWeakHash, bits.OnesCount64(stuckQ)+bits.OnesCount64(stuckR), int(qf.qbits)+int(qf.rbits), hashProbes, highest)
+}
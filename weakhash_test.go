@@ -0,0 +1,41 @@
+package qf
+
+import (
+	"errors"
+	"hash"
+	"hash/fnv"
+	"testing"
+)
+
+func TestWeakHash(t *testing.T) {
+	// FNV-64a truncated to 32 bits, widened back to 64.
+	truncated := WithHashFunc(func(b []byte) uint64 { return fnv64a(fnvOffset64, b) & (1<<32 - 1) })
+	weak, _ := NewWithOptions(0, WithQR(20, 20), truncated)
+	for range 2 {
+		if err := weak.Add("fox"); !errors.Is(err, ErrWeakHash) {
+			t.Fatal("Expected ErrWeakHash from a truncated hash, got", err)
+		}
+	}
+	if _, err := weak.AddAll([]string{"dog"}); !errors.Is(err, ErrWeakHash) || weak.Len() != 0 {
+		t.Fatal("Expected ErrWeakHash from AddAll, got", err)
+	}
+	striped, _ := NewStriped(mustNew(NewWithOptions(0, WithQR(20, 20), truncated)), 4)
+	if err := striped.Add("fox"); !errors.Is(err, ErrWeakHash) {
+		t.Fatal("Expected ErrWeakHash from a striped filter, got", err)
+	}
+
+	// 32 bits are enough for fingerprints of 32 bits, and the check can be skipped.
+	for _, qf := range []*QuotientFilter{
+		mustNew(NewWithOptions(0, WithQR(16, 16), truncated)),
+		mustNew(NewWithOptions(0, WithQR(20, 20), truncated, WithoutHashCheck())),
+		mustNew(NewWithOptions(0, WithQR(20, 44), WithHash(func() hash.Hash64 { return fnv.New64() }))),
+		mustNew(NewWithOptions(0, WithQR(20, 44), WithXXHash())),
+	} {
+		if err := qf.Add("fox"); err != nil || !qf.Contains("fox") {
+			t.Fatal("Unexpected error adding to a filter with hash", qf.Params().Hash, err)
+		}
+	}
+	if MustNew(20, 20).hashCheck != nil {
+		t.Fatal("The built-in hash is checked")
+	}
+}
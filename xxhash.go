@@ -0,0 +1,97 @@
+package qf
+
+import (
+	"hash"
+	"math/bits"
+)
+
+// WithXXHash hashes keys with xxHash64, which distributes similar keys like URLs better and
+// hashes long keys faster than the default FNV-64a.
+func WithXXHash() Option {
+	return func(c *config) error {
+		c.newHash, c.hashID, c.hashSeed, c.hash128 = newXXHash, HashXXHash64, "", nil
+		return nil
+	}
+}
+
+func newXXHash() hash.Hash64 {
+	return &funcHash{sum: xxhash64Bytes, sumString: xxhash64String}
+}
+
+func xxhash64Bytes(b []byte) uint64  { return xxhash64(0, b) }
+func xxhash64String(s string) uint64 { return xxhash64(0, s) }
+
+const (
+	xxPrime1 uint64 = 11400714785074694791
+	xxPrime2 uint64 = 14029467366897019727
+	xxPrime3 uint64 = 1609587929392839161
+	xxPrime4 uint64 = 9650029242287828579
+	xxPrime5 uint64 = 2870177450012600261
+)
+
+// xxhash64 returns the xxHash64 of b with seed.
+func xxhash64[T string | []byte](seed uint64, b T) uint64 {
+	n := len(b)
+	var h uint64
+	if n >= 32 {
+		// v1 = seed + prime1 + prime2 and v4 = seed - prime1, wrapping around.
+		v1, v2, v3, v4 := seed+xxPrime1, seed+xxPrime2, seed, seed
+		v1 += xxPrime2
+		v4 -= xxPrime1
+		for ; len(b) >= 32; b = b[32:] {
+			v1 = xxRound(v1, le64(b[0:8]))
+			v2 = xxRound(v2, le64(b[8:16]))
+			v3 = xxRound(v3, le64(b[16:24]))
+			v4 = xxRound(v4, le64(b[24:32]))
+		}
+		h = bits.RotateLeft64(v1, 1) + bits.RotateLeft64(v2, 7) + bits.RotateLeft64(v3, 12) + bits.RotateLeft64(v4, 18)
+		h = xxMerge(h, v1)
+		h = xxMerge(h, v2)
+		h = xxMerge(h, v3)
+		h = xxMerge(h, v4)
+	} else {
+		h = seed + xxPrime5
+	}
+	h += uint64(n)
+	for ; len(b) >= 8; b = b[8:] {
+		h ^= xxRound(0, le64(b[:8]))
+		h = bits.RotateLeft64(h, 27)*xxPrime1 + xxPrime4
+	}
+	if len(b) >= 4 {
+		h ^= uint64(le32(b[:4])) * xxPrime1
+		h = bits.RotateLeft64(h, 23)*xxPrime2 + xxPrime3
+		b = b[4:]
+	}
+	for i := 0; i < len(b); i++ {
+		h ^= uint64(b[i]) * xxPrime5
+		h = bits.RotateLeft64(h, 11) * xxPrime1
+	}
+	h ^= h >> 33
+	h *= xxPrime2
+	h ^= h >> 29
+	h *= xxPrime3
+	h ^= h >> 32
+	return h
+}
+
+func xxRound(acc, input uint64) uint64 {
+	acc += input * xxPrime2
+	return bits.RotateLeft64(acc, 31) * xxPrime1
+}
+
+func xxMerge(h, v uint64) uint64 {
+	h ^= xxRound(0, v)
+	return h*xxPrime1 + xxPrime4
+}
+
+// le64 and le32 decode little-endian integers from the first bytes of b.
+func le64[T string | []byte](b T) uint64 {
+	_ = b[7]
+	return uint64(b[0]) | uint64(b[1])<<8 | uint64(b[2])<<16 | uint64(b[3])<<24 |
+		uint64(b[4])<<32 | uint64(b[5])<<40 | uint64(b[6])<<48 | uint64(b[7])<<56
+}
+
+func le32[T string | []byte](b T) uint32 {
+	_ = b[3]
+	return uint32(b[0]) | uint32(b[1])<<8 | uint32(b[2])<<16 | uint32(b[3])<<24
+}
@@ -0,0 +1,102 @@
+package qf
+
+import (
+	"fmt"
+	"math"
+	"testing"
+)
+
+func TestXXHash64(t *testing.T) {
+	for _, test := range []struct {
+		in   string
+		want uint64
+	}{
+		{"", 0xef46db3751d8e999},
+		{"a", 0xd24ec4f1a98c6e5b},
+		{"abc", 0x44bc2cf5ad770999},
+		{"Nobody inspects the spammish repetition", 0xfbcea83c8a378bf1},
+	} {
+		if got := xxhash64(0, test.in); got != test.want || xxhash64(0, []byte(test.in)) != test.want {
+			t.Errorf("xxhash64(%q) = %#x, want %#x", test.in, got, test.want)
+		}
+	}
+
+	qf, err := NewWithOptions(1000, WithXXHash())
+	if err != nil || qf.Params().Hash != HashXXHash64 || qf.hashFunc == nil {
+		t.Fatal("Unexpected result creating an xxhash filter", err)
+	}
+	urls := urlKeys(1000)
+	qf.AddAll(urls)
+	b, _ := qf.MarshalBinary()
+	decoded := MustNew(4, 4)
+	if err := decoded.UnmarshalBinary(b); err != nil || decoded.Params() != qf.Params() {
+		t.Fatal("Unexpected result decoding an xxhash filter", err)
+	}
+	for _, k := range urls {
+		if !decoded.Contains(k) {
+			t.Fatal("Missing after decoding", k)
+		}
+	}
+}
+
+// urlKeys returns n URLs differing only in a few characters, like the keys of a crawler.
+func urlKeys(n int) []string {
+	keys := make([]string, n)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("https://www.example.com/catalog/products/%d/reviews?page=%d", i/10, i%10)
+	}
+	return keys
+}
+
+// TestXXHashDistribution checks with a chi-squared test that URL keys spread evenly over
+// the quotients of a filter, grouped into 256 buckets.
+func TestXXHashDistribution(t *testing.T) {
+	const buckets, keys = 256, 1 << 17
+	qf, _ := NewWithOptions(0, WithQR(20, 12), WithXXHash())
+	var counts [buckets]float64
+	for _, k := range urlKeys(keys) {
+		q, _ := qf.Fingerprint(k)
+		counts[q>>(20-8)]++
+	}
+	chi2 := 0.0
+	expected := float64(keys) / buckets
+	for _, c := range counts {
+		chi2 += (c - expected) * (c - expected) / expected
+	}
+	// the statistic has a mean of 255 and a standard deviation of about 22.6 for even
+	// distributions.
+	if limit := 255 + 6*math.Sqrt(2*255); chi2 > limit {
+		t.Fatal("Chi-squared of the quotients", chi2, "exceeds", limit)
+	}
+}
+
+// BenchmarkHashes adds and looks up URL keys with the built-in hash functions at identical
+// parameters.
+func BenchmarkHashes(b *testing.B) {
+	for _, c := range []struct {
+		name string
+		opt  Option
+	}{{"FNV64a", WithQR(20, 16)}, {"XXHash64", WithXXHash()}} {
+		keys := urlKeys(1 << 16)
+		b.Run(c.name+"/Add", func(b *testing.B) {
+			qf, _ := NewWithOptions(0, WithQR(20, 16), c.opt)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if i&(1<<16-1) == 0 {
+					qf.Reset()
+				}
+				qf.Add(keys[i&(1<<16-1)])
+			}
+		})
+		b.Run(c.name+"/Contains", func(b *testing.B) {
+			qf, _ := NewWithOptions(0, WithQR(20, 16), c.opt)
+			qf.AddAll(keys[:1<<15])
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				qf.Contains(keys[i&(1<<16-1)])
+			}
+		})
+	}
+}